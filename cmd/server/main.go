@@ -3,107 +3,262 @@ package main
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 
-	"github.com/slack-go/slack"
-
 	"github.com/rs/zerolog"
-
-	"github.com/gorilla/mux"
-	_ "github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
+	"github.com/yammine/yamex-go"
 	"github.com/yammine/yamex-go/notabankbot/adapter"
 	"github.com/yammine/yamex-go/notabankbot/app"
+	"github.com/yammine/yamex-go/notabankbot/config"
 	"github.com/yammine/yamex-go/notabankbot/port"
+	"github.com/yammine/yamex-go/notabankbot/tracing"
 )
 
 const ServiceName = "yamex"
 
 func main() {
-	if !viper.IsSet("PRODUCTION") {
-		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	// Slack tokens must never reach the logs, whoever is logging.
+	logOutput := yamex.NewRedactingWriter(os.Stderr)
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: logOutput})
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not load config")
 	}
+	if cfg.Production {
+		log.Logger = log.Output(logOutput)
+	}
+	zerolog.SetGlobalLevel(cfg.LogLevel)
 
-	viper.AutomaticEnv()
-	viper.SetDefault("PORT", 3000)
-	viper.SetConfigName("config")
-	viper.SetConfigType("yml")
-	viper.AddConfigPath(".")
-	if err := viper.ReadInConfig(); err != nil {
-		log.Error().Err(err).Msg("viper couldn't find config.yml, falling back to ENV config")
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not set up tracing")
 	}
 
-	// App repo
-	repo := adapter.NewPostgresRepository(viper.GetString("POSTGRES_DSN"))
-	repo.Migrate()
+	// One pool for every Postgres adapter, waiting for the database if it's
+	// still starting.
+	pool, err := adapter.OpenPostgresPool(context.Background(), cfg.Postgres)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not connect to database")
+	}
+	keyring, err := adapter.LoadTokenKeyring(cfg.SlackTokens.ActiveKey, cfg.SlackTokens.KeysFile, cfg.SlackTokens.Keys)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid slack token encryption keys")
+	}
+	// App repo, whose webhook secrets share the Slack token keyring
+	repo := adapter.NewPostgresRepository(pool, keyring)
+	if err := repo.Migrate(); err != nil {
+		log.Fatal().Err(err).Msg("could not migrate database")
+	}
 	// Slack credentials repo
-	slackCredentialsStore := adapter.NewSlackCredentialPostgresRepository(viper.GetString("POSTGRES_DSN"))
-	slackCredentialsStore.Migrate()
+	credentialsRepo := adapter.NewSlackCredentialPostgresRepository(pool, keyring, adapter.CredentialCacheConfig{
+		TTL:         cfg.SlackTokens.CacheTTL,
+		NegativeTTL: cfg.SlackTokens.NegativeCacheTTL,
+	})
+	if err := credentialsRepo.Migrate(); err != nil {
+		log.Fatal().Err(err).Msg("could not migrate slack credentials")
+	}
+	// HTTP API keys & idempotency keys
+	apiRepo := adapter.NewAPIPostgresRepository(pool)
+	if err := apiRepo.Migrate(); err != nil {
+		log.Fatal().Err(err).Msg("could not migrate api keys")
+	}
+	// Inbound webhook integrations, whose secrets share the Slack token keyring
+	integrationRepo := adapter.NewIntegrationPostgresRepository(pool, keyring)
+	if err := integrationRepo.Migrate(); err != nil {
+		log.Fatal().Err(err).Msg("could not migrate integrations")
+	}
+	workers := port.NewWorkers()
+	workers.Go("credential_listener", credentialsRepo.Listen)
+	slackOAuth := port.NewSlackOAuthClient(cfg.Slack.ClientID, cfg.Slack.ClientSecret, cfg.Slack.APIURL)
+	// Rotating tokens are refreshed whenever they're fetched.
+	slackCredentialsStore := port.NewRefreshingCredentialStore(credentialsRepo, slackOAuth)
 
-	application := app.NewApplication(repo)
-	slackConsumer := port.NewSlackConsumer(application, slackCredentialsStore)
-	slackInteractor := port.NewSlackInteractor(slackCredentialsStore, application)
+	signer, err := adapter.NewLedgerSigner(cfg.LedgerSigningKey, cfg.LedgerRetiredKeys)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid ledger signing key")
+	}
+	application := app.NewApplication(repo, signer)
+	application.SetGrantPolicy(cfg.GrantPolicy)
+	slackClients := port.NewSlackClientFactory(cfg.Slack.APIURL)
+	rateLimiter := app.NewRateLimiter(repo, repo, cfg.RateLimits)
+	// Commands keep running after Slack has been answered, shutdown waits for them.
+	inFlight := port.NewInFlight()
+	dispatcher := port.NewSlackDispatcher(application, slackCredentialsStore, slackClients, inFlight)
+	slackConsumer := port.NewSlackConsumer(application, slackCredentialsStore, dispatcher, rateLimiter, cfg.Slack.SigningSecret, inFlight)
+	slackInteractor := port.NewSlackInteractor(application, dispatcher, cfg.Slack.SigningSecret, inFlight)
+	reconciler := port.NewReconciler(application, slackCredentialsStore, slackClients, port.AdminChannel{
+		TeamID:    cfg.AdminSlackTeamID,
+		ChannelID: cfg.AdminChannelID,
+	})
+	slackInstaller := port.NewSlackInstaller(application, port.SlackOAuthConfig{
+		ClientID:     cfg.Slack.ClientID,
+		ClientSecret: cfg.Slack.ClientSecret,
+		RedirectURI:  cfg.Slack.RedirectURI,
+		Scopes:       cfg.Slack.Scopes,
+		StateSecret:  cfg.Slack.StateSecret,
+	}, slackCredentialsStore, slackOAuth)
+	workers.Go("outbox_dispatcher", func(ctx context.Context) { dispatcher.Run(ctx, cfg.OutboxPollInterval) })
+	webhooks := port.NewWebhookDispatcher(application)
+	workers.Go("webhook_dispatcher", func(ctx context.Context) { webhooks.Run(ctx, cfg.OutboxPollInterval) })
+	if interval := cfg.ReconciliationInterval; interval > 0 {
+		workers.Go("reconciler", func(ctx context.Context) { reconciler.Run(ctx, interval) })
+	}
+	if interval := cfg.LedgerCheckpointInterval; interval > 0 && signer != nil {
+		workers.Go("ledger_checkpoints", func(ctx context.Context) { port.RunLedgerCheckpoints(ctx, application, interval) })
+	}
+	if interval := cfg.BalanceSnapshotInterval; interval > 0 {
+		workers.Go("balance_snapshots", func(ctx context.Context) { port.RunBalanceSnapshots(ctx, application, interval) })
+	}
+	api := port.NewAPI(application, apiRepo, apiRepo)
+	workers.Go("idempotency_pruning", func(ctx context.Context) { api.RunIdempotencyPruning(ctx, time.Hour) })
+	if cfg.Slack.SocketMode {
+		socketMode := port.NewSlackSocketMode(slackConsumer, slackInteractor, cfg.Slack.AppToken, cfg.Slack.APIURL)
+		workers.Go("socket_mode", socketMode.Run)
+	}
+
+	health := port.NewHealth(
+		port.ReadinessCheck{Name: "database", Check: pool.Ping},
+		port.ReadinessCheck{Name: "schema", Check: repo.CheckSchema},
+		port.ReadinessCheck{Name: "credentials", Check: credentialsRepo.Ping},
+	)
+	handlers := port.Handlers{
+		App:        application,
+		Consumer:   slackConsumer,
+		Interactor: slackInteractor,
+		Installer:  slackInstaller,
+		Health:     health,
+		API:        api,
+		// Integrations are created with the CLI, so the route is always served.
+		Integrations: port.NewIntegrationHooks(application, integrationRepo, apiRepo),
+	}
+	if cfg.AdminDebugToken != "" {
+		handlers.Debug = port.NewAdminDebug(cfg.AdminDebugToken, application, workers, config.Settings)
+	}
+	if cfg.AdminAPIToken != "" {
+		handlers.AdminAPI = port.NewAdminAPI(cfg.AdminAPIToken, application, credentialsRepo)
+	}
+	router := port.NewRouter(handlers)
 
-	router := mux.NewRouter()
-	router.HandleFunc("/slack/events", slackConsumer.Handler())
-	router.HandleFunc("/slack/interaction", slackInteractor.Handler())
-	router.HandleFunc("/slack/oauth", oAuthRedirectHandler(slackCredentialsStore))
+	var handler http.Handler = router
+	if path := cfg.Slack.RecordFile; path != "" {
+		recording, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not open slack recording file")
+		}
+		defer recording.Close()
+		handler = port.NewSlackRecorder(recording, cfg.Slack.SigningSecret).Middleware(router)
+		log.Warn().Str("file", path).Msg("Recording inbound slack requests")
+	}
 
 	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", viper.GetInt("PORT")),
+		Addr:         fmt.Sprintf(":%d", cfg.Port),
 		WriteTimeout: time.Second * 15,
 		ReadTimeout:  time.Second * 15,
 		IdleTimeout:  time.Second * 60,
-		Handler:      router,
+		Handler:      handler,
 	}
 
+	listener, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		log.Fatal().Err(err).Str("service", ServiceName).Msg("error starting http listener")
+	}
 	go func() {
-		if err := srv.ListenAndServe(); err != nil {
-			log.Fatal().Err(err).Str("service", ServiceName).Msg("error starting http listener")
+		if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Fatal().Err(err).Str("service", ServiceName).Msg("http server failed")
 		}
 	}()
-
-	c := make(chan os.Signal, 1)
-	// We'll accept graceful shutdowns when quit via SIGINT (Ctrl+C)
-	// SIGKILL, SIGQUIT or SIGTERM (Ctrl+/) will not be caught.
-	signal.Notify(c, os.Interrupt)
-
-	// Block until we receive our signal.
-	<-c
-
-	// Create a deadline to wait for.
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	// Doesn't block if no connections, but will otherwise wait
-	// until the timeout deadline.
-	srv.Shutdown(ctx)
-	log.Info().Msg("Shutting down")
-	os.Exit(0)
-}
-
-func oAuthRedirectHandler(repo port.SlackCredentialStore) func(w http.ResponseWriter, r *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
-		code := r.URL.Query().Get("code")
-		log.Info().Str("code", code).Msg("Code from query params")
-
-		client := &http.Client{Timeout: 5 * time.Second}
-		oauthResp, err := slack.GetOAuthV2Response(client, viper.GetString("SLACK_CLIENT_ID"), viper.GetString("SLACK_CLIENT_SECRET"), code, viper.GetString("SLACK_REDIRECT_URI"))
-
+	var grpcServer *grpc.Server
+	ledger := port.NewLedgerServer(application, apiRepo)
+	if cfg.GRPC.Port > 0 {
+		var creds credentials.TransportCredentials
+		if cfg.GRPC.CertFile != "" {
+			creds, err = port.LoadGRPCTLS(cfg.GRPC.CertFile, cfg.GRPC.KeyFile, cfg.GRPC.ClientCAFile)
+			if err != nil {
+				log.Fatal().Err(err).Msg("could not load grpc tls config")
+			}
+		}
+		grpcServer = port.NewGRPCServer(ledger, creds)
+		grpcListener, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.GRPC.Port))
 		if err != nil {
-			// TODO: something
+			log.Fatal().Err(err).Str("service", ServiceName).Msg("error starting grpc listener")
 		}
+		go func() {
+			if err := grpcServer.Serve(grpcListener); err != nil {
+				log.Fatal().Err(err).Str("service", ServiceName).Msg("grpc server failed")
+			}
+		}()
+		log.Info().Str("addr", grpcListener.Addr().String()).Bool("tls", creds != nil).Msg("Serving gRPC")
+	}
+	// Everything is migrated & running, let the load balancer in.
+	health.SetReady(true)
+	log.Info().Str("addr", srv.Addr).Msg("Ready")
 
-		// Persist the token & team ID, so we can use them later when responding to mentions
-		if err := repo.SaveCredentials(r.Context(), oauthResp.Team.ID, oauthResp.AccessToken); err != nil {
-			// TODO: something
+	// SIGHUP reloads the settings that are safe to change while running.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reloadConfig(application, rateLimiter)
 		}
+	}()
+
+	// On SIGINT or SIGTERM, stop taking new work, finish what was accepted, then
+	// let go of everything else.
+	lifecycle := port.NewLifecycle(cfg.ShutdownTimeout)
+	lifecycle.OnShutdown("http", func(ctx context.Context) error {
+		health.SetReady(false)
+		return srv.Shutdown(ctx)
+	})
+	lifecycle.OnShutdown("grpc", func(ctx context.Context) error {
+		ledger.Close()
+		if grpcServer == nil {
+			return nil
+		}
+		return port.StopGRPC(ctx, grpcServer)
+	})
+	lifecycle.OnShutdown("socket_mode", func(ctx context.Context) error {
+		return workers.Stop(ctx, "socket_mode")
+	})
+	lifecycle.OnShutdown("commands", inFlight.Drain)
+	lifecycle.OnShutdown("workers", func(ctx context.Context) error {
+		return workers.Stop(ctx)
+	})
+	lifecycle.OnShutdown("outbox", dispatcher.Drain)
+	lifecycle.OnShutdown("database", func(ctx context.Context) error {
+		return pool.Close()
+	})
+	lifecycle.OnShutdown("tracing", shutdownTracing)
+	if !lifecycle.Wait() {
+		os.Exit(1)
+	}
+	log.Info().Msg("Shut down cleanly")
+}
 
-		w.WriteHeader(200)
+// reloadConfig applies the log level, rate limits & grant policy from a fresh
+// read of the config. Everything else needs a restart. An invalid config is
+// ignored, leaving the current settings in place.
+func reloadConfig(application *app.Application, rateLimiter *app.RateLimiter) {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Error().Err(err).Msg("Not reloading config")
 		return
 	}
+	zerolog.SetGlobalLevel(cfg.LogLevel)
+	rateLimiter.SetConfig(cfg.RateLimits)
+	application.SetGrantPolicy(cfg.GrantPolicy)
+	log.Info().
+		Str("log_level", cfg.LogLevel.String()).
+		Str("grant_amount", cfg.GrantPolicy.Amount.String()).
+		Dur("grant_cooldown", cfg.GrantPolicy.Cooldown).
+		Msg("Reloaded config")
 }
@@ -0,0 +1,253 @@
+// Command yamex-repl runs bot commands through the same processing as Slack
+// mentions, without Slack.
+//
+// Usage:
+//
+//	yamex-repl [-repo memory|postgres] [-workspace T...]
+//	yamex-repl -script commands.txt [-golden commands.golden [-update]]
+//
+// Each line is `as @alice: @yamex send 5 $coffee @bob`. Other lines:
+//
+//	admin @alice   make alice an admin (memory repository only)
+//	# ...          a comment
+//	help           print this help
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+
+	"github.com/yammine/yamex-go"
+	"github.com/yammine/yamex-go/notabankbot/adapter"
+	"github.com/yammine/yamex-go/notabankbot/app"
+	"github.com/yammine/yamex-go/notabankbot/port"
+)
+
+const help = `as @user: <message>   send a message as user, mention the bot with @yamex
+admin @user           make user an admin (memory repository only)
+help                  print this help
+quit                  exit`
+
+func main() {
+	repoName := flag.String("repo", "memory", "repository to run against: memory or postgres (POSTGRES_DSN)")
+	workspace := flag.String("workspace", "TREPL000000", "workspace the commands are sent from")
+	script := flag.String("script", "", "run the commands in this file instead of reading from stdin")
+	golden := flag.String("golden", "", "compare the script's output with this file, exiting non-zero on differences")
+	update := flag.Bool("update", false, "rewrite the golden file with the script's output")
+	verbose := flag.Bool("v", false, "log to stderr at debug level")
+	flag.Parse()
+
+	level := zerolog.WarnLevel
+	if *verbose {
+		level = zerolog.DebugLevel
+	}
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: yamex.NewRedactingWriter(os.Stderr)}).Level(level)
+
+	viper.AutomaticEnv()
+	viper.SetConfigName("config")
+	viper.SetConfigType("yml")
+	viper.AddConfigPath(".")
+	if err := viper.ReadInConfig(); err != nil {
+		log.Debug().Err(err).Msg("viper couldn't find config.yml, falling back to ENV config")
+	}
+
+	session, err := newSession(*repoName, *workspace)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not start session")
+	}
+
+	if *script == "" {
+		session.interactive(os.Stdin, os.Stdout)
+		return
+	}
+
+	in, err := os.Open(*script)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not open script")
+	}
+	defer in.Close()
+
+	var out bytes.Buffer
+	session.run(in, &out)
+
+	switch {
+	case *golden != "" && *update:
+		if err := ioutil.WriteFile(*golden, out.Bytes(), 0644); err != nil {
+			log.Fatal().Err(err).Msg("could not write golden file")
+		}
+	case *golden != "":
+		want, err := ioutil.ReadFile(*golden)
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not read golden file")
+		}
+		if line, ok := firstDifference(string(want), out.String()); !ok {
+			fmt.Fprintf(os.Stderr, "output differs from %s at line %d, rerun with -update to accept it\n", *golden, line)
+			os.Stdout.Write(out.Bytes())
+			os.Exit(1)
+		}
+	default:
+		os.Stdout.Write(out.Bytes())
+	}
+}
+
+type session struct {
+	workspace string
+	consumer  *port.SlackConsumer
+	admins    adminSetter
+	users     *userDirectory
+}
+
+// adminSetter is implemented by repositories that let the REPL promote users.
+type adminSetter interface {
+	SetAdmin(ctx context.Context, slackUserID string, admin bool) error
+}
+
+func newSession(repoName, workspace string) (*session, error) {
+	var repo app.Repository
+	switch repoName {
+	case "memory":
+		repo = adapter.NewMemoryRepository()
+	case "postgres":
+		pool, err := adapter.OpenPostgresPool(context.Background(), adapter.PostgresPoolConfig{DSN: viper.GetString("POSTGRES_DSN")})
+		if err != nil {
+			return nil, fmt.Errorf("connecting: %w", err)
+		}
+		postgres := adapter.NewPostgresRepository(pool, nil)
+		if err := postgres.Migrate(); err != nil {
+			return nil, fmt.Errorf("migrating: %w", err)
+		}
+		repo = postgres
+	default:
+		return nil, fmt.Errorf("unknown repository %q", repoName)
+	}
+
+	signer, err := adapter.NewLedgerSigner(viper.GetString("LEDGER_SIGNING_KEY"), viper.GetStringSlice("LEDGER_RETIRED_KEYS"))
+	if err != nil {
+		return nil, err
+	}
+	application := app.NewApplication(repo, signer)
+	admins, _ := repo.(adminSetter)
+
+	return &session{
+		workspace: workspace,
+		// Mentions never reach Slack, so there are no credentials and replies
+		// are returned rather than queued.
+		consumer: port.NewSlackConsumer(application, nil, nil, nil, "", nil),
+		admins:   admins,
+		users:    newUserDirectory(),
+	}, nil
+}
+
+func (s *session) interactive(in io.Reader, out io.Writer) {
+	fmt.Fprintln(out, "yamex REPL, type `help` for help")
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(out, "yamex> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return
+		}
+		if err := s.exec(scanner.Text(), out); errors.Is(err, errQuit) {
+			return
+		} else if err != nil {
+			fmt.Fprintf(out, "error: %s\n", err)
+		}
+	}
+}
+
+// run executes a script, echoing each command so the output reads on its own.
+func (s *session) run(in io.Reader, out io.Writer) {
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fmt.Fprintf(out, "> %s\n", line)
+		if err := s.exec(line, out); errors.Is(err, errQuit) {
+			return
+		} else if err != nil {
+			fmt.Fprintf(out, "error: %s\n", err)
+		}
+		fmt.Fprintln(out)
+	}
+}
+
+var errQuit = errors.New("quit")
+
+func (s *session) exec(line string, out io.Writer) error {
+	line = strings.TrimSpace(line)
+	switch {
+	case line == "" || strings.HasPrefix(line, "#"):
+		return nil
+	case line == "help":
+		fmt.Fprintln(out, help)
+		return nil
+	case line == "quit" || line == "exit":
+		return errQuit
+	case strings.HasPrefix(line, "admin "):
+		if s.admins == nil {
+			return errors.New("this repository doesn't support making admins")
+		}
+		name := strings.TrimSpace(strings.TrimPrefix(line, "admin "))
+		if err := s.admins.SetAdmin(context.Background(), s.users.id(name), true); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%s is now an admin\n", name)
+		return nil
+	case strings.HasPrefix(line, "as "):
+		parts := strings.SplitN(strings.TrimPrefix(line, "as "), ":", 2)
+		if len(parts) != 2 {
+			return errors.New("expected `as @user: <message>`")
+		}
+		user := s.users.id(strings.TrimSpace(parts[0]))
+		response := s.consumer.ProcessAppMention(context.Background(), &port.BotMention{
+			Platform:    "repl",
+			WorkspaceID: s.workspace,
+			UserID:      user,
+			Text:        s.users.encode(strings.TrimSpace(parts[1])),
+		})
+		s.print(response, out)
+		return nil
+	default:
+		return errors.New("unknown command, type `help` for help")
+	}
+}
+
+func (s *session) print(response port.BotResponse, out io.Writer) {
+	if response.Ephemeral {
+		fmt.Fprintln(out, "(only visible to you)")
+	}
+	if response.Text != "" {
+		fmt.Fprintln(out, s.users.decode(response.Text))
+	}
+	if len(response.Blocks) > 0 {
+		fmt.Fprint(out, s.users.decode(renderBlocks(response.Blocks)))
+	}
+}
+
+// firstDifference returns the first line number where the texts differ.
+func firstDifference(want, got string) (int, bool) {
+	if want == got {
+		return 0, true
+	}
+	wantLines, gotLines := strings.Split(want, "\n"), strings.Split(got, "\n")
+	for i := range wantLines {
+		if i >= len(gotLines) || wantLines[i] != gotLines[i] {
+			return i + 1, false
+		}
+	}
+	return len(wantLines) + 1, false
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files with the scripts' output")
+
+// TestScripts runs every testdata/*.txt script against the memory repository
+// and compares its output with the .golden file next to it.
+func TestScripts(t *testing.T) {
+	log.Logger = log.Output(ioutil.Discard).Level(zerolog.Disabled)
+
+	scripts, err := filepath.Glob(filepath.Join("testdata", "*.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scripts) == 0 {
+		t.Fatal("no scripts in testdata")
+	}
+
+	for _, script := range scripts {
+		script := script
+		t.Run(filepath.Base(script), func(t *testing.T) {
+			in, err := os.Open(script)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer in.Close()
+
+			session, err := newSession("memory", "TREPL000000")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			session.run(in, &out)
+
+			golden := strings.TrimSuffix(script, ".txt") + ".golden"
+			if *update {
+				if err := ioutil.WriteFile(golden, out.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line, ok := firstDifference(string(want), out.String()); !ok {
+				t.Errorf("output differs from %s at line %d, rerun with -update to accept it:\n%s", golden, line, out.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+// renderBlocks draws Block Kit blocks as indented text, one block per
+// paragraph. It works from the blocks' JSON so any block type renders.
+func renderBlocks(blocks []slack.Block) string {
+	var b strings.Builder
+	for _, block := range blocks {
+		var fields map[string]interface{}
+		encoded, err := json.Marshal(block)
+		if err == nil {
+			err = json.Unmarshal(encoded, &fields)
+		}
+		if err != nil {
+			fmt.Fprintf(&b, "[%s: %s]\n", block.BlockType(), err)
+			continue
+		}
+
+		header := fmt.Sprintf("[%s", fields["type"])
+		if label := text(fields["label"]); label != "" {
+			header += " " + label
+		}
+		fmt.Fprintln(&b, header+"]")
+		for _, line := range blockLines(fields) {
+			fmt.Fprintf(&b, "  %s\n", line)
+		}
+	}
+	return b.String()
+}
+
+func blockLines(fields map[string]interface{}) []string {
+	var lines []string
+	if t := text(fields["text"]); t != "" {
+		lines = append(lines, strings.Split(t, "\n")...)
+	}
+	for _, key := range []string{"fields", "elements"} {
+		if items, ok := fields[key].([]interface{}); ok {
+			for _, item := range items {
+				lines = append(lines, elementLine(item))
+			}
+		}
+	}
+	for _, key := range []string{"element", "accessory"} {
+		if item, ok := fields[key]; ok {
+			lines = append(lines, elementLine(item))
+		}
+	}
+	return lines
+}
+
+// elementLine shows text as is and interactive elements in brackets.
+func elementLine(item interface{}) string {
+	element, ok := item.(map[string]interface{})
+	if !ok {
+		return fmt.Sprint(item)
+	}
+	t := text(element)
+	switch element["type"] {
+	case "plain_text", "mrkdwn":
+		return t
+	}
+	parts := []string{fmt.Sprint(element["type"])}
+	if id, ok := element["action_id"].(string); ok {
+		parts = append(parts, id)
+	}
+	if t != "" {
+		parts = append(parts, fmt.Sprintf("%q", t))
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
+
+// text extracts the text of a text object, which may be nested.
+func text(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case map[string]interface{}:
+		return text(t["text"])
+	}
+	return ""
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// botName is how the bot is mentioned in REPL input.
+const botName = "@yamex"
+
+var (
+	mentionExpression      = regexp.MustCompile(`@[A-Za-z0-9._-]+`)
+	slackMentionExpression = regexp.MustCompile(`<@([A-Z0-9]{11})>`)
+	nonAlphanumeric        = regexp.MustCompile(`[^A-Za-z0-9]`)
+)
+
+// userDirectory maps REPL names like @alice to Slack-looking user IDs, which
+// is what the mention expressions expect, and back again for output.
+type userDirectory struct {
+	ids   map[string]string
+	names map[string]string
+}
+
+func newUserDirectory() *userDirectory {
+	d := &userDirectory{ids: map[string]string{}, names: map[string]string{}}
+	d.id(botName)
+	return d
+}
+
+// id returns the user ID for a name, with or without the leading @.
+func (d *userDirectory) id(name string) string {
+	name = "@" + strings.TrimPrefix(name, "@")
+	if id, ok := d.ids[name]; ok {
+		return id
+	}
+
+	id := "U" + pad(strings.ToUpper(nonAlphanumeric.ReplaceAllString(name, "")))
+	if _, taken := d.names[id]; taken {
+		sum := sha256.Sum256([]byte(name))
+		id = "U" + strings.ToUpper(hex.EncodeToString(sum[:5]))
+	}
+	d.ids[name], d.names[id] = id, name
+	return id
+}
+
+// encode turns @names into Slack mentions.
+func (d *userDirectory) encode(text string) string {
+	return mentionExpression.ReplaceAllStringFunc(text, func(name string) string {
+		return fmt.Sprintf("<@%s>", d.id(name))
+	})
+}
+
+// decode turns Slack mentions, and bare IDs of known users, back into @names.
+func (d *userDirectory) decode(text string) string {
+	text = slackMentionExpression.ReplaceAllStringFunc(text, func(mention string) string {
+		if name, ok := d.names[mention[2:len(mention)-1]]; ok {
+			return name
+		}
+		return mention
+	})
+	for id, name := range d.names {
+		text = strings.ReplaceAll(text, id, name)
+	}
+	return text
+}
+
+func pad(s string) string {
+	if len(s) > 10 {
+		return s[:10]
+	}
+	return s + strings.Repeat("0", 10-len(s))
+}
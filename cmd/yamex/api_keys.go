@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/yammine/yamex-go/notabankbot/adapter"
+	"github.com/yammine/yamex-go/notabankbot/port"
+)
+
+const apiKeyUsage = "usage: yamex api-key create -workspace <team id> -name <name> [-scope read|transfer|admin] [-user <user id>] | list [-workspace <team id>] | revoke <prefix>"
+
+var apiKeyCommand = &command{
+	name:  "api-key",
+	usage: "create, list or revoke HTTP API keys",
+	run:   runAPIKey,
+}
+
+func runAPIKey(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New(apiKeyUsage)
+	}
+	store, err := newAPIStore()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "create":
+		return createAPIKey(ctx, store, args[1:])
+	case "list":
+		return listAPIKeys(ctx, store, args[1:])
+	case "revoke":
+		if len(args) != 2 {
+			return errors.New(apiKeyUsage)
+		}
+		if err := store.RevokeAPIKey(ctx, args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("Revoked %s\n", args[1])
+		return nil
+	default:
+		return errors.New(apiKeyUsage)
+	}
+}
+
+func createAPIKey(ctx context.Context, store *adapter.APIPostgres, args []string) error {
+	flags := flag.NewFlagSet("api-key create", flag.ExitOnError)
+	workspace := flags.String("workspace", "", "slack team ID the key can access")
+	name := flags.String("name", "", "who or what the key is for")
+	scopeName := flags.String("scope", string(port.ScopeRead), "read, transfer or admin, each includes the ones before it")
+	user := flags.String("user", "", "slack user ID whose funds a transfer key moves, admin keys can move anyone's")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *workspace == "" || *name == "" {
+		return errors.New(apiKeyUsage)
+	}
+	scope, err := port.ParseAPIScope(*scopeName)
+	if err != nil {
+		return err
+	}
+
+	secret, key, err := port.NewAPIKey(*workspace, *name, scope)
+	if err != nil {
+		return err
+	}
+	key.UserID = *user
+	if err := store.SaveAPIKey(ctx, key); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Created %s key %s for %s, it won't be shown again:\n", scope, key.Prefix, *workspace)
+	fmt.Println(secret)
+	return nil
+}
+
+func listAPIKeys(ctx context.Context, store *adapter.APIPostgres, args []string) error {
+	flags := flag.NewFlagSet("api-key list", flag.ExitOnError)
+	workspace := flags.String("workspace", "", "only list keys for this slack team ID")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	keys, err := store.ListAPIKeys(ctx, *workspace)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PREFIX\tWORKSPACE\tNAME\tSCOPE\tUSER\tCREATED\tLAST USED\tREVOKED")
+	for _, k := range keys {
+		user := k.UserID
+		if user == "" {
+			user = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			k.Prefix, k.WorkspaceID, k.Name, k.Scope, user, k.CreatedAt.Format(time.RFC3339), formatTime(k.LastUsedAt), formatTime(k.RevokedAt))
+	}
+	return w.Flush()
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+func newAPIStore() (*adapter.APIPostgres, error) {
+	pool, err := postgresPool()
+	if err != nil {
+		return nil, err
+	}
+	store := adapter.NewAPIPostgresRepository(pool)
+	if err := store.Migrate(); err != nil {
+		return nil, fmt.Errorf("migrating api keys: %w", err)
+	}
+	return store, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/yammine/yamex-go/notabankbot/domain"
+)
+
+const (
+	errChainBroken     = "ledger hash chain is broken"
+	errChainUnverified = "checkpoint signatures were not verified, set LEDGER_SIGNING_KEY"
+)
+
+var verifyChainCommand = &command{
+	name:  "verify-chain",
+	usage: "recompute the ledger hash chain and report the first broken link",
+	run:   runVerifyChain,
+}
+
+var verifyReceiptCommand = &command{
+	name:  "verify-receipt",
+	usage: "check a receipt offline against the ledger public key",
+	run:   runVerifyReceipt,
+}
+
+var ledgerKeygenCommand = &command{
+	name:  "ledger-keygen",
+	usage: "generate a ledger signing key",
+	run:   runLedgerKeygen,
+}
+
+func runVerifyChain(ctx context.Context, args []string) error {
+	application, err := newApplication(nil)
+	if err != nil {
+		return err
+	}
+
+	report, err := application.VerifyChain(ctx)
+	if err != nil {
+		return fmt.Errorf("verifying chain: %w", err)
+	}
+	fmt.Printf("Checked %d entries and %d checkpoints across %d workspaces\n", report.EntriesChecked, report.CheckpointsChecked, report.Workspaces)
+
+	for _, b := range report.Breaks {
+		fmt.Printf("workspace %q: first broken link at sequence %d (entry %d): %s\n", b.WorkspaceID, b.Sequence, b.EntryID, b.Problem)
+	}
+	if report.CheckpointsUnverified > 0 {
+		fmt.Printf("%d checkpoints could not be verified without a ledger signing key\n", report.CheckpointsUnverified)
+	}
+	switch {
+	case len(report.Breaks) > 0:
+		return errors.New(errChainBroken)
+	case !report.Verified():
+		return errors.New(errChainUnverified)
+	}
+	return nil
+}
+
+func runVerifyReceipt(_ context.Context, args []string) error {
+	flags := flag.NewFlagSet("verify-receipt", flag.ExitOnError)
+	encodedKey := flags.String("public-key", "", "base64 ledger public key, as served at /ledger/public-key")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	publicKey, err := base64.StdEncoding.DecodeString(*encodedKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("-public-key must be a base64 ed25519 public key")
+	}
+
+	in := io.Reader(os.Stdin)
+	if flags.NArg() > 0 {
+		f, err := os.Open(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	var receipt domain.Receipt
+	if err := json.NewDecoder(in).Decode(&receipt); err != nil {
+		return fmt.Errorf("decoding receipt: %w", err)
+	}
+	if receipt.KeyID != domain.KeyID(publicKey) {
+		return fmt.Errorf("receipt was signed with key %s, not %s", receipt.KeyID, domain.KeyID(publicKey))
+	}
+	if err := receipt.Verify(publicKey); err != nil {
+		return err
+	}
+
+	fmt.Printf("Receipt #%d is valid (workspace %q, sequence %d)\n", receipt.EntryID, receipt.WorkspaceID, receipt.Sequence)
+	return nil
+}
+
+func runLedgerKeygen(_ context.Context, _ []string) error {
+	seed := make([]byte, ed25519.SeedSize)
+	if _, err := rand.Read(seed); err != nil {
+		return err
+	}
+	key := ed25519.NewKeyFromSeed(seed)
+	publicKey := key.Public().(ed25519.PublicKey)
+
+	fmt.Printf("LEDGER_SIGNING_KEY: %q\n", base64.StdEncoding.EncodeToString(seed))
+	fmt.Printf("# public key (%s): %s\n", domain.KeyID(publicKey), base64.StdEncoding.EncodeToString(publicKey))
+	return nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/spf13/viper"
+
+	"github.com/yammine/yamex-go/notabankbot/adapter"
+	"github.com/yammine/yamex-go/notabankbot/app"
+	"github.com/yammine/yamex-go/notabankbot/port"
+)
+
+const integrationUsage = "usage: yamex integration create -name <name> -workspace <team id> -treasury <user id> -recipient <expr> -currency <expr> [-mode grant|transfer -amount <expr> -note <expr> -when <expr>] | list [-workspace <team id>] | disable <name> | dry-run <name> <payload.json>"
+
+var integrationCommand = &command{
+	name:  "integration",
+	usage: "create, list or disable inbound webhook integrations, or dry-run a payload",
+	run:   runIntegration,
+}
+
+func runIntegration(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New(integrationUsage)
+	}
+	store, err := newIntegrationStore()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "create":
+		return createIntegration(ctx, store, args[1:])
+	case "list":
+		return listIntegrations(ctx, store, args[1:])
+	case "disable":
+		if len(args) != 2 {
+			return errors.New(integrationUsage)
+		}
+		if err := store.DisableIntegration(ctx, args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("Disabled %s\n", args[1])
+		return nil
+	case "dry-run":
+		if len(args) != 3 {
+			return errors.New(integrationUsage)
+		}
+		return dryRunIntegration(ctx, store, args[1], args[2])
+	default:
+		return errors.New(integrationUsage)
+	}
+}
+
+func createIntegration(ctx context.Context, store *adapter.IntegrationPostgres, args []string) error {
+	flags := flag.NewFlagSet("integration create", flag.ExitOnError)
+	integration := &port.Integration{}
+	flags.StringVar(&integration.Name, "name", "", "name in the hook's URL, /hooks/<name>")
+	flags.StringVar(&integration.WorkspaceID, "workspace", "", "slack team ID awards are made in")
+	flags.StringVar(&integration.TreasuryID, "treasury", "", "slack user ID awards come from, grants need an admin")
+	mode := flags.String("mode", string(port.IntegrationGrant), "grant the grant policy's amount, or transfer from the treasury's balance")
+	flags.StringVar(&integration.Recipient, "recipient", "", "JSONPath to the recipient's slack user ID, e.g. $.user.slack_id")
+	flags.StringVar(&integration.Currency, "currency", "", "currency, or a JSONPath to it")
+	flags.StringVar(&integration.Amount, "amount", "", "JSONPath to the amount, transfers only")
+	flags.StringVar(&integration.Note, "note", "", "note, {$.path} placeholders are filled from the payload")
+	flags.StringVar(&integration.When, "when", "", "JSONPath that must pick a truthy value, or the payload is skipped")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	integration.Mode = port.IntegrationMode(*mode)
+	if err := port.NewIntegration(integration); err != nil {
+		return err
+	}
+	if err := store.SaveIntegration(ctx, integration); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Created %s, POST payloads to /hooks/%s signed with this secret, keep it safe:\n", integration.Name, integration.Name)
+	fmt.Println(integration.Secret)
+	return nil
+}
+
+func listIntegrations(ctx context.Context, store *adapter.IntegrationPostgres, args []string) error {
+	flags := flag.NewFlagSet("integration list", flag.ExitOnError)
+	workspace := flags.String("workspace", "", "only list integrations for this slack team ID")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	integrations, err := store.ListIntegrations(ctx, *workspace)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tWORKSPACE\tMODE\tTREASURY\tRECIPIENT\tCURRENCY\tAMOUNT\tCREATED\tDISABLED")
+	for _, i := range integrations {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			i.Name, i.WorkspaceID, i.Mode, i.TreasuryID, i.Recipient, i.Currency, i.Amount, i.CreatedAt.Format(time.RFC3339), formatTime(i.DisabledAt))
+	}
+	return w.Flush()
+}
+
+// dryRunIntegration shows what a payload would award, without checking its
+// signature or moving anything.
+func dryRunIntegration(ctx context.Context, store *adapter.IntegrationPostgres, name, path string) error {
+	integration, err := store.GetIntegration(ctx, name)
+	if err != nil {
+		return err
+	}
+	payload, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	grantAmount := app.DefaultGrantPolicy().Amount
+	if s := viper.GetString("GRANT_AMOUNT"); s != "" {
+		if grantAmount, err = decimal.NewFromString(s); err != nil {
+			return fmt.Errorf("GRANT_AMOUNT: %w", err)
+		}
+	}
+
+	award, err := integration.MapAward(payload, grantAmount)
+	if err != nil {
+		return err
+	}
+	if award == nil {
+		fmt.Println("Skipped, the when condition isn't met")
+		return nil
+	}
+	out := json.NewEncoder(os.Stdout)
+	out.SetIndent("", "  ")
+	return out.Encode(award)
+}
+
+func newIntegrationStore() (*adapter.IntegrationPostgres, error) {
+	keyring, err := loadTokenKeyring()
+	if err != nil {
+		return nil, err
+	}
+	pool, err := postgresPool()
+	if err != nil {
+		return nil, err
+	}
+	store := adapter.NewIntegrationPostgresRepository(pool, keyring)
+	if err := store.Migrate(); err != nil {
+		return nil, fmt.Errorf("migrating integrations: %w", err)
+	}
+	return store, nil
+}
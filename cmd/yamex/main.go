@@ -0,0 +1,151 @@
+// Command yamex bundles the operational tasks that run outside the server.
+//
+// Usage:
+//
+//	yamex verify [-fix -reason "..."]
+//	yamex verify-chain
+//	yamex verify-receipt -public-key <base64> [receipt.json]
+//	yamex ledger-keygen
+//	yamex snapshot [-through YYYY-MM-DD]
+//	yamex rotate-keys
+//	yamex replay [-dsn <scratch dsn>] <recording.jsonl>
+//	yamex api-key create -workspace <team id> -name <name> [-scope read|transfer|admin] [-user <user id>]
+//	yamex api-key list [-workspace <team id>]
+//	yamex api-key revoke <prefix>
+//	yamex webhook create -workspace <team id> -url <url> -events <type,...> [-currency <currency> -threshold <amount>]
+//	yamex webhook list [-workspace <team id>]
+//	yamex webhook deliveries [-limit n] <id>
+//	yamex webhook test <id>
+//	yamex webhook disable <id>
+//	yamex integration create -name <name> -workspace <team id> -treasury <user id> -recipient <expr> -currency <expr> [-mode grant|transfer -amount <expr> -note <expr> -when <expr>]
+//	yamex integration list [-workspace <team id>]
+//	yamex integration disable <name>
+//	yamex integration dry-run <name> <payload.json>
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+
+	"github.com/yammine/yamex-go"
+	"github.com/yammine/yamex-go/notabankbot/adapter"
+	"github.com/yammine/yamex-go/notabankbot/app"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, args []string) error
+}
+
+var commands = []*command{
+	verifyCommand,
+	verifyChainCommand,
+	verifyReceiptCommand,
+	ledgerKeygenCommand,
+	snapshotCommand,
+	rotateKeysCommand,
+	replayCommand,
+	apiKeyCommand,
+	webhookCommand,
+	integrationCommand,
+}
+
+func main() {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: yamex.NewRedactingWriter(os.Stderr)})
+
+	viper.AutomaticEnv()
+	viper.SetConfigName("config")
+	viper.SetConfigType("yml")
+	viper.AddConfigPath(".")
+	if err := viper.ReadInConfig(); err != nil {
+		log.Debug().Err(err).Msg("viper couldn't find config.yml, falling back to ENV config")
+	}
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != os.Args[1] {
+			continue
+		}
+		if err := cmd.run(context.Background(), os.Args[2:]); err != nil {
+			log.Error().Err(err).Str("command", cmd.name).Msg("command failed")
+			os.Exit(1)
+		}
+		return
+	}
+
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: yamex <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", cmd.name, cmd.usage)
+	}
+}
+
+var pool *adapter.PostgresPool
+
+// postgresPool connects on first use, so commands needing both the ledger and
+// credentials share a pool.
+func postgresPool() (*adapter.PostgresPool, error) {
+	if pool != nil {
+		return pool, nil
+	}
+	var err error
+	pool, err = adapter.OpenPostgresPool(context.Background(), adapter.PostgresPoolConfig{DSN: viper.GetString("POSTGRES_DSN")})
+	return pool, err
+}
+
+// newApplication only needs a keyring for commands handling webhook endpoint
+// secrets, others pass nil.
+func newApplication(keyring *adapter.TokenKeyring) (*app.Application, error) {
+	signer, err := adapter.NewLedgerSigner(viper.GetString("LEDGER_SIGNING_KEY"), viper.GetStringSlice("LEDGER_RETIRED_KEYS"))
+	if err != nil {
+		return nil, err
+	}
+	pool, err := postgresPool()
+	if err != nil {
+		return nil, err
+	}
+	repo := adapter.NewPostgresRepository(pool, keyring)
+
+	return app.NewApplication(repo, signer), nil
+}
+
+func loadTokenKeyring() (*adapter.TokenKeyring, error) {
+	return adapter.LoadTokenKeyring(
+		viper.GetString("SLACK_TOKEN_ACTIVE_KEY"),
+		viper.GetString("SLACK_TOKEN_KEYS_FILE"),
+		viper.GetStringMapString("SLACK_TOKEN_KEYS"),
+	)
+}
+
+func newCredentialStore() (*adapter.SlackCredentialPostgres, error) {
+	keyring, err := loadTokenKeyring()
+	if err != nil {
+		return nil, err
+	}
+	pool, err := postgresPool()
+	if err != nil {
+		return nil, err
+	}
+
+	// Commands are short lived, so the cache only needs to last the run.
+	return adapter.NewSlackCredentialPostgresRepository(pool, keyring, adapter.CredentialCacheConfig{
+		TTL:         time.Hour,
+		NegativeTTL: time.Hour,
+	}), nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/yammine/yamex-go/notabankbot/adapter"
+	"github.com/yammine/yamex-go/notabankbot/app"
+	"github.com/yammine/yamex-go/notabankbot/port"
+	"github.com/yammine/yamex-go/notabankbot/slackfake"
+)
+
+// replayBotUserID is the bot's user ID in every replayed workspace.
+const replayBotUserID = "UREPLAYBOT0"
+
+var replayCommand = &command{
+	name:  "replay",
+	usage: "feed a recording of slack requests through the router against a scratch ledger",
+	run:   runReplay,
+}
+
+func runReplay(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	dsn := flags.String("dsn", "", "scratch postgres database to replay against, defaults to an in-memory ledger")
+	wait := flags.Duration("wait", time.Second, "how long to wait for the bot to reply to each request")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: yamex replay [-dsn <scratch dsn>] <recording.jsonl>")
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	requests, err := port.ReadRecording(f)
+	if err != nil {
+		return fmt.Errorf("reading recording: %w", err)
+	}
+
+	var repo app.Repository = adapter.NewMemoryRepository()
+	if *dsn != "" {
+		if *dsn == viper.GetString("POSTGRES_DSN") {
+			return errors.New("refusing to replay against POSTGRES_DSN, use a scratch database")
+		}
+		pool, err := adapter.OpenPostgresPool(ctx, adapter.PostgresPoolConfig{DSN: *dsn})
+		if err != nil {
+			return fmt.Errorf("connecting to scratch database: %w", err)
+		}
+		defer pool.Close()
+		postgres := adapter.NewPostgresRepository(pool, nil)
+		if err := postgres.Migrate(); err != nil {
+			return fmt.Errorf("migrating scratch database: %w", err)
+		}
+		repo = postgres
+	}
+	signer, err := adapter.NewLedgerSigner(viper.GetString("LEDGER_SIGNING_KEY"), viper.GetStringSlice("LEDGER_RETIRED_KEYS"))
+	if err != nil {
+		return err
+	}
+	application := app.NewApplication(repo, signer)
+
+	// Replies go to a fake Slack, never the real one.
+	fake := slackfake.NewServer()
+	defer fake.Close()
+	secret, err := replaySecret()
+	if err != nil {
+		return err
+	}
+
+	credentials := replayCredentials{}
+	dispatcher := port.NewSlackDispatcher(application, credentials, port.NewSlackClientFactory(fake.APIURL()), nil)
+	dispatchCtx, stopDispatcher := context.WithCancel(ctx)
+	defer stopDispatcher()
+	go dispatcher.Run(dispatchCtx, *wait)
+	router := port.NewRouter(port.Handlers{
+		App:        application,
+		Consumer:   port.NewSlackConsumer(application, credentials, dispatcher, nil, secret, nil),
+		Interactor: port.NewSlackInteractor(application, dispatcher, secret, nil),
+	})
+
+	for i, recorded := range requests {
+		body := strings.NewReplacer(
+			port.RedactedResponseURL, fake.ResponseURL(fmt.Sprint(i+1)),
+			url.QueryEscape(port.RedactedResponseURL), url.QueryEscape(fake.ResponseURL(fmt.Sprint(i+1))),
+		).Replace(recorded.Body)
+
+		req := httptest.NewRequest(http.MethodPost, recorded.Path, strings.NewReader(body))
+		req.Header.Set("Content-Type", recorded.ContentType)
+		slackfake.Sign(req, secret, []byte(body))
+
+		seen := len(fake.Calls(""))
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+
+		fmt.Printf("#%d %s %s (recorded %s)\n", i+1, recorded.Path, http.StatusText(res.Code), recorded.RecordedAt.Format(time.RFC3339))
+		calls, _ := fake.WaitForCalls("", seen+1, *wait)
+		for _, call := range calls[seen:] {
+			fmt.Printf("  %s\n", describeCall(call))
+		}
+	}
+
+	return nil
+}
+
+// describeCall summarises what the bot sent to Slack.
+func describeCall(call slackfake.Call) string {
+	text, target := call.Params.Get("text"), call.Params.Get("channel")
+	if call.Method == "response_url" {
+		var msg struct {
+			Text         string `json:"text"`
+			ResponseType string `json:"response_type"`
+		}
+		call.JSON(&msg)
+		text, target = msg.Text, msg.ResponseType
+	}
+	if blocks := call.Params.Get("blocks"); blocks != "" && text == "" {
+		text = blocks
+	}
+
+	return fmt.Sprintf("%s %s: %s", call.Method, target, strings.ReplaceAll(text, "\n", "\n    "))
+}
+
+func replaySecret() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// replayCredentials treats every workspace as installed.
+type replayCredentials struct{}
+
+func (replayCredentials) SaveCredentials(ctx context.Context, installation *port.SlackInstallation) error {
+	return nil
+}
+
+func (replayCredentials) GetCredentials(ctx context.Context, workspaceID string) (*port.SlackInstallation, error) {
+	return &port.SlackInstallation{TeamID: workspaceID, BotUserID: replayBotUserID, Token: "xoxb-replay"}, nil
+}
+
+func (replayCredentials) RevokeCredentials(ctx context.Context, workspaceID string) error {
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/yammine/yamex-go/notabankbot/adapter"
+)
+
+var rotateKeysCommand = &command{
+	name:  "rotate-keys",
+	usage: "re-encrypt all stored Slack tokens, integration and webhook secrets with the active key",
+	run:   runRotateKeys,
+}
+
+func runRotateKeys(ctx context.Context, _ []string) error {
+	store, err := newCredentialStore()
+	if err != nil {
+		return err
+	}
+	if err := store.Migrate(); err != nil {
+		return fmt.Errorf("migrating credentials: %w", err)
+	}
+
+	rotated, err := store.RotateKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("rotated %d tokens before failing: %w", rotated, err)
+	}
+
+	fmt.Printf("Re-encrypted %d tokens\n", rotated)
+
+	integrations, err := newIntegrationStore()
+	if err != nil {
+		return err
+	}
+	rotated, err = integrations.RotateKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("rotated %d integration secrets before failing: %w", rotated, err)
+	}
+
+	fmt.Printf("Re-encrypted %d integration secrets\n", rotated)
+
+	keyring, err := loadTokenKeyring()
+	if err != nil {
+		return err
+	}
+	pool, err := postgresPool()
+	if err != nil {
+		return err
+	}
+	repo := adapter.NewPostgresRepository(pool, keyring)
+	if err := repo.Migrate(); err != nil {
+		return fmt.Errorf("migrating database: %w", err)
+	}
+	rotated, err = repo.RotateWebhookSecrets(ctx)
+	if err != nil {
+		return fmt.Errorf("rotated %d webhook secrets before failing: %w", rotated, err)
+	}
+
+	fmt.Printf("Re-encrypted %d webhook secrets\n", rotated)
+	return nil
+}
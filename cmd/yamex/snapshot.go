@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/yammine/yamex-go/notabankbot/domain"
+)
+
+var snapshotCommand = &command{
+	name:  "snapshot",
+	usage: "backfill daily balance snapshots",
+	run:   runSnapshot,
+}
+
+func runSnapshot(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	defaultThrough := time.Now().UTC().AddDate(0, 0, -1).Format(domain.SnapshotDateLayout)
+	through := flags.String("through", defaultThrough, "last day to snapshot (YYYY-MM-DD), defaults to yesterday")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	day, err := time.Parse(domain.SnapshotDateLayout, *through)
+	if err != nil {
+		return fmt.Errorf("-through: %w", err)
+	}
+	if !domain.EndOfDay(day).Before(time.Now()) {
+		return fmt.Errorf("-through must be a day that has already ended")
+	}
+
+	application, err := newApplication(nil)
+	if err != nil {
+		return err
+	}
+	days, err := application.SnapshotBalances(ctx, day)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Snapshotted %d days through %s\n", days, *through)
+	return nil
+}
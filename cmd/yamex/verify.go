@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/spf13/viper"
+
+	"github.com/yammine/yamex-go/notabankbot/app"
+	"github.com/yammine/yamex-go/notabankbot/port"
+)
+
+const errLedgerUnhealthy = "ledger has discrepancies"
+
+var verifyCommand = &command{
+	name:  "verify",
+	usage: "recompute balances from movements and report discrepancies",
+	run:   runVerify,
+}
+
+func runVerify(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	fix := flags.Bool("fix", false, "write adjustment movements for drifting accounts")
+	reason := flags.String("reason", "", "audit reason recorded on adjustment movements (required with -fix)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	application, err := newApplication(nil)
+	if err != nil {
+		return err
+	}
+	credentials, err := newCredentialStore()
+	if err != nil {
+		return err
+	}
+	slackOAuth := port.NewSlackOAuthClient(viper.GetString("SLACK_CLIENT_ID"), viper.GetString("SLACK_CLIENT_SECRET"), viper.GetString("SLACK_API_URL"))
+	reconciler := port.NewReconciler(application, port.NewRefreshingCredentialStore(credentials, slackOAuth), port.NewSlackClientFactory(viper.GetString("SLACK_API_URL")), port.AdminChannel{
+		TeamID:    viper.GetString("ADMIN_SLACK_TEAM_ID"),
+		ChannelID: viper.GetString("ADMIN_SLACK_CHANNEL_ID"),
+	})
+
+	report, err := reconciler.Verify(ctx, &app.ReconcileInput{Correct: *fix, Reason: *reason})
+	if err != nil {
+		return fmt.Errorf("verifying ledger: %w", err)
+	}
+	fmt.Print(port.RenderReconciliationReport(report))
+
+	if !report.Healthy() {
+		return errors.New(errLedgerUnhealthy)
+	}
+	return nil
+}
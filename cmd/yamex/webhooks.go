@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/yammine/yamex-go/notabankbot/app"
+	"github.com/yammine/yamex-go/notabankbot/domain"
+	"github.com/yammine/yamex-go/notabankbot/port"
+)
+
+const webhookUsage = "usage: yamex webhook create -workspace <team id> -url <url> -events <type,...> [-currency <currency> -threshold <amount>] | list [-workspace <team id>] | deliveries [-limit n] <id> | test <id> | disable <id>"
+
+var webhookCommand = &command{
+	name:  "webhook",
+	usage: "create, list, test or disable webhook endpoints, or show their deliveries",
+	run:   runWebhook,
+}
+
+func runWebhook(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New(webhookUsage)
+	}
+	keyring, err := loadTokenKeyring()
+	if err != nil {
+		return err
+	}
+	application, err := newApplication(keyring)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "create":
+		return createWebhook(ctx, application, args[1:])
+	case "list":
+		return listWebhooks(ctx, application, args[1:])
+	case "deliveries":
+		return listWebhookDeliveries(ctx, application, args[1:])
+	case "test":
+		id, err := webhookID(args[1:])
+		if err != nil {
+			return err
+		}
+		delivery, err := port.NewWebhookDispatcher(application).Test(ctx, id)
+		if err != nil {
+			return err
+		}
+		if delivery.DeliveredAt == nil {
+			return fmt.Errorf("test delivery %d failed: %s", delivery.ID, delivery.LastError)
+		}
+		fmt.Printf("Delivered test event %d, endpoint answered %d\n", delivery.ID, delivery.LastStatus)
+		return nil
+	case "disable":
+		id, err := webhookID(args[1:])
+		if err != nil {
+			return err
+		}
+		if _, err := application.DisableWebhookEndpoint(ctx, id); err != nil {
+			return err
+		}
+		fmt.Printf("Disabled %d\n", id)
+		return nil
+	default:
+		return errors.New(webhookUsage)
+	}
+}
+
+func createWebhook(ctx context.Context, application *app.Application, args []string) error {
+	flags := flag.NewFlagSet("webhook create", flag.ExitOnError)
+	workspace := flags.String("workspace", "", "slack team ID whose events are sent")
+	url := flags.String("url", "", "where events are POSTed")
+	eventNames := flags.String("events", "", "comma separated: grant.created, transfer.completed, currency.created, account.threshold_reached")
+	currency := flags.String("currency", "", "currency account.threshold_reached watches")
+	threshold := flags.String("threshold", "0", "balance account.threshold_reached is sent at")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *workspace == "" || *url == "" || *eventNames == "" {
+		return errors.New(webhookUsage)
+	}
+	events, err := domain.ParseWebhookEvents(*eventNames)
+	if err != nil {
+		return err
+	}
+	amount, err := decimal.NewFromString(*threshold)
+	if err != nil {
+		return fmt.Errorf("threshold: %w", err)
+	}
+
+	endpoint, err := application.CreateWebhookEndpoint(ctx, &app.CreateWebhookEndpointInput{
+		WorkspaceID:       *workspace,
+		URL:               *url,
+		Events:            events,
+		ThresholdCurrency: *currency,
+		ThresholdAmount:   amount,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Created endpoint %d for %s. Payloads are signed with this secret, keep it safe:\n", endpoint.ID, *workspace)
+	fmt.Println(endpoint.Secret)
+	return nil
+}
+
+func listWebhooks(ctx context.Context, application *app.Application, args []string) error {
+	flags := flag.NewFlagSet("webhook list", flag.ExitOnError)
+	workspace := flags.String("workspace", "", "only list endpoints for this slack team ID")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	endpoints, err := application.ListWebhookEndpoints(ctx, *workspace)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tWORKSPACE\tURL\tEVENTS\tTHRESHOLD\tCREATED\tDISABLED")
+	for _, e := range endpoints {
+		threshold := "-"
+		if e.ThresholdCurrency != "" {
+			threshold = e.ThresholdAmount.String() + " " + e.ThresholdCurrency
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			e.ID, e.WorkspaceID, e.URL, e.Events, threshold, e.CreatedAt.Format(time.RFC3339), formatTime(e.DisabledAt))
+	}
+	return w.Flush()
+}
+
+func listWebhookDeliveries(ctx context.Context, application *app.Application, args []string) error {
+	flags := flag.NewFlagSet("webhook deliveries", flag.ExitOnError)
+	limit := flags.Int("limit", 20, "how many of the latest deliveries to show")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	id, err := webhookID(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	deliveries, err := application.ListWebhookDeliveries(ctx, id, 0, *limit)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tEVENT\tCREATED\tSTATE\tATTEMPTS\tSTATUS\tERROR")
+	for _, d := range deliveries {
+		state := "pending"
+		switch {
+		case d.DeliveredAt != nil:
+			state = "delivered"
+		case d.FailedAt != nil:
+			state = "failed"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d\t%d\t%s\n",
+			d.ID, d.EventType, d.CreatedAt.Format(time.RFC3339), state, d.Attempts, d.LastStatus, d.LastError)
+	}
+	return w.Flush()
+}
+
+func webhookID(args []string) (uint, error) {
+	if len(args) != 1 {
+		return 0, errors.New(webhookUsage)
+	}
+	id, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return 0, errors.New(webhookUsage)
+	}
+	return uint(id), nil
+}
@@ -4,24 +4,39 @@ module github.com/yammine/yamex-go
 go 1.16
 
 require (
+	github.com/DATA-DOG/go-sqlmock v1.5.0
 	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/getkin/kin-openapi v0.110.0
 	github.com/gin-gonic/gin v1.7.2
 	github.com/go-playground/validator/v10 v10.7.0 // indirect
-	github.com/gorilla/mux v1.8.0 // indirect
+	github.com/gorilla/mux v1.8.0
+	github.com/gorilla/websocket v1.4.2
+	github.com/jackc/pgconn v1.8.1
 	github.com/jackc/pgtype v1.8.0 // indirect
+	github.com/jackc/pgx/v4 v4.11.0
 	github.com/jdkato/prose/v2 v2.0.0 // indirect
 	github.com/leodido/go-urn v1.2.1 // indirect
 	github.com/mattn/go-isatty v0.0.13 // indirect
-	github.com/olekukonko/tablewriter v0.0.5 // indirect
-	github.com/rs/zerolog v1.23.0 // indirect
+	github.com/olekukonko/tablewriter v0.0.5
+	github.com/prometheus/client_golang v1.11.0
+	github.com/rs/zerolog v1.23.0
 	github.com/shopspring/decimal v1.2.0
-	github.com/slack-go/slack v0.9.4 // indirect
+	github.com/slack-go/slack v0.9.4
 	github.com/spf13/viper v1.8.1
 	github.com/ugorji/go v1.2.6 // indirect
+	go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux v0.24.0
+	go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp v0.24.0
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.0.0
+	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.0.0
+	go.opentelemetry.io/otel/sdk v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
 	golang.org/x/crypto v0.0.0-20210711020723-a769d52b0f97 // indirect
+	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
 	golang.org/x/sys v0.0.0-20210630005230-0f9fa26af87c // indirect
 	golang.org/x/text v0.3.6 // indirect
-	google.golang.org/protobuf v1.27.1 // indirect
+	google.golang.org/grpc v1.40.0
+	google.golang.org/protobuf v1.27.1
 	gorm.io/driver/postgres v1.1.0
 	gorm.io/gorm v1.21.12
 )
@@ -0,0 +1,80 @@
+package adapter
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/yammine/yamex-go/notabankbot/port"
+)
+
+// APIKeyMemory keeps API keys in memory, for in-process harnesses alongside
+// MemoryRepository.
+type APIKeyMemory struct {
+	mu   sync.Mutex
+	keys map[string]*port.APIKey
+}
+
+func NewAPIKeyMemory() *APIKeyMemory {
+	return &APIKeyMemory{keys: map[string]*port.APIKey{}}
+}
+
+func (a *APIKeyMemory) SaveAPIKey(ctx context.Context, key *port.APIKey) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	saved := *key
+	a.keys[key.Prefix] = &saved
+	return nil
+}
+
+func (a *APIKeyMemory) GetAPIKey(ctx context.Context, prefix string) (*port.APIKey, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key, ok := a.keys[prefix]
+	if !ok || key.RevokedAt != nil {
+		return nil, port.ErrUnknownAPIKey
+	}
+	found := *key
+	return &found, nil
+}
+
+func (a *APIKeyMemory) ListAPIKeys(ctx context.Context, workspaceID string) ([]*port.APIKey, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var keys []*port.APIKey
+	for _, key := range a.keys {
+		if workspaceID == "" || key.WorkspaceID == workspaceID {
+			found := *key
+			keys = append(keys, &found)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+func (a *APIKeyMemory) RevokeAPIKey(ctx context.Context, prefix string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key, ok := a.keys[prefix]
+	if !ok || key.RevokedAt != nil {
+		return port.ErrUnknownAPIKey
+	}
+	now := time.Now()
+	key.RevokedAt = &now
+	return nil
+}
+
+func (a *APIKeyMemory) TouchAPIKey(ctx context.Context, prefix string, usedAt time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if key, ok := a.keys[prefix]; ok {
+		key.LastUsedAt = &usedAt
+	}
+	return nil
+}
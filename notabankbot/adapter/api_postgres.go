@@ -0,0 +1,203 @@
+package adapter
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/yammine/yamex-go/notabankbot/port"
+)
+
+// APIKey is a port.APIKey at rest, only the hash of its secret is stored.
+type APIKey struct {
+	Prefix      string `gorm:"primarykey"`
+	WorkspaceID string `gorm:"index"`
+	Name        string
+	Scope       string
+	UserID      string
+	SecretHash  string
+	CreatedAt   time.Time
+	LastUsedAt  *time.Time
+	RevokedAt   *time.Time
+}
+
+// IdempotencyKey remembers the outcome of a write made with an
+// Idempotency-Key, so a retry gets the same response.
+type IdempotencyKey struct {
+	APIKeyPrefix string `gorm:"primarykey"`
+	Key          string `gorm:"primarykey"`
+	Fingerprint  string
+	Status       int
+	Body         []byte
+	CreatedAt    time.Time `gorm:"index"`
+	CompletedAt  *time.Time
+}
+
+// APIPostgres stores API keys and idempotency keys for the HTTP API.
+type APIPostgres struct {
+	db *gorm.DB
+}
+
+func NewAPIPostgresRepository(pool *PostgresPool) *APIPostgres {
+	return &APIPostgres{db: pool.primary}
+}
+
+func (a *APIPostgres) Migrate() error {
+	return a.db.AutoMigrate(&APIKey{}, &IdempotencyKey{})
+}
+
+func (a *APIPostgres) SaveAPIKey(ctx context.Context, key *port.APIKey) error {
+	row := &APIKey{
+		Prefix:      key.Prefix,
+		WorkspaceID: key.WorkspaceID,
+		Name:        key.Name,
+		Scope:       string(key.Scope),
+		UserID:      key.UserID,
+		SecretHash:  key.SecretHash,
+		CreatedAt:   key.CreatedAt,
+	}
+	if err := a.db.WithContext(ctx).Create(row).Error; err != nil {
+		return fmt.Errorf("inserting API key: %w", err)
+	}
+	return nil
+}
+
+func (a *APIPostgres) GetAPIKey(ctx context.Context, prefix string) (*port.APIKey, error) {
+	var row APIKey
+	err := a.db.WithContext(ctx).Where("prefix = ? AND revoked_at IS NULL", prefix).Take(&row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, port.ErrUnknownAPIKey
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fetching API key: %w", err)
+	}
+	return row.toPort(), nil
+}
+
+func (a *APIPostgres) ListAPIKeys(ctx context.Context, workspaceID string) ([]*port.APIKey, error) {
+	var rows []*APIKey
+	query := a.db.WithContext(ctx).Order("created_at")
+	if workspaceID != "" {
+		query = query.Where("workspace_id = ?", workspaceID)
+	}
+	if err := query.Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("listing API keys: %w", err)
+	}
+
+	keys := make([]*port.APIKey, len(rows))
+	for i, row := range rows {
+		keys[i] = row.toPort()
+	}
+	return keys, nil
+}
+
+func (a *APIPostgres) RevokeAPIKey(ctx context.Context, prefix string) error {
+	result := a.db.WithContext(ctx).Model(&APIKey{}).
+		Where("prefix = ? AND revoked_at IS NULL", prefix).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return fmt.Errorf("revoking API key: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return port.ErrUnknownAPIKey
+	}
+	return nil
+}
+
+func (a *APIPostgres) TouchAPIKey(ctx context.Context, prefix string, usedAt time.Time) error {
+	err := a.db.WithContext(ctx).Model(&APIKey{}).Where("prefix = ?", prefix).Update("last_used_at", usedAt).Error
+	if err != nil {
+		return fmt.Errorf("touching API key: %w", err)
+	}
+	return nil
+}
+
+func (k APIKey) toPort() *port.APIKey {
+	return &port.APIKey{
+		Prefix:      k.Prefix,
+		WorkspaceID: k.WorkspaceID,
+		Name:        k.Name,
+		Scope:       port.APIScope(k.Scope),
+		UserID:      k.UserID,
+		SecretHash:  k.SecretHash,
+		CreatedAt:   k.CreatedAt,
+		LastUsedAt:  k.LastUsedAt,
+		RevokedAt:   k.RevokedAt,
+	}
+}
+
+// An expired key, or one whose claim outlived its lease without completing, is
+// claimed afresh, otherwise nothing is returned and the request already holding
+// it is read back.
+const beginRequestSQL = `
+INSERT INTO idempotency_keys AS k (api_key_prefix, key, fingerprint, status, created_at)
+VALUES (@prefix, @key, @fingerprint, 0, @now)
+ON CONFLICT (api_key_prefix, key) DO UPDATE SET
+	fingerprint = excluded.fingerprint,
+	status = 0,
+	body = NULL,
+	created_at = excluded.created_at,
+	completed_at = NULL
+WHERE k.created_at < @expired_before
+	OR (k.completed_at IS NULL AND k.created_at < @abandoned_before)
+RETURNING k.key`
+
+func (a *APIPostgres) BeginRequest(ctx context.Context, apiKey, key, fingerprint string, ttl, lease time.Duration) (*port.IdempotentRequest, error) {
+	var claimed string
+	now := time.Now()
+	err := a.db.WithContext(ctx).Raw(beginRequestSQL,
+		sql.Named("prefix", apiKey),
+		sql.Named("key", key),
+		sql.Named("fingerprint", fingerprint),
+		sql.Named("now", now),
+		sql.Named("expired_before", now.Add(-ttl)),
+		sql.Named("abandoned_before", now.Add(-lease)),
+	).Row().Scan(&claimed)
+	if err == nil {
+		return nil, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, fmt.Errorf("claiming idempotency key: %w", err)
+	}
+
+	var row IdempotencyKey
+	if err := a.db.WithContext(ctx).Where("api_key_prefix = ? AND key = ?", apiKey, key).Take(&row).Error; err != nil {
+		return nil, fmt.Errorf("fetching idempotency key: %w", err)
+	}
+	return &port.IdempotentRequest{
+		Fingerprint: row.Fingerprint,
+		Status:      row.Status,
+		Body:        row.Body,
+		CompletedAt: row.CompletedAt,
+	}, nil
+}
+
+func (a *APIPostgres) CompleteRequest(ctx context.Context, apiKey, key string, status int, body []byte) error {
+	err := a.db.WithContext(ctx).Model(&IdempotencyKey{}).
+		Where("api_key_prefix = ? AND key = ?", apiKey, key).
+		Updates(map[string]interface{}{"status": status, "body": body, "completed_at": time.Now()}).Error
+	if err != nil {
+		return fmt.Errorf("completing idempotency key: %w", err)
+	}
+	return nil
+}
+
+func (a *APIPostgres) ReleaseRequest(ctx context.Context, apiKey, key string) error {
+	err := a.db.WithContext(ctx).Where("api_key_prefix = ? AND key = ?", apiKey, key).Delete(&IdempotencyKey{}).Error
+	if err != nil {
+		return fmt.Errorf("releasing idempotency key: %w", err)
+	}
+	return nil
+}
+
+func (a *APIPostgres) PruneRequests(ctx context.Context, ttl time.Duration) (int64, error) {
+	result := a.db.WithContext(ctx).Where("created_at < ?", time.Now().Add(-ttl)).Delete(&IdempotencyKey{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("pruning idempotency keys: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
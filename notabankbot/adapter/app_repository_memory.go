@@ -0,0 +1,813 @@
+package adapter
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/yammine/yamex-go/notabankbot/app"
+	"github.com/yammine/yamex-go/notabankbot/domain"
+)
+
+// MemoryRepository keeps the ledger in memory. It is meant for tests and local
+// tooling, where running Postgres is overkill. IDs are assigned sequentially,
+// so a fresh repository always produces the same output for the same commands.
+type MemoryRepository struct {
+	mu sync.Mutex
+
+	users       []*domain.User
+	members     []*domain.WorkspaceUser
+	accounts    []*domain.Account
+	movements   []*domain.Movement
+	entries     []*domain.JournalEntry
+	grants      []*domain.Grant
+	heads       map[string]*domain.ChainHead
+	checkpoints []*domain.Checkpoint
+	snapshots   map[string]*domain.BalanceSnapshot
+	feedback    []*Feedback
+	outbox      []*domain.OutboxMessage
+	endpoints   []*domain.WebhookEndpoint
+	deliveries  []*domain.WebhookDelivery
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		heads:     map[string]*domain.ChainHead{},
+		snapshots: map[string]*domain.BalanceSnapshot{},
+	}
+}
+
+// SetAdmin makes the user an admin, creating them if needed.
+func (m *MemoryRepository) SetAdmin(ctx context.Context, slackUserID string, admin bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.userBySlackID(slackUserID).Admin = admin
+	return nil
+}
+
+func (m *MemoryRepository) GetOrCreateUserBySlackID(ctx context.Context, slackUserId string) (*domain.User, error) {
+	if slackUserId == "" {
+		return nil, app.ErrCannotFindOrCreateUser
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user := *m.userBySlackID(slackUserId)
+	return &user, nil
+}
+
+func (m *MemoryRepository) JoinWorkspace(ctx context.Context, workspaceID, slackUserID string) (*domain.User, error) {
+	if slackUserID == "" {
+		return nil, app.ErrCannotFindOrCreateUser
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user := *m.userBySlackID(slackUserID)
+	m.addWorkspaceUsers(workspaceID, &user)
+	return &user, nil
+}
+
+func (m *MemoryRepository) FindWorkspaceUser(ctx context.Context, workspaceID, slackUserID string) (*domain.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if u.SlackID != slackUserID {
+			continue
+		}
+		if !m.isMember(workspaceID, u.ID) {
+			return nil, app.ErrUserNotInWorkspace
+		}
+		user := *u
+		return &user, nil
+	}
+	return nil, app.ErrUnknownUser
+}
+
+func (m *MemoryRepository) GetAccountsForUser(ctx context.Context, id uint) ([]*domain.Account, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var accounts []*domain.Account
+	for _, a := range m.accounts {
+		if a.UserID == id {
+			account := *a
+			accounts = append(accounts, &account)
+		}
+	}
+	return accounts, nil
+}
+
+func (m *MemoryRepository) GetAccountsForUserAsOf(ctx context.Context, id uint, day time.Time) ([]*domain.Account, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	end := domain.EndOfDay(day)
+	var accounts []*domain.Account
+	for _, a := range m.accounts {
+		if a.UserID != id || !a.CreatedAt.Before(end) {
+			continue
+		}
+		account := *a
+		account.Balance = m.movementSum(a.ID, end)
+		accounts = append(accounts, &account)
+	}
+	return accounts, nil
+}
+
+func (m *MemoryRepository) GrantCurrency(ctx context.Context, in *app.GrantCurrencyInput, grantFn app.GrantFunc) (*domain.Grant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	from := *m.userByID(in.From.ID)
+	since := time.Now().Add(-in.Cooldown)
+	for _, g := range m.grants {
+		if g.FromUserID == from.ID && !g.CreatedAt.Before(since) {
+			grant := *g
+			from.RecentlyGivenGrants = append(from.RecentlyGivenGrants, &grant)
+		}
+	}
+	// Business logic works on copies, so nothing changes unless it succeeds.
+	issuer := *m.issuerAccount(in.Currency)
+	account := *m.account(in.To.ID, in.Currency)
+
+	issued := false
+	for _, mv := range m.movements {
+		issued = issued || mv.AccountID == issuer.ID && m.entries[mv.JournalEntryID-1].WorkspaceID == in.WorkspaceID
+	}
+
+	out, err := grantFn(ctx, &app.GrantCurrencyFuncIn{
+		From:          &from,
+		To:            in.To,
+		ToAccount:     &account,
+		IssuerAccount: &issuer,
+		FirstIssue:    !issued,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("business logic error: %w", err)
+	}
+	entry, err := m.createJournalEntry(in.WorkspaceID, out.IssuanceMovement, out.Movement)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.createReply(in.Reply, entry); err != nil {
+		return nil, err
+	}
+	m.saveAccounts(&issuer, &account)
+	m.addWorkspaceUsers(in.WorkspaceID, &from, in.To)
+
+	out.Grant.ID = uint(len(m.grants) + 1)
+	out.Grant.CreatedAt = time.Now()
+	out.Grant.MovementID = out.Movement.ID
+	m.grants = append(m.grants, out.Grant)
+	grant := *out.Grant
+	grant.Movement = *out.Movement
+	if err := m.createWebhookDeliveries(in.WorkspaceID, in.Events, entry, &grant); err != nil {
+		return nil, err
+	}
+
+	return &grant, nil
+}
+
+func (m *MemoryRepository) SendCurrency(ctx context.Context, in *app.SendCurrencyInput, sendFn app.SendFunc) (*domain.JournalEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sender := *m.account(in.From.ID, in.Currency)
+	receiver := *m.account(in.To.ID, in.Currency)
+
+	out, err := sendFn(ctx, &app.SendCurrencyFuncIn{
+		FromAccount: &sender,
+		ToAccount:   &receiver,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("business logic: %w", err)
+	}
+	entry, err := m.createJournalEntry(in.WorkspaceID, out.SendingMovement, out.ReceivingMovement)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.createReply(in.Reply, entry); err != nil {
+		return nil, err
+	}
+	if err := m.createWebhookDeliveries(in.WorkspaceID, in.Events, entry, nil); err != nil {
+		return nil, err
+	}
+	m.saveAccounts(&sender, &receiver)
+	m.addWorkspaceUsers(in.WorkspaceID, in.From, in.To)
+
+	return entry, nil
+}
+
+func (m *MemoryRepository) SaveFeedback(ctx context.Context, user *domain.User, feedback string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f := &Feedback{UserID: user.ID, Text: feedback}
+	f.ID = uint(len(m.feedback) + 1)
+	f.CreatedAt = time.Now()
+	m.feedback = append(m.feedback, f)
+	return nil
+}
+
+func (m *MemoryRepository) ListFeedback(ctx context.Context, beforeID uint, limit int) ([]*app.Feedback, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var feedback []*app.Feedback
+	for i := len(m.feedback) - 1; i >= 0 && len(feedback) < limit; i-- {
+		f := m.feedback[i]
+		if beforeID != 0 && f.ID >= beforeID {
+			continue
+		}
+		feedback = append(feedback, &app.Feedback{
+			ID:          f.ID,
+			CreatedAt:   f.CreatedAt,
+			SlackUserID: m.users[f.UserID-1].SlackID,
+			Text:        f.Text,
+		})
+	}
+	return feedback, nil
+}
+
+func (m *MemoryRepository) GetLedgerSummary(ctx context.Context) ([]*app.AccountLedgerSummary, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	summaries := make([]*app.AccountLedgerSummary, len(m.accounts))
+	for i, a := range m.accounts {
+		summaries[i] = &app.AccountLedgerSummary{
+			AccountID:      a.ID,
+			UserID:         a.UserID,
+			Currency:       a.Currency,
+			Balance:        a.Balance,
+			MovementSum:    m.movementSum(a.ID, time.Time{}),
+			AllowOverdraft: a.AllowOverdraft,
+		}
+	}
+	return summaries, nil
+}
+
+func (m *MemoryRepository) ReconcileAccount(ctx context.Context, in *app.ReconcileAccountInput, reconcileFn app.ReconcileFunc) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if int(in.AccountID) < 1 || int(in.AccountID) > len(m.accounts) {
+		return fmt.Errorf("get account exclusive: account %d not found", in.AccountID)
+	}
+	account := *m.accounts[in.AccountID-1]
+	issuer := *m.issuerAccount(account.Currency)
+
+	out, err := reconcileFn(ctx, &app.ReconcileAccountFuncIn{
+		Account:       &account,
+		IssuerAccount: &issuer,
+		MovementSum:   m.movementSum(account.ID, time.Time{}),
+	})
+	if err != nil {
+		return fmt.Errorf("business logic: %w", err)
+	}
+	if out.Adjustment == nil {
+		return nil
+	}
+	if _, err := m.createJournalEntry(domain.SystemWorkspaceID, out.Adjustment, out.IssuanceMovement); err != nil {
+		return err
+	}
+	m.saveAccounts(&issuer)
+
+	return nil
+}
+
+func (m *MemoryRepository) AdjustAccount(ctx context.Context, in *app.AdjustAccountInput, adjustFn app.AdjustFunc) (*domain.JournalEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	account := *m.account(in.User.ID, in.Currency)
+	issuer := *m.issuerAccount(in.Currency)
+
+	out, err := adjustFn(ctx, &app.AdjustAccountFuncIn{Account: &account, IssuerAccount: &issuer})
+	if err != nil {
+		return nil, fmt.Errorf("business logic: %w", err)
+	}
+	entry, err := m.createJournalEntry(domain.SystemWorkspaceID, out.Movements...)
+	if err != nil {
+		return nil, err
+	}
+	m.saveAccounts(&account, &issuer)
+
+	return entry, nil
+}
+
+func (m *MemoryRepository) GetJournalEntry(ctx context.Context, id uint) (*domain.JournalEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if int(id) < 1 || int(id) > len(m.entries) {
+		return nil, app.ErrReceiptNotFound
+	}
+	return copyEntry(m.entries[id-1]), nil
+}
+
+func (m *MemoryRepository) ListJournalEntries(ctx context.Context, workspaceID string, afterSequence uint64, limit int) ([]*domain.JournalEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var entries []*domain.JournalEntry
+	for _, e := range m.entries {
+		if e.WorkspaceID == workspaceID && e.Sequence > afterSequence && len(entries) < limit {
+			entries = append(entries, copyEntry(e))
+		}
+	}
+	return entries, nil
+}
+
+func (m *MemoryRepository) ListMovements(ctx context.Context, q *app.ListMovementsQuery) ([]*app.UserMovement, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Movement IDs are their position, so walk them in the order asked for.
+	next, step := len(m.movements)-1, -1
+	if q.AfterID != nil {
+		next, step = int(*q.AfterID), 1
+	}
+	var movements []*app.UserMovement
+	for i := next; i >= 0 && i < len(m.movements) && len(movements) < q.Limit; i += step {
+		mv := m.movements[i]
+		if q.BeforeID != 0 && mv.ID >= q.BeforeID {
+			continue
+		}
+		account := m.accounts[mv.AccountID-1]
+		if account.UserID != q.UserID || (q.Currency != "" && account.Currency != q.Currency) {
+			continue
+		}
+		if m.entries[mv.JournalEntryID-1].WorkspaceID != q.WorkspaceID {
+			continue
+		}
+		movements = append(movements, &app.UserMovement{
+			ID:             mv.ID,
+			CreatedAt:      mv.CreatedAt,
+			JournalEntryID: mv.JournalEntryID,
+			Currency:       account.Currency,
+			Amount:         mv.Amount,
+			Reason:         mv.Reason,
+		})
+	}
+	return movements, nil
+}
+
+func (m *MemoryRepository) ListCurrencies(ctx context.Context, workspaceID, after string, limit int) ([]*app.Currency, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var issuer *domain.User
+	for _, u := range m.users {
+		if u.SlackID == domain.IssuerSlackID {
+			issuer = u
+		}
+	}
+	byCode := map[string]*app.Currency{}
+	var currencies []*app.Currency
+	for _, mv := range m.movements {
+		a := m.accounts[mv.AccountID-1]
+		if issuer == nil || a.UserID != issuer.ID || a.Currency <= after || m.entries[mv.JournalEntryID-1].WorkspaceID != workspaceID {
+			continue
+		}
+		c, ok := byCode[a.Currency]
+		if !ok {
+			c = &app.Currency{Code: a.Currency}
+			byCode[a.Currency] = c
+			currencies = append(currencies, c)
+		}
+		c.Circulating = c.Circulating.Sub(mv.Amount)
+	}
+	sort.Slice(currencies, func(i, j int) bool { return currencies[i].Code < currencies[j].Code })
+	if len(currencies) > limit {
+		currencies = currencies[:limit]
+	}
+	return currencies, nil
+}
+
+func (m *MemoryRepository) ListChainHeads(ctx context.Context) ([]*domain.ChainHead, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	heads := make([]*domain.ChainHead, 0, len(m.heads))
+	for _, h := range m.heads {
+		head := *h
+		heads = append(heads, &head)
+	}
+	sort.Slice(heads, func(i, j int) bool { return heads[i].WorkspaceID < heads[j].WorkspaceID })
+	return heads, nil
+}
+
+func (m *MemoryRepository) ListCheckpoints(ctx context.Context, workspaceID string) ([]*domain.Checkpoint, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var checkpoints []*domain.Checkpoint
+	for _, c := range m.checkpoints {
+		if c.WorkspaceID == workspaceID {
+			checkpoint := *c
+			checkpoints = append(checkpoints, &checkpoint)
+		}
+	}
+	return checkpoints, nil
+}
+
+func (m *MemoryRepository) SaveCheckpoint(ctx context.Context, checkpoint *domain.Checkpoint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	checkpoint.ID = uint(len(m.checkpoints) + 1)
+	saved := *checkpoint
+	m.checkpoints = append(m.checkpoints, &saved)
+	return nil
+}
+
+func (m *MemoryRepository) GetLatestSnapshotDate(ctx context.Context) (*time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var latest *time.Time
+	for _, s := range m.snapshots {
+		if latest == nil || s.Date.After(*latest) {
+			date := s.Date
+			latest = &date
+		}
+	}
+	return latest, nil
+}
+
+func (m *MemoryRepository) GetFirstMovementDate(ctx context.Context) (*time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.movements) == 0 {
+		return nil, nil
+	}
+	first := m.movements[0].CreatedAt.UTC()
+	return &first, nil
+}
+
+func (m *MemoryRepository) CreateBalanceSnapshots(ctx context.Context, day time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	end := domain.EndOfDay(day)
+	date := end.AddDate(0, 0, -1)
+	var created int64
+	for _, a := range m.accounts {
+		key := fmt.Sprintf("%d/%s", a.ID, date.Format(domain.SnapshotDateLayout))
+		if _, ok := m.snapshots[key]; ok || !a.CreatedAt.Before(end) {
+			continue
+		}
+		m.snapshots[key] = &domain.BalanceSnapshot{AccountID: a.ID, Date: date, Balance: m.movementSum(a.ID, end), CreatedAt: time.Now()}
+		created++
+	}
+	return created, nil
+}
+
+var _ app.Repository = (*MemoryRepository)(nil)
+
+// The helpers below expect m.mu to be held.
+
+func (m *MemoryRepository) EnqueueOutboxMessage(ctx context.Context, msg *domain.OutboxMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.insertOutboxMessage(msg)
+	return nil
+}
+
+func (m *MemoryRepository) ClaimOutboxMessages(ctx context.Context, limit int, lease time.Duration) ([]*domain.OutboxMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var claimed []*domain.OutboxMessage
+	for _, msg := range m.outbox {
+		if len(claimed) == limit {
+			break
+		}
+		if !msg.Pending() || msg.NextAttemptAt.After(now) {
+			continue
+		}
+		msg.NextAttemptAt = now.Add(lease)
+		claim := *msg
+		claimed = append(claimed, &claim)
+	}
+
+	return claimed, nil
+}
+
+func (m *MemoryRepository) SaveOutboxMessage(ctx context.Context, msg *domain.OutboxMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if msg.ID == 0 || int(msg.ID) > len(m.outbox) {
+		m.insertOutboxMessage(msg)
+		return nil
+	}
+	saved := *msg
+	m.outbox[msg.ID-1] = &saved
+	return nil
+}
+
+func (m *MemoryRepository) CountPendingOutboxMessages(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var count int64
+	for _, msg := range m.outbox {
+		if msg.Pending() {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *MemoryRepository) insertOutboxMessage(msg *domain.OutboxMessage) {
+	msg.ID = uint(len(m.outbox) + 1)
+	msg.CreatedAt = time.Now()
+	saved := *msg
+	m.outbox = append(m.outbox, &saved)
+}
+
+// createReply queues the reply to a ledger change. Rendering a reply doesn't
+// fail in practice, so unlike Postgres the entry isn't rolled back if it does.
+func (m *MemoryRepository) createReply(reply app.ReplyFunc, entry *domain.JournalEntry) error {
+	if reply == nil {
+		return nil
+	}
+	msg, err := reply(entry)
+	if err != nil {
+		return fmt.Errorf("building reply: %w", err)
+	}
+	msg.JournalEntryID = &entry.ID
+	msg.NextAttemptAt = time.Now()
+	m.insertOutboxMessage(msg)
+	return nil
+}
+
+func (m *MemoryRepository) SaveWebhookEndpoint(ctx context.Context, endpoint *domain.WebhookEndpoint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if endpoint.ID == 0 {
+		endpoint.ID = uint(len(m.endpoints) + 1)
+		endpoint.CreatedAt = time.Now()
+		m.endpoints = append(m.endpoints, nil)
+	}
+	saved := *endpoint
+	m.endpoints[endpoint.ID-1] = &saved
+	return nil
+}
+
+func (m *MemoryRepository) GetWebhookEndpoint(ctx context.Context, id uint) (*domain.WebhookEndpoint, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id == 0 || int(id) > len(m.endpoints) {
+		return nil, app.ErrUnknownWebhookEndpoint
+	}
+	endpoint := *m.endpoints[id-1]
+	return &endpoint, nil
+}
+
+func (m *MemoryRepository) ListWebhookEndpoints(ctx context.Context, workspaceID string) ([]*domain.WebhookEndpoint, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var endpoints []*domain.WebhookEndpoint
+	for _, e := range m.endpoints {
+		if workspaceID == "" || e.WorkspaceID == workspaceID {
+			endpoint := *e
+			endpoints = append(endpoints, &endpoint)
+		}
+	}
+	return endpoints, nil
+}
+
+func (m *MemoryRepository) EnqueueWebhookDelivery(ctx context.Context, delivery *domain.WebhookDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.insertWebhookDelivery(delivery)
+	return nil
+}
+
+func (m *MemoryRepository) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*domain.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var claimed []*domain.WebhookDelivery
+	for _, d := range m.deliveries {
+		if len(claimed) == limit {
+			break
+		}
+		if !d.Pending() || d.NextAttemptAt.After(now) {
+			continue
+		}
+		d.NextAttemptAt = now.Add(lease)
+		claim := *d
+		claimed = append(claimed, &claim)
+	}
+	return claimed, nil
+}
+
+func (m *MemoryRepository) SaveWebhookDelivery(ctx context.Context, delivery *domain.WebhookDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if delivery.ID == 0 || int(delivery.ID) > len(m.deliveries) {
+		m.insertWebhookDelivery(delivery)
+		return nil
+	}
+	saved := *delivery
+	m.deliveries[delivery.ID-1] = &saved
+	return nil
+}
+
+func (m *MemoryRepository) ListWebhookDeliveries(ctx context.Context, endpointID, beforeID uint, limit int) ([]*domain.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var deliveries []*domain.WebhookDelivery
+	for i := len(m.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		d := m.deliveries[i]
+		if d.EndpointID != endpointID || (beforeID != 0 && d.ID >= beforeID) {
+			continue
+		}
+		delivery := *d
+		deliveries = append(deliveries, &delivery)
+	}
+	return deliveries, nil
+}
+
+func (m *MemoryRepository) CountPendingWebhookDeliveries(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var count int64
+	for _, d := range m.deliveries {
+		if d.Pending() {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *MemoryRepository) insertWebhookDelivery(delivery *domain.WebhookDelivery) {
+	delivery.ID = uint(len(m.deliveries) + 1)
+	delivery.CreatedAt = time.Now()
+	saved := *delivery
+	m.deliveries = append(m.deliveries, &saved)
+}
+
+// createWebhookDeliveries queues a ledger change's events for the workspace's
+// endpoints.
+func (m *MemoryRepository) createWebhookDeliveries(workspaceID string, events app.EventFunc, entry *domain.JournalEntry, grant *domain.Grant) error {
+	if events == nil {
+		return nil
+	}
+	var changeEvents []*domain.LedgerEvent
+	for _, endpoint := range m.endpoints {
+		if endpoint.WorkspaceID != workspaceID || endpoint.DisabledAt != nil {
+			continue
+		}
+		if changeEvents == nil {
+			changeEvents = events(entry, grant)
+		}
+		deliveries, err := endpoint.Deliveries(changeEvents)
+		if err != nil {
+			return err
+		}
+		for _, d := range deliveries {
+			m.insertWebhookDelivery(d)
+		}
+	}
+	return nil
+}
+
+// addWorkspaceUsers makes the users members of the workspace, if they aren't
+// already. Changes made outside any workspace don't make anyone a member.
+func (m *MemoryRepository) addWorkspaceUsers(workspaceID string, users ...*domain.User) {
+	if workspaceID == domain.SystemWorkspaceID {
+		return
+	}
+	for _, u := range users {
+		if !m.isMember(workspaceID, u.ID) {
+			m.members = append(m.members, &domain.WorkspaceUser{WorkspaceID: workspaceID, UserID: u.ID, CreatedAt: time.Now()})
+		}
+	}
+}
+
+func (m *MemoryRepository) isMember(workspaceID string, userID uint) bool {
+	for _, member := range m.members {
+		if member.WorkspaceID == workspaceID && member.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MemoryRepository) userBySlackID(slackID string) *domain.User {
+	for _, u := range m.users {
+		if u.SlackID == slackID {
+			return u
+		}
+	}
+	user := &domain.User{SlackID: slackID}
+	user.ID = uint(len(m.users) + 1)
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = user.CreatedAt
+	m.users = append(m.users, user)
+	return user
+}
+
+func (m *MemoryRepository) userByID(id uint) *domain.User {
+	return m.users[id-1]
+}
+
+func (m *MemoryRepository) account(userID uint, currency string) *domain.Account {
+	for _, a := range m.accounts {
+		if a.UserID == userID && a.Currency == currency {
+			return a
+		}
+	}
+	account := &domain.Account{UserID: userID, Currency: currency}
+	account.ID = uint(len(m.accounts) + 1)
+	account.CreatedAt = time.Now()
+	account.UpdatedAt = account.CreatedAt
+	m.accounts = append(m.accounts, account)
+	return account
+}
+
+func (m *MemoryRepository) issuerAccount(currency string) *domain.Account {
+	account := m.account(m.userBySlackID(domain.IssuerSlackID).ID, currency)
+	account.AllowOverdraft = true
+	return account
+}
+
+func (m *MemoryRepository) saveAccounts(accounts ...*domain.Account) {
+	for _, a := range accounts {
+		a.UpdatedAt = time.Now()
+		saved := *a
+		m.accounts[a.ID-1] = &saved
+	}
+}
+
+// movementSum adds up an account's movements made before end, or all of them
+// when end is zero.
+func (m *MemoryRepository) movementSum(accountID uint, end time.Time) decimal.Decimal {
+	sum := decimal.Zero
+	for _, mv := range m.movements {
+		if mv.AccountID == accountID && (end.IsZero() || mv.CreatedAt.Before(end)) {
+			sum = sum.Add(mv.Amount)
+		}
+	}
+	return sum
+}
+
+func (m *MemoryRepository) createJournalEntry(workspaceID string, movements ...*domain.Movement) (*domain.JournalEntry, error) {
+	entry, err := domain.NewJournalEntry(movements...)
+	if err != nil {
+		return nil, err
+	}
+
+	head, ok := m.heads[workspaceID]
+	if !ok {
+		head = &domain.ChainHead{WorkspaceID: workspaceID}
+		m.heads[workspaceID] = head
+	}
+	entry.Chain(head)
+	head.UpdatedAt = entry.CreatedAt
+
+	entry.ID = uint(len(m.entries) + 1)
+	for _, mv := range movements {
+		mv.ID = uint(len(m.movements) + 1)
+		mv.CreatedAt = entry.CreatedAt
+		mv.UpdatedAt = entry.CreatedAt
+		mv.JournalEntryID = entry.ID
+		m.movements = append(m.movements, mv)
+	}
+	m.entries = append(m.entries, entry)
+
+	return copyEntry(entry), nil
+}
+
+func copyEntry(e *domain.JournalEntry) *domain.JournalEntry {
+	entry := *e
+	entry.Movements = make([]*domain.Movement, len(e.Movements))
+	for i, mv := range e.Movements {
+		movement := *mv
+		entry.Movements[i] = &movement
+	}
+	return &entry
+}
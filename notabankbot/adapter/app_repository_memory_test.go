@@ -0,0 +1,298 @@
+package adapter
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/yammine/yamex-go/notabankbot/app"
+	"github.com/yammine/yamex-go/notabankbot/domain"
+)
+
+func TestReconcileCorrectsTotals(t *testing.T) {
+	repo := NewMemoryRepository()
+	application := app.NewApplication(repo, nil)
+	ctx := context.Background()
+
+	// A balance no movement explains, as left behind by a bad write.
+	user, err := repo.GetOrCreateUserBySlackID(ctx, "UALICE00000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.account(user.ID, "$coffee").Balance = decimal.New(5, 0)
+	repo.issuerAccount("$coffee")
+
+	report, err := application.Reconcile(ctx, &app.ReconcileInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Healthy() || len(report.Discrepancies) != 1 {
+		t.Fatalf("report = %+v, want a single uncorrected discrepancy", report)
+	}
+
+	report, err = application.Reconcile(ctx, &app.ReconcileInput{Correct: true, Reason: "bad write"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Healthy() {
+		t.Errorf("report after correcting is unhealthy: %+v", report.Currencies[0])
+	}
+	if got := report.Currencies[0].Issued; !got.Equal(decimal.New(5, 0)) {
+		t.Errorf("issued = %s, want 5", got)
+	}
+
+	report, err = application.Reconcile(ctx, &app.ReconcileInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Healthy() || len(report.Discrepancies) != 0 {
+		t.Errorf("report after correcting = %+v, want healthy", report)
+	}
+}
+
+// chainedLedger returns an application whose ledger has two adjustments and a
+// checkpoint, signed with a new key unless signed is false.
+func chainedLedger(t *testing.T, signed bool) (*MemoryRepository, *app.Application) {
+	t.Helper()
+	seed := base64.StdEncoding.EncodeToString(make([]byte, ed25519.SeedSize))
+	signer, err := NewLedgerSigner(seed, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewMemoryRepository()
+	ctx := context.Background()
+
+	for _, amount := range []int64{5, -2} {
+		in := &app.AdjustBalanceInput{UserID: "UALICE00000", Currency: "$coffee", Amount: decimal.New(amount, 0), Reason: "test"}
+		if _, err := app.NewApplication(repo, signer).AdjustBalance(ctx, in); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := app.NewApplication(repo, signer).CheckpointLedger(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if !signed {
+		signer = nil
+	}
+	return repo, app.NewApplication(repo, signer)
+}
+
+func TestVerifyChain(t *testing.T) {
+	tests := []struct {
+		name       string
+		signed     bool
+		tamper     func(repo *MemoryRepository)
+		unverified int
+		problem    string
+	}{
+		{name: "intact", signed: true},
+		{name: "no signing key", unverified: 1},
+		{
+			name:   "movement changed",
+			signed: true,
+			tamper: func(repo *MemoryRepository) {
+				repo.entries[0].Movements[0].Amount = decimal.New(-50, 0)
+			},
+			problem: "contents do not match the stored hash",
+		},
+		{
+			name:   "entry removed",
+			signed: true,
+			tamper: func(repo *MemoryRepository) {
+				repo.entries[0].Sequence = 0
+			},
+			problem: "entry missing, next entry found has sequence 2",
+		},
+		{
+			name:   "checkpoint forged",
+			signed: true,
+			tamper: func(repo *MemoryRepository) {
+				repo.checkpoints[0].Hash = repo.entries[0].Hash
+			},
+			problem: "checkpoint 1 has an invalid signature",
+		},
+		{
+			name:   "checkpoint from another key",
+			signed: true,
+			tamper: func(repo *MemoryRepository) {
+				repo.checkpoints[0].KeyID = "0123456789abcdef"
+			},
+			problem: `checkpoint 1 was signed with unknown key "0123456789abcdef"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, application := chainedLedger(t, tt.signed)
+			if tt.tamper != nil {
+				tt.tamper(repo)
+			}
+
+			report, err := application.VerifyChain(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.CheckpointsUnverified != tt.unverified {
+				t.Errorf("unverified checkpoints = %d, want %d", report.CheckpointsUnverified, tt.unverified)
+			}
+			if report.Verified() != (tt.unverified == 0 && tt.problem == "") {
+				t.Errorf("Verified() = %t for %+v", report.Verified(), report)
+			}
+			switch {
+			case tt.problem == "" && len(report.Breaks) > 0:
+				t.Errorf("unexpected break: %+v", report.Breaks[0])
+			case tt.problem != "" && (len(report.Breaks) != 1 || report.Breaks[0].Problem != tt.problem):
+				t.Errorf("breaks = %+v, want %q", report.Breaks, tt.problem)
+			}
+		})
+	}
+}
+
+func TestGetReceipt(t *testing.T) {
+	_, application := chainedLedger(t, true)
+	ctx := context.Background()
+	_, publicKey, err := application.LedgerPublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receipt, err := application.GetReceipt(ctx, &app.GetReceiptInput{UserID: "UALICE00000", EntryID: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := receipt.Verify(publicKey); err != nil {
+		t.Errorf("Verify() = %v", err)
+	}
+	if receipt.Sequence != 2 || len(receipt.Lines) != 2 {
+		t.Errorf("receipt = %+v, want the second entry's", receipt)
+	}
+
+	_, err = application.GetReceipt(ctx, &app.GetReceiptInput{UserID: "UBOB0000000", EntryID: 2})
+	if !errors.Is(err, app.ErrReceiptNotFound) {
+		t.Errorf("receipt for another user's entry: err = %v, want %v", err, app.ErrReceiptNotFound)
+	}
+}
+
+func TestListCurrenciesByWorkspace(t *testing.T) {
+	application := app.NewApplication(NewMemoryRepository(), nil)
+	ctx := context.Background()
+	grants := []*app.GrantInput{
+		{WorkspaceID: "T1", GranterID: "UALICE00000", ReceiverID: "UBOB0000000", Currency: "$coffee"},
+		{WorkspaceID: "T2", GranterID: "UCAROL00000", ReceiverID: "UDAVE000000", Currency: "$coffee"},
+		{WorkspaceID: "T2", GranterID: "UDAVE000000", ReceiverID: "UCAROL00000", Currency: "$tea"},
+	}
+	for _, in := range grants {
+		if _, err := application.Grant(ctx, in); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		workspaceID string
+		after       string
+		want        []string
+	}{
+		{workspaceID: "T1", want: []string{"$coffee 1"}},
+		{workspaceID: "T2", want: []string{"$coffee 1", "$tea 1"}},
+		{workspaceID: "T2", after: "$coffee", want: []string{"$tea 1"}},
+		{workspaceID: "T3"},
+	}
+	for _, tt := range tests {
+		currencies, err := application.ListCurrencies(ctx, tt.workspaceID, tt.after, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, c := range currencies {
+			got = append(got, c.Code+" "+c.Circulating.String())
+		}
+		if strings.Join(got, ", ") != strings.Join(tt.want, ", ") {
+			t.Errorf("ListCurrencies(%q, %q) = %v, want %v", tt.workspaceID, tt.after, got, tt.want)
+		}
+	}
+}
+
+func TestFindWorkspaceUser(t *testing.T) {
+	repo := NewMemoryRepository()
+	application := app.NewApplication(repo, nil)
+	ctx := context.Background()
+
+	// Known to the ledger, but through no workspace.
+	if _, err := repo.GetOrCreateUserBySlackID(ctx, "UCAROL00000"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := application.JoinWorkspace(ctx, "T1", "UALICE00000"); err != nil {
+		t.Fatal(err)
+	}
+	in := &app.GrantInput{WorkspaceID: "T2", GranterID: "UBOB0000000", ReceiverID: "UDAVE000000", Currency: "$coffee"}
+	if _, err := application.Grant(ctx, in); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		workspaceID string
+		slackUserID string
+		want        error
+	}{
+		{workspaceID: "T1", slackUserID: "UALICE00000"},
+		{workspaceID: "T2", slackUserID: "UALICE00000", want: app.ErrUserNotInWorkspace},
+		{workspaceID: "T2", slackUserID: "UBOB0000000"},
+		{workspaceID: "T2", slackUserID: "UDAVE000000"},
+		{workspaceID: "T1", slackUserID: "UDAVE000000", want: app.ErrUserNotInWorkspace},
+		{workspaceID: "T1", slackUserID: "UCAROL00000", want: app.ErrUserNotInWorkspace},
+		{workspaceID: "T1", slackUserID: "UNOBODY0000", want: app.ErrUnknownUser},
+	}
+	for _, tt := range tests {
+		user, err := application.WorkspaceUser(ctx, tt.workspaceID, tt.slackUserID)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("WorkspaceUser(%q, %q) = %v, want %v", tt.workspaceID, tt.slackUserID, err, tt.want)
+		}
+		if err == nil && user.SlackID != tt.slackUserID {
+			t.Errorf("WorkspaceUser(%q, %q) = %s", tt.workspaceID, tt.slackUserID, user.SlackID)
+		}
+	}
+}
+
+func TestCurrencyCreatedPerWorkspace(t *testing.T) {
+	application := app.NewApplication(NewMemoryRepository(), nil)
+	ctx := context.Background()
+	endpoints := map[string]uint{}
+	for _, workspaceID := range []string{"T1", "T2"} {
+		endpoint, err := application.CreateWebhookEndpoint(ctx, &app.CreateWebhookEndpointInput{
+			WorkspaceID: workspaceID,
+			URL:         "https://example.com/hooks",
+			Events:      []string{domain.EventCurrencyCreated},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		endpoints[workspaceID] = endpoint.ID
+	}
+	// $coffee circulates in T1 before T2 first grants it.
+	grants := []*app.GrantInput{
+		{WorkspaceID: "T1", GranterID: "UALICE00000", ReceiverID: "UBOB0000000", Currency: "$coffee"},
+		{WorkspaceID: "T1", GranterID: "UBOB0000000", ReceiverID: "UALICE00000", Currency: "$coffee"},
+		{WorkspaceID: "T2", GranterID: "UCAROL00000", ReceiverID: "UDAVE000000", Currency: "$coffee"},
+		{WorkspaceID: "T2", GranterID: "UDAVE000000", ReceiverID: "UCAROL00000", Currency: "$coffee"},
+	}
+	for _, in := range grants {
+		if _, err := application.Grant(ctx, in); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for workspaceID, id := range endpoints {
+		deliveries, err := application.ListWebhookDeliveries(ctx, id, 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deliveries) != 1 {
+			t.Errorf("%s got %d currency.created deliveries, want 1", workspaceID, len(deliveries))
+		}
+	}
+}
@@ -2,40 +2,55 @@ package adapter
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
 	"time"
 
+	"github.com/shopspring/decimal"
+
 	"github.com/yammine/yamex-go/notabankbot/app"
 	"github.com/yammine/yamex-go/notabankbot/domain"
 
 	"gorm.io/gorm/clause"
 
-	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
-func NewPostgresRepository(dsn string) *PostgresRepository {
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
-	if err != nil {
-		log.Fatalf("Could not connect to database: %s", err)
-	}
-
-	return &PostgresRepository{DB: db}
+// NewPostgresRepository seals webhook endpoint secrets with the keyring.
+// Without one, endpoints can't be saved or signed for.
+func NewPostgresRepository(pool *PostgresPool, keyring *TokenKeyring) *PostgresRepository {
+	return &PostgresRepository{DB: pool.primary, reads: pool.replica, keyring: keyring}
 }
 
 type PostgresRepository struct {
 	DB *gorm.DB
+	// reads serves read-only queries that can tolerate replication lag.
+	reads   *gorm.DB
+	keyring *TokenKeyring
 }
 
 func (p PostgresRepository) Migrate() error {
-	return p.DB.AutoMigrate(&domain.User{}, &domain.Account{}, &domain.Movement{}, &domain.Grant{}, &Feedback{})
+	err := p.DB.AutoMigrate(&domain.User{}, &domain.Account{}, &domain.JournalEntry{}, &domain.Movement{}, &domain.Grant{}, &domain.ChainHead{}, &domain.Checkpoint{}, &domain.BalanceSnapshot{}, &Feedback{}, &RateLimitBucket{}, &domain.OutboxMessage{}, &WebhookEndpoint{}, &domain.WebhookDelivery{}, &domain.WorkspaceUser{}, &SchemaVersion{})
+	if err != nil {
+		return err
+	}
+	if err := migrateLedgerConstraints(p.DB); err != nil {
+		return err
+	}
+	if err := backfillOpeningBalances(p.DB); err != nil {
+		return fmt.Errorf("backfilling opening balances: %w", err)
+	}
+	if err := backfillWorkspaceUsers(p.DB); err != nil {
+		return fmt.Errorf("backfilling workspace users: %w", err)
+	}
+
+	return recordSchemaVersion(p.DB)
 }
 
 func (p PostgresRepository) GetAccountsForUser(ctx context.Context, id uint) ([]*domain.Account, error) {
 	var accounts []*domain.Account
 
-	if err := p.DB.WithContext(ctx).Find(&accounts, domain.Account{UserID: id}).Error; err != nil {
+	if err := p.reads.WithContext(ctx).Find(&accounts, domain.Account{UserID: id}).Error; err != nil {
 		return nil, err
 	}
 
@@ -56,35 +71,85 @@ func (p PostgresRepository) GetOrCreateUserBySlackID(ctx context.Context, slackU
 	return &user, tx.Error
 }
 
+func (p PostgresRepository) FindWorkspaceUser(ctx context.Context, workspaceID, slackUserID string) (*domain.User, error) {
+	var user domain.User
+	err := p.DB.WithContext(ctx).Where("slack_id = ?", slackUserID).Take(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, app.ErrUnknownUser
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fetching user: %w", err)
+	}
+
+	var member int64
+	err = p.DB.WithContext(ctx).Model(&domain.WorkspaceUser{}).
+		Where("workspace_id = ? AND user_id = ?", workspaceID, user.ID).
+		Count(&member).Error
+	if err != nil {
+		return nil, fmt.Errorf("checking workspace membership: %w", err)
+	}
+	if member == 0 {
+		return nil, app.ErrUserNotInWorkspace
+	}
+	return &user, nil
+}
+
+// issuedInWorkspaceSQL reports whether the issuer account has moved in the
+// workspace's journal.
+const issuedInWorkspaceSQL = `
+SELECT EXISTS (
+	SELECT 1 FROM movements
+	JOIN journal_entries ON journal_entries.id = movements.journal_entry_id
+	WHERE movements.account_id = ? AND journal_entries.workspace_id = ?
+)`
+
 func (p PostgresRepository) GrantCurrency(ctx context.Context, input *app.GrantCurrencyInput, grantFn app.GrantFunc) (*domain.Grant, error) {
 	var grant *domain.Grant
-	err := p.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		from, txErr := getUserExclusive(tx, input.From.ID)
+	err := p.ledgerTransaction(ctx, "grant", func(tx *gorm.DB) error {
+		from, txErr := getUserExclusive(tx, input.From.ID, time.Now().Add(-input.Cooldown))
 		if txErr != nil {
 			return fmt.Errorf("get sender user exclusive: %w", txErr)
 		}
+		issuer, txErr := getIssuerAccountExclusive(tx, input.Currency)
+		if txErr != nil {
+			return fmt.Errorf("get issuer account exclusive: %w", txErr)
+		}
 		account, txErr := getAccountExclusive(tx, input.To.ID, input.Currency)
 		if txErr != nil {
 			return fmt.Errorf("get receiver account exclusive: %w", txErr)
 		}
+		// The issuer's lock keeps two first grants in a workspace apart.
+		var issued bool
+		if txErr := tx.Raw(issuedInWorkspaceSQL, issuer.ID, input.WorkspaceID).Scan(&issued).Error; txErr != nil {
+			return fmt.Errorf("checking earlier issues: %w", txErr)
+		}
 
 		// Creates appropriate entities and updates account balance.
 		out, txErr := grantFn(ctx, &app.GrantCurrencyFuncIn{
-			From:      from,
-			To:        input.To,
-			ToAccount: account,
+			From:          from,
+			To:            input.To,
+			ToAccount:     account,
+			IssuerAccount: issuer,
+			FirstIssue:    !issued,
 		})
 		if txErr != nil {
 			return fmt.Errorf("business logic error: %w", txErr)
 		}
 
-		// Save the updated account balance
+		// Save the updated account balances
+		if saveAccountErr := tx.Save(issuer).Error; saveAccountErr != nil {
+			return fmt.Errorf("saving updated issuer account: %w", saveAccountErr)
+		}
 		if saveAccountErr := tx.Save(account).Error; saveAccountErr != nil {
 			return fmt.Errorf("saving updated account: %w", saveAccountErr)
 		}
 
-		if insertMovementErr := tx.Create(out.Movement).Error; insertMovementErr != nil {
-			return fmt.Errorf("inserting movement: %w", insertMovementErr)
+		entry, insertEntryErr := createJournalEntry(tx, input.WorkspaceID, out.IssuanceMovement, out.Movement)
+		if insertEntryErr != nil {
+			return insertEntryErr
+		}
+		if txErr := addWorkspaceUsers(tx, input.WorkspaceID, from, input.To); txErr != nil {
+			return txErr
 		}
 
 		// Associate the newly inserted movement with the grant.
@@ -92,15 +157,21 @@ func (p PostgresRepository) GrantCurrency(ctx context.Context, input *app.GrantC
 		if insertGrantErr := tx.Create(out.Grant).Error; insertGrantErr != nil {
 			return fmt.Errorf("inserting grant: %w", insertGrantErr)
 		}
+		grant = out.Grant
+		grant.Movement = *out.Movement
 
-		return nil
+		if txErr := createWebhookDeliveries(tx, input.WorkspaceID, input.Events, entry, grant); txErr != nil {
+			return txErr
+		}
+		return createReply(tx, input.Reply, entry)
 	})
 
-	return grant, err
+	return grant, translatePgError(err)
 }
 
-func (p PostgresRepository) SendCurrency(ctx context.Context, in *app.SendCurrencyInput, sendFn app.SendFunc) error {
-	return p.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+func (p PostgresRepository) SendCurrency(ctx context.Context, in *app.SendCurrencyInput, sendFn app.SendFunc) (*domain.JournalEntry, error) {
+	var entry *domain.JournalEntry
+	err := p.ledgerTransaction(ctx, "send", func(tx *gorm.DB) error {
 		sender, txErr := getAccountExclusive(tx, in.From.ID, in.Currency)
 		if txErr != nil {
 			return fmt.Errorf("get sender account exclusive: %w", txErr)
@@ -128,12 +199,22 @@ func (p PostgresRepository) SendCurrency(ctx context.Context, in *app.SendCurren
 			return fmt.Errorf("updating receiver account: %w", updateReceiverErr)
 		}
 
-		if insertMovementsErr := tx.Create([]*domain.Movement{out.SendingMovement, out.ReceivingMovement}).Error; insertMovementsErr != nil {
-			fmt.Errorf("insert movements: %w", insertMovementsErr)
+		var insertEntryErr error
+		entry, insertEntryErr = createJournalEntry(tx, in.WorkspaceID, out.SendingMovement, out.ReceivingMovement)
+		if insertEntryErr != nil {
+			return insertEntryErr
+		}
+		if txErr := addWorkspaceUsers(tx, in.WorkspaceID, in.From, in.To); txErr != nil {
+			return txErr
 		}
 
-		return nil
+		if txErr := createWebhookDeliveries(tx, in.WorkspaceID, in.Events, entry, nil); txErr != nil {
+			return txErr
+		}
+		return createReply(tx, in.Reply, entry)
 	})
+
+	return entry, translatePgError(err)
 }
 
 func (p PostgresRepository) SaveFeedback(ctx context.Context, user *domain.User, feedback string) error {
@@ -147,13 +228,242 @@ func (p PostgresRepository) SaveFeedback(ctx context.Context, user *domain.User,
 	return nil
 }
 
+func (p PostgresRepository) ListFeedback(ctx context.Context, beforeID uint, limit int) ([]*app.Feedback, error) {
+	var feedback []*app.Feedback
+
+	query := p.reads.WithContext(ctx).
+		Table("feedbacks").
+		Select("feedbacks.id, feedbacks.created_at, users.slack_id AS slack_user_id, feedbacks.text").
+		Joins("JOIN users ON users.id = feedbacks.user_id").
+		Where("feedbacks.deleted_at IS NULL")
+	if beforeID != 0 {
+		query = query.Where("feedbacks.id < ?", beforeID)
+	}
+	if err := query.Order("feedbacks.id DESC").Limit(limit).Scan(&feedback).Error; err != nil {
+		return nil, fmt.Errorf("listing feedback: %w", err)
+	}
+
+	return feedback, nil
+}
+
+func (p PostgresRepository) SetAdmin(ctx context.Context, slackUserID string, admin bool) error {
+	err := p.DB.WithContext(ctx).Model(&domain.User{}).Where("slack_id = ?", slackUserID).Update("admin", admin).Error
+	if err != nil {
+		return fmt.Errorf("updating user: %w", err)
+	}
+	return nil
+}
+
+func (p PostgresRepository) GetLedgerSummary(ctx context.Context) ([]*app.AccountLedgerSummary, error) {
+	var summaries []*app.AccountLedgerSummary
+
+	err := p.DB.WithContext(ctx).
+		Table("accounts").
+		Select("accounts.id AS account_id, accounts.user_id, accounts.currency, accounts.balance, accounts.allow_overdraft, COALESCE(SUM(movements.amount), 0) AS movement_sum").
+		// Movements from before journal entries are journaled by the opening balances backfill.
+		Joins("LEFT JOIN movements ON movements.account_id = accounts.id AND movements.journal_entry_id IS NOT NULL AND movements.deleted_at IS NULL").
+		Where("accounts.deleted_at IS NULL").
+		Group("accounts.id").
+		Order("accounts.id").
+		Scan(&summaries).Error
+	if err != nil {
+		return nil, fmt.Errorf("summarising ledger: %w", err)
+	}
+
+	return summaries, nil
+}
+
+func (p PostgresRepository) ReconcileAccount(ctx context.Context, in *app.ReconcileAccountInput, reconcileFn app.ReconcileFunc) error {
+	err := p.ledgerTransaction(ctx, "reconcile", func(tx *gorm.DB) error {
+		// The issuer is locked before the account, in the same order as
+		// grants, so the two can't deadlock. An account's currency never
+		// changes, it is safe to read it first.
+		var account domain.Account
+		if txErr := tx.Select("currency").First(&account, in.AccountID).Error; txErr != nil {
+			return fmt.Errorf("get account: %w", txErr)
+		}
+		issuer, txErr := getIssuerAccountExclusive(tx, account.Currency)
+		if txErr != nil {
+			return fmt.Errorf("get issuer account exclusive: %w", txErr)
+		}
+		if txErr := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&account, in.AccountID).Error; txErr != nil {
+			return fmt.Errorf("get account exclusive: %w", txErr)
+		}
+
+		var movementSum decimal.Decimal
+		txErr = tx.Model(&domain.Movement{}).
+			Select("COALESCE(SUM(amount), 0)").
+			Where("account_id = ? AND journal_entry_id IS NOT NULL", account.ID).
+			Row().Scan(&movementSum)
+		if txErr != nil {
+			return fmt.Errorf("summing movements: %w", txErr)
+		}
+
+		out, txErr := reconcileFn(ctx, &app.ReconcileAccountFuncIn{
+			Account:       &account,
+			IssuerAccount: issuer,
+			MovementSum:   movementSum,
+		})
+		if txErr != nil {
+			return fmt.Errorf("business logic: %w", txErr)
+		}
+		if out.Adjustment == nil {
+			return nil
+		}
+
+		if updateIssuerErr := tx.Save(issuer).Error; updateIssuerErr != nil {
+			return fmt.Errorf("updating issuer account: %w", updateIssuerErr)
+		}
+
+		// Adjustments are not made on behalf of a workspace.
+		_, insertEntryErr := createJournalEntry(tx, domain.SystemWorkspaceID, out.Adjustment, out.IssuanceMovement)
+		return insertEntryErr
+	})
+
+	return translatePgError(err)
+}
+
+func (p PostgresRepository) AdjustAccount(ctx context.Context, in *app.AdjustAccountInput, adjustFn app.AdjustFunc) (*domain.JournalEntry, error) {
+	var entry *domain.JournalEntry
+	err := p.ledgerTransaction(ctx, "adjust", func(tx *gorm.DB) error {
+		issuer, txErr := getIssuerAccountExclusive(tx, in.Currency)
+		if txErr != nil {
+			return fmt.Errorf("get issuer account exclusive: %w", txErr)
+		}
+		account, txErr := getAccountExclusive(tx, in.User.ID, in.Currency)
+		if txErr != nil {
+			return fmt.Errorf("get account exclusive: %w", txErr)
+		}
+
+		out, txErr := adjustFn(ctx, &app.AdjustAccountFuncIn{Account: account, IssuerAccount: issuer})
+		if txErr != nil {
+			return fmt.Errorf("business logic: %w", txErr)
+		}
+
+		if saveAccountErr := tx.Save(issuer).Error; saveAccountErr != nil {
+			return fmt.Errorf("saving updated issuer account: %w", saveAccountErr)
+		}
+		if saveAccountErr := tx.Save(account).Error; saveAccountErr != nil {
+			return fmt.Errorf("saving updated account: %w", saveAccountErr)
+		}
+
+		// Adjustments are not made on behalf of a workspace.
+		entry, txErr = createJournalEntry(tx, domain.SystemWorkspaceID, out.Movements...)
+		return txErr
+	})
+
+	return entry, translatePgError(err)
+}
+
+func (p PostgresRepository) GetJournalEntry(ctx context.Context, id uint) (*domain.JournalEntry, error) {
+	var entry domain.JournalEntry
+	if err := p.DB.WithContext(ctx).Preload("Movements").First(&entry, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, app.ErrReceiptNotFound
+		}
+		return nil, fmt.Errorf("fetching journal entry: %w", err)
+	}
+
+	return &entry, nil
+}
+
+func (p PostgresRepository) ListJournalEntries(ctx context.Context, workspaceID string, afterSequence uint64, limit int) ([]*domain.JournalEntry, error) {
+	var entries []*domain.JournalEntry
+
+	err := p.DB.WithContext(ctx).
+		Preload("Movements").
+		Where("workspace_id = ? AND sequence > ?", workspaceID, afterSequence).
+		Order("sequence").
+		Limit(limit).
+		Find(&entries).Error
+	if err != nil {
+		return nil, fmt.Errorf("listing journal entries: %w", err)
+	}
+
+	return entries, nil
+}
+
+func (p PostgresRepository) ListMovements(ctx context.Context, q *app.ListMovementsQuery) ([]*app.UserMovement, error) {
+	var movements []*app.UserMovement
+
+	query := p.DB.WithContext(ctx).
+		Table("movements").
+		Select("movements.id, movements.created_at, movements.journal_entry_id, accounts.currency, movements.amount, movements.reason").
+		Joins("JOIN accounts ON accounts.id = movements.account_id").
+		Joins("JOIN journal_entries ON journal_entries.id = movements.journal_entry_id").
+		Where("movements.deleted_at IS NULL AND accounts.user_id = ? AND journal_entries.workspace_id = ?", q.UserID, q.WorkspaceID)
+	if q.Currency != "" {
+		query = query.Where("accounts.currency = ?", q.Currency)
+	}
+	order := "movements.id DESC"
+	if q.BeforeID != 0 {
+		query = query.Where("movements.id < ?", q.BeforeID)
+	}
+	if q.AfterID != nil {
+		query = query.Where("movements.id > ?", *q.AfterID)
+		order = "movements.id"
+	}
+	if err := query.Order(order).Limit(q.Limit).Scan(&movements).Error; err != nil {
+		return nil, fmt.Errorf("listing movements: %w", err)
+	}
+
+	return movements, nil
+}
+
+func (p PostgresRepository) ListCurrencies(ctx context.Context, workspaceID, after string, limit int) ([]*app.Currency, error) {
+	var currencies []*app.Currency
+
+	err := p.reads.WithContext(ctx).
+		Table("movements").
+		Select("accounts.currency AS code, -SUM(movements.amount) AS circulating").
+		Joins("JOIN accounts ON accounts.id = movements.account_id").
+		Joins("JOIN users ON users.id = accounts.user_id").
+		Joins("JOIN journal_entries ON journal_entries.id = movements.journal_entry_id").
+		Where("users.slack_id = ? AND journal_entries.workspace_id = ? AND movements.deleted_at IS NULL AND accounts.currency > ?", domain.IssuerSlackID, workspaceID, after).
+		Group("accounts.currency").
+		Order("accounts.currency").
+		Limit(limit).
+		Scan(&currencies).Error
+	if err != nil {
+		return nil, fmt.Errorf("listing currencies: %w", err)
+	}
+
+	return currencies, nil
+}
+
+func (p PostgresRepository) ListChainHeads(ctx context.Context) ([]*domain.ChainHead, error) {
+	var heads []*domain.ChainHead
+	if err := p.DB.WithContext(ctx).Order("workspace_id").Find(&heads).Error; err != nil {
+		return nil, fmt.Errorf("listing chain heads: %w", err)
+	}
+
+	return heads, nil
+}
+
+func (p PostgresRepository) ListCheckpoints(ctx context.Context, workspaceID string) ([]*domain.Checkpoint, error) {
+	var checkpoints []*domain.Checkpoint
+	if err := p.DB.WithContext(ctx).Where("workspace_id = ?", workspaceID).Order("sequence").Find(&checkpoints).Error; err != nil {
+		return nil, fmt.Errorf("listing checkpoints: %w", err)
+	}
+
+	return checkpoints, nil
+}
+
+func (p PostgresRepository) SaveCheckpoint(ctx context.Context, checkpoint *domain.Checkpoint) error {
+	if err := p.DB.WithContext(ctx).Create(checkpoint).Error; err != nil {
+		return fmt.Errorf("inserting checkpoint: %w", err)
+	}
+
+	return nil
+}
+
 var _ app.Repository = (*PostgresRepository)(nil)
 
-func getUserExclusive(tx *gorm.DB, id uint) (*domain.User, error) {
+func getUserExclusive(tx *gorm.DB, id uint, grantsSince time.Time) (*domain.User, error) {
 	var user domain.User
 
 	err := tx.
-		Preload("RecentlyGivenGrants", "created_at >= ?", time.Now().Add(-domain.TimeBetweenGrants())).
+		Preload("RecentlyGivenGrants", "created_at >= ?", grantsSince).
 		Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, id).
 		Error
 	if err != nil {
@@ -163,6 +473,52 @@ func getUserExclusive(tx *gorm.DB, id uint) (*domain.User, error) {
 	return &user, nil
 }
 
+func getIssuerAccountExclusive(tx *gorm.DB, currency string) (*domain.Account, error) {
+	issuer := domain.User{SlackID: domain.IssuerSlackID}
+	if txErr := tx.FirstOrCreate(&issuer, issuer).Error; txErr != nil {
+		return nil, fmt.Errorf("get issuer: %w", txErr)
+	}
+
+	var account *domain.Account
+	txErr := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Attrs(domain.Account{AllowOverdraft: true}).
+		FirstOrCreate(&account, domain.Account{UserID: issuer.ID, Currency: currency}).Error
+	if txErr != nil {
+		return nil, fmt.Errorf("get issuer account: %w", txErr)
+	}
+
+	return account, nil
+}
+
+// createJournalEntry appends the movements to the workspace's hash chain.
+// Holding the chain head lock serialises ledger writes per workspace.
+func createJournalEntry(tx *gorm.DB, workspaceID string, movements ...*domain.Movement) (*domain.JournalEntry, error) {
+	entry, err := domain.NewJournalEntry(movements...)
+	if err != nil {
+		return nil, err
+	}
+
+	createHeadErr := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&domain.ChainHead{WorkspaceID: workspaceID}).Error
+	if createHeadErr != nil {
+		return nil, fmt.Errorf("creating chain head: %w", createHeadErr)
+	}
+	var head domain.ChainHead
+	if lockHeadErr := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&head, "workspace_id = ?", workspaceID).Error; lockHeadErr != nil {
+		return nil, fmt.Errorf("get chain head exclusive: %w", lockHeadErr)
+	}
+	entry.Chain(&head)
+
+	// Movements are inserted along with the entry through the association.
+	if insertEntryErr := tx.Create(entry).Error; insertEntryErr != nil {
+		return nil, fmt.Errorf("inserting journal entry: %w", insertEntryErr)
+	}
+	if updateHeadErr := tx.Save(&head).Error; updateHeadErr != nil {
+		return nil, fmt.Errorf("updating chain head: %w", updateHeadErr)
+	}
+
+	return entry, nil
+}
+
 func getAccountExclusive(tx *gorm.DB, id uint, currency string) (*domain.Account, error) {
 	var account *domain.Account
 	txErr := tx.Clauses(clause.Locking{Strength: "UPDATE"}).FirstOrCreate(&account, domain.Account{UserID: id, Currency: currency}).Error
@@ -0,0 +1,95 @@
+package adapter
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/yammine/yamex-go/notabankbot/domain"
+)
+
+// A snapshot builds on the previous day's snapshot when there is one, and on
+// the account's full history otherwise. Movements from before journal entries
+// are journaled by the opening balances backfill.
+const createBalanceSnapshotsSQL = `
+INSERT INTO balance_snapshots (account_id, date, balance, created_at)
+SELECT accounts.id, @day::date, COALESCE(prev.balance, 0) + COALESCE(SUM(movements.amount), 0), NOW()
+FROM accounts
+LEFT JOIN balance_snapshots prev ON prev.account_id = accounts.id AND prev.date = @day::date - 1
+LEFT JOIN movements ON movements.account_id = accounts.id
+	AND movements.journal_entry_id IS NOT NULL
+	AND movements.created_at < @end
+	AND (prev.account_id IS NULL OR movements.created_at >= @start)
+WHERE accounts.created_at < @end
+GROUP BY accounts.id, prev.balance
+ON CONFLICT (account_id, date) DO NOTHING`
+
+const accountsAsOfSQL = `
+SELECT accounts.id, accounts.created_at, accounts.updated_at, accounts.user_id, accounts.currency, accounts.allow_overdraft,
+	COALESCE(snapshot.balance, 0) + COALESCE((
+		SELECT SUM(movements.amount) FROM movements
+		WHERE movements.account_id = accounts.id
+			AND movements.journal_entry_id IS NOT NULL
+			AND movements.created_at < @end
+			AND (snapshot.date IS NULL OR movements.created_at >= (snapshot.date + 1)::timestamp AT TIME ZONE 'UTC')
+	), 0) AS balance
+FROM accounts
+LEFT JOIN LATERAL (
+	SELECT date, balance FROM balance_snapshots
+	WHERE balance_snapshots.account_id = accounts.id AND balance_snapshots.date <= @day::date
+	ORDER BY date DESC LIMIT 1
+) snapshot ON true
+WHERE accounts.user_id = @user_id AND accounts.created_at < @end AND accounts.deleted_at IS NULL
+ORDER BY accounts.id`
+
+func (p PostgresRepository) GetAccountsForUserAsOf(ctx context.Context, id uint, day time.Time) ([]*domain.Account, error) {
+	var accounts []*domain.Account
+
+	err := p.reads.WithContext(ctx).Raw(accountsAsOfSQL, sql.Named("user_id", id), sql.Named("day", day.Format(domain.SnapshotDateLayout)), sql.Named("end", domain.EndOfDay(day))).
+		Scan(&accounts).Error
+	if err != nil {
+		return nil, fmt.Errorf("fetching balances as of %s: %w", day.Format(domain.SnapshotDateLayout), err)
+	}
+
+	return accounts, nil
+}
+
+func (p PostgresRepository) GetLatestSnapshotDate(ctx context.Context) (*time.Time, error) {
+	var latest sql.NullTime
+	if err := p.DB.WithContext(ctx).Model(&domain.BalanceSnapshot{}).Select("MAX(date)").Row().Scan(&latest); err != nil {
+		return nil, fmt.Errorf("fetching latest snapshot date: %w", err)
+	}
+	if !latest.Valid {
+		return nil, nil
+	}
+
+	return &latest.Time, nil
+}
+
+func (p PostgresRepository) GetFirstMovementDate(ctx context.Context) (*time.Time, error) {
+	var first sql.NullTime
+	if err := p.DB.WithContext(ctx).Model(&domain.Movement{}).Where("journal_entry_id IS NOT NULL").Select("MIN(created_at)").Row().Scan(&first); err != nil {
+		return nil, fmt.Errorf("fetching first movement date: %w", err)
+	}
+	if !first.Valid {
+		return nil, nil
+	}
+	day := first.Time.UTC()
+
+	return &day, nil
+}
+
+func (p PostgresRepository) CreateBalanceSnapshots(ctx context.Context, day time.Time) (int64, error) {
+	end := domain.EndOfDay(day)
+	tx := p.DB.WithContext(ctx).Exec(createBalanceSnapshotsSQL,
+		sql.Named("day", day.Format(domain.SnapshotDateLayout)),
+		sql.Named("start", end.AddDate(0, 0, -1)),
+		sql.Named("end", end),
+	)
+	if tx.Error != nil {
+		return 0, fmt.Errorf("creating balance snapshots: %w", tx.Error)
+	}
+
+	return tx.RowsAffected, nil
+}
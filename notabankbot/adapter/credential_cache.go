@@ -0,0 +1,75 @@
+package adapter
+
+import (
+	"sync"
+	"time"
+
+	"github.com/yammine/yamex-go/notabankbot/port"
+)
+
+type CredentialCacheConfig struct {
+	// TTL bounds how long a replica can use credentials that changed without
+	// it hearing about it, e.g. while its listener was reconnecting.
+	TTL time.Duration
+	// NegativeTTL is how long unknown workspaces are remembered as unknown.
+	NegativeTTL time.Duration
+}
+
+type cachedCredentials struct {
+	// nil for workspaces we have no credentials for.
+	installation *port.SlackInstallation
+	expiresAt    time.Time
+}
+
+type credentialCache struct {
+	sync.RWMutex
+
+	config  CredentialCacheConfig
+	entries map[string]cachedCredentials
+}
+
+func newCredentialCache(config CredentialCacheConfig) *credentialCache {
+	return &credentialCache{
+		config:  config,
+		entries: make(map[string]cachedCredentials),
+	}
+}
+
+// get reports whether the workspace was cached, and if so its installation,
+// which is nil when the workspace is known not to exist.
+func (c *credentialCache) get(workspaceID string) (*port.SlackInstallation, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	entry, ok := c.entries[workspaceID]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return nil, false
+	}
+	return entry.installation, true
+}
+
+func (c *credentialCache) set(workspaceID string, installation *port.SlackInstallation) {
+	c.put(workspaceID, cachedCredentials{installation: installation, expiresAt: time.Now().Add(c.config.TTL)})
+}
+
+func (c *credentialCache) setMissing(workspaceID string) {
+	c.put(workspaceID, cachedCredentials{expiresAt: time.Now().Add(c.config.NegativeTTL)})
+}
+
+func (c *credentialCache) put(workspaceID string, entry cachedCredentials) {
+	c.Lock()
+	defer c.Unlock()
+	c.entries[workspaceID] = entry
+}
+
+func (c *credentialCache) evict(workspaceID string) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.entries, workspaceID)
+}
+
+func (c *credentialCache) clear() {
+	c.Lock()
+	defer c.Unlock()
+	c.entries = make(map[string]cachedCredentials)
+}
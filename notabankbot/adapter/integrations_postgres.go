@@ -0,0 +1,179 @@
+package adapter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/yammine/yamex-go/notabankbot/port"
+)
+
+// Integration is a port.Integration at rest. Its secret is needed to check
+// payloads' HMACs, so rather than hashed it's envelope encrypted like Slack
+// tokens, see TokenKeyring.
+type Integration struct {
+	Name        string `gorm:"primarykey"`
+	WorkspaceID string `gorm:"index"`
+	Mode        string
+	TreasuryID  string
+	Recipient   string
+	Currency    string
+	Amount      string
+	Note        string
+	When        string `gorm:"column:when_expr"`
+	CreatedAt   time.Time
+	DisabledAt  *time.Time
+
+	KeyID        string
+	EncryptedKey []byte
+	Ciphertext   []byte
+}
+
+// IntegrationPostgres stores inbound webhook integrations.
+type IntegrationPostgres struct {
+	db      *gorm.DB
+	keyring *TokenKeyring
+}
+
+func NewIntegrationPostgresRepository(pool *PostgresPool, keyring *TokenKeyring) *IntegrationPostgres {
+	return &IntegrationPostgres{db: pool.primary, keyring: keyring}
+}
+
+func (i *IntegrationPostgres) Migrate() error {
+	return i.db.AutoMigrate(&Integration{})
+}
+
+func (i *IntegrationPostgres) SaveIntegration(ctx context.Context, integration *port.Integration) error {
+	row := &Integration{
+		Name:        integration.Name,
+		WorkspaceID: integration.WorkspaceID,
+		Mode:        string(integration.Mode),
+		TreasuryID:  integration.TreasuryID,
+		Recipient:   integration.Recipient,
+		Currency:    integration.Currency,
+		Amount:      integration.Amount,
+		Note:        integration.Note,
+		When:        integration.When,
+		CreatedAt:   integration.CreatedAt,
+	}
+	sealed, err := i.keyring.Seal(integration.Secret)
+	if err != nil {
+		return fmt.Errorf("encrypting integration secret: %w", err)
+	}
+	row.seal(sealed)
+	if err := i.db.WithContext(ctx).Create(row).Error; err != nil {
+		return fmt.Errorf("inserting integration: %w", err)
+	}
+	return nil
+}
+
+func (i *IntegrationPostgres) GetIntegration(ctx context.Context, name string) (*port.Integration, error) {
+	var row Integration
+	err := i.db.WithContext(ctx).Where("name = ? AND disabled_at IS NULL", name).Take(&row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, port.ErrUnknownIntegration
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fetching integration: %w", err)
+	}
+	integration := row.toPort()
+	if integration.Secret, err = i.keyring.Open(row.sealed()); err != nil {
+		return nil, fmt.Errorf("integration %s: %w", row.Name, err)
+	}
+	return integration, nil
+}
+
+// ListIntegrations leaves secrets out, they're only revealed to check
+// payloads.
+func (i *IntegrationPostgres) ListIntegrations(ctx context.Context, workspaceID string) ([]*port.Integration, error) {
+	var rows []*Integration
+	query := i.db.WithContext(ctx).Order("created_at")
+	if workspaceID != "" {
+		query = query.Where("workspace_id = ?", workspaceID)
+	}
+	if err := query.Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("listing integrations: %w", err)
+	}
+
+	integrations := make([]*port.Integration, len(rows))
+	for n, row := range rows {
+		integrations[n] = row.toPort()
+	}
+	return integrations, nil
+}
+
+func (i *IntegrationPostgres) DisableIntegration(ctx context.Context, name string) error {
+	result := i.db.WithContext(ctx).Model(&Integration{}).
+		Where("name = ? AND disabled_at IS NULL", name).
+		Update("disabled_at", time.Now())
+	if result.Error != nil {
+		return fmt.Errorf("disabling integration: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return port.ErrUnknownIntegration
+	}
+	return nil
+}
+
+// RotateKeys re-encrypts every integration's secret under the active key.
+func (i *IntegrationPostgres) RotateKeys(ctx context.Context) (int, error) {
+	rotated := 0
+	var batch []*Integration
+
+	err := i.db.WithContext(ctx).FindInBatches(&batch, rotateKeysBatchSize, func(_ *gorm.DB, _ int) error {
+		for _, row := range batch {
+			secret, err := i.keyring.Open(row.sealed())
+			if err != nil {
+				return fmt.Errorf("integration %s: %w", row.Name, err)
+			}
+			sealed, err := i.keyring.Seal(secret)
+			if err != nil {
+				return fmt.Errorf("integration %s: %w", row.Name, err)
+			}
+			row.seal(sealed)
+
+			// The batch's session carries its query, each row gets a fresh one.
+			err = i.db.WithContext(ctx).Model(&Integration{}).Where("name = ?", row.Name).Updates(map[string]interface{}{
+				"key_id":        row.KeyID,
+				"encrypted_key": row.EncryptedKey,
+				"ciphertext":    row.Ciphertext,
+			}).Error
+			if err != nil {
+				return fmt.Errorf("integration %s: saving re-encrypted secret: %w", row.Name, err)
+			}
+			rotated++
+		}
+		return nil
+	}).Error
+
+	return rotated, err
+}
+
+func (i Integration) sealed() *SealedToken {
+	return &SealedToken{KeyID: i.KeyID, EncryptedKey: i.EncryptedKey, Ciphertext: i.Ciphertext}
+}
+
+func (i *Integration) seal(secret *SealedToken) {
+	i.KeyID = secret.KeyID
+	i.EncryptedKey = secret.EncryptedKey
+	i.Ciphertext = secret.Ciphertext
+}
+
+func (i Integration) toPort() *port.Integration {
+	return &port.Integration{
+		Name:        i.Name,
+		WorkspaceID: i.WorkspaceID,
+		Mode:        port.IntegrationMode(i.Mode),
+		TreasuryID:  i.TreasuryID,
+		Recipient:   i.Recipient,
+		Currency:    i.Currency,
+		Amount:      i.Amount,
+		Note:        i.Note,
+		When:        i.When,
+		CreatedAt:   i.CreatedAt,
+		DisabledAt:  i.DisabledAt,
+	}
+}
@@ -0,0 +1,56 @@
+package adapter
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestIntegrationsRotateKeys(t *testing.T) {
+	db, mock := mockPostgres(t)
+	old := testKeyring(t, "old")
+	rows := sqlmock.NewRows([]string{"name", "workspace_id", "key_id", "encrypted_key", "ciphertext"})
+	for _, name := range []string{"ci", "desk"} {
+		sealed, err := old.Seal("whsec_" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows.AddRow(name, "T1", sealed.KeyID, sealed.EncryptedKey, sealed.Ciphertext)
+	}
+
+	i := &IntegrationPostgres{db: db, keyring: testKeyring(t, "new", "old")}
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "integrations"`)).WillReturnRows(rows)
+
+	saved := map[string][]*captured{}
+	for _, name := range []string{"ci", "desk"} {
+		saved[name] = []*captured{{}, {}, {}}
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "integrations" SET "ciphertext"=$1,"encrypted_key"=$2,"key_id"=$3 WHERE name = $4`)).
+			WithArgs(saved[name][0], saved[name][1], saved[name][2], name).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+
+	rotated, err := i.RotateKeys(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated != 2 {
+		t.Errorf("rotated = %d, want 2", rotated)
+	}
+
+	// Retired keys can go once every row has been rotated.
+	i.keyring = testKeyring(t, "new")
+	for name, s := range saved {
+		row := Integration{KeyID: s[2].value.(string), EncryptedKey: s[1].bytes(), Ciphertext: s[0].bytes()}
+		secret, err := i.keyring.Open(row.sealed())
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if secret != "whsec_"+name {
+			t.Errorf("%s secret = %q, want %q", name, secret, "whsec_"+name)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package adapter
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgconn"
+	"gorm.io/gorm"
+
+	"github.com/yammine/yamex-go/notabankbot/domain"
+)
+
+const (
+	// Postgres error codes we translate into domain errors.
+	pgCheckViolation = "23514"
+	// Custom SQLSTATEs raised by the ledger triggers below.
+	pgMovementImmutable      = "YX001"
+	pgUnbalancedJournalEntry = "YX002"
+
+	balanceNonNegativeConstraint = "chk_accounts_balance_non_negative"
+)
+
+// ledgerConstraints are applied after AutoMigrate so that the ledger rules
+// hold no matter which code path writes to the database. Every statement
+// must be safe to run repeatedly.
+var ledgerConstraints = []string{
+	`CREATE OR REPLACE FUNCTION yamex_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION '% are append-only', TG_TABLE_NAME USING ERRCODE = '` + pgMovementImmutable + `';
+END;
+$$ LANGUAGE plpgsql`,
+	// Soft deletes are UPDATEs of deleted_at, so this covers gorm's Delete as well.
+	`DROP TRIGGER IF EXISTS movements_append_only ON movements`,
+	`CREATE TRIGGER movements_append_only BEFORE UPDATE OR DELETE ON movements
+	FOR EACH ROW EXECUTE FUNCTION yamex_append_only()`,
+	`DROP TRIGGER IF EXISTS movements_no_truncate ON movements`,
+	`CREATE TRIGGER movements_no_truncate BEFORE TRUNCATE ON movements
+	FOR EACH STATEMENT EXECUTE FUNCTION yamex_append_only()`,
+	// The hash chain is only tamper-evident if its links can't be rewritten in place.
+	`DROP TRIGGER IF EXISTS journal_entries_append_only ON journal_entries`,
+	`CREATE TRIGGER journal_entries_append_only BEFORE UPDATE OR DELETE ON journal_entries
+	FOR EACH ROW EXECUTE FUNCTION yamex_append_only()`,
+	`DROP TRIGGER IF EXISTS checkpoints_append_only ON checkpoints`,
+	`CREATE TRIGGER checkpoints_append_only BEFORE UPDATE OR DELETE ON checkpoints
+	FOR EACH ROW EXECUTE FUNCTION yamex_append_only()`,
+
+	`CREATE OR REPLACE FUNCTION yamex_journal_entry_balanced() RETURNS trigger AS $$
+BEGIN
+	IF NEW.journal_entry_id IS NULL OR
+		(SELECT SUM(amount) FROM movements WHERE journal_entry_id = NEW.journal_entry_id) <> 0 THEN
+		RAISE EXCEPTION 'journal entry % does not sum to zero', NEW.journal_entry_id
+			USING ERRCODE = '` + pgUnbalancedJournalEntry + `';
+	END IF;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql`,
+	// Deferred until commit so that all movements of an entry are visible.
+	`DROP TRIGGER IF EXISTS movements_journal_entry_balanced ON movements`,
+	`CREATE CONSTRAINT TRIGGER movements_journal_entry_balanced AFTER INSERT ON movements
+	DEFERRABLE INITIALLY DEFERRED
+	FOR EACH ROW EXECUTE FUNCTION yamex_journal_entry_balanced()`,
+}
+
+func migrateLedgerConstraints(db *gorm.DB) error {
+	for _, stmt := range ledgerConstraints {
+		if err := db.Exec(stmt).Error; err != nil {
+			return fmt.Errorf("applying ledger constraints: %w", err)
+		}
+	}
+	return nil
+}
+
+// translatePgError maps violations of the ledger constraints back onto the
+// domain errors that the Go code would have returned.
+func translatePgError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+
+	switch {
+	case pgErr.Code == pgCheckViolation && pgErr.ConstraintName == balanceNonNegativeConstraint:
+		return fmt.Errorf("%w: %v", domain.ErrInsufficientBalance, err)
+	case pgErr.Code == pgMovementImmutable:
+		return fmt.Errorf("%w: %v", domain.ErrMovementImmutable, err)
+	case pgErr.Code == pgUnbalancedJournalEntry:
+		return fmt.Errorf("%w: %v", domain.ErrUnbalancedJournalEntry, err)
+	default:
+		return err
+	}
+}
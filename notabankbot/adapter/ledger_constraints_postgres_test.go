@@ -0,0 +1,67 @@
+package adapter
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgconn"
+
+	"github.com/yammine/yamex-go/notabankbot/domain"
+)
+
+func TestTranslatePgError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{
+			name: "negative balance",
+			err:  &pgconn.PgError{Code: pgCheckViolation, ConstraintName: balanceNonNegativeConstraint},
+			want: domain.ErrInsufficientBalance,
+		},
+		{
+			name: "movement update",
+			err:  &pgconn.PgError{Code: pgMovementImmutable, Message: "movements are append-only"},
+			want: domain.ErrMovementImmutable,
+		},
+		{
+			name: "unbalanced entry",
+			err:  &pgconn.PgError{Code: pgUnbalancedJournalEntry, Message: "journal entry 1 does not sum to zero"},
+			want: domain.ErrUnbalancedJournalEntry,
+		},
+		{
+			name: "wrapped by gorm",
+			err:  fmt.Errorf("inserting journal entry: %w", &pgconn.PgError{Code: pgUnbalancedJournalEntry}),
+			want: domain.ErrUnbalancedJournalEntry,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := translatePgError(tt.err)
+			if !errors.Is(got, tt.want) {
+				t.Errorf("translatePgError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTranslatePgErrorPassesOthersThrough(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{name: "nil", err: nil},
+		{name: "not from postgres", err: errors.New("connection reset")},
+		{name: "other check", err: &pgconn.PgError{Code: pgCheckViolation, ConstraintName: "chk_something_else"}},
+		{name: "other code", err: &pgconn.PgError{Code: "23505", ConstraintName: balanceNonNegativeConstraint}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := translatePgError(tt.err); got != tt.err {
+				t.Errorf("translatePgError() = %v, want %v unchanged", got, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package adapter
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/yammine/yamex-go/notabankbot/app"
+	"github.com/yammine/yamex-go/notabankbot/domain"
+)
+
+type Ed25519Signer struct {
+	key   ed25519.PrivateKey
+	keyID string
+	// Public keys by key ID, the signing key's and any retired ones, so
+	// checkpoints signed before a key rotation still verify.
+	publicKeys map[string]ed25519.PublicKey
+}
+
+// NewLedgerSigner builds a signer from a base64 encoded ed25519 seed and the
+// base64 public keys it replaced. An empty seed disables signing and returns a
+// nil signer.
+func NewLedgerSigner(encodedSeed string, retiredKeys []string) (app.Signer, error) {
+	if encodedSeed == "" {
+		return nil, nil
+	}
+	seed, err := base64.StdEncoding.DecodeString(encodedSeed)
+	if err != nil {
+		return nil, fmt.Errorf("decoding ledger signing key: %w", err)
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("ledger signing key must be a %d byte ed25519 seed, got %d bytes", ed25519.SeedSize, len(seed))
+	}
+
+	key := ed25519.NewKeyFromSeed(seed)
+	publicKey := key.Public().(ed25519.PublicKey)
+	signer := &Ed25519Signer{
+		key:        key,
+		keyID:      domain.KeyID(publicKey),
+		publicKeys: map[string]ed25519.PublicKey{domain.KeyID(publicKey): publicKey},
+	}
+	for _, encoded := range retiredKeys {
+		retired, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(retired) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("retired ledger key %q must be a base64 ed25519 public key", encoded)
+		}
+		signer.publicKeys[domain.KeyID(retired)] = retired
+	}
+	return signer, nil
+}
+
+func (s Ed25519Signer) KeyID() string {
+	return s.keyID
+}
+
+func (s Ed25519Signer) PublicKey() ed25519.PublicKey {
+	return s.key.Public().(ed25519.PublicKey)
+}
+
+func (s Ed25519Signer) Sign(payload []byte) []byte {
+	return ed25519.Sign(s.key, payload)
+}
+
+func (s Ed25519Signer) Verify(keyID string, payload, signature []byte) (bool, error) {
+	publicKey, ok := s.publicKeys[keyID]
+	if !ok {
+		return false, app.ErrUnknownSigningKey
+	}
+	return ed25519.Verify(publicKey, payload, signature), nil
+}
+
+var _ app.Signer = (*Ed25519Signer)(nil)
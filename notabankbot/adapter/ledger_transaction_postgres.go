@@ -0,0 +1,53 @@
+package adapter
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"gorm.io/gorm"
+
+	"github.com/yammine/yamex-go/notabankbot/metrics"
+)
+
+const (
+	// Postgres error codes for transactions that may succeed if retried.
+	pgSerializationFailure = "40001"
+	pgDeadlockDetected     = "40P01"
+
+	ledgerTransactionAttempts = 3
+)
+
+// ledgerTransaction runs fn in a transaction, retrying it when Postgres aborts
+// it because of a deadlock or serialization failure. fn must be safe to run
+// again. Latency and retries are recorded against operation.
+func (p PostgresRepository) ledgerTransaction(ctx context.Context, operation string, fn func(tx *gorm.DB) error) error {
+	start := time.Now()
+	var err error
+	for attempt := 1; attempt <= ledgerTransactionAttempts; attempt++ {
+		if attempt > 1 {
+			metrics.LedgerTransactionRetries.WithLabelValues(operation).Inc()
+		}
+		err = p.DB.WithContext(ctx).Transaction(fn)
+		if !retryableTransaction(err) || ctx.Err() != nil {
+			break
+		}
+	}
+
+	outcome := "committed"
+	if err != nil {
+		outcome = "rolled_back"
+	}
+	metrics.LedgerTransactionDuration.WithLabelValues(operation, outcome).Observe(time.Since(start).Seconds())
+
+	return err
+}
+
+func retryableTransaction(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return pgErr.Code == pgSerializationFailure || pgErr.Code == pgDeadlockDetected
+}
@@ -0,0 +1,109 @@
+package adapter
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/yammine/yamex-go/notabankbot/domain"
+)
+
+const (
+	// openingBalancesVersion is the schema version that brought balances from
+	// before journal entries into the journal.
+	openingBalancesVersion = 3
+	openingBalanceReason   = "opening balance"
+)
+
+// backfillOpeningBalances runs once, bringing balances that predate journal
+// entries into the journal. Movements recorded back then belong to no entry,
+// and their grants never debited an issuer, so each account gets an opening
+// entry that takes in its legacy movements, balanced against its currency's
+// issuer. Legacy movements keep their dates, so balances as of earlier days
+// and the snapshots built from them don't change. From then on only journaled
+// movements count towards the ledger, otherwise Reconcile would report the
+// same drift forever.
+func backfillOpeningBalances(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		// Servers migrating at the same time wait here, then find it done.
+		if err := tx.Exec("LOCK TABLE schema_versions IN EXCLUSIVE MODE").Error; err != nil {
+			return fmt.Errorf("locking schema versions: %w", err)
+		}
+		var done int64
+		if err := tx.Model(&SchemaVersion{}).Where("version >= ?", openingBalancesVersion).Count(&done).Error; err != nil {
+			return fmt.Errorf("reading schema version: %w", err)
+		}
+		if done > 0 {
+			return nil
+		}
+
+		var accountIDs []uint
+		err := tx.Model(&domain.Account{}).Where("NOT allow_overdraft").Order("id").Pluck("id", &accountIDs).Error
+		if err != nil {
+			return fmt.Errorf("listing accounts: %w", err)
+		}
+		for _, id := range accountIDs {
+			if err := openAccount(tx, id); err != nil {
+				return fmt.Errorf("opening account %d: %w", id, err)
+			}
+		}
+		if err := tx.Create(&SchemaVersion{Version: openingBalancesVersion, MigratedAt: time.Now()}).Error; err != nil {
+			return fmt.Errorf("recording schema version: %w", err)
+		}
+		return nil
+	})
+}
+
+// openAccount journals the account's legacy movements, along with an opening
+// movement for any part of its balance that no movement adds up to. The
+// balance is unchanged.
+func openAccount(tx *gorm.DB, accountID uint) error {
+	var account domain.Account
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&account, accountID).Error; err != nil {
+		return fmt.Errorf("get account exclusive: %w", err)
+	}
+	var journaled decimal.Decimal
+	err := tx.Model(&domain.Movement{}).
+		Select("COALESCE(SUM(amount), 0)").
+		Where("account_id = ? AND journal_entry_id IS NOT NULL", account.ID).
+		Row().Scan(&journaled)
+	if err != nil {
+		return fmt.Errorf("summing movements: %w", err)
+	}
+	var movements []*domain.Movement
+	if err := tx.Where("account_id = ? AND journal_entry_id IS NULL", account.ID).Order("id").Find(&movements).Error; err != nil {
+		return fmt.Errorf("listing legacy movements: %w", err)
+	}
+
+	amount := account.Balance.Sub(journaled)
+	unexplained := amount
+	for _, m := range movements {
+		unexplained = unexplained.Sub(m.Amount)
+	}
+	// Nothing recorded it, so it only shows up in balances from now on.
+	if !unexplained.IsZero() {
+		movements = append(movements, domain.NewMovement(&account, unexplained, openingBalanceReason))
+	}
+	if !amount.IsZero() {
+		issuer, err := getIssuerAccountExclusive(tx, account.Currency)
+		if err != nil {
+			return fmt.Errorf("get issuer account exclusive: %w", err)
+		}
+		issuance, _ := issuer.Credit(amount.Neg(), openingBalanceReason)
+		if err := tx.Save(issuer).Error; err != nil {
+			return fmt.Errorf("updating issuer account: %w", err)
+		}
+		movements = append(movements, issuance)
+	}
+	if len(movements) == 0 {
+		return nil
+	}
+
+	// Opening balances are not made on behalf of a workspace. Legacy movements
+	// already exist, the association only sets their journal entry.
+	_, err = createJournalEntry(tx, domain.SystemWorkspaceID, movements...)
+	return translatePgError(err)
+}
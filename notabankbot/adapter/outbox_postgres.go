@@ -0,0 +1,84 @@
+package adapter
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/yammine/yamex-go/notabankbot/app"
+	"github.com/yammine/yamex-go/notabankbot/domain"
+)
+
+// Claiming pushes next_attempt_at past the lease, SKIP LOCKED keeps replicas
+// from claiming the same messages at once.
+const claimOutboxMessagesSQL = `
+UPDATE outbox_messages SET next_attempt_at = @lease_until
+WHERE id IN (
+	SELECT id FROM outbox_messages
+	WHERE delivered_at IS NULL AND failed_at IS NULL AND next_attempt_at <= @now
+	ORDER BY id
+	LIMIT @limit
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING *`
+
+func (p PostgresRepository) EnqueueOutboxMessage(ctx context.Context, msg *domain.OutboxMessage) error {
+	if err := p.DB.WithContext(ctx).Create(msg).Error; err != nil {
+		return fmt.Errorf("inserting outbox message: %w", err)
+	}
+	return nil
+}
+
+func (p PostgresRepository) ClaimOutboxMessages(ctx context.Context, limit int, lease time.Duration) ([]*domain.OutboxMessage, error) {
+	var msgs []*domain.OutboxMessage
+	now := time.Now()
+	err := p.DB.WithContext(ctx).Raw(claimOutboxMessagesSQL,
+		sql.Named("now", now),
+		sql.Named("lease_until", now.Add(lease)),
+		sql.Named("limit", limit),
+	).Scan(&msgs).Error
+	if err != nil {
+		return nil, fmt.Errorf("claiming outbox messages: %w", err)
+	}
+
+	return msgs, nil
+}
+
+func (p PostgresRepository) SaveOutboxMessage(ctx context.Context, msg *domain.OutboxMessage) error {
+	if err := p.DB.WithContext(ctx).Save(msg).Error; err != nil {
+		return fmt.Errorf("updating outbox message: %w", err)
+	}
+	return nil
+}
+
+func (p PostgresRepository) CountPendingOutboxMessages(ctx context.Context) (int64, error) {
+	var count int64
+	err := p.DB.WithContext(ctx).Model(&domain.OutboxMessage{}).
+		Where("delivered_at IS NULL AND failed_at IS NULL").
+		Count(&count).Error
+	if err != nil {
+		return 0, fmt.Errorf("counting outbox messages: %w", err)
+	}
+	return count, nil
+}
+
+// createReply queues the message announcing a ledger change, as part of the
+// change's transaction.
+func createReply(tx *gorm.DB, reply app.ReplyFunc, entry *domain.JournalEntry) error {
+	if reply == nil {
+		return nil
+	}
+	msg, err := reply(entry)
+	if err != nil {
+		return fmt.Errorf("building reply: %w", err)
+	}
+	msg.JournalEntryID = &entry.ID
+	msg.NextAttemptAt = time.Now()
+	if err := tx.Create(msg).Error; err != nil {
+		return fmt.Errorf("inserting reply: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package adapter
+
+import (
+	"database/sql/driver"
+	"encoding/base64"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// mockPostgres opens gorm on a mocked connection. Expectations are checked
+// when the test ends.
+func mockPostgres(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		sqlDB.Close()
+	})
+	return db, mock
+}
+
+// testKeyring returns a keyring holding the given key IDs, each key filled
+// with its ID's first byte, with the first one active.
+func testKeyring(t *testing.T, ids ...string) *TokenKeyring {
+	t.Helper()
+	keys := map[string]string{}
+	for _, id := range ids {
+		key := make([]byte, tokenKeySize)
+		for i := range key {
+			key[i] = id[0]
+		}
+		keys[id] = base64.StdEncoding.EncodeToString(key)
+	}
+	keyring, err := NewTokenKeyring(ids[0], keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+// captured matches any argument, remembering it.
+type captured struct {
+	value driver.Value
+}
+
+func (c *captured) Match(v driver.Value) bool {
+	c.value = v
+	return true
+}
+
+func (c *captured) bytes() []byte {
+	b, _ := c.value.([]byte)
+	return b
+}
@@ -1,14 +1,48 @@
 package adapter
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 // TODO: Use adapter defined models for marshalling/unmarshalling DB values.
 
 type SlackCredential struct {
 	gorm.Model
 
-	TeamID string `gorm:"uniqueIndex"`
-	Token  string
+	TeamID          string `gorm:"uniqueIndex"`
+	EnterpriseID    string
+	BotUserID       string
+	Scopes          string
+	InstallerUserID string
+	// Token is only set on rows saved before tokens were encrypted at rest,
+	// `yamex rotate-keys` encrypts and clears it.
+	Token string
+
+	// Envelope encrypted secrets, see TokenKeyring & slackSecrets.
+	KeyID        string
+	EncryptedKey []byte
+	Ciphertext   []byte
+	// When the access token expires, only set with token rotation.
+	ExpiresAt *time.Time
+}
+
+// slackSecrets is what gets encrypted into SlackCredential.Ciphertext.
+type slackSecrets struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+func (c SlackCredential) sealed() *SealedToken {
+	return &SealedToken{KeyID: c.KeyID, EncryptedKey: c.EncryptedKey, Ciphertext: c.Ciphertext}
+}
+
+func (c *SlackCredential) seal(token *SealedToken) {
+	c.Token = ""
+	c.KeyID = token.KeyID
+	c.EncryptedKey = token.EncryptedKey
+	c.Ciphertext = token.Ciphertext
 }
 
 type Feedback struct {
@@ -0,0 +1,161 @@
+package adapter
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
+	"github.com/rs/zerolog/log"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	connectMinBackoff = 500 * time.Millisecond
+	connectMaxBackoff = 10 * time.Second
+)
+
+// PostgresPoolConfig tunes the connection pool. Zero values are left to the
+// database/sql defaults, except ConnectTimeout which then tries only once.
+type PostgresPoolConfig struct {
+	DSN string
+	// ReplicaDSN, if set, serves read-only queries that can tolerate lag.
+	ReplicaDSN      string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	// StatementTimeout makes Postgres cancel any statement that runs longer.
+	StatementTimeout time.Duration
+	// ConnectTimeout is how long to keep retrying the first connection, e.g.
+	// while the database is still starting.
+	ConnectTimeout time.Duration
+}
+
+// PostgresPool is the connection pool shared by every Postgres adapter.
+type PostgresPool struct {
+	primary *gorm.DB
+	// replica is primary unless a replica is configured.
+	replica    *gorm.DB
+	connConfig *pgx.ConnConfig
+}
+
+// OpenPostgresPool connects to the primary, and replica if any, retrying with
+// backoff until ConnectTimeout.
+func OpenPostgresPool(ctx context.Context, config PostgresPoolConfig) (*PostgresPool, error) {
+	primary, connConfig, err := openPostgres(ctx, config.DSN, config)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to primary: %w", err)
+	}
+	pool := &PostgresPool{primary: primary, replica: primary, connConfig: connConfig}
+	if config.ReplicaDSN != "" {
+		if pool.replica, _, err = openPostgres(ctx, config.ReplicaDSN, config); err != nil {
+			pool.Close()
+			return nil, fmt.Errorf("connecting to replica: %w", err)
+		}
+	}
+
+	return pool, nil
+}
+
+func openPostgres(ctx context.Context, dsn string, config PostgresPoolConfig) (*gorm.DB, *pgx.ConnConfig, error) {
+	connConfig, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing dsn: %w", err)
+	}
+	if config.StatementTimeout > 0 {
+		connConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(config.StatementTimeout.Milliseconds(), 10)
+	}
+
+	sqlDB := stdlib.OpenDB(*connConfig)
+	sqlDB.SetMaxOpenConns(config.MaxOpenConns)
+	if config.MaxIdleConns > 0 {
+		// Zero would mean no idle connections at all.
+		sqlDB.SetMaxIdleConns(config.MaxIdleConns)
+	}
+	sqlDB.SetConnMaxLifetime(config.ConnMaxLifetime)
+	if err := pingWithRetry(ctx, sqlDB, config.ConnectTimeout); err != nil {
+		sqlDB.Close()
+		return nil, nil, err
+	}
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{DisableAutomaticPing: true})
+	if err != nil {
+		sqlDB.Close()
+		return nil, nil, err
+	}
+	if err := db.Use(gormTracing{}); err != nil {
+		sqlDB.Close()
+		return nil, nil, fmt.Errorf("instrumenting: %w", err)
+	}
+
+	return db, connConfig, nil
+}
+
+func pingWithRetry(ctx context.Context, db *sql.DB, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	backoff := connectMinBackoff
+	for {
+		err := db.PingContext(ctx)
+		if err == nil {
+			return nil
+		}
+		wait := backoff
+		if remaining := time.Until(deadline); remaining <= 0 {
+			return err
+		} else if remaining < wait {
+			wait = remaining
+		}
+		log.Warn().Err(err).Dur("retry_in", wait).Msg("Could not connect to database, retrying")
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		if backoff *= 2; backoff > connectMaxBackoff {
+			backoff = connectMaxBackoff
+		}
+	}
+}
+
+// Ping checks that the primary, and replica if any, are reachable.
+func (p *PostgresPool) Ping(ctx context.Context) error {
+	for name, db := range map[string]*gorm.DB{"primary": p.primary, "replica": p.replica} {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return err
+		}
+		if err := sqlDB.PingContext(ctx); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Close closes every connection in the pool.
+func (p *PostgresPool) Close() error {
+	var firstErr error
+	for _, db := range []*gorm.DB{p.primary, p.replica} {
+		sqlDB, err := db.DB()
+		if err == nil {
+			err = sqlDB.Close()
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if p.replica == p.primary {
+			break
+		}
+	}
+	return firstErr
+}
+
+// connect opens a connection to the primary outside the pool, for sessions
+// that hold on to it, e.g. LISTEN.
+func (p *PostgresPool) connect(ctx context.Context) (*pgx.Conn, error) {
+	return pgx.ConnectConfig(ctx, p.connConfig.Copy())
+}
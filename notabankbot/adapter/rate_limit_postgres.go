@@ -0,0 +1,63 @@
+package adapter
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/yammine/yamex-go/notabankbot/app"
+)
+
+// RateLimitBucket is the stored state of an app.RateLimitBucket. Tokens are
+// refilled lazily, from the time elapsed since UpdatedAt, whenever one is taken.
+type RateLimitBucket struct {
+	Key       string `gorm:"primarykey"`
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+// A full bucket is created on first use. The update only happens when a whole
+// token is available after refilling, so no row comes back when throttled.
+const takeTokenSQL = `
+INSERT INTO rate_limit_buckets AS bucket (key, tokens, updated_at)
+VALUES (@key, @burst - 1, clock_timestamp())
+ON CONFLICT (key) DO UPDATE SET
+	tokens = LEAST(@burst, bucket.tokens + EXTRACT(EPOCH FROM clock_timestamp() - bucket.updated_at) * @refill) - 1,
+	updated_at = clock_timestamp()
+WHERE LEAST(@burst, bucket.tokens + EXTRACT(EPOCH FROM clock_timestamp() - bucket.updated_at) * @refill) >= 1
+RETURNING tokens`
+
+func (p PostgresRepository) TakeTokens(ctx context.Context, buckets []*app.RateLimitBucket) (*app.RateLimitBucket, error) {
+	var empty *app.RateLimitBucket
+	err := p.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, b := range buckets {
+			var tokens float64
+			err := tx.Raw(takeTokenSQL,
+				sql.Named("key", b.Key),
+				sql.Named("burst", b.Limit.Burst),
+				sql.Named("refill", b.Limit.RefillPerSecond()),
+			).Row().Scan(&tokens)
+			if err == sql.ErrNoRows {
+				// Roll back tokens taken from the other buckets.
+				empty = b
+				return errBucketEmpty
+			}
+			if err != nil {
+				return fmt.Errorf("taking token from %s: %w", b.Key, err)
+			}
+		}
+		return nil
+	})
+	if err == errBucketEmpty {
+		return empty, nil
+	}
+
+	return nil, err
+}
+
+var errBucketEmpty = fmt.Errorf("rate limit bucket empty")
+
+var _ app.RateLimitStore = (*PostgresRepository)(nil)
@@ -0,0 +1,50 @@
+package adapter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/yammine/yamex-go"
+)
+
+// schemaVersion is bumped whenever Migrate changes the schema, so readiness
+// checks can spot a database that hasn't been migrated for this build.
+const schemaVersion = workspaceUsersVersion
+
+const ErrSchemaOutdated = yamex.Sentinel("database schema is older than this build")
+
+// SchemaVersion records each schema version Migrate has applied.
+type SchemaVersion struct {
+	Version    int `gorm:"primaryKey;autoIncrement:false"`
+	MigratedAt time.Time
+}
+
+func recordSchemaVersion(db *gorm.DB) error {
+	err := db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&SchemaVersion{Version: schemaVersion, MigratedAt: time.Now()}).Error
+	if err != nil {
+		return fmt.Errorf("recording schema version: %w", err)
+	}
+	return nil
+}
+
+// CheckSchema fails unless the database has been migrated for this build. A
+// newer schema is fine, migrations only ever add to it.
+func (p PostgresRepository) CheckSchema(ctx context.Context) error {
+	var version *int
+	if err := p.DB.WithContext(ctx).Model(&SchemaVersion{}).Select("max(version)").Scan(&version).Error; err != nil {
+		return fmt.Errorf("reading schema version: %w", err)
+	}
+	if version == nil || *version < schemaVersion {
+		current := 0
+		if version != nil {
+			current = *version
+		}
+		return fmt.Errorf("%w: database is at version %d, expected %d", ErrSchemaOutdated, current, schemaVersion)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package adapter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	credentialsChangedChannel = "slack_credentials_changed"
+
+	listenMinBackoff = time.Second
+	listenMaxBackoff = 30 * time.Second
+)
+
+// notifyChanged tells every replica to evict the workspace from its cache.
+// A failed notification is only logged: the cache TTL still bounds staleness.
+func (s *SlackCredentialPostgres) notifyChanged(ctx context.Context, workspaceID string) {
+	if err := s.db.WithContext(ctx).Exec("SELECT pg_notify(?, ?)", credentialsChangedChannel, workspaceID).Error; err != nil {
+		log.Error().Err(err).Str("team", workspaceID).Msg("Failed to notify replicas of credential change")
+	}
+}
+
+// Listen evicts cached credentials whenever any replica changes them, until
+// ctx is cancelled. It reconnects with backoff, dropping the whole cache each
+// time since notifications may have been missed while disconnected.
+func (s *SlackCredentialPostgres) Listen(ctx context.Context) {
+	backoff := listenMinBackoff
+	for {
+		err := s.listen(ctx, func() { backoff = listenMinBackoff })
+		if ctx.Err() != nil {
+			return
+		}
+		s.cache.clear()
+		log.Error().Err(err).Dur("retry_in", backoff).Msg("Credential change listener disconnected")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > listenMaxBackoff {
+			backoff = listenMaxBackoff
+		}
+	}
+}
+
+func (s *SlackCredentialPostgres) listen(ctx context.Context, connected func()) error {
+	conn, err := s.pool.connect(ctx)
+	if err != nil {
+		return fmt.Errorf("connecting: %w", err)
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+credentialsChangedChannel); err != nil {
+		return fmt.Errorf("listening: %w", err)
+	}
+	connected()
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return fmt.Errorf("waiting for notification: %w", err)
+		}
+		s.cache.evict(notification.Payload)
+	}
+}
@@ -2,78 +2,213 @@ package adapter
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"sync"
+	"strings"
+	"time"
 
 	"gorm.io/gorm/clause"
 
-	"gorm.io/driver/postgres"
-
 	"gorm.io/gorm"
 
+	"github.com/yammine/yamex-go/notabankbot/metrics"
 	"github.com/yammine/yamex-go/notabankbot/port"
 )
 
-type SlackCredentialPostgres struct {
-	sync.RWMutex
+const rotateKeysBatchSize = 100
 
-	db    *gorm.DB
-	cache map[string]string
+type SlackCredentialPostgres struct {
+	db      *gorm.DB
+	pool    *PostgresPool
+	keyring *TokenKeyring
+	cache   *credentialCache
 }
 
-func NewSlackCredentialPostgresRepository(dsn string) *SlackCredentialPostgres {
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
-	if err != nil {
-		log.Fatalf("Could not connect to database: %s", err)
-	}
-
+func NewSlackCredentialPostgresRepository(pool *PostgresPool, keyring *TokenKeyring, cacheConfig CredentialCacheConfig) *SlackCredentialPostgres {
 	return &SlackCredentialPostgres{
-		db:    db,
-		cache: make(map[string]string),
+		db:      pool.primary,
+		pool:    pool,
+		keyring: keyring,
+		cache:   newCredentialCache(cacheConfig),
 	}
 }
 
-func (s SlackCredentialPostgres) Migrate() error {
+func (s *SlackCredentialPostgres) Migrate() error {
 	return s.db.AutoMigrate(&SlackCredential{})
 }
 
-func (s SlackCredentialPostgres) SaveCredentials(ctx context.Context, workspaceID, token string) error {
-	err := s.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(&SlackCredential{TeamID: workspaceID, Token: token}).Error
+// Ping checks that credentials can be read.
+func (s *SlackCredentialPostgres) Ping(ctx context.Context) error {
+	if err := s.db.WithContext(ctx).Exec("SELECT 1 FROM slack_credentials LIMIT 1").Error; err != nil {
+		return fmt.Errorf("reading credentials: %w", err)
+	}
+	return nil
+}
+
+func (s *SlackCredentialPostgres) SaveCredentials(ctx context.Context, installation *port.SlackInstallation) error {
+	creds := &SlackCredential{
+		TeamID:          installation.TeamID,
+		EnterpriseID:    installation.EnterpriseID,
+		BotUserID:       installation.BotUserID,
+		Scopes:          installation.Scopes,
+		InstallerUserID: installation.InstallerUserID,
+		ExpiresAt:       installation.ExpiresAt,
+	}
+	if err := s.seal(creds, installation); err != nil {
+		return err
+	}
+
+	// Reinstalling replaces the previous installation, including a revoked one.
+	err := s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "team_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"updated_at", "deleted_at", "enterprise_id", "bot_user_id", "scopes", "installer_user_id",
+			"token", "key_id", "encrypted_key", "ciphertext", "expires_at",
+		}),
+	}).Create(creds).Error
 	if err != nil {
 		return fmt.Errorf("insert credentials: %w", err)
 	}
-	// Update the cache
-	s.Lock()
-	defer s.Unlock()
-	s.cache[workspaceID] = token
+	// Update the cache, and tell other replicas to drop theirs
+	s.cache.set(installation.TeamID, installation)
+	s.notifyChanged(ctx, installation.TeamID)
 
 	return nil
 }
 
-func (s SlackCredentialPostgres) GetCredentials(ctx context.Context, workspaceID string) (string, error) {
-	s.RLock()
-
-	// Check cache first
-	token, ok := s.cache[workspaceID]
-	// Explicitly release read lock
-	s.RUnlock()
-	// That's a hit, return the token
-	if ok {
-		return token, nil
+func (s *SlackCredentialPostgres) GetCredentials(ctx context.Context, workspaceID string) (*port.SlackInstallation, error) {
+	// Check cache first, it remembers unknown workspaces too
+	if installation, ok := s.cache.get(workspaceID); ok {
+		if installation == nil {
+			metrics.CredentialCacheLookups.WithLabelValues("missing").Inc()
+			return nil, port.ErrUnknownWorkspace
+		}
+		metrics.CredentialCacheLookups.WithLabelValues("hit").Inc()
+		return installation, nil
 	}
+	metrics.CredentialCacheLookups.WithLabelValues("miss").Inc()
 
 	// Fallback to DB
 	creds := &SlackCredential{}
-	if err := s.db.Where("team_id = ?", workspaceID).First(creds).Error; err != nil {
-		return "", fmt.Errorf("could not find credentials: %w", err)
+	if err := s.db.WithContext(ctx).Where("team_id = ?", workspaceID).First(creds).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.cache.setMissing(workspaceID)
+			return nil, port.ErrUnknownWorkspace
+		}
+		return nil, fmt.Errorf("could not find credentials: %w", err)
+	}
+	installation, err := s.reveal(creds)
+	if err != nil {
+		return nil, err
 	}
 	// Populate cache for subsequent queries
-	s.Lock()
-	defer s.Unlock()
-	s.cache[creds.TeamID] = creds.Token
+	s.cache.set(creds.TeamID, installation)
+
+	return installation, nil
+}
+
+func (s *SlackCredentialPostgres) RevokeCredentials(ctx context.Context, workspaceID string) error {
+	err := s.db.WithContext(ctx).Model(&SlackCredential{}).Where("team_id = ?", workspaceID).Updates(map[string]interface{}{
+		"deleted_at":    time.Now(),
+		"token":         "",
+		"encrypted_key": nil,
+		"ciphertext":    nil,
+		"expires_at":    nil,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("revoke credentials: %w", err)
+	}
+	// Evict everywhere, otherwise we'd keep using the dead token.
+	s.cache.evict(workspaceID)
+	s.notifyChanged(ctx, workspaceID)
+
+	return nil
+}
+
+// RotateKeys re-encrypts every stored token with a fresh data key under the
+// active key, including rows still holding a plaintext token. Once it has
+// run, retired keys can be removed from the keyring.
+func (s *SlackCredentialPostgres) RotateKeys(ctx context.Context) (int, error) {
+	rotated := 0
+	var batch []*SlackCredential
+
+	err := s.db.WithContext(ctx).FindInBatches(&batch, rotateKeysBatchSize, func(_ *gorm.DB, _ int) error {
+		for _, creds := range batch {
+			installation, err := s.reveal(creds)
+			if err != nil {
+				return err
+			}
+			if err := s.seal(creds, installation); err != nil {
+				return fmt.Errorf("team %s: %w", creds.TeamID, err)
+			}
+
+			// The batch's session carries its query, each row gets a fresh one.
+			err = s.db.WithContext(ctx).Model(&SlackCredential{}).Where("id = ?", creds.ID).Updates(map[string]interface{}{
+				"token":         "",
+				"key_id":        creds.KeyID,
+				"encrypted_key": creds.EncryptedKey,
+				"ciphertext":    creds.Ciphertext,
+			}).Error
+			if err != nil {
+				return fmt.Errorf("team %s: saving re-encrypted token: %w", creds.TeamID, err)
+			}
+			rotated++
+		}
+		return nil
+	}).Error
+
+	return rotated, err
+}
+
+func (s *SlackCredentialPostgres) seal(creds *SlackCredential, installation *port.SlackInstallation) error {
+	secrets, err := json.Marshal(slackSecrets{Token: installation.Token, RefreshToken: installation.RefreshToken})
+	if err != nil {
+		return err
+	}
+	sealed, err := s.keyring.Seal(string(secrets))
+	if err != nil {
+		return err
+	}
+	creds.seal(sealed)
+
+	return nil
+}
+
+func (s *SlackCredentialPostgres) reveal(creds *SlackCredential) (*port.SlackInstallation, error) {
+	installation := &port.SlackInstallation{
+		TeamID:          creds.TeamID,
+		EnterpriseID:    creds.EnterpriseID,
+		BotUserID:       creds.BotUserID,
+		Scopes:          creds.Scopes,
+		InstallerUserID: creds.InstallerUserID,
+		ExpiresAt:       creds.ExpiresAt,
+	}
+
+	// Saved before encryption at rest, still usable until keys are rotated.
+	if creds.Ciphertext == nil {
+		installation.Token = creds.Token
+		return installation, nil
+	}
+
+	plaintext, err := s.keyring.Open(creds.sealed())
+	if err != nil {
+		return nil, fmt.Errorf("team %s: %w", creds.TeamID, err)
+	}
+	// Rows encrypted before token rotation hold the bare token.
+	if !strings.HasPrefix(plaintext, "{") {
+		installation.Token = plaintext
+		return installation, nil
+	}
+
+	var secrets slackSecrets
+	if err := json.Unmarshal([]byte(plaintext), &secrets); err != nil {
+		return nil, fmt.Errorf("team %s: decoding secrets: %w", creds.TeamID, err)
+	}
+	installation.Token = secrets.Token
+	installation.RefreshToken = secrets.RefreshToken
 
-	return creds.Token, nil
+	return installation, nil
 }
 
 var _ port.SlackCredentialStore = (*SlackCredentialPostgres)(nil)
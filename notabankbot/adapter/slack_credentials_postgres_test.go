@@ -0,0 +1,58 @@
+package adapter
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"github.com/yammine/yamex-go/notabankbot/port"
+)
+
+func TestSlackCredentialsRotateKeys(t *testing.T) {
+	db, mock := mockPostgres(t)
+	old := &SlackCredentialPostgres{keyring: testKeyring(t, "old")}
+	legacy := &SlackCredential{TeamID: "T1"}
+	if err := old.seal(legacy, &port.SlackInstallation{Token: "xoxb-1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &SlackCredentialPostgres{db: db, keyring: testKeyring(t, "new", "old")}
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "slack_credentials"`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "team_id", "token", "key_id", "encrypted_key", "ciphertext"}).
+			AddRow(1, "T1", "", legacy.KeyID, legacy.EncryptedKey, legacy.Ciphertext).
+			// Saved before tokens were encrypted at rest.
+			AddRow(2, "T2", "xoxb-2", "", nil, nil))
+
+	rows := map[uint][]*captured{}
+	for _, id := range []uint{1, 2} {
+		rows[id] = []*captured{{}, {}, {}}
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "slack_credentials" SET "ciphertext"=$1,"encrypted_key"=$2,"key_id"=$3,"token"=$4,"updated_at"=$5 WHERE id = $6 AND "slack_credentials"."deleted_at" IS NULL`)).
+			WithArgs(rows[id][0], rows[id][1], rows[id][2], "", sqlmock.AnyArg(), id).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+
+	rotated, err := s.RotateKeys(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated != 2 {
+		t.Errorf("rotated = %d, want 2", rotated)
+	}
+
+	// Retired keys can go once every row has been rotated.
+	s.keyring = testKeyring(t, "new")
+	for id, want := range map[uint]string{1: "xoxb-1", 2: "xoxb-2"} {
+		creds := &SlackCredential{KeyID: rows[id][2].value.(string), EncryptedKey: rows[id][1].bytes(), Ciphertext: rows[id][0].bytes()}
+		installation, err := s.reveal(creds)
+		if err != nil {
+			t.Fatalf("row %d: %v", id, err)
+		}
+		if installation.Token != want {
+			t.Errorf("row %d token = %q, want %q", id, installation.Token, want)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package adapter
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/yammine/yamex-go"
+)
+
+const (
+	ErrUnknownTokenKey = yamex.Sentinel("token encrypted with unknown key")
+	ErrNoActiveKey     = yamex.Sentinel("no active token encryption key")
+	ErrNoTokenKeyring  = yamex.Sentinel("no token encryption keys configured")
+
+	tokenKeySize = 32
+)
+
+// TokenKeyring holds the key-encryption keys used to protect Slack tokens at
+// rest. Each token is encrypted with its own data key, which is in turn
+// encrypted with the active key-encryption key (envelope encryption). Old keys
+// stay in the keyring so existing rows can still be read until rotated.
+type TokenKeyring struct {
+	active string
+	keys   map[string][]byte
+}
+
+// SealedToken is what gets persisted for a token.
+type SealedToken struct {
+	KeyID        string
+	EncryptedKey []byte
+	Ciphertext   []byte
+}
+
+// NewTokenKeyring builds a keyring from base64 encoded 32 byte keys by ID.
+func NewTokenKeyring(active string, encodedKeys map[string]string) (*TokenKeyring, error) {
+	keys := make(map[string][]byte, len(encodedKeys))
+	for id, encoded := range encodedKeys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("decoding token key %q: %w", id, err)
+		}
+		if len(key) != tokenKeySize {
+			return nil, fmt.Errorf("token key %q must be %d bytes, got %d", id, tokenKeySize, len(key))
+		}
+		keys[id] = key
+	}
+	if _, ok := keys[active]; !ok {
+		return nil, fmt.Errorf("%w: %q is not in the keyring", ErrNoActiveKey, active)
+	}
+
+	return &TokenKeyring{active: active, keys: keys}, nil
+}
+
+// ReadTokenKeyFile reads `id=base64key` lines, ignoring blanks and # comments.
+func ReadTokenKeyFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening token key file: %w", err)
+	}
+	defer f.Close()
+
+	keys := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("malformed line in token key file, expected id=key")
+		}
+		keys[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	return keys, scanner.Err()
+}
+
+func (k TokenKeyring) ActiveKeyID() string {
+	return k.active
+}
+
+// Seal encrypts the token under a fresh data key wrapped by the active key.
+func (k TokenKeyring) Seal(token string) (*SealedToken, error) {
+	dataKey := make([]byte, tokenKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, fmt.Errorf("generating data key: %w", err)
+	}
+	ciphertext, err := sealGCM(dataKey, []byte(token))
+	if err != nil {
+		return nil, fmt.Errorf("encrypting token: %w", err)
+	}
+	encryptedKey, err := sealGCM(k.keys[k.active], dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("encrypting data key: %w", err)
+	}
+
+	return &SealedToken{KeyID: k.active, EncryptedKey: encryptedKey, Ciphertext: ciphertext}, nil
+}
+
+func (k TokenKeyring) Open(sealed *SealedToken) (string, error) {
+	kek, ok := k.keys[sealed.KeyID]
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnknownTokenKey, sealed.KeyID)
+	}
+	dataKey, err := openGCM(kek, sealed.EncryptedKey)
+	if err != nil {
+		return "", fmt.Errorf("decrypting data key: %w", err)
+	}
+	token, err := openGCM(dataKey, sealed.Ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("decrypting token: %w", err)
+	}
+
+	return string(token), nil
+}
+
+// sealGCM encrypts with AES-256-GCM, prefixing the random nonce.
+func sealGCM(key, plaintext []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func openGCM(key, sealed []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// LoadTokenKeyring merges keys given inline with those from keysFile, if set.
+// Key IDs are case-insensitive since viper lowercases map keys.
+func LoadTokenKeyring(active, keysFile string, inline map[string]string) (*TokenKeyring, error) {
+	keys := map[string]string{}
+	for id, key := range inline {
+		keys[strings.ToLower(id)] = key
+	}
+	if keysFile != "" {
+		fromFile, err := ReadTokenKeyFile(keysFile)
+		if err != nil {
+			return nil, err
+		}
+		for id, key := range fromFile {
+			keys[strings.ToLower(id)] = key
+		}
+	}
+
+	return NewTokenKeyring(strings.ToLower(active), keys)
+}
@@ -0,0 +1,77 @@
+package adapter
+
+import (
+	"errors"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const gormSpanKey = "yamex:span"
+
+var tracer = otel.Tracer("github.com/yammine/yamex-go/notabankbot/adapter")
+
+// gormTracing is a gorm plugin that wraps every query in a span, so lock waits
+// such as getAccountExclusive's SELECT ... FOR UPDATE show up in traces.
+type gormTracing struct{}
+
+func (gormTracing) Name() string {
+	return "yamex:tracing"
+}
+
+func (gormTracing) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	for _, err := range []error{
+		cb.Create().Before("gorm:create").Register("yamex:before_create", startGormSpan("create")),
+		cb.Create().After("gorm:create").Register("yamex:after_create", endGormSpan),
+		cb.Query().Before("gorm:query").Register("yamex:before_query", startGormSpan("query")),
+		cb.Query().After("gorm:query").Register("yamex:after_query", endGormSpan),
+		cb.Update().Before("gorm:update").Register("yamex:before_update", startGormSpan("update")),
+		cb.Update().After("gorm:update").Register("yamex:after_update", endGormSpan),
+		cb.Delete().Before("gorm:delete").Register("yamex:before_delete", startGormSpan("delete")),
+		cb.Delete().After("gorm:delete").Register("yamex:after_delete", endGormSpan),
+		cb.Row().Before("gorm:row").Register("yamex:before_row", startGormSpan("row")),
+		cb.Row().After("gorm:row").Register("yamex:after_row", endGormSpan),
+		cb.Raw().Before("gorm:raw").Register("yamex:before_raw", startGormSpan("raw")),
+		cb.Raw().After("gorm:raw").Register("yamex:after_raw", endGormSpan),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func startGormSpan(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		if db.Statement == nil || db.Statement.Context == nil {
+			return
+		}
+		ctx, span := tracer.Start(db.Statement.Context, "gorm."+operation, trace.WithSpanKind(trace.SpanKindClient))
+		db.Statement.Context = ctx
+		db.InstanceSet(gormSpanKey, span)
+	}
+}
+
+func endGormSpan(db *gorm.DB) {
+	value, ok := db.InstanceGet(gormSpanKey)
+	if !ok {
+		return
+	}
+	span := value.(trace.Span)
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("db.system", "postgresql"),
+		attribute.String("db.sql.table", db.Statement.Table),
+		attribute.String("db.statement", db.Statement.SQL.String()),
+		attribute.Int64("db.rows_affected", db.Statement.RowsAffected),
+	)
+	if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+		span.RecordError(db.Error)
+		span.SetStatus(codes.Error, db.Error.Error())
+	}
+}
@@ -0,0 +1,231 @@
+package adapter
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/yammine/yamex-go/notabankbot/app"
+	"github.com/yammine/yamex-go/notabankbot/domain"
+)
+
+// Claiming works as for the outbox, see claimOutboxMessagesSQL.
+const claimWebhookDeliveriesSQL = `
+UPDATE webhook_deliveries SET next_attempt_at = @lease_until
+WHERE id IN (
+	SELECT id FROM webhook_deliveries
+	WHERE delivered_at IS NULL AND failed_at IS NULL AND next_attempt_at <= @now
+	ORDER BY id
+	LIMIT @limit
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING *`
+
+// WebhookEndpoint stores a domain.WebhookEndpoint with its secret sealed by
+// the token keyring. The embedded Secret column is only set on rows saved
+// before secrets were encrypted at rest, `yamex rotate-keys` encrypts and
+// clears it.
+type WebhookEndpoint struct {
+	domain.WebhookEndpoint
+
+	KeyID        string
+	EncryptedKey []byte
+	Ciphertext   []byte
+}
+
+func (p PostgresRepository) SaveWebhookEndpoint(ctx context.Context, endpoint *domain.WebhookEndpoint) error {
+	row := &WebhookEndpoint{WebhookEndpoint: *endpoint}
+	if err := p.sealWebhookSecret(row); err != nil {
+		return err
+	}
+	if err := p.DB.WithContext(ctx).Save(row).Error; err != nil {
+		return fmt.Errorf("saving webhook endpoint: %w", err)
+	}
+	endpoint.ID = row.ID
+	endpoint.CreatedAt = row.CreatedAt
+	return nil
+}
+
+func (p PostgresRepository) GetWebhookEndpoint(ctx context.Context, id uint) (*domain.WebhookEndpoint, error) {
+	var row WebhookEndpoint
+	err := p.DB.WithContext(ctx).Take(&row, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, app.ErrUnknownWebhookEndpoint
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fetching webhook endpoint: %w", err)
+	}
+	return p.revealWebhookSecret(&row)
+}
+
+// ListWebhookEndpoints leaves secrets out, they're only revealed to sign
+// deliveries.
+func (p PostgresRepository) ListWebhookEndpoints(ctx context.Context, workspaceID string) ([]*domain.WebhookEndpoint, error) {
+	var rows []*WebhookEndpoint
+	query := p.DB.WithContext(ctx).Order("id")
+	if workspaceID != "" {
+		query = query.Where("workspace_id = ?", workspaceID)
+	}
+	if err := query.Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("listing webhook endpoints: %w", err)
+	}
+	endpoints := make([]*domain.WebhookEndpoint, len(rows))
+	for n, row := range rows {
+		endpoint := row.WebhookEndpoint
+		endpoint.Secret = ""
+		endpoints[n] = &endpoint
+	}
+	return endpoints, nil
+}
+
+// RotateWebhookSecrets re-encrypts every endpoint's secret under the active
+// key, including rows still holding a plaintext secret.
+func (p PostgresRepository) RotateWebhookSecrets(ctx context.Context) (int, error) {
+	rotated := 0
+	var batch []*WebhookEndpoint
+
+	err := p.DB.WithContext(ctx).FindInBatches(&batch, rotateKeysBatchSize, func(_ *gorm.DB, _ int) error {
+		for _, row := range batch {
+			endpoint, err := p.revealWebhookSecret(row)
+			if err != nil {
+				return err
+			}
+			row.Secret = endpoint.Secret
+			if err := p.sealWebhookSecret(row); err != nil {
+				return err
+			}
+
+			// The batch's session carries its query, each row gets a fresh one.
+			err = p.DB.WithContext(ctx).Model(&WebhookEndpoint{}).Where("id = ?", row.ID).Updates(map[string]interface{}{
+				"secret":        "",
+				"key_id":        row.KeyID,
+				"encrypted_key": row.EncryptedKey,
+				"ciphertext":    row.Ciphertext,
+			}).Error
+			if err != nil {
+				return fmt.Errorf("webhook endpoint %d: saving re-encrypted secret: %w", row.ID, err)
+			}
+			rotated++
+		}
+		return nil
+	}).Error
+
+	return rotated, err
+}
+
+func (p PostgresRepository) sealWebhookSecret(row *WebhookEndpoint) error {
+	if p.keyring == nil {
+		return ErrNoTokenKeyring
+	}
+	sealed, err := p.keyring.Seal(row.Secret)
+	if err != nil {
+		return fmt.Errorf("webhook endpoint %d: encrypting secret: %w", row.ID, err)
+	}
+	row.Secret = ""
+	row.KeyID = sealed.KeyID
+	row.EncryptedKey = sealed.EncryptedKey
+	row.Ciphertext = sealed.Ciphertext
+	return nil
+}
+
+func (p PostgresRepository) revealWebhookSecret(row *WebhookEndpoint) (*domain.WebhookEndpoint, error) {
+	endpoint := row.WebhookEndpoint
+	// Saved before encryption at rest, still usable until keys are rotated.
+	if row.Ciphertext == nil {
+		return &endpoint, nil
+	}
+	if p.keyring == nil {
+		return nil, ErrNoTokenKeyring
+	}
+	secret, err := p.keyring.Open(&SealedToken{KeyID: row.KeyID, EncryptedKey: row.EncryptedKey, Ciphertext: row.Ciphertext})
+	if err != nil {
+		return nil, fmt.Errorf("webhook endpoint %d: %w", row.ID, err)
+	}
+	endpoint.Secret = secret
+	return &endpoint, nil
+}
+
+func (p PostgresRepository) EnqueueWebhookDelivery(ctx context.Context, delivery *domain.WebhookDelivery) error {
+	if err := p.DB.WithContext(ctx).Create(delivery).Error; err != nil {
+		return fmt.Errorf("inserting webhook delivery: %w", err)
+	}
+	return nil
+}
+
+func (p PostgresRepository) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*domain.WebhookDelivery, error) {
+	var deliveries []*domain.WebhookDelivery
+	now := time.Now()
+	err := p.DB.WithContext(ctx).Raw(claimWebhookDeliveriesSQL,
+		sql.Named("now", now),
+		sql.Named("lease_until", now.Add(lease)),
+		sql.Named("limit", limit),
+	).Scan(&deliveries).Error
+	if err != nil {
+		return nil, fmt.Errorf("claiming webhook deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+func (p PostgresRepository) SaveWebhookDelivery(ctx context.Context, delivery *domain.WebhookDelivery) error {
+	if err := p.DB.WithContext(ctx).Save(delivery).Error; err != nil {
+		return fmt.Errorf("updating webhook delivery: %w", err)
+	}
+	return nil
+}
+
+func (p PostgresRepository) ListWebhookDeliveries(ctx context.Context, endpointID, beforeID uint, limit int) ([]*domain.WebhookDelivery, error) {
+	var deliveries []*domain.WebhookDelivery
+	query := p.reads.WithContext(ctx).Where("endpoint_id = ?", endpointID)
+	if beforeID != 0 {
+		query = query.Where("id < ?", beforeID)
+	}
+	if err := query.Order("id DESC").Limit(limit).Find(&deliveries).Error; err != nil {
+		return nil, fmt.Errorf("listing webhook deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+func (p PostgresRepository) CountPendingWebhookDeliveries(ctx context.Context) (int64, error) {
+	var count int64
+	err := p.DB.WithContext(ctx).Model(&domain.WebhookDelivery{}).
+		Where("delivered_at IS NULL AND failed_at IS NULL").
+		Count(&count).Error
+	if err != nil {
+		return 0, fmt.Errorf("counting webhook deliveries: %w", err)
+	}
+	return count, nil
+}
+
+// createWebhookDeliveries queues a ledger change's events for the workspace's
+// endpoints, as part of the change's transaction.
+func createWebhookDeliveries(tx *gorm.DB, workspaceID string, events app.EventFunc, entry *domain.JournalEntry, grant *domain.Grant) error {
+	if events == nil {
+		return nil
+	}
+	var endpoints []*domain.WebhookEndpoint
+	if err := tx.Where("workspace_id = ? AND disabled_at IS NULL", workspaceID).Find(&endpoints).Error; err != nil {
+		return fmt.Errorf("fetching webhook endpoints: %w", err)
+	}
+	if len(endpoints) == 0 {
+		return nil
+	}
+
+	changeEvents := events(entry, grant)
+	for _, endpoint := range endpoints {
+		deliveries, err := endpoint.Deliveries(changeEvents)
+		if err != nil {
+			return err
+		}
+		if len(deliveries) == 0 {
+			continue
+		}
+		if err := tx.Create(&deliveries).Error; err != nil {
+			return fmt.Errorf("inserting webhook deliveries: %w", err)
+		}
+	}
+	return nil
+}
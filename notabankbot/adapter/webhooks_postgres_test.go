@@ -0,0 +1,72 @@
+package adapter
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"github.com/yammine/yamex-go/notabankbot/domain"
+)
+
+func TestRotateWebhookSecrets(t *testing.T) {
+	db, mock := mockPostgres(t)
+	old := PostgresRepository{keyring: testKeyring(t, "old")}
+	sealed := &WebhookEndpoint{WebhookEndpoint: domain.WebhookEndpoint{ID: 1, Secret: "whsec_1"}}
+	if err := old.sealWebhookSecret(sealed); err != nil {
+		t.Fatal(err)
+	}
+
+	p := PostgresRepository{DB: db, keyring: testKeyring(t, "new", "old")}
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT * FROM "webhook_endpoints"`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "workspace_id", "secret", "key_id", "encrypted_key", "ciphertext"}).
+			AddRow(1, "T1", "", sealed.KeyID, sealed.EncryptedKey, sealed.Ciphertext).
+			// Saved before secrets were encrypted at rest.
+			AddRow(2, "T1", "whsec_2", "", nil, nil))
+
+	rows := map[uint][]*captured{}
+	for _, id := range []uint{1, 2} {
+		rows[id] = []*captured{{}, {}, {}}
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "webhook_endpoints" SET "ciphertext"=$1,"encrypted_key"=$2,"key_id"=$3,"secret"=$4 WHERE id = $5`)).
+			WithArgs(rows[id][0], rows[id][1], rows[id][2], "", id).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+
+	rotated, err := p.RotateWebhookSecrets(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated != 2 {
+		t.Errorf("rotated = %d, want 2", rotated)
+	}
+
+	// Retired keys can go once every row has been rotated.
+	p.keyring = testKeyring(t, "new")
+	for id, want := range map[uint]string{1: "whsec_1", 2: "whsec_2"} {
+		row := &WebhookEndpoint{KeyID: rows[id][2].value.(string), EncryptedKey: rows[id][1].bytes(), Ciphertext: rows[id][0].bytes()}
+		endpoint, err := p.revealWebhookSecret(row)
+		if err != nil {
+			t.Fatalf("row %d: %v", id, err)
+		}
+		if endpoint.Secret != want {
+			t.Errorf("row %d secret = %q, want %q", id, endpoint.Secret, want)
+		}
+	}
+}
+
+func TestWebhookSecretsNeedKeyring(t *testing.T) {
+	p := PostgresRepository{}
+	if err := p.sealWebhookSecret(&WebhookEndpoint{}); !errors.Is(err, ErrNoTokenKeyring) {
+		t.Errorf("sealing without a keyring = %v, want %v", err, ErrNoTokenKeyring)
+	}
+
+	legacy := &WebhookEndpoint{WebhookEndpoint: domain.WebhookEndpoint{Secret: "whsec_1"}}
+	endpoint, err := p.revealWebhookSecret(legacy)
+	if err != nil || endpoint.Secret != "whsec_1" {
+		t.Errorf("revealing a plaintext secret = %v, %v, want it as saved", endpoint, err)
+	}
+}
@@ -0,0 +1,86 @@
+package adapter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/yammine/yamex-go/notabankbot/app"
+	"github.com/yammine/yamex-go/notabankbot/domain"
+)
+
+// workspaceUsersVersion is the schema version that started recording
+// workspace membership.
+const workspaceUsersVersion = 4
+
+func (p PostgresRepository) JoinWorkspace(ctx context.Context, workspaceID, slackUserID string) (*domain.User, error) {
+	if slackUserID == "" {
+		return nil, app.ErrCannotFindOrCreateUser
+	}
+	user := domain.User{SlackID: slackUserID}
+	err := p.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.FirstOrCreate(&user, user).Error; err != nil {
+			return fmt.Errorf("fetching user: %w", err)
+		}
+		return addWorkspaceUsers(tx, workspaceID, &user)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// addWorkspaceUsers makes the users members of the workspace, if they aren't
+// already. Changes made outside any workspace don't make anyone a member.
+func addWorkspaceUsers(tx *gorm.DB, workspaceID string, users ...*domain.User) error {
+	if workspaceID == domain.SystemWorkspaceID {
+		return nil
+	}
+	members := make([]*domain.WorkspaceUser, len(users))
+	for i, u := range users {
+		members[i] = &domain.WorkspaceUser{WorkspaceID: workspaceID, UserID: u.ID}
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&members).Error; err != nil {
+		return fmt.Errorf("adding workspace users: %w", err)
+	}
+	return nil
+}
+
+// backfillWorkspaceUsers runs once, making everyone who moved currency in a
+// workspace before membership was recorded a member of it.
+func backfillWorkspaceUsers(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		// Servers migrating at the same time wait here, then find it done.
+		if err := tx.Exec("LOCK TABLE schema_versions IN EXCLUSIVE MODE").Error; err != nil {
+			return fmt.Errorf("locking schema versions: %w", err)
+		}
+		var done int64
+		if err := tx.Model(&SchemaVersion{}).Where("version >= ?", workspaceUsersVersion).Count(&done).Error; err != nil {
+			return fmt.Errorf("reading schema version: %w", err)
+		}
+		if done > 0 {
+			return nil
+		}
+
+		err := tx.Exec(`INSERT INTO workspace_users (workspace_id, user_id, created_at)
+SELECT journal_entries.workspace_id, accounts.user_id, MIN(movements.created_at)
+FROM movements
+JOIN accounts ON accounts.id = movements.account_id
+JOIN journal_entries ON journal_entries.id = movements.journal_entry_id
+JOIN users ON users.id = accounts.user_id
+WHERE movements.deleted_at IS NULL AND journal_entries.workspace_id <> ? AND users.slack_id <> ?
+GROUP BY journal_entries.workspace_id, accounts.user_id
+ON CONFLICT DO NOTHING`, domain.SystemWorkspaceID, domain.IssuerSlackID).Error
+		if err != nil {
+			return fmt.Errorf("adding workspace users: %w", err)
+		}
+		if err := tx.Create(&SchemaVersion{Version: workspaceUsersVersion, MigratedAt: time.Now()}).Error; err != nil {
+			return fmt.Errorf("recording schema version: %w", err)
+		}
+		return nil
+	})
+}
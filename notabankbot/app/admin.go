@@ -0,0 +1,101 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/yammine/yamex-go"
+	"github.com/yammine/yamex-go/notabankbot/domain"
+	"github.com/yammine/yamex-go/notabankbot/tracing"
+)
+
+const ErrZeroAdjustment = yamex.Sentinel("adjustment amount cannot be zero")
+
+// GetUser returns the user, creating them if needed.
+func (a Application) GetUser(ctx context.Context, slackUserID string) (_ *domain.User, err error) {
+	ctx, span := tracer.Start(ctx, "app.GetUser")
+	defer tracing.End(span, &err)
+
+	return a.repo.GetOrCreateUserBySlackID(ctx, slackUserID)
+}
+
+// SetAdmin grants or takes away a user's admin rights, creating them if needed.
+func (a Application) SetAdmin(ctx context.Context, slackUserID string, admin bool) (_ *domain.User, err error) {
+	ctx, span := tracer.Start(ctx, "app.SetAdmin")
+	defer tracing.End(span, &err)
+
+	user, err := a.repo.GetOrCreateUserBySlackID(ctx, slackUserID)
+	if err != nil {
+		return nil, fmt.Errorf("fetching user: %w", err)
+	}
+	if err := a.repo.SetAdmin(ctx, slackUserID, admin); err != nil {
+		return nil, err
+	}
+	user.Admin = admin
+
+	return user, nil
+}
+
+type AdjustBalanceInput struct {
+	UserID   string
+	Currency string
+	// Amount is credited to the user when positive, debited when negative.
+	Amount decimal.Decimal
+	// Reason is recorded on both movements for auditing.
+	Reason string
+}
+
+// AdjustBalance moves currency between a user and its issuance account, for
+// corrections an operator makes by hand. Debits can't overdraw the user.
+func (a Application) AdjustBalance(ctx context.Context, in *AdjustBalanceInput) (_ *domain.JournalEntry, err error) {
+	ctx, span := tracer.Start(ctx, "app.AdjustBalance")
+	defer tracing.End(span, &err)
+
+	if in.Reason == "" {
+		return nil, ErrReconcileReasonRequired
+	}
+	if in.Amount.IsZero() {
+		return nil, ErrZeroAdjustment
+	}
+	user, err := a.repo.GetOrCreateUserBySlackID(ctx, in.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("fetching user: %w", err)
+	}
+
+	reason := fmt.Sprintf("adjustment: %s", in.Reason)
+	return a.repo.AdjustAccount(ctx, &AdjustAccountInput{User: user, Currency: in.Currency},
+		func(ctx context.Context, adj *AdjustAccountFuncIn) (*AdjustAccountFuncOut, error) {
+			from, to := adj.IssuerAccount, adj.Account
+			amount := in.Amount
+			if amount.IsNegative() {
+				from, to, amount = to, from, amount.Neg()
+			}
+			debit, err := from.Debit(amount, reason)
+			if err != nil {
+				return nil, err
+			}
+			credit, _ := to.Credit(amount, reason)
+
+			return &AdjustAccountFuncOut{Movements: []*domain.Movement{debit, credit}}, nil
+		})
+}
+
+// Feedback is what a user sent with the feedback command.
+type Feedback struct {
+	ID          uint
+	CreatedAt   time.Time
+	SlackUserID string
+	Text        string
+}
+
+// ListFeedback returns feedback newest first, starting before the given ID
+// unless it's zero.
+func (a Application) ListFeedback(ctx context.Context, beforeID uint, limit int) (_ []*Feedback, err error) {
+	ctx, span := tracer.Start(ctx, "app.ListFeedback")
+	defer tracing.End(span, &err)
+
+	return a.repo.ListFeedback(ctx, beforeID, limit)
+}
@@ -5,86 +5,194 @@ import (
 	"fmt"
 
 	"github.com/shopspring/decimal"
+	"go.opentelemetry.io/otel"
 
 	"github.com/yammine/yamex-go/notabankbot/domain"
+	"github.com/yammine/yamex-go/notabankbot/metrics"
+	"github.com/yammine/yamex-go/notabankbot/tracing"
 )
 
+var tracer = otel.Tracer("github.com/yammine/yamex-go/notabankbot/app")
+
 type Application struct {
-	repo Repository
+	repo        Repository
+	signer      Signer
+	grantPolicy *grantPolicyHolder
 }
 
-func NewApplication(repo Repository) *Application {
+// NewApplication wires the use cases. signer may be nil, in which case
+// receipts and ledger checkpoints are unavailable.
+func NewApplication(repo Repository, signer Signer) *Application {
 	return &Application{
-		repo: repo,
+		repo:        repo,
+		signer:      signer,
+		grantPolicy: &grantPolicyHolder{policy: DefaultGrantPolicy()},
 	}
 }
 
 type GrantInput struct {
-	GranterID  string
-	ReceiverID string
-	Platform   string
-	Currency   string
-	Note       string
+	WorkspaceID string
+	GranterID   string
+	ReceiverID  string
+	Platform    string
+	Currency    string
+	Note        string
+	// WorkspaceUsersOnly refuses known users who aren't members of the
+	// workspace, for callers that can name any user.
+	WorkspaceUsersOnly bool
+	// Reply, if set, queues a message in the same transaction as the grant.
+	Reply ReplyFunc
 }
 
-func (a Application) Grant(ctx context.Context, in *GrantInput) (*domain.Grant, error) {
-	granter, err := a.repo.GetOrCreateUserBySlackID(ctx, in.GranterID)
+func (a Application) Grant(ctx context.Context, in *GrantInput) (_ *domain.Grant, err error) {
+	ctx, span := tracer.Start(ctx, "app.Grant")
+	defer tracing.End(span, &err)
+
+	fetch := a.repo.GetOrCreateUserBySlackID
+	if in.WorkspaceUsersOnly {
+		fetch = func(ctx context.Context, slackUserID string) (*domain.User, error) {
+			return a.workspaceCounterparty(ctx, in.WorkspaceID, slackUserID)
+		}
+	}
+	granter, err := fetch(ctx, in.GranterID)
 	if err != nil {
 		return nil, fmt.Errorf("fetching sender: %w", err)
 	}
-	receiver, err := a.repo.GetOrCreateUserBySlackID(ctx, in.ReceiverID)
+	receiver, err := fetch(ctx, in.ReceiverID)
 	if err != nil {
 		return nil, fmt.Errorf("fetching receiver: %w", err)
 	}
 
+	policy := a.GrantPolicy()
+	// Filled in by the business logic, for the events.
+	var credited domain.BalanceChange
+	var firstIssue bool
+	events := func(entry *domain.JournalEntry, grant *domain.Grant) []*domain.LedgerEvent {
+		credited.JournalEntryID = entry.ID
+		events := []*domain.LedgerEvent{{
+			Type:        domain.EventGrantCreated,
+			WorkspaceID: in.WorkspaceID,
+			CreatedAt:   entry.CreatedAt,
+			Data: domain.GrantCreated{
+				GrantID:        grant.ID,
+				JournalEntryID: entry.ID,
+				GranterID:      in.GranterID,
+				ReceiverID:     in.ReceiverID,
+				Currency:       in.Currency,
+				Amount:         policy.Amount,
+			},
+			Credited: &credited,
+		}}
+		if firstIssue {
+			events = append(events, &domain.LedgerEvent{
+				Type:        domain.EventCurrencyCreated,
+				WorkspaceID: in.WorkspaceID,
+				CreatedAt:   entry.CreatedAt,
+				Data:        domain.CurrencyCreated{Currency: in.Currency, JournalEntryID: entry.ID, CreatedBy: in.GranterID},
+			})
+		}
+		return events
+	}
 	grant, err := a.repo.GrantCurrency(
 		ctx,
-		&GrantCurrencyInput{From: granter, To: receiver, Currency: in.Currency},
+		&GrantCurrencyInput{WorkspaceID: in.WorkspaceID, From: granter, To: receiver, Currency: in.Currency, Cooldown: policy.Cooldown, Reply: in.Reply, Events: events},
 		func(ctx context.Context, gin *GrantCurrencyFuncIn) (*GrantCurrencyFuncOut, error) {
 			if !gin.From.CanGrantCurrency() {
 				return nil, domain.ErrAlreadyGranted
 			}
 			g := domain.NewGrant(gin.From, gin.To)
-			m, _ := gin.ToAccount.Credit(decimal.New(1, 0), in.Note)
+			amount := policy.Amount
+			firstIssue = gin.FirstIssue
+			credited = domain.BalanceChange{UserID: in.ReceiverID, Currency: in.Currency, Before: gin.ToAccount.Balance}
+			// Issuance accounts may be overdrawn, so this only errors on bad input.
+			issuance, err := gin.IssuerAccount.Debit(amount, in.Note)
+			if err != nil {
+				return nil, err
+			}
+			m, _ := gin.ToAccount.Credit(amount, in.Note)
+			credited.After = gin.ToAccount.Balance
 
 			return &GrantCurrencyFuncOut{
-				Grant:    g,
-				Movement: m,
+				Grant:            g,
+				Movement:         m,
+				IssuanceMovement: issuance,
 			}, nil
 		})
 
 	if err != nil {
 		return nil, fmt.Errorf("repo.GrantCurrency: %w", err)
 	}
+	metrics.Grants.Inc()
 
 	return grant, nil
 }
 
 type TransferInput struct {
-	SenderID   string
-	ReceiverID string
-	Platform   string
-	Currency   string
-	Amount     decimal.Decimal
-	Note       string
+	WorkspaceID string
+	SenderID    string
+	ReceiverID  string
+	Platform    string
+	Currency    string
+	Amount      decimal.Decimal
+	Note        string
+	// WorkspaceUsersOnly refuses known users who aren't members of the
+	// workspace, and a sender who isn't one, for callers that can name any
+	// user.
+	WorkspaceUsersOnly bool
+	// Reply, if set, queues a message in the same transaction as the transfer.
+	Reply ReplyFunc
 }
 
-func (a Application) Transfer(ctx context.Context, input *TransferInput) error {
-	sender, err := a.repo.GetOrCreateUserBySlackID(ctx, input.SenderID)
+func (a Application) Transfer(ctx context.Context, input *TransferInput) (_ *domain.JournalEntry, err error) {
+	ctx, span := tracer.Start(ctx, "app.Transfer")
+	defer tracing.End(span, &err)
+
+	var sender, receiver *domain.User
+	if input.WorkspaceUsersOnly {
+		sender, err = a.WorkspaceUser(ctx, input.WorkspaceID, input.SenderID)
+	} else {
+		sender, err = a.repo.GetOrCreateUserBySlackID(ctx, input.SenderID)
+	}
 	if err != nil {
-		return fmt.Errorf("fetching sender: %w", err)
+		return nil, fmt.Errorf("fetching sender: %w", err)
+	}
+	if input.WorkspaceUsersOnly {
+		receiver, err = a.workspaceCounterparty(ctx, input.WorkspaceID, input.ReceiverID)
+	} else {
+		receiver, err = a.repo.GetOrCreateUserBySlackID(ctx, input.ReceiverID)
 	}
-	receiver, err := a.repo.GetOrCreateUserBySlackID(ctx, input.ReceiverID)
 	if err != nil {
-		return fmt.Errorf("fetching receiver: %w", err)
+		return nil, fmt.Errorf("fetching receiver: %w", err)
 	}
 
-	return a.repo.SendCurrency(ctx,
+	// Filled in by the business logic, for the events.
+	var credited domain.BalanceChange
+	events := func(entry *domain.JournalEntry, _ *domain.Grant) []*domain.LedgerEvent {
+		credited.JournalEntryID = entry.ID
+		return []*domain.LedgerEvent{{
+			Type:        domain.EventTransferCompleted,
+			WorkspaceID: input.WorkspaceID,
+			CreatedAt:   entry.CreatedAt,
+			Data: domain.TransferCompleted{
+				JournalEntryID: entry.ID,
+				SenderID:       input.SenderID,
+				ReceiverID:     input.ReceiverID,
+				Currency:       input.Currency,
+				Amount:         input.Amount,
+			},
+			Credited: &credited,
+		}}
+	}
+	entry, err := a.repo.SendCurrency(ctx,
 		&SendCurrencyInput{
-			From:     sender,
-			To:       receiver,
-			Currency: input.Currency,
+			WorkspaceID: input.WorkspaceID,
+			From:        sender,
+			To:          receiver,
+			Currency:    input.Currency,
+			Reply:       input.Reply,
+			Events:      events,
 		}, func(ctx context.Context, in *SendCurrencyFuncIn) (*SendCurrencyFuncOut, error) {
+			credited = domain.BalanceChange{UserID: input.ReceiverID, Currency: input.Currency, Before: in.ToAccount.Balance}
 			// debit the sender
 			debit, err := in.FromAccount.Debit(input.Amount, input.Note)
 			if err != nil {
@@ -92,27 +200,45 @@ func (a Application) Transfer(ctx context.Context, input *TransferInput) error {
 			}
 			// credit the receiver
 			credit, _ := in.ToAccount.Credit(input.Amount, input.Note)
+			credited.After = in.ToAccount.Balance
 
 			return &SendCurrencyFuncOut{
 				SendingMovement:   debit,
 				ReceivingMovement: credit,
 			}, nil
 		})
+	if err != nil {
+		return nil, err
+	}
+	metrics.Transfers.Inc()
+	amount, _ := input.Amount.Float64()
+	metrics.TransferredAmount.Add(amount)
+
+	return entry, nil
 }
 
 type GetBalanceInput struct {
 	UserID string
+	// WorkspaceID, if set, only finds users the workspace knows, and never
+	// creates one.
+	WorkspaceID string
 }
 
-func (a Application) GetBalance(ctx context.Context, in *GetBalanceInput) ([]*domain.Account, error) {
-	user, err := a.repo.GetOrCreateUserBySlackID(ctx, in.UserID)
+func (a Application) GetBalance(ctx context.Context, in *GetBalanceInput) (_ []*domain.Account, err error) {
+	ctx, span := tracer.Start(ctx, "app.GetBalance")
+	defer tracing.End(span, &err)
+
+	user, err := a.lookupUser(ctx, in.WorkspaceID, in.UserID)
 	if err != nil {
 		return nil, fmt.Errorf("fetching user: %w", err)
 	}
 	return a.repo.GetAccountsForUser(ctx, user.ID)
 }
 
-func (a Application) SaveFeedback(ctx context.Context, slackUserID, feedback string) error {
+func (a Application) SaveFeedback(ctx context.Context, slackUserID, feedback string) (err error) {
+	ctx, span := tracer.Start(ctx, "app.SaveFeedback")
+	defer tracing.End(span, &err)
+
 	user, err := a.repo.GetOrCreateUserBySlackID(ctx, slackUserID)
 	if err != nil {
 		return fmt.Errorf("failed to fetch user: %w", err)
@@ -0,0 +1,73 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/yammine/yamex-go"
+
+	"github.com/yammine/yamex-go/notabankbot/domain"
+	"github.com/yammine/yamex-go/notabankbot/tracing"
+)
+
+const ErrDateInFuture = yamex.Sentinel("date is in the future")
+
+type GetBalanceAsOfInput struct {
+	UserID string
+	// WorkspaceID, if set, only finds users the workspace knows, and never
+	// creates one.
+	WorkspaceID string
+	// Day is inclusive: balances are as of the end of the day, UTC.
+	Day time.Time
+}
+
+// GetBalanceAsOf returns the user's accounts with their balances as they were
+// at the end of the given day.
+func (a Application) GetBalanceAsOf(ctx context.Context, in *GetBalanceAsOfInput) (_ []*domain.Account, err error) {
+	ctx, span := tracer.Start(ctx, "app.GetBalanceAsOf")
+	defer tracing.End(span, &err)
+
+	if in.Day.After(time.Now()) {
+		return nil, ErrDateInFuture
+	}
+	user, err := a.lookupUser(ctx, in.WorkspaceID, in.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("fetching user: %w", err)
+	}
+
+	return a.repo.GetAccountsForUserAsOf(ctx, user.ID, in.Day)
+}
+
+// SnapshotBalances writes daily balance snapshots for every day that is
+// missing one, up to and including through. Days are snapshotted in order so
+// that each one builds on the day before.
+func (a Application) SnapshotBalances(ctx context.Context, through time.Time) (days int, err error) {
+	ctx, span := tracer.Start(ctx, "app.SnapshotBalances")
+	defer tracing.End(span, &err)
+
+	from, err := a.repo.GetLatestSnapshotDate(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("repo.GetLatestSnapshotDate: %w", err)
+	}
+	if from != nil {
+		next := from.AddDate(0, 0, 1)
+		from = &next
+	} else if from, err = a.repo.GetFirstMovementDate(ctx); err != nil {
+		return 0, fmt.Errorf("repo.GetFirstMovementDate: %w", err)
+	}
+	// No movements yet, nothing to snapshot.
+	if from == nil {
+		return 0, nil
+	}
+
+	last := domain.EndOfDay(through).AddDate(0, 0, -1)
+	for day := domain.EndOfDay(*from).AddDate(0, 0, -1); !day.After(last); day = day.AddDate(0, 0, 1) {
+		if _, err := a.repo.CreateBalanceSnapshots(ctx, day); err != nil {
+			return days, fmt.Errorf("snapshotting %s: %w", day.Format(domain.SnapshotDateLayout), err)
+		}
+		days++
+	}
+
+	return days, nil
+}
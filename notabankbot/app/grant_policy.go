@@ -0,0 +1,41 @@
+package app
+
+import (
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/yammine/yamex-go/notabankbot/domain"
+)
+
+// GrantPolicy is how much a grant is worth and how long non-admins must wait
+// between grants.
+type GrantPolicy struct {
+	Amount   decimal.Decimal
+	Cooldown time.Duration
+}
+
+func DefaultGrantPolicy() GrantPolicy {
+	return GrantPolicy{Amount: decimal.New(1, 0), Cooldown: domain.TimeBetweenGrants()}
+}
+
+// grantPolicyHolder lets the policy change, e.g. on a config reload, while
+// grants are in flight.
+type grantPolicyHolder struct {
+	mu     sync.RWMutex
+	policy GrantPolicy
+}
+
+// SetGrantPolicy applies to grants made from now on.
+func (a Application) SetGrantPolicy(policy GrantPolicy) {
+	a.grantPolicy.mu.Lock()
+	defer a.grantPolicy.mu.Unlock()
+	a.grantPolicy.policy = policy
+}
+
+func (a Application) GrantPolicy() GrantPolicy {
+	a.grantPolicy.mu.RLock()
+	defer a.grantPolicy.mu.RUnlock()
+	return a.grantPolicy.policy
+}
@@ -0,0 +1,240 @@
+package app
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/yammine/yamex-go"
+
+	"github.com/yammine/yamex-go/notabankbot/domain"
+	"github.com/yammine/yamex-go/notabankbot/tracing"
+)
+
+const (
+	ErrSigningDisabled   = yamex.Sentinel("no ledger signing key is configured")
+	ErrReceiptNotFound   = yamex.Sentinel("receipt not found")
+	ErrUnknownSigningKey = yamex.Sentinel("signed with an unknown ledger key")
+	chainVerifyPageSize  = 500
+)
+
+// Signer signs checkpoints and receipts with the ledger's private key.
+type Signer interface {
+	KeyID() string
+	PublicKey() ed25519.PublicKey
+	Sign(payload []byte) []byte
+	// Verify checks a signature made with the key keyID, which may have been
+	// retired since. An unknown key is ErrUnknownSigningKey.
+	Verify(keyID string, payload, signature []byte) (bool, error)
+}
+
+type GetReceiptInput struct {
+	UserID  string
+	EntryID uint
+}
+
+// GetReceipt returns a signed receipt for a journal entry. Only users with an
+// account in the entry, or admins, may fetch it.
+func (a Application) GetReceipt(ctx context.Context, in *GetReceiptInput) (_ *domain.Receipt, err error) {
+	ctx, span := tracer.Start(ctx, "app.GetReceipt")
+	defer tracing.End(span, &err)
+
+	if a.signer == nil {
+		return nil, ErrSigningDisabled
+	}
+	user, err := a.repo.GetOrCreateUserBySlackID(ctx, in.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("fetching user: %w", err)
+	}
+	entry, err := a.repo.GetJournalEntry(ctx, in.EntryID)
+	if err != nil {
+		return nil, fmt.Errorf("repo.GetJournalEntry: %w", err)
+	}
+	// Entries from before the chain existed cannot be proven.
+	if entry.Sequence == 0 {
+		return nil, ErrReceiptNotFound
+	}
+
+	if !user.Admin {
+		accounts, err := a.repo.GetAccountsForUser(ctx, user.ID)
+		if err != nil {
+			return nil, fmt.Errorf("fetching accounts: %w", err)
+		}
+		if !entryTouchesAccounts(entry, accounts) {
+			return nil, ErrReceiptNotFound
+		}
+	}
+
+	receipt := domain.NewReceipt(entry)
+	receipt.KeyID = a.signer.KeyID()
+	receipt.Signature = base64.StdEncoding.EncodeToString(a.signer.Sign(receipt.SigningPayload()))
+
+	return receipt, nil
+}
+
+// LedgerPublicKey returns the key receipts and checkpoints are verified with.
+func (a Application) LedgerPublicKey() (keyID string, key ed25519.PublicKey, err error) {
+	if a.signer == nil {
+		return "", nil, ErrSigningDisabled
+	}
+	return a.signer.KeyID(), a.signer.PublicKey(), nil
+}
+
+// CheckpointLedger signs the current head of every workspace's chain.
+func (a Application) CheckpointLedger(ctx context.Context) (_ []*domain.Checkpoint, err error) {
+	ctx, span := tracer.Start(ctx, "app.CheckpointLedger")
+	defer tracing.End(span, &err)
+
+	if a.signer == nil {
+		return nil, ErrSigningDisabled
+	}
+	heads, err := a.repo.ListChainHeads(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("repo.ListChainHeads: %w", err)
+	}
+
+	checkpoints := make([]*domain.Checkpoint, 0, len(heads))
+	for _, head := range heads {
+		c := domain.NewCheckpoint(head)
+		c.KeyID = a.signer.KeyID()
+		c.Signature = base64.StdEncoding.EncodeToString(a.signer.Sign(c.SigningPayload()))
+		if err := a.repo.SaveCheckpoint(ctx, c); err != nil {
+			return nil, fmt.Errorf("repo.SaveCheckpoint: %w", err)
+		}
+		checkpoints = append(checkpoints, c)
+	}
+
+	return checkpoints, nil
+}
+
+// ChainBreak pinpoints the first entry of a workspace's chain that fails
+// verification.
+type ChainBreak struct {
+	WorkspaceID string
+	Sequence    uint64
+	EntryID     uint
+	Problem     string
+}
+
+type ChainReport struct {
+	Workspaces         int
+	EntriesChecked     int
+	CheckpointsChecked int
+	// CheckpointsUnverified counts checkpoints whose signatures could not be
+	// checked because no signing key is configured.
+	CheckpointsUnverified int
+	Breaks                []*ChainBreak
+}
+
+// Verified reports whether the chain is intact and every checkpoint's
+// signature was checked.
+func (r ChainReport) Verified() bool {
+	return len(r.Breaks) == 0 && r.CheckpointsUnverified == 0
+}
+
+// VerifyChain walks every workspace's chain from the start, recomputing each
+// hash and checking it against the next link and any signed checkpoints.
+func (a Application) VerifyChain(ctx context.Context) (*ChainReport, error) {
+	heads, err := a.repo.ListChainHeads(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("repo.ListChainHeads: %w", err)
+	}
+
+	report := &ChainReport{Workspaces: len(heads)}
+	for _, head := range heads {
+		b, err := a.verifyWorkspaceChain(ctx, head, report)
+		if err != nil {
+			return nil, err
+		}
+		if b != nil {
+			report.Breaks = append(report.Breaks, b)
+		}
+	}
+
+	return report, nil
+}
+
+func (a Application) verifyWorkspaceChain(ctx context.Context, head *domain.ChainHead, report *ChainReport) (*ChainBreak, error) {
+	checkpoints, err := a.repo.ListCheckpoints(ctx, head.WorkspaceID)
+	if err != nil {
+		return nil, fmt.Errorf("repo.ListCheckpoints: %w", err)
+	}
+	bySequence := map[uint64][]*domain.Checkpoint{}
+	for _, c := range checkpoints {
+		if a.signer == nil {
+			report.CheckpointsUnverified++
+		} else {
+			signature, _ := base64.StdEncoding.DecodeString(c.Signature)
+			valid, err := a.signer.Verify(c.KeyID, c.SigningPayload(), signature)
+			if errors.Is(err, ErrUnknownSigningKey) {
+				return &ChainBreak{WorkspaceID: head.WorkspaceID, Sequence: c.Sequence, Problem: fmt.Sprintf("checkpoint %d was signed with unknown key %q", c.ID, c.KeyID)}, nil
+			}
+			if err != nil {
+				return nil, fmt.Errorf("verifying checkpoint %d: %w", c.ID, err)
+			}
+			if !valid {
+				return &ChainBreak{WorkspaceID: head.WorkspaceID, Sequence: c.Sequence, Problem: fmt.Sprintf("checkpoint %d has an invalid signature", c.ID)}, nil
+			}
+		}
+		bySequence[c.Sequence] = append(bySequence[c.Sequence], c)
+	}
+
+	prevHash, sequence := "", uint64(0)
+	for {
+		entries, err := a.repo.ListJournalEntries(ctx, head.WorkspaceID, sequence, chainVerifyPageSize)
+		if err != nil {
+			return nil, fmt.Errorf("repo.ListJournalEntries: %w", err)
+		}
+
+		for _, e := range entries {
+			report.EntriesChecked++
+			broken := func(problem string) *ChainBreak {
+				return &ChainBreak{WorkspaceID: head.WorkspaceID, Sequence: sequence + 1, EntryID: e.ID, Problem: problem}
+			}
+
+			switch {
+			case e.Sequence != sequence+1:
+				return broken(fmt.Sprintf("entry missing, next entry found has sequence %d", e.Sequence)), nil
+			case e.PrevHash != prevHash:
+				return broken("previous hash does not match the entry before it"), nil
+			case e.ComputeHash() != e.Hash:
+				return broken("contents do not match the stored hash"), nil
+			}
+			for _, c := range bySequence[e.Sequence] {
+				report.CheckpointsChecked++
+				if c.Hash != e.Hash {
+					return broken(fmt.Sprintf("hash differs from checkpoint %d", c.ID)), nil
+				}
+			}
+
+			prevHash, sequence = e.Hash, e.Sequence
+		}
+
+		if len(entries) < chainVerifyPageSize {
+			break
+		}
+	}
+
+	for _, c := range checkpoints {
+		if c.Sequence > sequence {
+			return &ChainBreak{WorkspaceID: head.WorkspaceID, Sequence: sequence + 1, Problem: fmt.Sprintf("checkpoint %d covers entries that no longer exist", c.ID)}, nil
+		}
+	}
+	if sequence != head.Sequence || prevHash != head.Hash {
+		return &ChainBreak{WorkspaceID: head.WorkspaceID, Sequence: sequence + 1, Problem: "chain ends before the recorded head"}, nil
+	}
+	return nil, nil
+}
+
+func entryTouchesAccounts(entry *domain.JournalEntry, accounts []*domain.Account) bool {
+	for _, m := range entry.Movements {
+		for _, acc := range accounts {
+			if m.AccountID == acc.ID {
+				return true
+			}
+		}
+	}
+	return false
+}
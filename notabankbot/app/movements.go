@@ -0,0 +1,72 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/yammine/yamex-go/notabankbot/tracing"
+)
+
+// UserMovement is a movement on one of a user's accounts.
+type UserMovement struct {
+	ID             uint
+	CreatedAt      time.Time
+	JournalEntryID uint
+	Currency       string
+	Amount         decimal.Decimal
+	Reason         string
+}
+
+type ListMovementsInput struct {
+	WorkspaceID string
+	UserID      string
+	// Currency optionally narrows the movements to one account.
+	Currency string
+	// BeforeID pages backwards through history, zero starts at the newest.
+	BeforeID uint
+	// AfterID, if set, pages forwards instead, oldest first.
+	AfterID *uint
+	Limit   int
+}
+
+// ListMovements returns the user's movements in the workspace, newest first
+// unless paging forwards with AfterID. The user must be one the workspace
+// knows.
+func (a Application) ListMovements(ctx context.Context, in *ListMovementsInput) (_ []*UserMovement, err error) {
+	ctx, span := tracer.Start(ctx, "app.ListMovements")
+	defer tracing.End(span, &err)
+
+	user, err := a.WorkspaceUser(ctx, in.WorkspaceID, in.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("fetching user: %w", err)
+	}
+
+	return a.repo.ListMovements(ctx, &ListMovementsQuery{
+		WorkspaceID: in.WorkspaceID,
+		UserID:      user.ID,
+		Currency:    in.Currency,
+		BeforeID:    in.BeforeID,
+		AfterID:     in.AfterID,
+		Limit:       in.Limit,
+	})
+}
+
+// Currency is a currency in circulation in a workspace.
+type Currency struct {
+	Code string
+	// Circulating is everything ever granted in the workspace, i.e. its share
+	// of the issuer's overdraft.
+	Circulating decimal.Decimal
+}
+
+// ListCurrencies returns the currencies granted in a workspace ordered by
+// code, starting after the given code.
+func (a Application) ListCurrencies(ctx context.Context, workspaceID, after string, limit int) (_ []*Currency, err error) {
+	ctx, span := tracer.Start(ctx, "app.ListCurrencies")
+	defer tracing.End(span, &err)
+
+	return a.repo.ListCurrencies(ctx, workspaceID, after, limit)
+}
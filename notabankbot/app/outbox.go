@@ -0,0 +1,80 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/yammine/yamex-go/notabankbot/domain"
+)
+
+const (
+	// OutboxMaxAttempts is how many times delivery is tried before giving up.
+	OutboxMaxAttempts = 10
+
+	outboxMinBackoff = 2 * time.Second
+	outboxMaxBackoff = 10 * time.Minute
+)
+
+// DeliveryFailure describes why a message couldn't be delivered.
+type DeliveryFailure struct {
+	Err error
+	// Permanent failures are not retried, e.g. the channel no longer exists.
+	Permanent bool
+	// RetryAfter is the earliest the platform accepts another attempt.
+	RetryAfter time.Duration
+}
+
+// EnqueueMessage queues a message for delivery as soon as possible.
+func (a Application) EnqueueMessage(ctx context.Context, msg *domain.OutboxMessage) error {
+	msg.NextAttemptAt = time.Now()
+	return a.repo.EnqueueOutboxMessage(ctx, msg)
+}
+
+// ClaimMessages returns messages due for delivery. Other dispatchers won't see
+// them for lease, which should comfortably cover delivering them.
+func (a Application) ClaimMessages(ctx context.Context, limit int, lease time.Duration) ([]*domain.OutboxMessage, error) {
+	return a.repo.ClaimOutboxMessages(ctx, limit, lease)
+}
+
+// PendingMessages is how many messages are waiting to be delivered.
+func (a Application) PendingMessages(ctx context.Context) (int64, error) {
+	return a.repo.CountPendingOutboxMessages(ctx)
+}
+
+func (a Application) MessageDelivered(ctx context.Context, msg *domain.OutboxMessage) error {
+	now := time.Now()
+	msg.Attempts++
+	msg.DeliveredAt = &now
+	msg.LastError = ""
+	return a.repo.SaveOutboxMessage(ctx, msg)
+}
+
+// MessageFailed schedules the next attempt with exponential backoff, or gives
+// up on the message. It reports whether the message will be retried.
+func (a Application) MessageFailed(ctx context.Context, msg *domain.OutboxMessage, failure *DeliveryFailure) (bool, error) {
+	now := time.Now()
+	msg.Attempts++
+	msg.LastError = failure.Err.Error()
+
+	retry := !failure.Permanent && msg.Attempts < OutboxMaxAttempts
+	if retry {
+		msg.NextAttemptAt = now.Add(retryBackoff(msg.Attempts, failure))
+	} else {
+		msg.FailedAt = &now
+	}
+
+	return retry, a.repo.SaveOutboxMessage(ctx, msg)
+}
+
+// retryBackoff doubles the wait with each attempt made, up to a limit, unless
+// the failure asks for longer.
+func retryBackoff(attempts int, failure *DeliveryFailure) time.Duration {
+	backoff := outboxMinBackoff << uint(attempts-1)
+	if backoff > outboxMaxBackoff || backoff <= 0 {
+		backoff = outboxMaxBackoff
+	}
+	if failure.RetryAfter > backoff {
+		backoff = failure.RetryAfter
+	}
+	return backoff
+}
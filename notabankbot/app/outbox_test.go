@@ -0,0 +1,33 @@
+package app
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	tests := []struct {
+		name       string
+		attempts   int
+		retryAfter time.Duration
+		want       time.Duration
+	}{
+		{name: "first attempt", attempts: 1, want: outboxMinBackoff},
+		{name: "doubles", attempts: 2, want: 2 * outboxMinBackoff},
+		{name: "below the limit", attempts: 9, want: 256 * outboxMinBackoff},
+		{name: "at the limit", attempts: 10, want: outboxMaxBackoff},
+		{name: "shifted past overflow", attempts: 100, want: outboxMaxBackoff},
+		{name: "retry after is longer", attempts: 1, retryAfter: time.Minute, want: time.Minute},
+		{name: "retry after is shorter", attempts: 5, retryAfter: time.Second, want: 16 * outboxMinBackoff},
+		{name: "retry after beyond the limit", attempts: 10, retryAfter: time.Hour, want: time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failure := &DeliveryFailure{Err: errors.New("503 Service Unavailable"), RetryAfter: tt.retryAfter}
+			if got := retryBackoff(tt.attempts, failure); got != tt.want {
+				t.Errorf("retryBackoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+			}
+		})
+	}
+}
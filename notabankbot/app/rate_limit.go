@@ -0,0 +1,158 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yammine/yamex-go"
+)
+
+const ErrInvalidRateLimit = yamex.Sentinel("rate limits look like 10/1m")
+
+type RateLimitScope string
+
+const (
+	RateLimitUser      RateLimitScope = "user"
+	RateLimitChannel   RateLimitScope = "channel"
+	RateLimitWorkspace RateLimitScope = "workspace"
+)
+
+// RateLimit is a token bucket: up to Burst requests at once, refilled at
+// Burst requests per Per. The zero value means unlimited.
+type RateLimit struct {
+	Burst int
+	Per   time.Duration
+}
+
+// ParseRateLimit reads limits written as "<burst>/<duration>", e.g. "10/1m".
+// An empty string is no limit.
+func ParseRateLimit(s string) (RateLimit, error) {
+	if strings.TrimSpace(s) == "" {
+		return RateLimit{}, nil
+	}
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return RateLimit{}, fmt.Errorf("%w, got %q", ErrInvalidRateLimit, s)
+	}
+	burst, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || burst < 1 {
+		return RateLimit{}, fmt.Errorf("%w, got %q", ErrInvalidRateLimit, s)
+	}
+	per, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+	if err != nil || per <= 0 {
+		return RateLimit{}, fmt.Errorf("%w, got %q", ErrInvalidRateLimit, s)
+	}
+
+	return RateLimit{Burst: burst, Per: per}, nil
+}
+
+func (l RateLimit) Enabled() bool {
+	return l.Burst > 0 && l.Per > 0
+}
+
+// RefillPerSecond is how many requests the bucket regains each second.
+func (l RateLimit) RefillPerSecond() float64 {
+	return float64(l.Burst) / l.Per.Seconds()
+}
+
+type RateLimitConfig struct {
+	User      RateLimit
+	Channel   RateLimit
+	Workspace RateLimit
+	// ExemptAdmins lets admins through regardless of limits.
+	ExemptAdmins bool
+}
+
+// RateLimitBucket is a single bucket to take a token from.
+type RateLimitBucket struct {
+	Key   string
+	Scope RateLimitScope
+	Limit RateLimit
+}
+
+// RateLimitStore keeps buckets where every replica sees them.
+type RateLimitStore interface {
+	// TakeTokens takes a token from every bucket, or from none of them. It
+	// returns the first bucket that was empty, if any.
+	TakeTokens(ctx context.Context, buckets []*RateLimitBucket) (*RateLimitBucket, error)
+}
+
+type RateLimiter struct {
+	store RateLimitStore
+	repo  Repository
+
+	mu     sync.RWMutex
+	config RateLimitConfig
+}
+
+func NewRateLimiter(store RateLimitStore, repo Repository, config RateLimitConfig) *RateLimiter {
+	return &RateLimiter{
+		store:  store,
+		repo:   repo,
+		config: config,
+	}
+}
+
+// SetConfig applies to requests made from now on. Buckets already in the store
+// keep their tokens.
+func (r *RateLimiter) SetConfig(config RateLimitConfig) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.config = config
+}
+
+func (r *RateLimiter) Config() RateLimitConfig {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.config
+}
+
+type RateLimitInput struct {
+	WorkspaceID string
+	ChannelID   string
+	UserID      string
+}
+
+// Allow takes a request's tokens. When the request is over a limit it returns
+// the scope of that limit.
+func (r *RateLimiter) Allow(ctx context.Context, in *RateLimitInput) (RateLimitScope, error) {
+	config := r.Config()
+	var buckets []*RateLimitBucket
+	add := func(scope RateLimitScope, limit RateLimit, ids ...string) {
+		for _, id := range ids {
+			if id == "" || !limit.Enabled() {
+				return
+			}
+		}
+		buckets = append(buckets, &RateLimitBucket{Key: fmt.Sprintf("%s:%s", scope, strings.Join(ids, "/")), Scope: scope, Limit: limit})
+	}
+	add(RateLimitUser, config.User, in.WorkspaceID, in.UserID)
+	add(RateLimitChannel, config.Channel, in.WorkspaceID, in.ChannelID)
+	add(RateLimitWorkspace, config.Workspace, in.WorkspaceID)
+	if len(buckets) == 0 {
+		return "", nil
+	}
+
+	if config.ExemptAdmins {
+		user, err := r.repo.GetOrCreateUserBySlackID(ctx, in.UserID)
+		if err != nil {
+			return "", fmt.Errorf("fetching user: %w", err)
+		}
+		if user.Admin {
+			return "", nil
+		}
+	}
+
+	empty, err := r.store.TakeTokens(ctx, buckets)
+	if err != nil {
+		return "", fmt.Errorf("taking rate limit tokens: %w", err)
+	}
+	if empty != nil {
+		return empty.Scope, nil
+	}
+	return "", nil
+}
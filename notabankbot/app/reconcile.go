@@ -0,0 +1,165 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/yammine/yamex-go"
+	"github.com/yammine/yamex-go/notabankbot/tracing"
+)
+
+const ErrReconcileReasonRequired = yamex.Sentinel("a reason is required to correct balances")
+
+type ReconcileInput struct {
+	// Correct writes adjustment movements for every drifting account.
+	Correct bool
+	// Reason is recorded on every adjustment movement for auditing.
+	Reason string
+}
+
+type AccountDiscrepancy struct {
+	AccountID   uint
+	UserID      uint
+	Currency    string
+	Balance     decimal.Decimal
+	MovementSum decimal.Decimal
+	Drift       decimal.Decimal
+	Corrected   bool
+}
+
+// CurrencyTotals compares what holders own against what has been issued.
+// Every journal entry balances, so the two should always match.
+type CurrencyTotals struct {
+	Currency    string
+	Circulating decimal.Decimal
+	Issued      decimal.Decimal
+}
+
+func (c CurrencyTotals) Difference() decimal.Decimal {
+	return c.Circulating.Sub(c.Issued)
+}
+
+type ReconciliationReport struct {
+	StartedAt       time.Time
+	Duration        time.Duration
+	AccountsChecked int
+
+	Discrepancies []*AccountDiscrepancy
+	Currencies    []*CurrencyTotals
+}
+
+func (r ReconciliationReport) Healthy() bool {
+	for _, d := range r.Discrepancies {
+		if !d.Corrected {
+			return false
+		}
+	}
+	for _, c := range r.Currencies {
+		if !c.Difference().IsZero() {
+			return false
+		}
+	}
+	return true
+}
+
+func (a Application) Reconcile(ctx context.Context, in *ReconcileInput) (_ *ReconciliationReport, err error) {
+	ctx, span := tracer.Start(ctx, "app.Reconcile")
+	defer tracing.End(span, &err)
+
+	if in.Correct && in.Reason == "" {
+		return nil, ErrReconcileReasonRequired
+	}
+
+	report := &ReconciliationReport{StartedAt: time.Now()}
+	summaries, err := a.repo.GetLedgerSummary(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("repo.GetLedgerSummary: %w", err)
+	}
+	report.AccountsChecked = len(summaries)
+
+	corrected := false
+	for _, s := range summaries {
+		if s.Balance.Equal(s.MovementSum) {
+			continue
+		}
+		d := &AccountDiscrepancy{
+			AccountID:   s.AccountID,
+			UserID:      s.UserID,
+			Currency:    s.Currency,
+			Balance:     s.Balance,
+			MovementSum: s.MovementSum,
+			Drift:       s.Balance.Sub(s.MovementSum),
+		}
+		report.Discrepancies = append(report.Discrepancies, d)
+
+		// Issuance accounts are only reported, correcting them would need an
+		// offsetting account of their own.
+		if !in.Correct || s.AllowOverdraft {
+			continue
+		}
+		if err := a.correctAccount(ctx, s.AccountID, in.Reason); err != nil {
+			return nil, fmt.Errorf("correcting account %d: %w", s.AccountID, err)
+		}
+		d.Corrected = true
+		corrected = true
+	}
+
+	// Corrections move balances, so the totals are taken after them.
+	if corrected {
+		if summaries, err = a.repo.GetLedgerSummary(ctx); err != nil {
+			return nil, fmt.Errorf("repo.GetLedgerSummary: %w", err)
+		}
+	}
+	report.Currencies = currencyTotals(summaries)
+	report.Duration = time.Since(report.StartedAt)
+
+	return report, nil
+}
+
+func currencyTotals(summaries []*AccountLedgerSummary) []*CurrencyTotals {
+	currencies := map[string]*CurrencyTotals{}
+	for _, s := range summaries {
+		totals, ok := currencies[s.Currency]
+		if !ok {
+			totals = &CurrencyTotals{Currency: s.Currency}
+			currencies[s.Currency] = totals
+		}
+		if s.AllowOverdraft {
+			totals.Issued = totals.Issued.Sub(s.Balance)
+		} else {
+			totals.Circulating = totals.Circulating.Add(s.Balance)
+		}
+	}
+
+	all := make([]*CurrencyTotals, 0, len(currencies))
+	for _, c := range currencies {
+		all = append(all, c)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Currency < all[j].Currency
+	})
+	return all
+}
+
+// correctAccount offsets an account's drift against its currency's issuance
+// account, which keeps the adjustment journal entry balanced.
+func (a Application) correctAccount(ctx context.Context, accountID uint, reason string) error {
+	return a.repo.ReconcileAccount(ctx, &ReconcileAccountInput{AccountID: accountID},
+		func(ctx context.Context, in *ReconcileAccountFuncIn) (*ReconcileAccountFuncOut, error) {
+			drift := in.Account.Drift(in.MovementSum)
+			if drift.IsZero() {
+				return &ReconcileAccountFuncOut{}, nil
+			}
+			adjustment := in.Account.Reconcile(in.MovementSum, reason)
+			issuance, _ := in.IssuerAccount.Credit(drift.Neg(), fmt.Sprintf("adjustment: %s", reason))
+
+			return &ReconcileAccountFuncOut{
+				Adjustment:       adjustment,
+				IssuanceMovement: issuance,
+			}, nil
+		})
+}
@@ -2,6 +2,9 @@ package app
 
 import (
 	"context"
+	"time"
+
+	"github.com/shopspring/decimal"
 
 	"github.com/yammine/yamex-go"
 
@@ -10,46 +13,135 @@ import (
 
 const (
 	ErrCannotFindOrCreateUser = yamex.Sentinel("cannot find or create user")
+	ErrUnknownUser            = yamex.Sentinel("unknown user")
+	ErrUserNotInWorkspace     = yamex.Sentinel("user is not a member of the workspace")
 )
 
 type GrantFunc = func(ctx context.Context, in *GrantCurrencyFuncIn) (*GrantCurrencyFuncOut, error)
 type SendFunc = func(ctx context.Context, in *SendCurrencyFuncIn) (*SendCurrencyFuncOut, error)
+type ReconcileFunc = func(ctx context.Context, in *ReconcileAccountFuncIn) (*ReconcileAccountFuncOut, error)
+type AdjustFunc = func(ctx context.Context, in *AdjustAccountFuncIn) (*AdjustAccountFuncOut, error)
+
+// ReplyFunc builds the message announcing a ledger change, once the change's
+// journal entry exists. It runs inside the change's transaction.
+type ReplyFunc = func(entry *domain.JournalEntry) (*domain.OutboxMessage, error)
+
+// EventFunc lists the webhook events of a ledger change, once the change's
+// journal entry, and grant if any, exist. It runs inside the change's
+// transaction, which queues a delivery to each endpoint that wants them.
+type EventFunc = func(entry *domain.JournalEntry, grant *domain.Grant) []*domain.LedgerEvent
 
 type Repository interface {
 	GrantCurrency(ctx context.Context, in *GrantCurrencyInput, grantFn GrantFunc) (*domain.Grant, error)
-	SendCurrency(ctx context.Context, in *SendCurrencyInput, sendFn SendFunc) error
+	SendCurrency(ctx context.Context, in *SendCurrencyInput, sendFn SendFunc) (*domain.JournalEntry, error)
 
 	GetOrCreateUserBySlackID(ctx context.Context, slackUserId string) (*domain.User, error)
+	// JoinWorkspace gets or creates a user and makes them a member of the
+	// workspace. Grants and transfers join their parties to the workspace too.
+	JoinWorkspace(ctx context.Context, workspaceID, slackUserID string) (*domain.User, error)
+	// FindWorkspaceUser looks a user up without creating them. It returns
+	// ErrUnknownUser if there's no such user, and ErrUserNotInWorkspace if
+	// they aren't a member of the workspace.
+	FindWorkspaceUser(ctx context.Context, workspaceID, slackUserID string) (*domain.User, error)
 	GetAccountsForUser(ctx context.Context, id uint) ([]*domain.Account, error)
+	GetAccountsForUserAsOf(ctx context.Context, id uint, day time.Time) ([]*domain.Account, error)
 
 	SaveFeedback(ctx context.Context, user *domain.User, feedback string) error
+
+	GetLedgerSummary(ctx context.Context) ([]*AccountLedgerSummary, error)
+	ReconcileAccount(ctx context.Context, in *ReconcileAccountInput, reconcileFn ReconcileFunc) error
+
+	SetAdmin(ctx context.Context, slackUserID string, admin bool) error
+	AdjustAccount(ctx context.Context, in *AdjustAccountInput, adjustFn AdjustFunc) (*domain.JournalEntry, error)
+	ListFeedback(ctx context.Context, beforeID uint, limit int) ([]*Feedback, error)
+
+	ListMovements(ctx context.Context, q *ListMovementsQuery) ([]*UserMovement, error)
+	ListCurrencies(ctx context.Context, workspaceID, after string, limit int) ([]*Currency, error)
+
+	GetJournalEntry(ctx context.Context, id uint) (*domain.JournalEntry, error)
+	ListJournalEntries(ctx context.Context, workspaceID string, afterSequence uint64, limit int) ([]*domain.JournalEntry, error)
+	ListChainHeads(ctx context.Context) ([]*domain.ChainHead, error)
+	ListCheckpoints(ctx context.Context, workspaceID string) ([]*domain.Checkpoint, error)
+	SaveCheckpoint(ctx context.Context, checkpoint *domain.Checkpoint) error
+
+	GetLatestSnapshotDate(ctx context.Context) (*time.Time, error)
+	GetFirstMovementDate(ctx context.Context) (*time.Time, error)
+	CreateBalanceSnapshots(ctx context.Context, day time.Time) (int64, error)
+
+	EnqueueOutboxMessage(ctx context.Context, msg *domain.OutboxMessage) error
+	// ClaimOutboxMessages returns due messages, hiding them from other
+	// claimers until the lease expires.
+	ClaimOutboxMessages(ctx context.Context, limit int, lease time.Duration) ([]*domain.OutboxMessage, error)
+	SaveOutboxMessage(ctx context.Context, msg *domain.OutboxMessage) error
+	// CountPendingOutboxMessages counts messages not yet delivered or given up on.
+	CountPendingOutboxMessages(ctx context.Context) (int64, error)
+
+	SaveWebhookEndpoint(ctx context.Context, endpoint *domain.WebhookEndpoint) error
+	// GetWebhookEndpoint returns ErrUnknownWebhookEndpoint if there's no such
+	// endpoint.
+	GetWebhookEndpoint(ctx context.Context, id uint) (*domain.WebhookEndpoint, error)
+	ListWebhookEndpoints(ctx context.Context, workspaceID string) ([]*domain.WebhookEndpoint, error)
+	EnqueueWebhookDelivery(ctx context.Context, delivery *domain.WebhookDelivery) error
+	// ClaimWebhookDeliveries returns due deliveries, hiding them from other
+	// claimers until the lease expires.
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*domain.WebhookDelivery, error)
+	SaveWebhookDelivery(ctx context.Context, delivery *domain.WebhookDelivery) error
+	// ListWebhookDeliveries returns an endpoint's deliveries, newest first.
+	ListWebhookDeliveries(ctx context.Context, endpointID, beforeID uint, limit int) ([]*domain.WebhookDelivery, error)
+	CountPendingWebhookDeliveries(ctx context.Context) (int64, error)
+}
+
+// ListMovementsQuery selects a user's movements within a workspace, newest
+// first unless AfterID is set.
+type ListMovementsQuery struct {
+	WorkspaceID string
+	UserID      uint
+	Currency    string
+	BeforeID    uint
+	AfterID     *uint
+	Limit       int
 }
 
 // GrantCurrency
 
 type GrantCurrencyInput struct {
-	From     *domain.User
-	To       *domain.User
-	Currency string
+	WorkspaceID string
+	From        *domain.User
+	To          *domain.User
+	Currency    string
+	// Cooldown is how far back From's grants are loaded into
+	// RecentlyGivenGrants.
+	Cooldown time.Duration
+	Reply    ReplyFunc
+	Events   EventFunc
 }
 
 type GrantCurrencyFuncIn struct {
-	From      *domain.User
-	To        *domain.User
-	ToAccount *domain.Account
+	From          *domain.User
+	To            *domain.User
+	ToAccount     *domain.Account
+	IssuerAccount *domain.Account
+	// FirstIssue is set when nothing of the currency has been issued in the
+	// workspace's journal yet. The issuer account is shared by every
+	// workspace, so its balance can't tell.
+	FirstIssue bool
 }
 
 type GrantCurrencyFuncOut struct {
-	Movement *domain.Movement
-	Grant    *domain.Grant
+	Movement         *domain.Movement
+	IssuanceMovement *domain.Movement
+	Grant            *domain.Grant
 }
 
 // SendCurrency
 
 type SendCurrencyInput struct {
-	From     *domain.User
-	To       *domain.User
-	Currency string
+	WorkspaceID string
+	From        *domain.User
+	To          *domain.User
+	Currency    string
+	Reply       ReplyFunc
+	Events      EventFunc
 }
 
 type SendCurrencyFuncIn struct {
@@ -61,3 +153,46 @@ type SendCurrencyFuncOut struct {
 	SendingMovement   *domain.Movement
 	ReceivingMovement *domain.Movement
 }
+
+// Reconciliation
+
+type AccountLedgerSummary struct {
+	AccountID      uint
+	UserID         uint
+	Currency       string
+	Balance        decimal.Decimal
+	MovementSum    decimal.Decimal
+	AllowOverdraft bool
+}
+
+type ReconcileAccountInput struct {
+	AccountID uint
+}
+
+type ReconcileAccountFuncIn struct {
+	Account       *domain.Account
+	IssuerAccount *domain.Account
+	MovementSum   decimal.Decimal
+}
+
+type ReconcileAccountFuncOut struct {
+	// Both are nil when the account no longer needs correcting.
+	Adjustment       *domain.Movement
+	IssuanceMovement *domain.Movement
+}
+
+// Adjustments
+
+type AdjustAccountInput struct {
+	User     *domain.User
+	Currency string
+}
+
+type AdjustAccountFuncIn struct {
+	Account       *domain.Account
+	IssuerAccount *domain.Account
+}
+
+type AdjustAccountFuncOut struct {
+	Movements []*domain.Movement
+}
@@ -0,0 +1,153 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/yammine/yamex-go"
+	"github.com/yammine/yamex-go/notabankbot/domain"
+	"github.com/yammine/yamex-go/notabankbot/tracing"
+)
+
+const (
+	ErrUnknownWebhookEndpoint = yamex.Sentinel("unknown webhook endpoint")
+	ErrInvalidWebhookURL      = yamex.Sentinel("webhook URL must be an absolute http or https URL")
+	ErrThresholdRequired      = yamex.Sentinel("account.threshold_reached needs a currency and a positive threshold")
+
+	// WebhookMaxAttempts is how many times a delivery is tried before giving up.
+	WebhookMaxAttempts = 10
+)
+
+type CreateWebhookEndpointInput struct {
+	WorkspaceID string
+	URL         string
+	Events      []string
+	// ThresholdCurrency & ThresholdAmount are required for
+	// account.threshold_reached.
+	ThresholdCurrency string
+	ThresholdAmount   decimal.Decimal
+}
+
+// CreateWebhookEndpoint subscribes a URL to the workspace's ledger events,
+// generating the secret its payloads are signed with.
+func (a Application) CreateWebhookEndpoint(ctx context.Context, in *CreateWebhookEndpointInput) (_ *domain.WebhookEndpoint, err error) {
+	ctx, span := tracer.Start(ctx, "app.CreateWebhookEndpoint")
+	defer tracing.End(span, &err)
+
+	u, err := url.Parse(in.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, ErrInvalidWebhookURL
+	}
+	endpoint := &domain.WebhookEndpoint{
+		WorkspaceID: in.WorkspaceID,
+		URL:         in.URL,
+		Events:      strings.Join(in.Events, ","),
+	}
+	if endpoint.Wants(domain.EventThresholdReached) {
+		if in.ThresholdCurrency == "" || !in.ThresholdAmount.IsPositive() {
+			return nil, ErrThresholdRequired
+		}
+		endpoint.ThresholdCurrency = in.ThresholdCurrency
+		endpoint.ThresholdAmount = in.ThresholdAmount
+	}
+
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("generating secret: %w", err)
+	}
+	endpoint.Secret = "whsec_" + hex.EncodeToString(secret)
+
+	if err := a.repo.SaveWebhookEndpoint(ctx, endpoint); err != nil {
+		return nil, err
+	}
+	return endpoint, nil
+}
+
+func (a Application) GetWebhookEndpoint(ctx context.Context, id uint) (*domain.WebhookEndpoint, error) {
+	return a.repo.GetWebhookEndpoint(ctx, id)
+}
+
+// ListWebhookEndpoints returns the workspace's endpoints, or every endpoint
+// when workspaceID is empty.
+func (a Application) ListWebhookEndpoints(ctx context.Context, workspaceID string) ([]*domain.WebhookEndpoint, error) {
+	return a.repo.ListWebhookEndpoints(ctx, workspaceID)
+}
+
+// DisableWebhookEndpoint stops queueing events for the endpoint. Deliveries
+// already queued are given up on when they're next due.
+func (a Application) DisableWebhookEndpoint(ctx context.Context, id uint) (_ *domain.WebhookEndpoint, err error) {
+	ctx, span := tracer.Start(ctx, "app.DisableWebhookEndpoint")
+	defer tracing.End(span, &err)
+
+	endpoint, err := a.repo.GetWebhookEndpoint(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.DisabledAt == nil {
+		now := time.Now()
+		endpoint.DisabledAt = &now
+		if err := a.repo.SaveWebhookEndpoint(ctx, endpoint); err != nil {
+			return nil, err
+		}
+	}
+	return endpoint, nil
+}
+
+// ListWebhookDeliveries is the endpoint's delivery log, newest first.
+func (a Application) ListWebhookDeliveries(ctx context.Context, endpointID, beforeID uint, limit int) ([]*domain.WebhookDelivery, error) {
+	return a.repo.ListWebhookDeliveries(ctx, endpointID, beforeID, limit)
+}
+
+// EnqueueWebhookDelivery queues a delivery outside a ledger change, e.g. a
+// test event. It's due at once unless NextAttemptAt says otherwise.
+func (a Application) EnqueueWebhookDelivery(ctx context.Context, delivery *domain.WebhookDelivery) error {
+	if delivery.NextAttemptAt.IsZero() {
+		delivery.NextAttemptAt = time.Now()
+	}
+	return a.repo.EnqueueWebhookDelivery(ctx, delivery)
+}
+
+// ClaimWebhookDeliveries returns deliveries due to be sent. Other dispatchers
+// won't see them for lease.
+func (a Application) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*domain.WebhookDelivery, error) {
+	return a.repo.ClaimWebhookDeliveries(ctx, limit, lease)
+}
+
+// PendingWebhookDeliveries is how many deliveries are waiting to be sent.
+func (a Application) PendingWebhookDeliveries(ctx context.Context) (int64, error) {
+	return a.repo.CountPendingWebhookDeliveries(ctx)
+}
+
+func (a Application) WebhookDelivered(ctx context.Context, delivery *domain.WebhookDelivery, status int) error {
+	now := time.Now()
+	delivery.Attempts++
+	delivery.DeliveredAt = &now
+	delivery.LastStatus = status
+	delivery.LastError = ""
+	return a.repo.SaveWebhookDelivery(ctx, delivery)
+}
+
+// WebhookFailed schedules the next attempt with exponential backoff, or gives
+// up on the delivery. It reports whether the delivery will be retried.
+func (a Application) WebhookFailed(ctx context.Context, delivery *domain.WebhookDelivery, status int, failure *DeliveryFailure) (bool, error) {
+	now := time.Now()
+	delivery.Attempts++
+	delivery.LastStatus = status
+	delivery.LastError = failure.Err.Error()
+
+	retry := !failure.Permanent && delivery.Attempts < WebhookMaxAttempts
+	if retry {
+		delivery.NextAttemptAt = now.Add(retryBackoff(delivery.Attempts, failure))
+	} else {
+		delivery.FailedAt = &now
+	}
+
+	return retry, a.repo.SaveWebhookDelivery(ctx, delivery)
+}
@@ -0,0 +1,43 @@
+package app
+
+import (
+	"context"
+	"errors"
+
+	"github.com/yammine/yamex-go/notabankbot/domain"
+)
+
+// Users aren't tied to a workspace, they become members of the workspaces
+// they use the bot in. Tools outside Slack can name any user, so they're
+// limited to the workspace's members, or users the ledger doesn't know yet.
+
+// JoinWorkspace records that a Slack user belongs to the workspace, creating
+// them if they're new to the ledger.
+func (a Application) JoinWorkspace(ctx context.Context, workspaceID, slackUserID string) (*domain.User, error) {
+	return a.repo.JoinWorkspace(ctx, workspaceID, slackUserID)
+}
+
+// WorkspaceUser finds a member of the workspace, without creating them.
+func (a Application) WorkspaceUser(ctx context.Context, workspaceID, slackUserID string) (*domain.User, error) {
+	return a.repo.FindWorkspaceUser(ctx, workspaceID, slackUserID)
+}
+
+// workspaceCounterparty fetches a user to take part in a ledger change,
+// joining them to the workspace if they're new to the ledger, but refusing
+// known users who aren't members.
+func (a Application) workspaceCounterparty(ctx context.Context, workspaceID, slackUserID string) (*domain.User, error) {
+	user, err := a.repo.FindWorkspaceUser(ctx, workspaceID, slackUserID)
+	if errors.Is(err, ErrUnknownUser) {
+		return a.repo.JoinWorkspace(ctx, workspaceID, slackUserID)
+	}
+	return user, err
+}
+
+// lookupUser fetches a user, creating them unless workspaceID limits the
+// lookup to the workspace's members.
+func (a Application) lookupUser(ctx context.Context, workspaceID, slackUserID string) (*domain.User, error) {
+	if workspaceID == "" {
+		return a.repo.GetOrCreateUserBySlackID(ctx, slackUserID)
+	}
+	return a.repo.FindWorkspaceUser(ctx, workspaceID, slackUserID)
+}
@@ -0,0 +1,220 @@
+// Package client calls the admin API, as specified by
+// port/admin_api.openapi.json, for ops scripts.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/yammine/yamex-go"
+	"github.com/yammine/yamex-go/notabankbot/app"
+	"github.com/yammine/yamex-go/notabankbot/domain"
+)
+
+// Errors the API answers with are matched to these, so callers can use
+// errors.Is just as they would against the app and domain packages.
+const (
+	ErrInsufficientBalance    = domain.ErrInsufficientBalance
+	ErrAmountCannotBeNegative = domain.ErrAmountCannotBeNegative
+	ErrAlreadyGranted         = domain.ErrAlreadyGranted
+	ErrDateInFuture           = app.ErrDateInFuture
+	ErrReasonRequired         = app.ErrReconcileReasonRequired
+	ErrZeroAdjustment         = app.ErrZeroAdjustment
+
+	ErrUnauthorized   = yamex.Sentinel("admin token is missing or wrong")
+	ErrInvalidRequest = yamex.Sentinel("request was rejected as invalid")
+)
+
+var errorCodes = map[string]error{
+	"insufficient_balance": ErrInsufficientBalance,
+	"negative_amount":      ErrAmountCannotBeNegative,
+	"already_granted":      ErrAlreadyGranted,
+	"date_in_future":       ErrDateInFuture,
+	"reason_required":      ErrReasonRequired,
+	"zero_adjustment":      ErrZeroAdjustment,
+	"unauthorized":         ErrUnauthorized,
+	"invalid_request":      ErrInvalidRequest,
+	"invalid_cursor":       ErrInvalidRequest,
+}
+
+// Error is a request the API answered with an error. It unwraps to one of the
+// sentinels above when the code is known.
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("admin api: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	return errorCodes[e.Code]
+}
+
+type Client struct {
+	baseURL string
+	token   string
+	// HTTPClient sends the requests, http.DefaultClient unless replaced.
+	HTTPClient *http.Client
+}
+
+// NewClient calls the admin API served at baseURL, e.g.
+// https://yamex.example.com/admin/api, with ADMIN_API_TOKEN.
+func NewClient(baseURL, token string) *Client {
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		token:      token,
+		HTTPClient: http.DefaultClient,
+	}
+}
+
+type Account struct {
+	Currency string          `json:"currency"`
+	Balance  decimal.Decimal `json:"balance"`
+}
+
+type User struct {
+	UserID   string    `json:"user_id"`
+	Admin    bool      `json:"admin"`
+	Accounts []Account `json:"accounts"`
+}
+
+func (c *Client) GetUser(ctx context.Context, userID string) (*User, error) {
+	var user User
+	if err := c.do(ctx, http.MethodGet, "/users/"+url.PathEscape(userID), nil, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (c *Client) SetAdmin(ctx context.Context, userID string, admin bool) (*User, error) {
+	var user User
+	body := map[string]bool{"admin": admin}
+	if err := c.do(ctx, http.MethodPut, "/users/"+url.PathEscape(userID)+"/admin", body, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+type AdjustmentRequest struct {
+	UserID   string `json:"user_id"`
+	Currency string `json:"currency"`
+	// Amount is credited to the user when positive, debited when negative.
+	Amount decimal.Decimal `json:"amount"`
+	Reason string          `json:"reason"`
+}
+
+type Adjustment struct {
+	JournalEntryID uint      `json:"journal_entry_id"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+func (c *Client) AdjustBalance(ctx context.Context, req *AdjustmentRequest) (*Adjustment, error) {
+	var adjustment Adjustment
+	if err := c.do(ctx, http.MethodPost, "/adjustments", req, &adjustment); err != nil {
+		return nil, err
+	}
+	return &adjustment, nil
+}
+
+type Feedback struct {
+	ID        uint      `json:"id"`
+	UserID    string    `json:"user_id"`
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type FeedbackPage struct {
+	Feedback []Feedback `json:"feedback"`
+	// NextCursor fetches the next page, it's empty on the last one.
+	NextCursor string `json:"next_cursor"`
+}
+
+// ListFeedback returns a page of feedback, newest first. Pass an empty cursor
+// for the first page, and zero limit for the server's default.
+func (c *Client) ListFeedback(ctx context.Context, cursor string, limit int) (*FeedbackPage, error) {
+	query := url.Values{}
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	path := "/feedback"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	var page FeedbackPage
+	if err := c.do(ctx, http.MethodGet, path, nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// RotateSlackCredentials re-encrypts every stored Slack token with the active
+// key, returning how many were.
+func (c *Client) RotateSlackCredentials(ctx context.Context) (int, error) {
+	var rotation struct {
+		Rotated int `json:"rotated"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/slack-credentials/rotate", nil, &rotation); err != nil {
+		return 0, err
+	}
+	return rotation.Rotated, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("encoding request: %w", err)
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= http.StatusBadRequest {
+		var failure struct {
+			Error struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		// Proxies may answer with something other than our JSON errors.
+		if err := json.NewDecoder(res.Body).Decode(&failure); err != nil {
+			return &Error{StatusCode: res.StatusCode, Code: "unknown", Message: res.Status}
+		}
+		return &Error{StatusCode: res.StatusCode, Code: failure.Error.Code, Message: failure.Error.Message}
+	}
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,313 @@
+// Package config loads the server's settings from config.yml and the
+// environment into a typed Config, checking them all up front so a bad deploy
+// fails at startup rather than on the first request that needs them.
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/shopspring/decimal"
+	"github.com/spf13/viper"
+
+	"github.com/yammine/yamex-go"
+	"github.com/yammine/yamex-go/notabankbot/adapter"
+	"github.com/yammine/yamex-go/notabankbot/app"
+	"github.com/yammine/yamex-go/notabankbot/tracing"
+)
+
+const ErrInvalidConfig = yamex.Sentinel("invalid config")
+
+type Config struct {
+	Production bool
+	Port       int
+	// LogLevel is reloadable.
+	LogLevel zerolog.Level
+
+	Postgres adapter.PostgresPoolConfig
+
+	Slack       SlackConfig
+	SlackTokens SlackTokenConfig
+
+	// RateLimits & GrantPolicy are reloadable.
+	RateLimits  app.RateLimitConfig
+	GrantPolicy app.GrantPolicy
+
+	Tracing tracing.Config
+
+	OutboxPollInterval time.Duration
+	// ShutdownTimeout bounds how long shutdown waits for work in flight.
+	ShutdownTimeout time.Duration
+	// Scheduled jobs, zero disables them.
+	ReconciliationInterval   time.Duration
+	LedgerCheckpointInterval time.Duration
+	BalanceSnapshotInterval  time.Duration
+
+	LedgerSigningKey string
+	// LedgerRetiredKeys are the public keys of previous signing keys, which
+	// older checkpoints are verified against.
+	LedgerRetiredKeys []string
+	AdminSlackTeamID  string
+	AdminChannelID    string
+	// AdminDebugToken enables /admin/debug.
+	AdminDebugToken string
+	// AdminAPIToken enables /admin/api.
+	AdminAPIToken string
+
+	GRPC GRPCConfig
+}
+
+// GRPCConfig serves the ledger over gRPC when Port is set. Without a
+// certificate it's served in plaintext, e.g. behind a TLS terminating proxy.
+type GRPCConfig struct {
+	Port     int
+	CertFile string
+	KeyFile  string
+	// ClientCAFile requires clients to present a certificate signed by it.
+	ClientCAFile string
+}
+
+type SlackConfig struct {
+	SigningSecret string
+	ClientID      string
+	ClientSecret  string
+	RedirectURI   string
+	Scopes        []string
+	StateSecret   string
+	APIURL        string
+	SocketMode    bool
+	AppToken      string
+	RecordFile    string
+}
+
+type SlackTokenConfig struct {
+	ActiveKey        string
+	Keys             map[string]string
+	KeysFile         string
+	CacheTTL         time.Duration
+	NegativeCacheTTL time.Duration
+}
+
+func setDefaults() {
+	viper.SetDefault("PORT", 3000)
+	viper.SetDefault("LOG_LEVEL", "info")
+	viper.SetDefault("POSTGRES_MAX_OPEN_CONNS", 20)
+	viper.SetDefault("POSTGRES_MAX_IDLE_CONNS", 5)
+	viper.SetDefault("POSTGRES_CONN_MAX_LIFETIME", 30*time.Minute)
+	viper.SetDefault("POSTGRES_STATEMENT_TIMEOUT", 30*time.Second)
+	viper.SetDefault("POSTGRES_CONNECT_TIMEOUT", time.Minute)
+	viper.SetDefault("SLACK_CREDENTIAL_CACHE_TTL", 10*time.Minute)
+	viper.SetDefault("SLACK_CREDENTIAL_NEGATIVE_CACHE_TTL", time.Minute)
+	viper.SetDefault("RATE_LIMIT_USER", "20/1m")
+	viper.SetDefault("RATE_LIMIT_CHANNEL", "60/1m")
+	viper.SetDefault("RATE_LIMIT_WORKSPACE", "300/1m")
+	viper.SetDefault("RATE_LIMIT_EXEMPT_ADMINS", true)
+	viper.SetDefault("GRANT_AMOUNT", app.DefaultGrantPolicy().Amount.String())
+	viper.SetDefault("GRANT_COOLDOWN", app.DefaultGrantPolicy().Cooldown)
+	viper.SetDefault("OUTBOX_POLL_INTERVAL", 5*time.Second)
+	viper.SetDefault("SHUTDOWN_TIMEOUT", 25*time.Second)
+	viper.SetDefault("TRACING_SAMPLE_RATIO", 1.0)
+	viper.SetDefault("SLACK_SCOPES", []string{"app_mentions:read", "channels:join", "chat:write", "commands", "reactions:read"})
+}
+
+// Load reads config.yml, if there is one, and the environment, which takes
+// precedence. Every problem found is reported in the one error. Call it again
+// to reload.
+func Load() (*Config, error) {
+	viper.AutomaticEnv()
+	setDefaults()
+	viper.SetConfigName("config")
+	viper.SetConfigType("yml")
+	viper.AddConfigPath(".")
+	if err := viper.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+			return nil, fmt.Errorf("%w: reading config.yml: %v", ErrInvalidConfig, err)
+		}
+	}
+
+	var p parser
+	c := &Config{
+		Production: viper.IsSet("PRODUCTION"),
+		Port:       viper.GetInt("PORT"),
+		LogLevel:   p.logLevel("LOG_LEVEL"),
+		Postgres: adapter.PostgresPoolConfig{
+			DSN:              p.required("POSTGRES_DSN"),
+			ReplicaDSN:       viper.GetString("POSTGRES_REPLICA_DSN"),
+			MaxOpenConns:     p.positiveInt("POSTGRES_MAX_OPEN_CONNS"),
+			MaxIdleConns:     p.positiveInt("POSTGRES_MAX_IDLE_CONNS"),
+			ConnMaxLifetime:  p.duration("POSTGRES_CONN_MAX_LIFETIME"),
+			StatementTimeout: p.duration("POSTGRES_STATEMENT_TIMEOUT"),
+			ConnectTimeout:   p.duration("POSTGRES_CONNECT_TIMEOUT"),
+		},
+		Slack: SlackConfig{
+			SigningSecret: p.required("SLACK_SIGNING_SECRET"),
+			ClientID:      p.required("SLACK_CLIENT_ID"),
+			ClientSecret:  p.required("SLACK_CLIENT_SECRET"),
+			RedirectURI:   p.required("SLACK_REDIRECT_URI"),
+			Scopes:        viper.GetStringSlice("SLACK_SCOPES"),
+			StateSecret:   viper.GetString("SLACK_STATE_SECRET"),
+			APIURL:        viper.GetString("SLACK_API_URL"),
+			SocketMode:    viper.GetBool("SLACK_SOCKET_MODE"),
+			RecordFile:    viper.GetString("SLACK_RECORD_FILE"),
+		},
+		SlackTokens: SlackTokenConfig{
+			ActiveKey:        viper.GetString("SLACK_TOKEN_ACTIVE_KEY"),
+			Keys:             viper.GetStringMapString("SLACK_TOKEN_KEYS"),
+			KeysFile:         viper.GetString("SLACK_TOKEN_KEYS_FILE"),
+			CacheTTL:         p.duration("SLACK_CREDENTIAL_CACHE_TTL"),
+			NegativeCacheTTL: p.duration("SLACK_CREDENTIAL_NEGATIVE_CACHE_TTL"),
+		},
+		RateLimits: app.RateLimitConfig{
+			User:         p.rateLimit("RATE_LIMIT_USER"),
+			Channel:      p.rateLimit("RATE_LIMIT_CHANNEL"),
+			Workspace:    p.rateLimit("RATE_LIMIT_WORKSPACE"),
+			ExemptAdmins: viper.GetBool("RATE_LIMIT_EXEMPT_ADMINS"),
+		},
+		GrantPolicy: app.GrantPolicy{
+			Amount:   p.positiveDecimal("GRANT_AMOUNT"),
+			Cooldown: p.duration("GRANT_COOLDOWN"),
+		},
+		Tracing: tracing.Config{
+			ServiceName: "yamex",
+			Exporter:    p.oneOf("TRACING_EXPORTER", "", "otlp", "stdout"),
+			SampleRatio: p.ratio("TRACING_SAMPLE_RATIO"),
+		},
+		OutboxPollInterval:       p.positiveDuration("OUTBOX_POLL_INTERVAL"),
+		ShutdownTimeout:          p.positiveDuration("SHUTDOWN_TIMEOUT"),
+		ReconciliationInterval:   p.duration("RECONCILIATION_INTERVAL"),
+		LedgerCheckpointInterval: p.duration("LEDGER_CHECKPOINT_INTERVAL"),
+		BalanceSnapshotInterval:  p.duration("BALANCE_SNAPSHOT_INTERVAL"),
+		LedgerSigningKey:         viper.GetString("LEDGER_SIGNING_KEY"),
+		LedgerRetiredKeys:        viper.GetStringSlice("LEDGER_RETIRED_KEYS"),
+		AdminSlackTeamID:         viper.GetString("ADMIN_SLACK_TEAM_ID"),
+		AdminChannelID:           viper.GetString("ADMIN_SLACK_CHANNEL_ID"),
+		AdminDebugToken:          viper.GetString("ADMIN_DEBUG_TOKEN"),
+		AdminAPIToken:            viper.GetString("ADMIN_API_TOKEN"),
+		GRPC: GRPCConfig{
+			Port:         viper.GetInt("GRPC_PORT"),
+			CertFile:     viper.GetString("GRPC_TLS_CERT_FILE"),
+			KeyFile:      viper.GetString("GRPC_TLS_KEY_FILE"),
+			ClientCAFile: viper.GetString("GRPC_CLIENT_CA_FILE"),
+		},
+	}
+	// The HTTP endpoints are served in socket mode too, so they still need the
+	// signing secret.
+	if c.Slack.SocketMode {
+		c.Slack.AppToken = p.required("SLACK_APP_TOKEN")
+	}
+	if c.Postgres.MaxIdleConns > c.Postgres.MaxOpenConns {
+		p.problem("POSTGRES_MAX_IDLE_CONNS can't be more than POSTGRES_MAX_OPEN_CONNS")
+	}
+	if c.LedgerCheckpointInterval > 0 && c.LedgerSigningKey == "" {
+		p.problem("LEDGER_CHECKPOINT_INTERVAL requires LEDGER_SIGNING_KEY")
+	}
+	if (c.GRPC.CertFile == "") != (c.GRPC.KeyFile == "") {
+		p.problem("GRPC_TLS_CERT_FILE and GRPC_TLS_KEY_FILE must be set together")
+	}
+	if c.GRPC.ClientCAFile != "" && c.GRPC.CertFile == "" {
+		p.problem("GRPC_CLIENT_CA_FILE requires GRPC_TLS_CERT_FILE")
+	}
+	if (c.AdminSlackTeamID == "") != (c.AdminChannelID == "") {
+		p.problem("ADMIN_SLACK_TEAM_ID and ADMIN_SLACK_CHANNEL_ID must be set together")
+	}
+
+	if len(p.problems) > 0 {
+		return nil, fmt.Errorf("%w:\n  %s", ErrInvalidConfig, strings.Join(p.problems, "\n  "))
+	}
+	return c, nil
+}
+
+// Settings is every setting as loaded, for reporting with secrets redacted.
+func Settings() map[string]interface{} {
+	return viper.AllSettings()
+}
+
+// parser reads settings, noting problems rather than stopping at the first.
+type parser struct {
+	problems []string
+}
+
+func (p *parser) problem(format string, args ...interface{}) {
+	p.problems = append(p.problems, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) required(key string) string {
+	v := viper.GetString(key)
+	if strings.TrimSpace(v) == "" {
+		p.problem("%s is required", key)
+	}
+	return v
+}
+
+func (p *parser) duration(key string) time.Duration {
+	if viper.GetString(key) == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(viper.GetString(key))
+	if err != nil || d < 0 {
+		p.problem("%s must be a duration like 10s or 1h, got %q", key, viper.GetString(key))
+	}
+	return d
+}
+
+func (p *parser) positiveDuration(key string) time.Duration {
+	d := p.duration(key)
+	if d == 0 {
+		p.problem("%s must be more than zero", key)
+	}
+	return d
+}
+
+func (p *parser) positiveInt(key string) int {
+	n, err := strconv.Atoi(viper.GetString(key))
+	if err != nil || n < 1 {
+		p.problem("%s must be a whole number above zero, got %q", key, viper.GetString(key))
+	}
+	return n
+}
+
+func (p *parser) rateLimit(key string) app.RateLimit {
+	limit, err := app.ParseRateLimit(viper.GetString(key))
+	if err != nil {
+		p.problem("%s: %v", key, err)
+	}
+	return limit
+}
+
+func (p *parser) positiveDecimal(key string) decimal.Decimal {
+	d, err := decimal.NewFromString(viper.GetString(key))
+	if err != nil || !d.IsPositive() {
+		p.problem("%s must be a positive number, got %q", key, viper.GetString(key))
+	}
+	return d
+}
+
+func (p *parser) ratio(key string) float64 {
+	r := viper.GetFloat64(key)
+	if r < 0 || r > 1 {
+		p.problem("%s must be between 0 and 1, got %v", key, r)
+	}
+	return r
+}
+
+func (p *parser) oneOf(key string, allowed ...string) string {
+	v := viper.GetString(key)
+	for _, a := range allowed {
+		if v == a {
+			return v
+		}
+	}
+	p.problem("%s must be one of %q, got %q", key, allowed, v)
+	return v
+}
+
+func (p *parser) logLevel(key string) zerolog.Level {
+	level, err := zerolog.ParseLevel(viper.GetString(key))
+	if err != nil {
+		p.problem("%s must be a level like debug, info or warn, got %q", key, viper.GetString(key))
+	}
+	return level
+}
@@ -18,7 +18,10 @@ type Account struct {
 
 	UserID   uint            `gorm:"index:idx_accounts_user_id_currency,unique"`
 	Currency string          `gorm:"index:idx_accounts_user_id_currency,unique"`
-	Balance  decimal.Decimal `gorm:"type:decimal(20,8);"`
+	Balance  decimal.Decimal `gorm:"type:decimal(20,8);check:chk_accounts_balance_non_negative,balance >= 0 OR allow_overdraft"`
+	// AllowOverdraft is only set on issuance accounts, whose negative balance
+	// is the total amount of the currency in circulation.
+	AllowOverdraft bool `gorm:"not null;default:false"`
 
 	Movements []Movement
 }
@@ -33,7 +36,7 @@ func (a *Account) Debit(amount decimal.Decimal, reason string) (*Movement, error
 	}
 
 	newBalance := a.Balance.Sub(amount)
-	if newBalance.IsNegative() {
+	if newBalance.IsNegative() && !a.AllowOverdraft {
 		return nil, ErrInsufficientBalance
 	}
 
@@ -51,3 +54,15 @@ func (a *Account) Credit(amount decimal.Decimal, reason string) (*Movement, erro
 	movement := NewMovement(a, amount, reason)
 	return movement, nil
 }
+
+// Drift is the difference between the stored balance and the sum of the
+// account's movements. It is zero for a healthy account.
+func (a *Account) Drift(movementSum decimal.Decimal) decimal.Decimal {
+	return a.Balance.Sub(movementSum)
+}
+
+// Reconcile records the drift as a movement so that the account's movements
+// sum to its stored balance again. The balance itself is left untouched.
+func (a *Account) Reconcile(movementSum decimal.Decimal, reason string) *Movement {
+	return NewMovement(a, a.Drift(movementSum), fmt.Sprintf("adjustment: %s", reason))
+}
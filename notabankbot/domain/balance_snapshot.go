@@ -0,0 +1,26 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// SnapshotDateLayout is how snapshot dates are written, both in commands and
+// when querying by date.
+const SnapshotDateLayout = "2006-01-02"
+
+// BalanceSnapshot is an account's balance at the end of a day (UTC). Balances
+// at any point in time are a snapshot plus the movements made since.
+type BalanceSnapshot struct {
+	AccountID uint            `gorm:"primarykey;autoIncrement:false"`
+	Date      time.Time       `gorm:"primarykey;type:date"`
+	Balance   decimal.Decimal `gorm:"type:decimal(20,8)"`
+	CreatedAt time.Time
+}
+
+// EndOfDay is the first instant after the given day, in UTC.
+func EndOfDay(day time.Time) time.Time {
+	y, m, d := day.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC).AddDate(0, 0, 1)
+}
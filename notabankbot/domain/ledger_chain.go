@@ -0,0 +1,167 @@
+package domain
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yammine/yamex-go"
+)
+
+const (
+	ErrInvalidReceiptSignature yamex.Sentinel = "receipt signature is invalid"
+	ErrReceiptHashMismatch     yamex.Sentinel = "receipt contents do not match its hash"
+)
+
+// SystemWorkspaceID is the chain used for ledger changes that are not made on
+// behalf of a workspace, such as reconciliation adjustments.
+const SystemWorkspaceID = ""
+
+// ChainHead tracks the latest journal entry of a workspace's hash chain.
+type ChainHead struct {
+	WorkspaceID string `gorm:"primarykey"`
+	Sequence    uint64
+	Hash        string
+	UpdatedAt   time.Time
+}
+
+// Checkpoint is a signed snapshot of a chain head. Once published, history
+// before the checkpoint cannot be rewritten without invalidating it.
+type Checkpoint struct {
+	ID          uint      `gorm:"primarykey"`
+	CreatedAt   time.Time `gorm:"index"`
+	WorkspaceID string    `gorm:"index"`
+	Sequence    uint64
+	Hash        string
+	KeyID       string
+	Signature   string
+}
+
+func NewCheckpoint(head *ChainHead) *Checkpoint {
+	return &Checkpoint{
+		CreatedAt:   time.Now().UTC().Truncate(time.Microsecond),
+		WorkspaceID: head.WorkspaceID,
+		Sequence:    head.Sequence,
+		Hash:        head.Hash,
+	}
+}
+
+func (c Checkpoint) SigningPayload() []byte {
+	return []byte(fmt.Sprintf("yamex-checkpoint\n%s\n%d\n%s\n%s", c.WorkspaceID, c.Sequence, c.Hash, c.CreatedAt.UTC().Format(time.RFC3339Nano)))
+}
+
+// Chain links the entry to the current head of its workspace's chain and
+// seals it with its hash. The head is advanced to the entry.
+func (j *JournalEntry) Chain(head *ChainHead) {
+	j.WorkspaceID = head.WorkspaceID
+	j.Sequence = head.Sequence + 1
+	j.PrevHash = head.Hash
+	// Postgres stores microseconds, truncate so the hash can be recomputed.
+	j.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	j.Hash = j.ComputeHash()
+
+	head.Sequence = j.Sequence
+	head.Hash = j.Hash
+}
+
+// ComputeHash hashes everything that makes up the entry along with the hash
+// of the entry before it.
+func (j JournalEntry) ComputeHash() string {
+	return hashChainLink(j.WorkspaceID, j.Sequence, j.PrevHash, j.CreatedAt, receiptLines(j.Movements))
+}
+
+// Receipt is a self-contained, signed proof that a journal entry is part of
+// the ledger. It can be verified offline with the ledger's public key.
+type Receipt struct {
+	EntryID     uint           `json:"entry_id"`
+	WorkspaceID string         `json:"workspace_id"`
+	Sequence    uint64         `json:"sequence"`
+	CreatedAt   time.Time      `json:"created_at"`
+	Lines       []*ReceiptLine `json:"lines"`
+	PrevHash    string         `json:"prev_hash"`
+	Hash        string         `json:"hash"`
+	KeyID       string         `json:"key_id"`
+	Signature   string         `json:"signature,omitempty"`
+}
+
+type ReceiptLine struct {
+	AccountID uint   `json:"account_id"`
+	Amount    string `json:"amount"`
+	Reason    string `json:"reason"`
+}
+
+func NewReceipt(j *JournalEntry) *Receipt {
+	return &Receipt{
+		EntryID:     j.ID,
+		WorkspaceID: j.WorkspaceID,
+		Sequence:    j.Sequence,
+		CreatedAt:   j.CreatedAt.UTC(),
+		Lines:       receiptLines(j.Movements),
+		PrevHash:    j.PrevHash,
+		Hash:        j.Hash,
+	}
+}
+
+// SigningPayload is the receipt without its signature, as canonical JSON.
+func (r Receipt) SigningPayload() []byte {
+	r.Signature = ""
+	payload, _ := json.Marshal(r)
+	return payload
+}
+
+// Verify checks the receipt's hash against its contents and its signature
+// against the ledger's public key.
+func (r Receipt) Verify(publicKey ed25519.PublicKey) error {
+	if hashChainLink(r.WorkspaceID, r.Sequence, r.PrevHash, r.CreatedAt, r.Lines) != r.Hash {
+		return ErrReceiptHashMismatch
+	}
+	signature, err := base64.StdEncoding.DecodeString(r.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidReceiptSignature, err)
+	}
+	if !ed25519.Verify(publicKey, r.SigningPayload(), signature) {
+		return ErrInvalidReceiptSignature
+	}
+	return nil
+}
+
+// KeyID derives a short, stable identifier for a signing key.
+func KeyID(publicKey ed25519.PublicKey) string {
+	sum := sha256.Sum256(publicKey)
+	return hex.EncodeToString(sum[:8])
+}
+
+func receiptLines(movements []*Movement) []*ReceiptLine {
+	lines := make([]*ReceiptLine, len(movements))
+	for i, m := range movements {
+		lines[i] = &ReceiptLine{AccountID: m.AccountID, Amount: m.Amount.StringFixed(8), Reason: m.Reason}
+	}
+	// Movement IDs are assigned on insert, so order by content instead.
+	sort.Slice(lines, func(i, j int) bool {
+		if lines[i].AccountID != lines[j].AccountID {
+			return lines[i].AccountID < lines[j].AccountID
+		}
+		if lines[i].Amount != lines[j].Amount {
+			return lines[i].Amount < lines[j].Amount
+		}
+		return lines[i].Reason < lines[j].Reason
+	})
+	return lines
+}
+
+func hashChainLink(workspaceID string, sequence uint64, prevHash string, createdAt time.Time, lines []*ReceiptLine) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "yamex-journal-entry\n%s\n%d\n%s\n%s\n", workspaceID, sequence, prevHash, createdAt.UTC().Format(time.RFC3339Nano))
+	for _, l := range lines {
+		fmt.Fprintf(&b, "%d\t%s\t%q\n", l.AccountID, l.Amount, l.Reason)
+	}
+
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
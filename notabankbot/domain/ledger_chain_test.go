@@ -0,0 +1,156 @@
+package domain
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func testEntry(t *testing.T, head *ChainHead) *JournalEntry {
+	t.Helper()
+	issuer := &Account{AllowOverdraft: true}
+	issuer.ID = 1
+	account := &Account{}
+	account.ID = 2
+
+	debit, err := issuer.Debit(decimal.New(10, 0), "grant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	credit, _ := account.Credit(decimal.New(10, 0), "grant")
+	entry, err := NewJournalEntry(debit, credit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Chain(head)
+	return entry
+}
+
+func TestJournalEntryChain(t *testing.T) {
+	head := &ChainHead{WorkspaceID: "T1"}
+	first := testEntry(t, head)
+	second := testEntry(t, head)
+
+	if first.Sequence != 1 || second.Sequence != 2 {
+		t.Errorf("sequences = %d, %d, want 1, 2", first.Sequence, second.Sequence)
+	}
+	if first.PrevHash != "" || second.PrevHash != first.Hash {
+		t.Errorf("second.PrevHash = %q, want the first entry's hash %q", second.PrevHash, first.Hash)
+	}
+	if head.Sequence != 2 || head.Hash != second.Hash {
+		t.Errorf("head = %+v, want it at the second entry", head)
+	}
+	for _, e := range []*JournalEntry{first, second} {
+		if e.WorkspaceID != "T1" || e.Hash != e.ComputeHash() {
+			t.Errorf("entry %d does not recompute to its hash", e.Sequence)
+		}
+	}
+}
+
+func TestComputeHash(t *testing.T) {
+	entry := testEntry(t, &ChainHead{WorkspaceID: "T1"})
+
+	reversed := *entry
+	reversed.Movements = []*Movement{entry.Movements[1], entry.Movements[0]}
+	if reversed.ComputeHash() != entry.Hash {
+		t.Error("hash depends on the order movements were loaded in")
+	}
+
+	tests := []struct {
+		name   string
+		tamper func(e *JournalEntry)
+	}{
+		{"workspace", func(e *JournalEntry) { e.WorkspaceID = "T2" }},
+		{"sequence", func(e *JournalEntry) { e.Sequence++ }},
+		{"previous hash", func(e *JournalEntry) { e.PrevHash = "00" }},
+		{"created at", func(e *JournalEntry) { e.CreatedAt = e.CreatedAt.Add(1) }},
+		{"amount", func(e *JournalEntry) { e.Movements[1].Amount = decimal.New(11, 0) }},
+		{"account", func(e *JournalEntry) { e.Movements[1].AccountID = 3 }},
+		{"reason", func(e *JournalEntry) { e.Movements[1].Reason = "gift" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := *entry
+			tampered.Movements = []*Movement{}
+			for _, m := range entry.Movements {
+				movement := *m
+				tampered.Movements = append(tampered.Movements, &movement)
+			}
+			tt.tamper(&tampered)
+			if tampered.ComputeHash() == entry.Hash {
+				t.Errorf("changing the %s kept the hash", tt.name)
+			}
+		})
+	}
+}
+
+func TestReceiptVerify(t *testing.T) {
+	publicKey, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(r *Receipt) {
+		r.KeyID = KeyID(publicKey)
+		r.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, r.SigningPayload()))
+	}
+
+	tests := []struct {
+		name      string
+		receipt   func(r *Receipt)
+		publicKey ed25519.PublicKey
+		want      error
+	}{
+		{name: "valid", receipt: sign, publicKey: publicKey},
+		{
+			name:      "other key",
+			receipt:   sign,
+			publicKey: otherKey,
+			want:      ErrInvalidReceiptSignature,
+		},
+		{
+			name: "amount changed",
+			receipt: func(r *Receipt) {
+				sign(r)
+				r.Lines[1].Amount = "100.00000000"
+			},
+			publicKey: publicKey,
+			want:      ErrReceiptHashMismatch,
+		},
+		{
+			name: "rehashed after signing",
+			receipt: func(r *Receipt) {
+				sign(r)
+				r.Lines[1].Amount = "100.00000000"
+				r.Hash = hashChainLink(r.WorkspaceID, r.Sequence, r.PrevHash, r.CreatedAt, r.Lines)
+			},
+			publicKey: publicKey,
+			want:      ErrInvalidReceiptSignature,
+		},
+		{
+			name: "signature not base64",
+			receipt: func(r *Receipt) {
+				sign(r)
+				r.Signature = "not base64!"
+			},
+			publicKey: publicKey,
+			want:      ErrInvalidReceiptSignature,
+		},
+		{name: "unsigned", receipt: func(r *Receipt) {}, publicKey: publicKey, want: ErrInvalidReceiptSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receipt := NewReceipt(testEntry(t, &ChainHead{WorkspaceID: "T1"}))
+			tt.receipt(receipt)
+			if err := receipt.Verify(tt.publicKey); !errors.Is(err, tt.want) {
+				t.Errorf("Verify() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -1,15 +1,24 @@
 package domain
 
 import (
+	"time"
+
 	"github.com/shopspring/decimal"
+	"github.com/yammine/yamex-go"
 	"gorm.io/gorm"
 )
 
+const (
+	ErrMovementImmutable      yamex.Sentinel = "movements cannot be changed once recorded"
+	ErrUnbalancedJournalEntry yamex.Sentinel = "journal entry does not sum to zero"
+)
+
 type Movement struct {
 	gorm.Model
-	AccountID uint
-	Amount    decimal.Decimal `gorm:"type:decimal(20,8)"`
-	Reason    string
+	AccountID      uint
+	JournalEntryID uint            `gorm:"index"`
+	Amount         decimal.Decimal `gorm:"type:decimal(20,8)"`
+	Reason         string
 }
 
 func NewMovement(account *Account, amount decimal.Decimal, reason string) *Movement {
@@ -19,3 +28,31 @@ func NewMovement(account *Account, amount decimal.Decimal, reason string) *Movem
 		Reason:    reason,
 	}
 }
+
+// JournalEntry groups the movements of a single ledger change. The amounts of
+// its movements must always sum to zero.
+//
+// Entries form a hash chain per workspace: each one stores the hash of the
+// entry before it, so rewriting history breaks every later link.
+type JournalEntry struct {
+	ID          uint      `gorm:"primarykey"`
+	CreatedAt   time.Time `gorm:"index"`
+	WorkspaceID string    `gorm:"index:idx_journal_entries_workspace_id_sequence,unique"`
+	Sequence    uint64    `gorm:"index:idx_journal_entries_workspace_id_sequence,unique"`
+	PrevHash    string
+	Hash        string
+
+	Movements []*Movement
+}
+
+func NewJournalEntry(movements ...*Movement) (*JournalEntry, error) {
+	sum := decimal.Zero
+	for _, m := range movements {
+		sum = sum.Add(m.Amount)
+	}
+	if !sum.IsZero() {
+		return nil, ErrUnbalancedJournalEntry
+	}
+
+	return &JournalEntry{Movements: movements}, nil
+}
@@ -0,0 +1,55 @@
+package domain
+
+import (
+	"time"
+)
+
+// OutboxMessage is a reply waiting to be delivered to a chat platform. Replies
+// to ledger changes are written in the same transaction as the change, so a
+// user always hears about a transfer that went through, even if delivery has
+// to be retried.
+type OutboxMessage struct {
+	ID          uint      `gorm:"primarykey"`
+	CreatedAt   time.Time `gorm:"index"`
+	WorkspaceID string
+	// ChannelID & ThreadTS address a message, EphemeralUserID makes it only
+	// visible to that user. ResponseURL is used instead when set.
+	ChannelID       string
+	ThreadTS        string
+	EphemeralUserID string
+	ResponseURL     string
+	ReplaceOriginal bool
+	// TraceParent links delivery back to the trace that queued the message.
+	TraceParent string
+
+	Text string
+	// Blocks are stored as the Block Kit JSON array.
+	Blocks []byte
+
+	JournalEntryID *uint
+	Attempts       int
+	// NextAttemptAt is also pushed forward while a dispatcher holds the message.
+	NextAttemptAt time.Time `gorm:"index"`
+	DeliveredAt   *time.Time
+	FailedAt      *time.Time
+	LastError     string
+}
+
+// Pending is true until the message is delivered or given up on.
+func (m OutboxMessage) Pending() bool {
+	return m.DeliveredAt == nil && m.FailedAt == nil
+}
+
+// Addressed copies where the message goes, without any content, so replies
+// can be built from a template.
+func (m OutboxMessage) Addressed() *OutboxMessage {
+	return &OutboxMessage{
+		WorkspaceID:     m.WorkspaceID,
+		ChannelID:       m.ChannelID,
+		ThreadTS:        m.ThreadTS,
+		EphemeralUserID: m.EphemeralUserID,
+		ResponseURL:     m.ResponseURL,
+		ReplaceOriginal: m.ReplaceOriginal,
+		TraceParent:     m.TraceParent,
+	}
+}
@@ -1,6 +1,10 @@
 package domain
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type User struct {
 	gorm.Model
@@ -13,6 +17,14 @@ type User struct {
 	GrantsReceived      []Grant  `gorm:"foreignKey:ToUserID"`
 }
 
+// WorkspaceUser records that a user belongs to a workspace: they talked to
+// the bot there, installed it, or took part in a grant or transfer there.
+type WorkspaceUser struct {
+	WorkspaceID string `gorm:"primarykey"`
+	UserID      uint   `gorm:"primarykey;autoIncrement:false"`
+	CreatedAt   time.Time
+}
+
 // CanGrantCurrency assumes RecentlyGivenGrants has been loaded.
 func (u User) CanGrantCurrency() bool {
 	// Admins can always grant currency
@@ -23,3 +35,8 @@ func (u User) CanGrantCurrency() bool {
 	// Non-admins can only grant if they have no recent grants
 	return len(u.RecentlyGivenGrants) < 1
 }
+
+// IssuerSlackID identifies the system user that owns every issuance account.
+// Granted currency is debited from the issuer so that each journal entry
+// balances.
+const IssuerSlackID = "yamex:issuer"
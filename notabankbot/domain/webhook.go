@@ -0,0 +1,213 @@
+package domain
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/yammine/yamex-go"
+)
+
+const ErrUnknownWebhookEvent = yamex.Sentinel("webhook events are grant.created, transfer.completed, currency.created or account.threshold_reached")
+
+// Event types webhooks can subscribe to.
+const (
+	EventGrantCreated      = "grant.created"
+	EventTransferCompleted = "transfer.completed"
+	EventCurrencyCreated   = "currency.created"
+	// EventThresholdReached is sent when an account's balance rises to the
+	// endpoint's threshold.
+	EventThresholdReached = "account.threshold_reached"
+	// EventWebhookTest is only sent when an admin tests an endpoint.
+	EventWebhookTest = "webhook.test"
+)
+
+var WebhookEventTypes = []string{EventGrantCreated, EventTransferCompleted, EventCurrencyCreated, EventThresholdReached}
+
+// ParseWebhookEvents checks a comma separated list of event types.
+func ParseWebhookEvents(s string) ([]string, error) {
+	var events []string
+	for _, event := range strings.Split(s, ",") {
+		event = strings.TrimSpace(event)
+		known := false
+		for _, t := range WebhookEventTypes {
+			known = known || t == event
+		}
+		if !known {
+			return nil, fmt.Errorf("%w: got %q", ErrUnknownWebhookEvent, event)
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// LedgerEvent is a ledger change as webhooks see it.
+type LedgerEvent struct {
+	Type        string
+	WorkspaceID string
+	CreatedAt   time.Time
+	// Data is the event's JSON payload.
+	Data interface{}
+	// Credited, if set, is the account the change credited, checked against
+	// endpoints' thresholds.
+	Credited *BalanceChange
+}
+
+// BalanceChange is an account's balance either side of a ledger change.
+type BalanceChange struct {
+	UserID         string
+	Currency       string
+	JournalEntryID uint
+	Before         decimal.Decimal
+	After          decimal.Decimal
+}
+
+type GrantCreated struct {
+	GrantID        uint            `json:"grant_id"`
+	JournalEntryID uint            `json:"journal_entry_id"`
+	GranterID      string          `json:"granter_id"`
+	ReceiverID     string          `json:"receiver_id"`
+	Currency       string          `json:"currency"`
+	Amount         decimal.Decimal `json:"amount"`
+}
+
+type TransferCompleted struct {
+	JournalEntryID uint            `json:"journal_entry_id"`
+	SenderID       string          `json:"sender_id"`
+	ReceiverID     string          `json:"receiver_id"`
+	Currency       string          `json:"currency"`
+	Amount         decimal.Decimal `json:"amount"`
+}
+
+type CurrencyCreated struct {
+	Currency       string `json:"currency"`
+	JournalEntryID uint   `json:"journal_entry_id"`
+	// CreatedBy granted the currency's first issue.
+	CreatedBy string `json:"created_by"`
+}
+
+type ThresholdReached struct {
+	UserID         string          `json:"user_id"`
+	Currency       string          `json:"currency"`
+	Threshold      decimal.Decimal `json:"threshold"`
+	Balance        decimal.Decimal `json:"balance"`
+	JournalEntryID uint            `json:"journal_entry_id"`
+}
+
+// WebhookEndpoint is where a workspace's ledger events are delivered. Payloads
+// are signed with its secret.
+type WebhookEndpoint struct {
+	ID          uint `gorm:"primarykey"`
+	CreatedAt   time.Time
+	WorkspaceID string `gorm:"index"`
+	URL         string
+	Secret      string
+	// Events is the comma separated event types it subscribes to.
+	Events string
+	// ThresholdCurrency & ThresholdAmount are what account.threshold_reached
+	// watches for.
+	ThresholdCurrency string
+	ThresholdAmount   decimal.Decimal `gorm:"type:decimal(20,8)"`
+	DisabledAt        *time.Time
+}
+
+// Wants reports whether the endpoint subscribes to the event type.
+func (e WebhookEndpoint) Wants(eventType string) bool {
+	for _, t := range strings.Split(e.Events, ",") {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Deliveries queues the events the endpoint subscribes to, along with an
+// account.threshold_reached for any credit that rose to its threshold.
+func (e WebhookEndpoint) Deliveries(events []*LedgerEvent) ([]*WebhookDelivery, error) {
+	var deliveries []*WebhookDelivery
+	for _, event := range events {
+		if e.Wants(event.Type) {
+			d, err := NewWebhookDelivery(e.ID, event)
+			if err != nil {
+				return nil, err
+			}
+			deliveries = append(deliveries, d)
+		}
+
+		c := event.Credited
+		if c == nil || !e.Wants(EventThresholdReached) || c.Currency != e.ThresholdCurrency {
+			continue
+		}
+		if c.Before.LessThan(e.ThresholdAmount) && !c.After.LessThan(e.ThresholdAmount) {
+			d, err := NewWebhookDelivery(e.ID, &LedgerEvent{
+				Type:        EventThresholdReached,
+				WorkspaceID: event.WorkspaceID,
+				CreatedAt:   event.CreatedAt,
+				Data: ThresholdReached{
+					UserID:         c.UserID,
+					Currency:       c.Currency,
+					Threshold:      e.ThresholdAmount,
+					Balance:        c.After,
+					JournalEntryID: c.JournalEntryID,
+				},
+			})
+			if err != nil {
+				return nil, err
+			}
+			deliveries = append(deliveries, d)
+		}
+	}
+	return deliveries, nil
+}
+
+// WebhookDelivery is an event waiting to be, or already, delivered to an
+// endpoint. Deliveries are kept once done as the endpoint's delivery log.
+type WebhookDelivery struct {
+	ID         uint      `gorm:"primarykey"`
+	CreatedAt  time.Time `gorm:"index"`
+	EndpointID uint      `gorm:"index"`
+	EventType  string
+	Payload    []byte
+
+	Attempts int
+	// NextAttemptAt is also pushed forward while a dispatcher holds the delivery.
+	NextAttemptAt time.Time `gorm:"index"`
+	DeliveredAt   *time.Time
+	FailedAt      *time.Time
+	// LastStatus is the endpoint's last HTTP status, zero if it didn't answer.
+	LastStatus int
+	LastError  string
+}
+
+type webhookPayload struct {
+	Type        string      `json:"type"`
+	WorkspaceID string      `json:"workspace_id"`
+	CreatedAt   time.Time   `json:"created_at"`
+	Data        interface{} `json:"data"`
+}
+
+func NewWebhookDelivery(endpointID uint, event *LedgerEvent) (*WebhookDelivery, error) {
+	payload, err := json.Marshal(webhookPayload{
+		Type:        event.Type,
+		WorkspaceID: event.WorkspaceID,
+		CreatedAt:   event.CreatedAt.UTC(),
+		Data:        event.Data,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("encoding %s payload: %w", event.Type, err)
+	}
+	return &WebhookDelivery{
+		EndpointID:    endpointID,
+		EventType:     event.Type,
+		Payload:       payload,
+		NextAttemptAt: time.Now(),
+	}, nil
+}
+
+// Pending is true until the delivery succeeds or is given up on.
+func (d WebhookDelivery) Pending() bool {
+	return d.DeliveredAt == nil && d.FailedAt == nil
+}
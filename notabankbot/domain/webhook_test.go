@@ -0,0 +1,101 @@
+package domain
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestWebhookEndpointDeliveries(t *testing.T) {
+	threshold := WebhookEndpoint{
+		ID:                1,
+		Events:            EventThresholdReached,
+		ThresholdCurrency: "$coffee",
+		ThresholdAmount:   decimal.New(10, 0),
+	}
+	credit := func(currency string, before, after int64) *LedgerEvent {
+		return &LedgerEvent{
+			Type:        EventTransferCompleted,
+			WorkspaceID: "T1",
+			CreatedAt:   time.Now(),
+			Credited: &BalanceChange{
+				UserID:   "UBOB0000000",
+				Currency: currency,
+				Before:   decimal.New(before, 0),
+				After:    decimal.New(after, 0),
+			},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		endpoint WebhookEndpoint
+		event    *LedgerEvent
+		want     []string
+	}{
+		{name: "rises to the threshold", endpoint: threshold, event: credit("$coffee", 9, 10), want: []string{EventThresholdReached}},
+		{name: "rises past the threshold", endpoint: threshold, event: credit("$coffee", 0, 25), want: []string{EventThresholdReached}},
+		{name: "stays below", endpoint: threshold, event: credit("$coffee", 1, 9)},
+		{name: "already at the threshold", endpoint: threshold, event: credit("$coffee", 10, 12)},
+		{name: "falls below", endpoint: threshold, event: credit("$coffee", 12, 8)},
+		{name: "other currency", endpoint: threshold, event: credit("$tea", 0, 25)},
+		{name: "nothing credited", endpoint: threshold, event: &LedgerEvent{Type: EventTransferCompleted}},
+		{
+			name:     "not subscribed to thresholds",
+			endpoint: WebhookEndpoint{ID: 1, Events: EventTransferCompleted, ThresholdCurrency: "$coffee", ThresholdAmount: decimal.New(10, 0)},
+			event:    credit("$coffee", 0, 25),
+			want:     []string{EventTransferCompleted},
+		},
+		{
+			name:     "subscribed to both",
+			endpoint: WebhookEndpoint{ID: 1, Events: EventTransferCompleted + "," + EventThresholdReached, ThresholdCurrency: "$coffee", ThresholdAmount: decimal.New(10, 0)},
+			event:    credit("$coffee", 0, 25),
+			want:     []string{EventTransferCompleted, EventThresholdReached},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deliveries, err := tt.endpoint.Deliveries([]*LedgerEvent{tt.event})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(deliveries) != len(tt.want) {
+				t.Fatalf("got %d deliveries, want %v", len(deliveries), tt.want)
+			}
+			for n, d := range deliveries {
+				if d.EventType != tt.want[n] || d.EndpointID != tt.endpoint.ID {
+					t.Errorf("delivery %d = %s to %d, want %s to %d", n, d.EventType, d.EndpointID, tt.want[n], tt.endpoint.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestThresholdReachedPayload(t *testing.T) {
+	endpoint := WebhookEndpoint{ID: 1, Events: EventThresholdReached, ThresholdCurrency: "$coffee", ThresholdAmount: decimal.New(10, 0)}
+	deliveries, err := endpoint.Deliveries([]*LedgerEvent{{
+		Type:        EventGrantCreated,
+		WorkspaceID: "T1",
+		Credited:    &BalanceChange{UserID: "UBOB0000000", Currency: "$coffee", JournalEntryID: 7, Before: decimal.New(9, 0), After: decimal.New(11, 0)},
+	}})
+	if err != nil || len(deliveries) != 1 {
+		t.Fatalf("Deliveries() = %v, %v, want one delivery", deliveries, err)
+	}
+
+	var payload struct {
+		Type        string           `json:"type"`
+		WorkspaceID string           `json:"workspace_id"`
+		Data        ThresholdReached `json:"data"`
+	}
+	if err := json.Unmarshal(deliveries[0].Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	want := ThresholdReached{UserID: "UBOB0000000", Currency: "$coffee", Threshold: decimal.New(10, 0), Balance: decimal.New(11, 0), JournalEntryID: 7}
+	if payload.Type != EventThresholdReached || payload.WorkspaceID != "T1" || payload.Data.UserID != want.UserID ||
+		payload.Data.Currency != want.Currency || !payload.Data.Threshold.Equal(want.Threshold) ||
+		!payload.Data.Balance.Equal(want.Balance) || payload.Data.JournalEntryID != want.JournalEntryID {
+		t.Errorf("payload = %+v, want %+v", payload, want)
+	}
+}
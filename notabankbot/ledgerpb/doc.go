@@ -0,0 +1,5 @@
+// Package ledgerpb is the generated code for the gRPC ledger service, served
+// by port.LedgerServer.
+package ledgerpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative ledger.proto
@@ -0,0 +1,281 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package ledgerpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// LedgerClient is the client API for Ledger service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type LedgerClient interface {
+	// Grant issues the granter's grant to the receiver. Needs the admin scope.
+	Grant(ctx context.Context, in *GrantRequest, opts ...grpc.CallOption) (*GrantResponse, error)
+	// Transfer moves currency between two users. Needs the transfer scope.
+	Transfer(ctx context.Context, in *TransferRequest, opts ...grpc.CallOption) (*TransferResponse, error)
+	GetBalance(ctx context.Context, in *GetBalanceRequest, opts ...grpc.CallOption) (*GetBalanceResponse, error)
+	// ListMovements pages through a user's movements, newest first.
+	ListMovements(ctx context.Context, in *ListMovementsRequest, opts ...grpc.CallOption) (*ListMovementsResponse, error)
+	// WatchMovements streams a user's movements, oldest first, as they're made.
+	WatchMovements(ctx context.Context, in *WatchMovementsRequest, opts ...grpc.CallOption) (Ledger_WatchMovementsClient, error)
+}
+
+type ledgerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewLedgerClient(cc grpc.ClientConnInterface) LedgerClient {
+	return &ledgerClient{cc}
+}
+
+func (c *ledgerClient) Grant(ctx context.Context, in *GrantRequest, opts ...grpc.CallOption) (*GrantResponse, error) {
+	out := new(GrantResponse)
+	err := c.cc.Invoke(ctx, "/yamex.ledger.v1.Ledger/Grant", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ledgerClient) Transfer(ctx context.Context, in *TransferRequest, opts ...grpc.CallOption) (*TransferResponse, error) {
+	out := new(TransferResponse)
+	err := c.cc.Invoke(ctx, "/yamex.ledger.v1.Ledger/Transfer", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ledgerClient) GetBalance(ctx context.Context, in *GetBalanceRequest, opts ...grpc.CallOption) (*GetBalanceResponse, error) {
+	out := new(GetBalanceResponse)
+	err := c.cc.Invoke(ctx, "/yamex.ledger.v1.Ledger/GetBalance", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ledgerClient) ListMovements(ctx context.Context, in *ListMovementsRequest, opts ...grpc.CallOption) (*ListMovementsResponse, error) {
+	out := new(ListMovementsResponse)
+	err := c.cc.Invoke(ctx, "/yamex.ledger.v1.Ledger/ListMovements", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ledgerClient) WatchMovements(ctx context.Context, in *WatchMovementsRequest, opts ...grpc.CallOption) (Ledger_WatchMovementsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Ledger_ServiceDesc.Streams[0], "/yamex.ledger.v1.Ledger/WatchMovements", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &ledgerWatchMovementsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Ledger_WatchMovementsClient interface {
+	Recv() (*Movement, error)
+	grpc.ClientStream
+}
+
+type ledgerWatchMovementsClient struct {
+	grpc.ClientStream
+}
+
+func (x *ledgerWatchMovementsClient) Recv() (*Movement, error) {
+	m := new(Movement)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// LedgerServer is the server API for Ledger service.
+// All implementations must embed UnimplementedLedgerServer
+// for forward compatibility
+type LedgerServer interface {
+	// Grant issues the granter's grant to the receiver. Needs the admin scope.
+	Grant(context.Context, *GrantRequest) (*GrantResponse, error)
+	// Transfer moves currency between two users. Needs the transfer scope.
+	Transfer(context.Context, *TransferRequest) (*TransferResponse, error)
+	GetBalance(context.Context, *GetBalanceRequest) (*GetBalanceResponse, error)
+	// ListMovements pages through a user's movements, newest first.
+	ListMovements(context.Context, *ListMovementsRequest) (*ListMovementsResponse, error)
+	// WatchMovements streams a user's movements, oldest first, as they're made.
+	WatchMovements(*WatchMovementsRequest, Ledger_WatchMovementsServer) error
+	mustEmbedUnimplementedLedgerServer()
+}
+
+// UnimplementedLedgerServer must be embedded to have forward compatible implementations.
+type UnimplementedLedgerServer struct {
+}
+
+func (UnimplementedLedgerServer) Grant(context.Context, *GrantRequest) (*GrantResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Grant not implemented")
+}
+func (UnimplementedLedgerServer) Transfer(context.Context, *TransferRequest) (*TransferResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Transfer not implemented")
+}
+func (UnimplementedLedgerServer) GetBalance(context.Context, *GetBalanceRequest) (*GetBalanceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBalance not implemented")
+}
+func (UnimplementedLedgerServer) ListMovements(context.Context, *ListMovementsRequest) (*ListMovementsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListMovements not implemented")
+}
+func (UnimplementedLedgerServer) WatchMovements(*WatchMovementsRequest, Ledger_WatchMovementsServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchMovements not implemented")
+}
+func (UnimplementedLedgerServer) mustEmbedUnimplementedLedgerServer() {}
+
+// UnsafeLedgerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to LedgerServer will
+// result in compilation errors.
+type UnsafeLedgerServer interface {
+	mustEmbedUnimplementedLedgerServer()
+}
+
+func RegisterLedgerServer(s grpc.ServiceRegistrar, srv LedgerServer) {
+	s.RegisterService(&Ledger_ServiceDesc, srv)
+}
+
+func _Ledger_Grant_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GrantRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LedgerServer).Grant(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/yamex.ledger.v1.Ledger/Grant",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LedgerServer).Grant(ctx, req.(*GrantRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ledger_Transfer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TransferRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LedgerServer).Transfer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/yamex.ledger.v1.Ledger/Transfer",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LedgerServer).Transfer(ctx, req.(*TransferRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ledger_GetBalance_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetBalanceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LedgerServer).GetBalance(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/yamex.ledger.v1.Ledger/GetBalance",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LedgerServer).GetBalance(ctx, req.(*GetBalanceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ledger_ListMovements_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListMovementsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LedgerServer).ListMovements(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/yamex.ledger.v1.Ledger/ListMovements",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LedgerServer).ListMovements(ctx, req.(*ListMovementsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ledger_WatchMovements_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchMovementsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(LedgerServer).WatchMovements(m, &ledgerWatchMovementsServer{stream})
+}
+
+type Ledger_WatchMovementsServer interface {
+	Send(*Movement) error
+	grpc.ServerStream
+}
+
+type ledgerWatchMovementsServer struct {
+	grpc.ServerStream
+}
+
+func (x *ledgerWatchMovementsServer) Send(m *Movement) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Ledger_ServiceDesc is the grpc.ServiceDesc for Ledger service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Ledger_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "yamex.ledger.v1.Ledger",
+	HandlerType: (*LedgerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Grant",
+			Handler:    _Ledger_Grant_Handler,
+		},
+		{
+			MethodName: "Transfer",
+			Handler:    _Ledger_Transfer_Handler,
+		},
+		{
+			MethodName: "GetBalance",
+			Handler:    _Ledger_GetBalance_Handler,
+		},
+		{
+			MethodName: "ListMovements",
+			Handler:    _Ledger_ListMovements_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchMovements",
+			Handler:       _Ledger_WatchMovements_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "ledger.proto",
+}
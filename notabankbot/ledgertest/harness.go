@@ -0,0 +1,108 @@
+// Package ledgertest runs the gRPC ledger service in-process over bufconn,
+// backed by the in-memory repository, so clients of the service can be
+// exercised without a network or Postgres.
+package ledgertest
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/yammine/yamex-go/notabankbot/adapter"
+	"github.com/yammine/yamex-go/notabankbot/app"
+	"github.com/yammine/yamex-go/notabankbot/ledgerpb"
+	"github.com/yammine/yamex-go/notabankbot/port"
+)
+
+const bufferSize = 1 << 20
+
+// Harness is a running ledger service and a client connected to it.
+type Harness struct {
+	// App is the application behind the service, for arranging state.
+	App    *app.Application
+	Repo   *adapter.MemoryRepository
+	Keys   *adapter.APIKeyMemory
+	Client ledgerpb.LedgerClient
+
+	ledger   *port.LedgerServer
+	server   *grpc.Server
+	listener *bufconn.Listener
+	conn     *grpc.ClientConn
+}
+
+// Start serves the ledger service. Calls need a key from NewKey, passed with
+// WithKey.
+func Start() (*Harness, error) {
+	h := &Harness{
+		Repo:     adapter.NewMemoryRepository(),
+		Keys:     adapter.NewAPIKeyMemory(),
+		listener: bufconn.Listen(bufferSize),
+	}
+	h.App = app.NewApplication(h.Repo, nil)
+	h.ledger = port.NewLedgerServer(h.App, h.Keys)
+	h.server = port.NewGRPCServer(h.ledger, nil)
+	go h.server.Serve(h.listener)
+
+	conn, err := grpc.Dial("bufconn",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return h.listener.Dial()
+		}),
+		grpc.WithInsecure(),
+	)
+	if err != nil {
+		h.server.Stop()
+		return nil, fmt.Errorf("dialing bufconn: %w", err)
+	}
+	h.conn = conn
+	h.Client = ledgerpb.NewLedgerClient(conn)
+	return h, nil
+}
+
+// NewKey creates an API key for the workspace, returning it as presented by
+// clients.
+func (h *Harness) NewKey(workspaceID string, scope port.APIScope) (string, error) {
+	return h.NewUserKey(workspaceID, "", scope)
+}
+
+// NewUserKey is NewKey for a key bound to a user, the only one whose funds it
+// may transfer without the admin scope.
+func (h *Harness) NewUserKey(workspaceID, userID string, scope port.APIScope) (string, error) {
+	presented, key, err := port.NewAPIKey(workspaceID, "ledgertest", scope)
+	if err != nil {
+		return "", err
+	}
+	key.UserID = userID
+	if err := h.Keys.SaveAPIKey(context.Background(), key); err != nil {
+		return "", err
+	}
+	return presented, nil
+}
+
+// Close ends watch streams and stops the server.
+func (h *Harness) Close() {
+	h.ledger.Close()
+	h.conn.Close()
+	h.server.Stop()
+}
+
+// WithKey authenticates every call made through the option with the key.
+func WithKey(key string) grpc.CallOption {
+	return grpc.PerRPCCredentials(bearerToken(key))
+}
+
+type bearerToken string
+
+func (t bearerToken) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+// bufconn has no transport security to demand.
+func (t bearerToken) RequireTransportSecurity() bool {
+	return false
+}
+
+var _ credentials.PerRPCCredentials = bearerToken("")
@@ -0,0 +1,188 @@
+// Package metrics holds the Prometheus collectors shared by the app, port and
+// adapter layers. They register with the default registry, served at /metrics.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "yamex"
+
+// HTTP
+
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route and status code.",
+	}, []string{"route", "code"})
+
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route"})
+)
+
+// Bot commands
+
+var (
+	Commands = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "commands_total",
+		Help:      "Bot commands processed, by command.",
+	}, []string{"command"})
+
+	CommandDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "command_duration_seconds",
+		Help:      "Time taken to process a bot command, by command.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"command"})
+
+	Interactions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "interactions_total",
+		Help:      "Slack interactions processed, by type.",
+	}, []string{"type"})
+
+	RateLimitDecisions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_decisions_total",
+		Help:      "Rate limit checks by decision: allowed, throttled or error. Throttled requests carry the scope that was exceeded.",
+	}, []string{"decision", "scope"})
+)
+
+// Ledger
+
+var (
+	LedgerTransactionDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ledger_transaction_duration_seconds",
+		Help:      "Ledger database transaction latency, including retries, by operation and outcome.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation", "outcome"})
+
+	LedgerTransactionRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ledger_transaction_retries_total",
+		Help:      "Ledger transactions retried after a deadlock or serialization failure, by operation.",
+	}, []string{"operation"})
+
+	// Anyone can name a new currency, so ledger metrics are not labelled by
+	// currency.
+	Grants = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "grants_total",
+		Help:      "Successful grants.",
+	})
+
+	Transfers = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "transfers_total",
+		Help:      "Successful transfers.",
+	})
+
+	TransferredAmount = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "transferred_amount_total",
+		Help:      "Sum of transferred amounts, across currencies.",
+	})
+)
+
+// Reconciliation
+
+var (
+	ReconciliationRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconciliation_runs_total",
+		Help:      "Reconciliation runs by outcome: healthy, discrepancies or failed.",
+	}, []string{"outcome"})
+
+	ReconciliationLastRun = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "reconciliation_last_run_timestamp_seconds",
+		Help:      "When the last reconciliation started.",
+	})
+
+	ReconciliationDuration = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "reconciliation_last_duration_seconds",
+		Help:      "How long the last reconciliation took.",
+	})
+
+	ReconciliationAccountsChecked = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "reconciliation_accounts_checked",
+		Help:      "Accounts checked by the last reconciliation.",
+	})
+
+	ReconciliationDiscrepancies = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "reconciliation_account_discrepancies",
+		Help:      "Accounts whose balance disagreed with their movements in the last reconciliation.",
+	})
+
+	ReconciliationUnbalancedCurrencies = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "reconciliation_unbalanced_currencies",
+		Help:      "Currencies whose circulating and issued totals differed in the last reconciliation.",
+	})
+)
+
+// Outbox
+
+var (
+	OutboxMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "outbox_messages_total",
+		Help:      "Outbox delivery attempts by outcome: delivered, retried, failed or enqueue_error.",
+	}, []string{"outcome"})
+
+	OutboxPending = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "outbox_pending_messages",
+		Help:      "Messages waiting to be delivered, including ones waiting for a retry.",
+	})
+)
+
+// Webhooks
+
+var (
+	WebhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_deliveries_total",
+		Help:      "Webhook delivery attempts by outcome: delivered, retried or failed.",
+	}, []string{"outcome"})
+
+	WebhookPending = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "webhook_pending_deliveries",
+		Help:      "Webhook deliveries waiting to be sent, including ones waiting for a retry.",
+	})
+)
+
+// Slack
+
+var (
+	SlackAPIRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "slack_api_requests_total",
+		Help:      "Calls to the Slack Web API and response URLs by method, status code and Slack error, which is empty on success.",
+	}, []string{"method", "code", "error"})
+
+	SlackAPIDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "slack_api_request_duration_seconds",
+		Help:      "Slack Web API latency by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+
+	CredentialCacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "credential_cache_lookups_total",
+		Help:      "Slack credential cache lookups by result: hit, miss or missing (a cached unknown workspace).",
+	}, []string{"result"})
+)
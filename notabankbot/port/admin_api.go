@@ -0,0 +1,218 @@
+package port
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/shopspring/decimal"
+
+	"github.com/yammine/yamex-go/notabankbot/app"
+	"github.com/yammine/yamex-go/notabankbot/tracing"
+)
+
+// adminOpenAPI is the admin API's contract. Handlers, and the client package,
+// are written to it rather than the other way round.
+//
+//go:embed admin_api.openapi.json
+var adminOpenAPI []byte
+
+// SlackKeyRotator re-encrypts stored Slack tokens with the active key.
+type SlackKeyRotator interface {
+	RotateKeys(ctx context.Context) (int, error)
+}
+
+// AdminAPI serves /admin/api, the operations that used to need SQL. Requests
+// must carry the token as a bearer token.
+type AdminAPI struct {
+	token   string
+	app     *app.Application
+	rotator SlackKeyRotator
+}
+
+func NewAdminAPI(token string, app *app.Application, rotator SlackKeyRotator) *AdminAPI {
+	return &AdminAPI{token: token, app: app, rotator: rotator}
+}
+
+// Register adds the admin API's routes, relative to router.
+func (a *AdminAPI) Register(router *mux.Router) {
+	router.HandleFunc("/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(adminOpenAPI)
+	}).Methods(http.MethodGet)
+	router.HandleFunc("/users/{user_id}", a.authorized(a.getUser)).Methods(http.MethodGet)
+	router.HandleFunc("/users/{user_id}/admin", a.authorized(a.setAdmin)).Methods(http.MethodPut)
+	router.HandleFunc("/adjustments", a.authorized(a.adjustBalance)).Methods(http.MethodPost)
+	router.HandleFunc("/feedback", a.authorized(a.listFeedback)).Methods(http.MethodGet)
+	router.HandleFunc("/slack-credentials/rotate", a.authorized(a.rotateSlackCredentials)).Methods(http.MethodPost)
+}
+
+func (a *AdminAPI) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !hasBearerToken(r, a.token) {
+			writeAPIError(w, http.StatusUnauthorized, "unauthorized", "missing or wrong admin token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+type adminUser struct {
+	UserID   string       `json:"user_id"`
+	Admin    bool         `json:"admin"`
+	Accounts []apiAccount `json:"accounts"`
+}
+
+func (a *AdminAPI) getUser(w http.ResponseWriter, r *http.Request) {
+	userID := mux.Vars(r)["user_id"]
+	if !slackUserIDPattern.MatchString(userID) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "user_id must be a Slack user ID")
+		return
+	}
+	a.writeUser(w, r, userID)
+}
+
+type setAdminRequest struct {
+	Admin *bool `json:"admin"`
+}
+
+func (a *AdminAPI) setAdmin(w http.ResponseWriter, r *http.Request) {
+	userID := mux.Vars(r)["user_id"]
+	var req setAdminRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if !slackUserIDPattern.MatchString(userID) || req.Admin == nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "user_id must be a Slack user ID and admin is required")
+		return
+	}
+
+	user, err := a.app.SetAdmin(r.Context(), userID, *req.Admin)
+	if err != nil {
+		writeAppError(w, r, err)
+		return
+	}
+	tracing.Logger(r.Context()).Info().Str("user_id", userID).Bool("admin", user.Admin).Msg("Admin rights changed")
+	a.writeUser(w, r, userID)
+}
+
+// writeUser answers with the user and their balances.
+func (a *AdminAPI) writeUser(w http.ResponseWriter, r *http.Request, userID string) {
+	user, err := a.app.GetUser(r.Context(), userID)
+	if err != nil {
+		writeAppError(w, r, err)
+		return
+	}
+	accounts, err := a.app.GetBalance(r.Context(), &app.GetBalanceInput{UserID: userID})
+	if err != nil {
+		writeAppError(w, r, err)
+		return
+	}
+
+	response := adminUser{UserID: userID, Admin: user.Admin, Accounts: make([]apiAccount, len(accounts))}
+	for i, account := range accounts {
+		response.Accounts[i] = apiAccount{Currency: account.Currency, Balance: account.Balance}
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+type adjustmentRequest struct {
+	UserID   string          `json:"user_id"`
+	Currency string          `json:"currency"`
+	Amount   decimal.Decimal `json:"amount"`
+	Reason   string          `json:"reason"`
+}
+
+func (a *AdminAPI) adjustBalance(w http.ResponseWriter, r *http.Request) {
+	var req adjustmentRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	switch {
+	case !slackUserIDPattern.MatchString(req.UserID):
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "user_id must be a Slack user ID")
+		return
+	case !currencyPattern.MatchString(req.Currency):
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "currency must be letters, optionally starting with $")
+		return
+	}
+
+	entry, err := a.app.AdjustBalance(r.Context(), &app.AdjustBalanceInput{
+		UserID:   req.UserID,
+		Currency: req.Currency,
+		Amount:   req.Amount,
+		Reason:   req.Reason,
+	})
+	if err != nil {
+		writeAppError(w, r, err)
+		return
+	}
+	tracing.Logger(r.Context()).Info().
+		Str("user_id", req.UserID).
+		Str("currency", req.Currency).
+		Str("amount", req.Amount.String()).
+		Uint("journal_entry_id", entry.ID).
+		Msg("Balance adjusted")
+	writeJSON(w, http.StatusCreated, journalEntryResponse{JournalEntryID: entry.ID, CreatedAt: entry.CreatedAt})
+}
+
+type adminFeedback struct {
+	ID        uint      `json:"id"`
+	UserID    string    `json:"user_id"`
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type feedbackResponse struct {
+	Feedback   []adminFeedback `json:"feedback"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+func (a *AdminAPI) listFeedback(w http.ResponseWriter, r *http.Request) {
+	limit, cursor, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+	var before uint
+	if cursor != "" {
+		id, err := strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid_cursor", "cursor is not valid")
+			return
+		}
+		before = uint(id)
+	}
+
+	feedback, err := a.app.ListFeedback(r.Context(), before, limit)
+	if err != nil {
+		writeAppError(w, r, err)
+		return
+	}
+
+	response := feedbackResponse{Feedback: make([]adminFeedback, len(feedback))}
+	for i, f := range feedback {
+		response.Feedback[i] = adminFeedback{ID: f.ID, UserID: f.SlackUserID, Text: f.Text, CreatedAt: f.CreatedAt}
+	}
+	if len(feedback) == limit {
+		response.NextCursor = encodeCursor(strconv.FormatUint(uint64(feedback[len(feedback)-1].ID), 10))
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+type rotationResponse struct {
+	Rotated int `json:"rotated"`
+}
+
+func (a *AdminAPI) rotateSlackCredentials(w http.ResponseWriter, r *http.Request) {
+	rotated, err := a.rotator.RotateKeys(r.Context())
+	if err != nil {
+		writeAppError(w, r, fmt.Errorf("rotated %d tokens before failing: %w", rotated, err))
+		return
+	}
+	tracing.Logger(r.Context()).Info().Int("rotated", rotated).Msg("Slack tokens re-encrypted")
+	writeJSON(w, http.StatusOK, rotationResponse{Rotated: rotated})
+}
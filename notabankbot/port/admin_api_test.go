@@ -0,0 +1,342 @@
+package port_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/shopspring/decimal"
+
+	"github.com/yammine/yamex-go/notabankbot/client"
+	"github.com/yammine/yamex-go/notabankbot/port"
+)
+
+const adminToken = "admin-token"
+
+// rotator pretends to re-encrypt a fixed number of tokens.
+type rotator struct {
+	rotated int
+	err     error
+}
+
+func (r rotator) RotateKeys(ctx context.Context) (int, error) {
+	return r.rotated, r.err
+}
+
+// specValidator checks every request and response against the admin API's
+// OpenAPI document, and which operations were exercised.
+type specValidator struct {
+	t      *testing.T
+	router routers.Router
+	next   http.Handler
+
+	mu         sync.Mutex
+	operations map[string]bool
+}
+
+func newSpecValidator(t *testing.T, next http.Handler) *specValidator {
+	t.Helper()
+	ctx := context.Background()
+	doc, err := openapi3.NewLoader().LoadFromData(port.AdminOpenAPI)
+	if err != nil {
+		t.Fatalf("loading spec: %v", err)
+	}
+	if err := doc.Validate(ctx); err != nil {
+		t.Fatalf("invalid spec: %v", err)
+	}
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	operations := map[string]bool{}
+	for _, path := range doc.Paths {
+		for _, op := range path.Operations() {
+			operations[op.OperationID] = false
+		}
+	}
+	return &specValidator{t: t, router: router, next: next, operations: operations}
+}
+
+func (v *specValidator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route, pathParams, err := v.router.FindRoute(r)
+	if err != nil {
+		v.t.Errorf("%s %s isn't in the spec: %v", r.Method, r.URL.Path, err)
+		v.next.ServeHTTP(w, r)
+		return
+	}
+	v.mu.Lock()
+	v.operations[route.Operation.OperationID] = true
+	v.mu.Unlock()
+
+	ctx := r.Context()
+	request := &openapi3filter.RequestValidationInput{
+		Request:    r,
+		PathParams: pathParams,
+		Route:      route,
+		Options: &openapi3filter.Options{
+			AuthenticationFunc: func(ctx context.Context, in *openapi3filter.AuthenticationInput) error {
+				if in.RequestValidationInput.Request.Header.Get("Authorization") != "Bearer "+adminToken {
+					return errors.New("missing or wrong admin token")
+				}
+				return nil
+			},
+		},
+	}
+	requestErr := openapi3filter.ValidateRequest(ctx, request)
+
+	recorder := httptest.NewRecorder()
+	v.next.ServeHTTP(recorder, r)
+	res := recorder.Result()
+	body := recorder.Body.Bytes()
+
+	// What the spec rejects, the server must reject too.
+	if requestErr != nil && res.StatusCode != http.StatusBadRequest && res.StatusCode != http.StatusUnauthorized {
+		v.t.Errorf("%s %s: spec rejects the request (%v) but the server answered %d", r.Method, r.URL, requestErr, res.StatusCode)
+	}
+	err = openapi3filter.ValidateResponse(ctx, &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: request,
+		Status:                 res.StatusCode,
+		Header:                 res.Header,
+		Body:                   ioutil.NopCloser(bytes.NewReader(body)),
+	})
+	if err != nil {
+		v.t.Errorf("%s %s: response %d doesn't match the spec: %v\n%s", r.Method, r.URL, res.StatusCode, err, body)
+	}
+
+	for k, values := range res.Header {
+		w.Header()[k] = values
+	}
+	w.WriteHeader(res.StatusCode)
+	w.Write(body)
+}
+
+// unexercised lists the spec's operations no request went to.
+func (v *specValidator) unexercised() []string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	var missing []string
+	for id, exercised := range v.operations {
+		if !exercised {
+			missing = append(missing, id)
+		}
+	}
+	return missing
+}
+
+type adminAPI struct {
+	*testBot
+	client    *client.Client
+	validator *specValidator
+	url       string
+}
+
+// startAdminAPI serves the admin API through the bot's router, checking all
+// traffic against the spec.
+func startAdminAPI(t *testing.T, rotation rotator) *adminAPI {
+	t.Helper()
+	bot := startBot(t, testBotUser, func(h *port.Handlers) {
+		h.AdminAPI = port.NewAdminAPI(adminToken, h.App, rotation)
+	})
+
+	validator := newSpecValidator(t, bot.router)
+	server := httptest.NewServer(validator)
+	t.Cleanup(server.Close)
+	return &adminAPI{
+		testBot:   bot,
+		client:    client.NewClient(server.URL+"/admin/api", adminToken),
+		validator: validator,
+		url:       server.URL + "/admin/api",
+	}
+}
+
+func TestAdminAPI(t *testing.T) {
+	api := startAdminAPI(t, rotator{rotated: 3})
+	ctx := context.Background()
+
+	t.Run("spec", func(t *testing.T) {
+		res, err := http.Get(api.url + "/openapi.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		served, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(served, port.AdminOpenAPI) {
+			t.Error("served spec differs from the embedded one")
+		}
+	})
+
+	t.Run("users", func(t *testing.T) {
+		user, err := api.client.GetUser(ctx, testAlice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.UserID != testAlice || user.Admin || len(user.Accounts) != 0 {
+			t.Errorf("new user = %+v, want alice without rights or accounts", user)
+		}
+
+		user, err = api.client.SetAdmin(ctx, testAlice, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !user.Admin {
+			t.Error("SetAdmin(true) didn't make alice an admin")
+		}
+		user, err = api.client.GetUser(ctx, testAlice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !user.Admin {
+			t.Error("alice's admin rights weren't saved")
+		}
+	})
+
+	t.Run("adjustments", func(t *testing.T) {
+		adjustment, err := api.client.AdjustBalance(ctx, &client.AdjustmentRequest{
+			UserID:   testBob,
+			Currency: "$coffee",
+			Amount:   decimal.NewFromInt(12),
+			Reason:   "lost in migration",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if adjustment.JournalEntryID == 0 || adjustment.CreatedAt.IsZero() {
+			t.Errorf("adjustment = %+v, want its journal entry", adjustment)
+		}
+		if _, err := api.client.AdjustBalance(ctx, &client.AdjustmentRequest{
+			UserID:   testBob,
+			Currency: "$coffee",
+			Amount:   decimal.NewFromInt(-2),
+			Reason:   "counted twice",
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		user, err := api.client.GetUser(ctx, testBob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(user.Accounts) != 1 || user.Accounts[0].Currency != "$coffee" || !user.Accounts[0].Balance.Equal(decimal.NewFromInt(10)) {
+			t.Errorf("bob's accounts = %+v, want 10 $coffee", user.Accounts)
+		}
+	})
+
+	t.Run("feedback", func(t *testing.T) {
+		for _, text := range []string{"first", "second", "third"} {
+			if err := api.app.SaveFeedback(ctx, testAlice, text); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var got []string
+		cursor := ""
+		for pages := 0; ; pages++ {
+			if pages > 3 {
+				t.Fatal("feedback pages never ended")
+			}
+			page, err := api.client.ListFeedback(ctx, cursor, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range page.Feedback {
+				if f.UserID != testAlice {
+					t.Errorf("feedback %d from %q, want alice", f.ID, f.UserID)
+				}
+				got = append(got, f.Text)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			cursor = page.NextCursor
+		}
+		if len(got) != 3 || got[0] != "third" || got[1] != "second" || got[2] != "first" {
+			t.Errorf("feedback = %q, want newest first", got)
+		}
+	})
+
+	t.Run("rotate", func(t *testing.T) {
+		rotated, err := api.client.RotateSlackCredentials(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rotated != 3 {
+			t.Errorf("rotated = %d, want 3", rotated)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		cases := []struct {
+			name string
+			call func(c *client.Client) error
+			want error
+		}{
+			{"wrong token", func(c *client.Client) error {
+				_, err := client.NewClient(api.url, "wrong").GetUser(ctx, testAlice)
+				return err
+			}, client.ErrUnauthorized},
+			{"invalid user ID", func(c *client.Client) error {
+				_, err := c.GetUser(ctx, "alice")
+				return err
+			}, client.ErrInvalidRequest},
+			{"invalid currency", func(c *client.Client) error {
+				_, err := c.AdjustBalance(ctx, &client.AdjustmentRequest{UserID: testBob, Currency: "co ffee", Amount: decimal.NewFromInt(1), Reason: "typo"})
+				return err
+			}, client.ErrInvalidRequest},
+			{"missing reason", func(c *client.Client) error {
+				_, err := c.AdjustBalance(ctx, &client.AdjustmentRequest{UserID: testBob, Currency: "$coffee", Amount: decimal.NewFromInt(1)})
+				return err
+			}, client.ErrReasonRequired},
+			{"zero adjustment", func(c *client.Client) error {
+				_, err := c.AdjustBalance(ctx, &client.AdjustmentRequest{UserID: testBob, Currency: "$coffee", Amount: decimal.Zero, Reason: "nothing"})
+				return err
+			}, client.ErrZeroAdjustment},
+			{"overdrawn", func(c *client.Client) error {
+				_, err := c.AdjustBalance(ctx, &client.AdjustmentRequest{UserID: testBob, Currency: "$coffee", Amount: decimal.NewFromInt(-100), Reason: "too much"})
+				return err
+			}, client.ErrInsufficientBalance},
+			{"invalid cursor", func(c *client.Client) error {
+				_, err := c.ListFeedback(ctx, "not a cursor", 0)
+				return err
+			}, client.ErrInvalidRequest},
+		}
+		for _, tc := range cases {
+			t.Run(tc.name, func(t *testing.T) {
+				err := tc.call(api.client)
+				if !errors.Is(err, tc.want) {
+					t.Errorf("err = %v, want %v", err, tc.want)
+				}
+				var apiErr *client.Error
+				if !errors.As(err, &apiErr) {
+					t.Errorf("err = %T, want *client.Error", err)
+				}
+			})
+		}
+	})
+
+	if missing := api.validator.unexercised(); len(missing) > 0 {
+		t.Errorf("operations not exercised: %v", missing)
+	}
+}
+
+func TestAdminAPIRotationFailure(t *testing.T) {
+	api := startAdminAPI(t, rotator{rotated: 1, err: errors.New("key unavailable")})
+
+	_, err := api.client.RotateSlackCredentials(context.Background())
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusInternalServerError || apiErr.Code != "internal" {
+		t.Errorf("err = %v, want a 500 internal error", err)
+	}
+}
@@ -0,0 +1,103 @@
+package port
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/yammine/yamex-go"
+	"github.com/yammine/yamex-go/notabankbot/app"
+	"github.com/yammine/yamex-go/notabankbot/tracing"
+)
+
+// AdminDebug serves /admin/debug, a snapshot of the running server for
+// operators. It's only served with a token, as config and queues leak details.
+type AdminDebug struct {
+	token     string
+	app       *app.Application
+	workers   *Workers
+	settings  func() map[string]interface{}
+	startedAt time.Time
+}
+
+// NewAdminDebug reports settings, redacted, alongside the state of the app &
+// its workers. Requests must carry the token as a bearer token.
+func NewAdminDebug(token string, app *app.Application, workers *Workers, settings func() map[string]interface{}) *AdminDebug {
+	return &AdminDebug{
+		token:     token,
+		app:       app,
+		workers:   workers,
+		settings:  settings,
+		startedAt: time.Now(),
+	}
+}
+
+type buildInfo struct {
+	Path      string `json:"path"`
+	Version   string `json:"version"`
+	GoVersion string `json:"go_version"`
+}
+
+type debugResponse struct {
+	Build      buildInfo              `json:"build"`
+	StartedAt  time.Time              `json:"started_at"`
+	Uptime     string                 `json:"uptime"`
+	Goroutines int                    `json:"goroutines"`
+	Config     map[string]interface{} `json:"config"`
+	Workers    []WorkerStatus         `json:"workers"`
+	Queues     map[string]int64       `json:"queues"`
+	Errors     map[string]string      `json:"errors,omitempty"`
+}
+
+func (a *AdminDebug) Handler() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !a.authorized(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		response := debugResponse{
+			Build:      readBuildInfo(),
+			StartedAt:  a.startedAt,
+			Uptime:     time.Since(a.startedAt).Round(time.Second).String(),
+			Goroutines: runtime.NumGoroutine(),
+			Config:     yamex.RedactSettings(a.settings()),
+			Workers:    a.workers.Status(),
+			Queues:     map[string]int64{},
+			Errors:     map[string]string{},
+		}
+		if pending, err := a.app.PendingMessages(r.Context()); err != nil {
+			tracing.Logger(r.Context()).Error().Err(err).Msg("Failed to count pending outbox messages")
+			response.Errors["outbox"] = err.Error()
+		} else {
+			response.Queues["outbox"] = pending
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+func (a *AdminDebug) authorized(r *http.Request) bool {
+	return hasBearerToken(r, a.token)
+}
+
+// hasBearerToken checks the request's bearer token against token, which must
+// not be empty.
+func hasBearerToken(r *http.Request, token string) bool {
+	presented := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token != "" && subtle.ConstantTimeCompare([]byte(presented), []byte(token)) == 1
+}
+
+func readBuildInfo() buildInfo {
+	build := buildInfo{GoVersion: runtime.Version()}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		build.Path = info.Main.Path
+		build.Version = info.Main.Version
+	}
+	return build
+}
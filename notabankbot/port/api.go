@@ -0,0 +1,423 @@
+package port
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/shopspring/decimal"
+
+	"github.com/yammine/yamex-go/notabankbot/app"
+	"github.com/yammine/yamex-go/notabankbot/domain"
+	"github.com/yammine/yamex-go/notabankbot/tracing"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+	maxAPIBodyBytes = 64 << 10
+)
+
+var (
+	currencyPattern    = regexp.MustCompile(`^[$A-Za-z]+$`)
+	slackUserIDPattern = regexp.MustCompile(`^[UW][A-Z0-9]+$`)
+)
+
+// API serves the JSON HTTP API under /api/v1, for tools that work with the
+// ledger without going through Slack. Every request is made with an API key,
+// which limits it to one workspace.
+type API struct {
+	app         *app.Application
+	keys        APIKeyStore
+	idempotency IdempotencyStore
+}
+
+func NewAPI(app *app.Application, keys APIKeyStore, idempotency IdempotencyStore) *API {
+	return &API{app: app, keys: keys, idempotency: idempotency}
+}
+
+type apiHandler func(w http.ResponseWriter, r *http.Request, key *APIKey)
+
+// Register adds the API's routes, relative to router.
+func (a *API) Register(router *mux.Router) {
+	router.HandleFunc("/accounts", a.authorized(ScopeRead, a.listAccounts)).Methods(http.MethodGet)
+	router.HandleFunc("/movements", a.authorized(ScopeRead, a.listMovements)).Methods(http.MethodGet)
+	router.HandleFunc("/currencies", a.authorized(ScopeRead, a.listCurrencies)).Methods(http.MethodGet)
+	router.HandleFunc("/transfers", a.authorized(ScopeTransfer, idempotent(a.idempotency, a.createTransfer))).Methods(http.MethodPost)
+	router.HandleFunc("/grants", a.authorized(ScopeAdmin, idempotent(a.idempotency, a.createGrant))).Methods(http.MethodPost)
+}
+
+// authorized checks the request's bearer API key has at least scope.
+func (a *API) authorized(scope APIScope, next apiHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		presented := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		key, err := authorizeAPIKey(ctx, a.keys, presented, scope)
+		switch {
+		case errors.Is(err, ErrUnknownAPIKey):
+			writeAPIError(w, http.StatusUnauthorized, "unauthorized", "missing, unknown or revoked API key")
+			return
+		case errors.Is(err, ErrAPIScopeDenied):
+			writeAPIError(w, http.StatusForbidden, "forbidden", fmt.Sprintf("this API key needs the %s scope", scope))
+			return
+		case err != nil:
+			tracing.Logger(ctx).Error().Err(err).Msg("Failed to authenticate API key")
+			writeAPIError(w, http.StatusInternalServerError, "internal", "internal error")
+			return
+		}
+
+		next(w, r, key)
+	}
+}
+
+type apiAccount struct {
+	Currency string          `json:"currency"`
+	Balance  decimal.Decimal `json:"balance"`
+}
+
+type accountsResponse struct {
+	UserID   string       `json:"user_id"`
+	AsOf     string       `json:"as_of,omitempty"`
+	Accounts []apiAccount `json:"accounts"`
+}
+
+func (a *API) listAccounts(w http.ResponseWriter, r *http.Request, key *APIKey) {
+	query := r.URL.Query()
+	userID := query.Get("user_id")
+	if !slackUserIDPattern.MatchString(userID) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "user_id must be a Slack user ID")
+		return
+	}
+
+	var accounts []*domain.Account
+	var err error
+	asOf := query.Get("as_of")
+	if asOf == "" {
+		accounts, err = a.app.GetBalance(r.Context(), &app.GetBalanceInput{UserID: userID, WorkspaceID: key.WorkspaceID})
+	} else {
+		day, parseErr := time.Parse(domain.SnapshotDateLayout, asOf)
+		if parseErr != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid_request", "as_of must look like 2021-12-31")
+			return
+		}
+		accounts, err = a.app.GetBalanceAsOf(r.Context(), &app.GetBalanceAsOfInput{UserID: userID, WorkspaceID: key.WorkspaceID, Day: day})
+	}
+	if err != nil {
+		writeAppError(w, r, err)
+		return
+	}
+
+	response := accountsResponse{UserID: userID, AsOf: asOf, Accounts: make([]apiAccount, len(accounts))}
+	for i, account := range accounts {
+		response.Accounts[i] = apiAccount{Currency: account.Currency, Balance: account.Balance}
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+type apiMovement struct {
+	ID             uint            `json:"id"`
+	CreatedAt      time.Time       `json:"created_at"`
+	JournalEntryID uint            `json:"journal_entry_id"`
+	Currency       string          `json:"currency"`
+	Amount         decimal.Decimal `json:"amount"`
+	Reason         string          `json:"reason"`
+}
+
+type movementsResponse struct {
+	Movements  []apiMovement `json:"movements"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+func (a *API) listMovements(w http.ResponseWriter, r *http.Request, key *APIKey) {
+	query := r.URL.Query()
+	userID := query.Get("user_id")
+	if !slackUserIDPattern.MatchString(userID) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "user_id must be a Slack user ID")
+		return
+	}
+	currency := query.Get("currency")
+	if currency != "" && !currencyPattern.MatchString(currency) {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "currency must be letters, optionally starting with $")
+		return
+	}
+	limit, cursor, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+	var before uint
+	if cursor != "" {
+		id, err := strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid_cursor", "cursor is not valid")
+			return
+		}
+		before = uint(id)
+	}
+
+	movements, err := a.app.ListMovements(r.Context(), &app.ListMovementsInput{
+		WorkspaceID: key.WorkspaceID,
+		UserID:      userID,
+		Currency:    currency,
+		BeforeID:    before,
+		Limit:       limit,
+	})
+	if err != nil {
+		writeAppError(w, r, err)
+		return
+	}
+
+	response := movementsResponse{Movements: make([]apiMovement, len(movements))}
+	for i, m := range movements {
+		response.Movements[i] = apiMovement{
+			ID:             m.ID,
+			CreatedAt:      m.CreatedAt,
+			JournalEntryID: m.JournalEntryID,
+			Currency:       m.Currency,
+			Amount:         m.Amount,
+			Reason:         m.Reason,
+		}
+	}
+	if len(movements) == limit {
+		response.NextCursor = encodeCursor(strconv.FormatUint(uint64(movements[len(movements)-1].ID), 10))
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+type apiCurrency struct {
+	Code        string          `json:"code"`
+	Circulating decimal.Decimal `json:"circulating"`
+}
+
+type currenciesResponse struct {
+	Currencies []apiCurrency `json:"currencies"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+func (a *API) listCurrencies(w http.ResponseWriter, r *http.Request, key *APIKey) {
+	limit, after, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	currencies, err := a.app.ListCurrencies(r.Context(), key.WorkspaceID, after, limit)
+	if err != nil {
+		writeAppError(w, r, err)
+		return
+	}
+
+	response := currenciesResponse{Currencies: make([]apiCurrency, len(currencies))}
+	for i, c := range currencies {
+		response.Currencies[i] = apiCurrency{Code: c.Code, Circulating: c.Circulating}
+	}
+	if len(currencies) == limit {
+		response.NextCursor = encodeCursor(currencies[len(currencies)-1].Code)
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+type transferRequest struct {
+	SenderID   string          `json:"sender_id"`
+	ReceiverID string          `json:"receiver_id"`
+	Currency   string          `json:"currency"`
+	Amount     decimal.Decimal `json:"amount"`
+	Note       string          `json:"note"`
+}
+
+type journalEntryResponse struct {
+	JournalEntryID uint      `json:"journal_entry_id"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+func (a *API) createTransfer(w http.ResponseWriter, r *http.Request, key *APIKey) {
+	var req transferRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	switch {
+	case !slackUserIDPattern.MatchString(req.SenderID) || !slackUserIDPattern.MatchString(req.ReceiverID):
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "sender_id and receiver_id must be Slack user IDs")
+		return
+	case !currencyPattern.MatchString(req.Currency):
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "currency must be letters, optionally starting with $")
+		return
+	case !req.Amount.IsPositive():
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "amount must be more than zero")
+		return
+	case !key.mayMoveFundsOf(req.SenderID):
+		writeAPIError(w, http.StatusForbidden, "forbidden", "this API key can only move its own user's funds, other senders need the admin scope")
+		return
+	}
+
+	entry, err := a.app.Transfer(r.Context(), &app.TransferInput{
+		WorkspaceID:        key.WorkspaceID,
+		SenderID:           req.SenderID,
+		ReceiverID:         req.ReceiverID,
+		Platform:           "api",
+		Currency:           req.Currency,
+		Amount:             req.Amount,
+		Note:               req.Note,
+		WorkspaceUsersOnly: true,
+	})
+	if err != nil {
+		writeAppError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, journalEntryResponse{JournalEntryID: entry.ID, CreatedAt: entry.CreatedAt})
+}
+
+type grantRequest struct {
+	GranterID  string `json:"granter_id"`
+	ReceiverID string `json:"receiver_id"`
+	Currency   string `json:"currency"`
+	Note       string `json:"note"`
+}
+
+type grantResponse struct {
+	GrantID        uint            `json:"grant_id"`
+	JournalEntryID uint            `json:"journal_entry_id"`
+	Amount         decimal.Decimal `json:"amount"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+func (a *API) createGrant(w http.ResponseWriter, r *http.Request, key *APIKey) {
+	var req grantRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	switch {
+	case !slackUserIDPattern.MatchString(req.GranterID) || !slackUserIDPattern.MatchString(req.ReceiverID):
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "granter_id and receiver_id must be Slack user IDs")
+		return
+	case !currencyPattern.MatchString(req.Currency):
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "currency must be letters, optionally starting with $")
+		return
+	}
+
+	grant, err := a.app.Grant(r.Context(), &app.GrantInput{
+		WorkspaceID:        key.WorkspaceID,
+		GranterID:          req.GranterID,
+		ReceiverID:         req.ReceiverID,
+		Platform:           "api",
+		Currency:           req.Currency,
+		Note:               req.Note,
+		WorkspaceUsersOnly: true,
+	})
+	if err != nil {
+		writeAppError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, grantResponse{
+		GrantID:        grant.ID,
+		JournalEntryID: grant.Movement.JournalEntryID,
+		Amount:         grant.Movement.Amount,
+		CreatedAt:      grant.CreatedAt,
+	})
+}
+
+// parsePage reads limit & the decoded cursor, answering 400 if either is bad.
+func parsePage(w http.ResponseWriter, r *http.Request) (limit int, cursor string, ok bool) {
+	query := r.URL.Query()
+	limit = defaultPageSize
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxPageSize {
+			writeAPIError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("limit must be between 1 and %d", maxPageSize))
+			return 0, "", false
+		}
+		limit = n
+	}
+	if s := query.Get("cursor"); s != "" {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid_cursor", "cursor is not valid")
+			return 0, "", false
+		}
+		cursor = string(b)
+	}
+	return limit, cursor, true
+}
+
+// Cursors are opaque to clients, so how pages are keyed can change.
+func encodeCursor(s string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+func readBody(r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxAPIBodyBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxAPIBodyBytes {
+		return nil, fmt.Errorf("body is over %d bytes", maxAPIBodyBytes)
+	}
+	// Put it back for the handler, idempotent has already read it.
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+func decodeRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	body, err := readBody(r)
+	if err == nil {
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(v)
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("invalid JSON body: %s", err))
+		return false
+	}
+	return true
+}
+
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type apiErrorResponse struct {
+	Error apiError `json:"error"`
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, apiErrorResponse{Error: apiError{Code: code, Message: message}})
+}
+
+// writeAppError maps the ledger's errors to responses, anything unexpected is
+// logged and hidden behind a 500.
+func writeAppError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, domain.ErrInsufficientBalance):
+		writeAPIError(w, http.StatusUnprocessableEntity, "insufficient_balance", domain.ErrInsufficientBalance.Error())
+	case errors.Is(err, domain.ErrAmountCannotBeNegative):
+		writeAPIError(w, http.StatusUnprocessableEntity, "negative_amount", domain.ErrAmountCannotBeNegative.Error())
+	case errors.Is(err, domain.ErrAlreadyGranted):
+		writeAPIError(w, http.StatusConflict, "already_granted", domain.ErrAlreadyGranted.Error())
+	case errors.Is(err, app.ErrUnknownUser), errors.Is(err, app.ErrUserNotInWorkspace):
+		// Whether the user belongs to another workspace isn't this one's business.
+		writeAPIError(w, http.StatusNotFound, "unknown_user", "this workspace doesn't know the user")
+	case errors.Is(err, app.ErrDateInFuture):
+		writeAPIError(w, http.StatusBadRequest, "date_in_future", app.ErrDateInFuture.Error())
+	case errors.Is(err, app.ErrReconcileReasonRequired):
+		writeAPIError(w, http.StatusBadRequest, "reason_required", app.ErrReconcileReasonRequired.Error())
+	case errors.Is(err, app.ErrZeroAdjustment):
+		writeAPIError(w, http.StatusBadRequest, "zero_adjustment", app.ErrZeroAdjustment.Error())
+	default:
+		tracing.Logger(r.Context()).Error().Err(err).Str("path", r.URL.Path).Msg("API request failed")
+		writeAPIError(w, http.StatusInternalServerError, "internal", "internal error")
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
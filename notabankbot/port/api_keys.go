@@ -0,0 +1,145 @@
+package port
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/yammine/yamex-go"
+	"github.com/yammine/yamex-go/notabankbot/tracing"
+)
+
+const (
+	ErrUnknownAPIKey   = yamex.Sentinel("unknown or revoked API key")
+	ErrInvalidAPIScope = yamex.Sentinel("API key scopes are read, transfer or admin")
+	ErrAPIScopeDenied  = yamex.Sentinel("API key's scope does not allow this")
+)
+
+// APIScope is what an API key may do. Each scope includes the ones below it.
+type APIScope string
+
+const (
+	ScopeRead     APIScope = "read"
+	ScopeTransfer APIScope = "transfer"
+	ScopeAdmin    APIScope = "admin"
+)
+
+var scopeRanks = map[APIScope]int{ScopeRead: 1, ScopeTransfer: 2, ScopeAdmin: 3}
+
+func ParseAPIScope(s string) (APIScope, error) {
+	scope := APIScope(s)
+	if _, ok := scopeRanks[scope]; !ok {
+		return "", fmt.Errorf("%w: got %q", ErrInvalidAPIScope, s)
+	}
+	return scope, nil
+}
+
+// Allows reports whether a key with this scope may do what needs requires.
+func (s APIScope) Allows(needs APIScope) bool {
+	return scopeRanks[s] >= scopeRanks[needs]
+}
+
+// APIKey grants access to one workspace's ledger over the HTTP API. Only a
+// hash of the secret is kept, the key itself is shown once when it's created.
+type APIKey struct {
+	Prefix      string
+	WorkspaceID string
+	Name        string
+	Scope       APIScope
+	// UserID, if set, is the Slack user whose funds the key moves. Keys
+	// without one need the admin scope to transfer.
+	UserID     string
+	SecretHash string
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+}
+
+type APIKeyStore interface {
+	SaveAPIKey(ctx context.Context, key *APIKey) error
+	// GetAPIKey returns ErrUnknownAPIKey if there's no such key, or it was
+	// revoked.
+	GetAPIKey(ctx context.Context, prefix string) (*APIKey, error)
+	ListAPIKeys(ctx context.Context, workspaceID string) ([]*APIKey, error)
+	RevokeAPIKey(ctx context.Context, prefix string) error
+	TouchAPIKey(ctx context.Context, prefix string, usedAt time.Time) error
+}
+
+const apiKeyPrefix = "yamex_"
+
+// NewAPIKey generates a key, returning it alongside the record to save.
+func NewAPIKey(workspaceID, name string, scope APIScope) (string, *APIKey, error) {
+	prefix, err := randomHex(6)
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := randomHex(24)
+	if err != nil {
+		return "", nil, err
+	}
+	key := &APIKey{
+		Prefix:      prefix,
+		WorkspaceID: workspaceID,
+		Name:        name,
+		Scope:       scope,
+		SecretHash:  hashAPISecret(secret),
+		CreatedAt:   time.Now(),
+	}
+	return apiKeyPrefix + prefix + "_" + secret, key, nil
+}
+
+// AuthenticateAPIKey looks up a key as presented by a client and checks its
+// secret.
+func AuthenticateAPIKey(ctx context.Context, store APIKeyStore, presented string) (*APIKey, error) {
+	parts := strings.SplitN(strings.TrimPrefix(presented, apiKeyPrefix), "_", 2)
+	if !strings.HasPrefix(presented, apiKeyPrefix) || len(parts) != 2 {
+		return nil, ErrUnknownAPIKey
+	}
+	key, err := store.GetAPIKey(ctx, parts[0])
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashAPISecret(parts[1])), []byte(key.SecretHash)) != 1 {
+		return nil, ErrUnknownAPIKey
+	}
+	return key, nil
+}
+
+// authorizeAPIKey authenticates the key and checks it has at least scope,
+// recording that it was used.
+func authorizeAPIKey(ctx context.Context, store APIKeyStore, presented string, scope APIScope) (*APIKey, error) {
+	key, err := AuthenticateAPIKey(ctx, store, presented)
+	if err != nil {
+		return nil, err
+	}
+	if !key.Scope.Allows(scope) {
+		return nil, fmt.Errorf("%w: needs the %s scope", ErrAPIScopeDenied, scope)
+	}
+	if err := store.TouchAPIKey(ctx, key.Prefix, time.Now()); err != nil {
+		tracing.Logger(ctx).Warn().Err(err).Msg("Failed to record API key use")
+	}
+	return key, nil
+}
+
+// mayMoveFundsOf reports whether the key may transfer the user's currency.
+func (k *APIKey) mayMoveFundsOf(userID string) bool {
+	return k.Scope.Allows(ScopeAdmin) || (k.UserID != "" && k.UserID == userID)
+}
+
+func hashAPISecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating key: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,40 @@
+package port
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/yammine/yamex-go/notabankbot/app"
+)
+
+// RunBalanceSnapshots snapshots every completed day each interval until ctx is
+// cancelled. Days that already have snapshots are skipped, so running more
+// often than daily only catches up sooner after downtime.
+func RunBalanceSnapshots(ctx context.Context, application *app.Application, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		snapshotCompletedDays(ctx, application)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func snapshotCompletedDays(ctx context.Context, application *app.Application) {
+	yesterday := time.Now().UTC().AddDate(0, 0, -1)
+	days, err := application.SnapshotBalances(ctx, yesterday)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to snapshot balances")
+		return
+	}
+	if days > 0 {
+		log.Info().Int("days", days).Msg("Balance snapshots written")
+	}
+}
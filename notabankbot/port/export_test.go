@@ -0,0 +1,27 @@
+package port
+
+import "strconv"
+
+// Exposed to the port_test package.
+var (
+	AdminOpenAPI   = adminOpenAPI
+	GRPCError      = grpcError
+	ResolveMapping = resolveMapping
+)
+
+// ParseJSONPath returns the path's segments as their keys, with array indexes
+// written [n].
+func ParseJSONPath(expr string) ([]string, error) {
+	segments, err := parseJSONPath(expr)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, len(segments))
+	for n, s := range segments {
+		keys[n] = s.key
+		if s.key == "" {
+			keys[n] = "[" + strconv.Itoa(s.index) + "]"
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,101 @@
+package port_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yammine/yamex-go/notabankbot/adapter"
+	"github.com/yammine/yamex-go/notabankbot/app"
+	"github.com/yammine/yamex-go/notabankbot/port"
+	"github.com/yammine/yamex-go/notabankbot/slackfake"
+)
+
+// Slack IDs and secrets the tests share.
+const (
+	testWorkspace = "TTEST000000"
+	testChannel   = "CTEST000000"
+	testBotUser   = "UYAMEX00000"
+	testAdmin     = "UADMIN00000"
+	testAlice     = "UALICE00000"
+	testBob       = "UBOB0000000"
+	testSecret    = "signing-secret"
+	// testWait bounds how long tests wait for replies and other background
+	// work.
+	testWait = 5 * time.Second
+)
+
+// installedWorkspaces treats every workspace as installed with the same bot.
+type installedWorkspaces struct {
+	botUserID string
+}
+
+func (installedWorkspaces) SaveCredentials(ctx context.Context, installation *port.SlackInstallation) error {
+	return nil
+}
+
+func (c installedWorkspaces) GetCredentials(ctx context.Context, workspaceID string) (*port.SlackInstallation, error) {
+	return &port.SlackInstallation{TeamID: workspaceID, BotUserID: c.botUserID, Token: "xoxb-test"}, nil
+}
+
+func (installedWorkspaces) RevokeCredentials(ctx context.Context, workspaceID string) error {
+	return nil
+}
+
+// testBot is the bot as the server wires it, on the memory repository, with
+// its replies going to a fake Slack.
+type testBot struct {
+	t          *testing.T
+	app        *app.Application
+	fake       *slackfake.Server
+	consumer   *port.SlackConsumer
+	interactor *port.SlackInteractor
+	router     http.Handler
+}
+
+// startBot starts a bot installed in every workspace as botUserID. with, if
+// not nil, adds optional handlers before the router is built.
+func startBot(t *testing.T, botUserID string, with func(h *port.Handlers)) *testBot {
+	t.Helper()
+	fake := slackfake.NewServer()
+	t.Cleanup(fake.Close)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	application := app.NewApplication(adapter.NewMemoryRepository(), nil)
+	credentials := installedWorkspaces{botUserID: botUserID}
+	dispatcher := port.NewSlackDispatcher(application, credentials, port.NewSlackClientFactory(fake.APIURL()), nil)
+	go dispatcher.Run(ctx, 10*time.Millisecond)
+
+	b := &testBot{
+		t:          t,
+		app:        application,
+		fake:       fake,
+		consumer:   port.NewSlackConsumer(application, credentials, dispatcher, nil, testSecret, nil),
+		interactor: port.NewSlackInteractor(application, dispatcher, testSecret, nil),
+	}
+	handlers := port.Handlers{App: application, Consumer: b.consumer, Interactor: b.interactor}
+	if with != nil {
+		with(&handlers)
+	}
+	b.router = port.NewRouter(handlers)
+	return b
+}
+
+// serve posts a request, as built by slackfake, to the router and checks it
+// was answered with a 200.
+func (b *testBot) serve(req *http.Request, err error) *httptest.ResponseRecorder {
+	t := b.t
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := httptest.NewRecorder()
+	b.router.ServeHTTP(res, req)
+	if res.Code != http.StatusOK {
+		t.Fatalf("%s answered %d, want 200", req.URL.Path, res.Code)
+	}
+	return res
+}
@@ -0,0 +1,446 @@
+package port
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/yammine/yamex-go/notabankbot/app"
+	"github.com/yammine/yamex-go/notabankbot/domain"
+	"github.com/yammine/yamex-go/notabankbot/ledgerpb"
+	"github.com/yammine/yamex-go/notabankbot/tracing"
+)
+
+// How often WatchMovements looks for new movements.
+const watchPollInterval = time.Second
+
+// grpcScopes is the scope each RPC needs.
+var grpcScopes = map[string]APIScope{
+	"Grant":          ScopeAdmin,
+	"Transfer":       ScopeTransfer,
+	"GetBalance":     ScopeRead,
+	"ListMovements":  ScopeRead,
+	"WatchMovements": ScopeRead,
+}
+
+// grpcCodes maps the ledger's errors to status codes, anything else is
+// Internal.
+var grpcCodes = []struct {
+	err  error
+	code codes.Code
+}{
+	{domain.ErrInsufficientBalance, codes.FailedPrecondition},
+	{domain.ErrAlreadyGranted, codes.FailedPrecondition},
+	{domain.ErrAmountCannotBeNegative, codes.InvalidArgument},
+	{app.ErrDateInFuture, codes.InvalidArgument},
+	{app.ErrCannotFindOrCreateUser, codes.InvalidArgument},
+	{app.ErrUnknownUser, codes.NotFound},
+	{app.ErrUserNotInWorkspace, codes.NotFound},
+	{ErrUnknownAPIKey, codes.Unauthenticated},
+	{ErrAPIScopeDenied, codes.PermissionDenied},
+	{ErrShuttingDown, codes.Unavailable},
+	{context.Canceled, codes.Canceled},
+	{context.DeadlineExceeded, codes.DeadlineExceeded},
+}
+
+// LedgerServer serves the Ledger gRPC service. Callers are identified by an
+// API key, or by a client certificate when the server requires mTLS.
+type LedgerServer struct {
+	ledgerpb.UnimplementedLedgerServer
+
+	app     *app.Application
+	keys    APIKeyStore
+	closing chan struct{}
+}
+
+func NewLedgerServer(app *app.Application, keys APIKeyStore) *LedgerServer {
+	return &LedgerServer{app: app, keys: keys, closing: make(chan struct{})}
+}
+
+// NewGRPCServer serves ledger with auth & error mapping. creds may be nil to
+// serve without TLS, e.g. behind a proxy that terminates it or in-process.
+func NewGRPCServer(ledger *LedgerServer, creds credentials.TransportCredentials) *grpc.Server {
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(ledger.unaryInterceptor),
+		grpc.ChainStreamInterceptor(ledger.streamInterceptor),
+	}
+	if creds != nil {
+		opts = append(opts, grpc.Creds(creds))
+	}
+	server := grpc.NewServer(opts...)
+	ledgerpb.RegisterLedgerServer(server, ledger)
+	return server
+}
+
+// LoadGRPCTLS reads the server's certificate. With clientCAFile, clients must
+// present a certificate signed by it (mTLS).
+func LoadGRPCTLS(certFile, keyFile, clientCAFile string) (credentials.TransportCredentials, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading certificate: %w", err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if clientCAFile != "" {
+		pem, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", clientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return credentials.NewTLS(config), nil
+}
+
+// Close ends WatchMovements streams, so a graceful stop isn't held up by them.
+func (l *LedgerServer) Close() {
+	close(l.closing)
+}
+
+// StopGRPC lets calls in flight finish, cutting them off when ctx is done.
+func StopGRPC(ctx context.Context, server *grpc.Server) error {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		server.Stop()
+		return fmt.Errorf("%w: gRPC calls", ErrWorkAbandoned)
+	}
+}
+
+type apiKeyContextKey struct{}
+
+func (l *LedgerServer) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := l.authorize(ctx, info.FullMethod)
+	if err != nil {
+		return nil, grpcError(ctx, info.FullMethod, err)
+	}
+	res, err := handler(ctx, req)
+	if err != nil {
+		return nil, grpcError(ctx, info.FullMethod, err)
+	}
+	return res, nil
+}
+
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s authorizedStream) Context() context.Context {
+	return s.ctx
+}
+
+func (l *LedgerServer) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := l.authorize(ss.Context(), info.FullMethod)
+	if err != nil {
+		return grpcError(ctx, info.FullMethod, err)
+	}
+	if err := handler(srv, authorizedStream{ServerStream: ss, ctx: ctx}); err != nil {
+		return grpcError(ctx, info.FullMethod, err)
+	}
+	return nil
+}
+
+// authorize identifies the caller, from a verified client certificate or
+// else an API key, and checks they may call method.
+func (l *LedgerServer) authorize(ctx context.Context, method string) (context.Context, error) {
+	scope, ok := grpcScopes[method[strings.LastIndex(method, "/")+1:]]
+	if !ok {
+		return ctx, fmt.Errorf("%w: unknown method %s", ErrAPIScopeDenied, method)
+	}
+
+	if key, ok := certificateKey(ctx); ok {
+		if !key.Scope.Allows(scope) {
+			return ctx, fmt.Errorf("%w: needs the %s scope", ErrAPIScopeDenied, scope)
+		}
+		return context.WithValue(ctx, apiKeyContextKey{}, key), nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	var presented string
+	if values := md.Get("authorization"); len(values) > 0 {
+		presented = strings.TrimPrefix(values[0], "Bearer ")
+	}
+	key, err := authorizeAPIKey(ctx, l.keys, presented, scope)
+	if err != nil {
+		return ctx, err
+	}
+	return context.WithValue(ctx, apiKeyContextKey{}, key), nil
+}
+
+// certificateKey reads who a verified client certificate was issued to: the
+// workspace is its common name, its scope the first organizational unit.
+func certificateKey(ctx context.Context) (*APIKey, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return nil, false
+	}
+	leaf := info.State.VerifiedChains[0][0]
+	key := &APIKey{
+		Prefix:      "cert:" + leaf.SerialNumber.String(),
+		WorkspaceID: leaf.Subject.CommonName,
+		Name:        leaf.Subject.String(),
+	}
+	if len(leaf.Subject.OrganizationalUnit) > 0 {
+		key.Scope, _ = ParseAPIScope(leaf.Subject.OrganizationalUnit[0])
+	}
+	return key, key.WorkspaceID != ""
+}
+
+func callerKey(ctx context.Context) *APIKey {
+	return ctx.Value(apiKeyContextKey{}).(*APIKey)
+}
+
+// grpcError turns err into a status. Unexpected errors are logged and their
+// details hidden.
+func grpcError(ctx context.Context, method string, err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	for _, c := range grpcCodes {
+		if errors.Is(err, c.err) {
+			return status.Error(c.code, c.err.Error())
+		}
+	}
+	tracing.Logger(ctx).Error().Err(err).Str("method", method).Msg("gRPC call failed")
+	return status.Error(codes.Internal, "internal error")
+}
+
+func invalidArgument(format string, args ...interface{}) error {
+	return status.Errorf(codes.InvalidArgument, format, args...)
+}
+
+func (l *LedgerServer) Grant(ctx context.Context, req *ledgerpb.GrantRequest) (*ledgerpb.GrantResponse, error) {
+	switch {
+	case !slackUserIDPattern.MatchString(req.GranterId) || !slackUserIDPattern.MatchString(req.ReceiverId):
+		return nil, invalidArgument("granter_id and receiver_id must be Slack user IDs")
+	case !currencyPattern.MatchString(req.Currency):
+		return nil, invalidArgument("currency must be letters, optionally starting with $")
+	}
+
+	grant, err := l.app.Grant(ctx, &app.GrantInput{
+		WorkspaceID:        callerKey(ctx).WorkspaceID,
+		GranterID:          req.GranterId,
+		ReceiverID:         req.ReceiverId,
+		Platform:           "grpc",
+		Currency:           req.Currency,
+		Note:               req.Note,
+		WorkspaceUsersOnly: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ledgerpb.GrantResponse{
+		GrantId:        uint64(grant.ID),
+		JournalEntryId: uint64(grant.Movement.JournalEntryID),
+		Amount:         grant.Movement.Amount.String(),
+		CreatedAt:      timestamppb.New(grant.CreatedAt),
+	}, nil
+}
+
+func (l *LedgerServer) Transfer(ctx context.Context, req *ledgerpb.TransferRequest) (*ledgerpb.TransferResponse, error) {
+	amount, err := decimal.NewFromString(req.Amount)
+	switch {
+	case !slackUserIDPattern.MatchString(req.SenderId) || !slackUserIDPattern.MatchString(req.ReceiverId):
+		return nil, invalidArgument("sender_id and receiver_id must be Slack user IDs")
+	case !currencyPattern.MatchString(req.Currency):
+		return nil, invalidArgument("currency must be letters, optionally starting with $")
+	case err != nil || !amount.IsPositive():
+		return nil, invalidArgument("amount must be a decimal more than zero")
+	}
+	key := callerKey(ctx)
+	if !key.mayMoveFundsOf(req.SenderId) {
+		return nil, fmt.Errorf("%w: only the key's own user's funds can be moved without the admin scope", ErrAPIScopeDenied)
+	}
+
+	entry, err := l.app.Transfer(ctx, &app.TransferInput{
+		WorkspaceID:        key.WorkspaceID,
+		SenderID:           req.SenderId,
+		ReceiverID:         req.ReceiverId,
+		Platform:           "grpc",
+		Currency:           req.Currency,
+		Amount:             amount,
+		Note:               req.Note,
+		WorkspaceUsersOnly: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ledgerpb.TransferResponse{
+		JournalEntryId: uint64(entry.ID),
+		CreatedAt:      timestamppb.New(entry.CreatedAt),
+	}, nil
+}
+
+func (l *LedgerServer) GetBalance(ctx context.Context, req *ledgerpb.GetBalanceRequest) (*ledgerpb.GetBalanceResponse, error) {
+	if !slackUserIDPattern.MatchString(req.UserId) {
+		return nil, invalidArgument("user_id must be a Slack user ID")
+	}
+
+	var accounts []*domain.Account
+	var err error
+	if req.AsOf == "" {
+		accounts, err = l.app.GetBalance(ctx, &app.GetBalanceInput{UserID: req.UserId, WorkspaceID: callerKey(ctx).WorkspaceID})
+	} else {
+		day, parseErr := time.Parse(domain.SnapshotDateLayout, req.AsOf)
+		if parseErr != nil {
+			return nil, invalidArgument("as_of must look like 2021-12-31")
+		}
+		accounts, err = l.app.GetBalanceAsOf(ctx, &app.GetBalanceAsOfInput{UserID: req.UserId, WorkspaceID: callerKey(ctx).WorkspaceID, Day: day})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res := &ledgerpb.GetBalanceResponse{Accounts: make([]*ledgerpb.Account, len(accounts))}
+	for i, account := range accounts {
+		res.Accounts[i] = &ledgerpb.Account{Currency: account.Currency, Balance: account.Balance.String()}
+	}
+	return res, nil
+}
+
+func (l *LedgerServer) ListMovements(ctx context.Context, req *ledgerpb.ListMovementsRequest) (*ledgerpb.ListMovementsResponse, error) {
+	if err := validateMovementFilter(req.UserId, req.Currency); err != nil {
+		return nil, err
+	}
+	limit := int(req.PageSize)
+	if limit <= 0 {
+		limit = defaultPageSize
+	} else if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	var before uint
+	if req.PageToken != "" {
+		b, err := base64.RawURLEncoding.DecodeString(req.PageToken)
+		id, parseErr := strconv.ParseUint(string(b), 10, 64)
+		if err != nil || parseErr != nil {
+			return nil, invalidArgument("page_token is not valid")
+		}
+		before = uint(id)
+	}
+
+	movements, err := l.app.ListMovements(ctx, &app.ListMovementsInput{
+		WorkspaceID: callerKey(ctx).WorkspaceID,
+		UserID:      req.UserId,
+		Currency:    req.Currency,
+		BeforeID:    before,
+		Limit:       limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := &ledgerpb.ListMovementsResponse{Movements: make([]*ledgerpb.Movement, len(movements))}
+	for i, m := range movements {
+		res.Movements[i] = movementProto(m)
+	}
+	if len(movements) == limit {
+		res.NextPageToken = encodeCursor(strconv.FormatUint(uint64(movements[len(movements)-1].ID), 10))
+	}
+	return res, nil
+}
+
+// WatchMovements polls for new movements until the client goes away or the
+// server is closing.
+func (l *LedgerServer) WatchMovements(req *ledgerpb.WatchMovementsRequest, stream ledgerpb.Ledger_WatchMovementsServer) error {
+	if err := validateMovementFilter(req.UserId, req.Currency); err != nil {
+		return err
+	}
+	ctx := stream.Context()
+	input := &app.ListMovementsInput{
+		WorkspaceID: callerKey(ctx).WorkspaceID,
+		UserID:      req.UserId,
+		Currency:    req.Currency,
+	}
+
+	after := uint(req.AfterId)
+	if after == 0 {
+		input.Limit = 1
+		latest, err := l.app.ListMovements(ctx, input)
+		if err != nil {
+			return err
+		}
+		if len(latest) > 0 {
+			after = latest[0].ID
+		}
+	}
+	input.Limit = maxPageSize
+
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+	for {
+		input.AfterID = &after
+		movements, err := l.app.ListMovements(ctx, input)
+		if err != nil {
+			return err
+		}
+		for _, m := range movements {
+			if err := stream.Send(movementProto(m)); err != nil {
+				return err
+			}
+			after = m.ID
+		}
+		// A full page means more are waiting.
+		if len(movements) == input.Limit {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-l.closing:
+			return ErrShuttingDown
+		case <-ticker.C:
+		}
+	}
+}
+
+func validateMovementFilter(userID, currency string) error {
+	if !slackUserIDPattern.MatchString(userID) {
+		return invalidArgument("user_id must be a Slack user ID")
+	}
+	if currency != "" && !currencyPattern.MatchString(currency) {
+		return invalidArgument("currency must be letters, optionally starting with $")
+	}
+	return nil
+}
+
+func movementProto(m *app.UserMovement) *ledgerpb.Movement {
+	return &ledgerpb.Movement{
+		Id:             uint64(m.ID),
+		JournalEntryId: uint64(m.JournalEntryID),
+		Currency:       m.Currency,
+		Amount:         m.Amount.String(),
+		Reason:         m.Reason,
+		CreatedAt:      timestamppb.New(m.CreatedAt),
+	}
+}
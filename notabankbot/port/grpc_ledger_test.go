@@ -0,0 +1,330 @@
+package port_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/yammine/yamex-go/notabankbot/app"
+	"github.com/yammine/yamex-go/notabankbot/domain"
+	"github.com/yammine/yamex-go/notabankbot/ledgerpb"
+	"github.com/yammine/yamex-go/notabankbot/ledgertest"
+	"github.com/yammine/yamex-go/notabankbot/port"
+)
+
+// grpcLedger is a running service in which the admin has granted alice 10
+// $coffee, with an admin key for the workspace.
+type grpcLedger struct {
+	*ledgertest.Harness
+	admin grpc.CallOption
+}
+
+func startGRPCLedger(t *testing.T) *grpcLedger {
+	t.Helper()
+	h, err := ledgertest.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(h.Close)
+	h.App.SetGrantPolicy(app.GrantPolicy{Amount: decimal.New(10, 0), Cooldown: domain.TimeBetweenGrants()})
+
+	l := &grpcLedger{Harness: h, admin: keyFor(t, h, testWorkspace, "", port.ScopeAdmin)}
+	_, err = h.Client.Grant(context.Background(), &ledgerpb.GrantRequest{GranterId: testAdmin, ReceiverId: testAlice, Currency: "$coffee"}, l.admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// keyFor authenticates calls with a new key for the workspace, bound to
+// userID unless it's empty.
+func keyFor(t *testing.T, h *ledgertest.Harness, workspaceID, userID string, scope port.APIScope) grpc.CallOption {
+	t.Helper()
+	key, err := h.NewUserKey(workspaceID, userID, scope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ledgertest.WithKey(key)
+}
+
+func (l *grpcLedger) balance(t *testing.T, userID string) string {
+	t.Helper()
+	res, err := l.Client.GetBalance(context.Background(), &ledgerpb.GetBalanceRequest{UserId: userID}, l.admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, account := range res.Accounts {
+		if account.Currency == "$coffee" {
+			return account.Balance
+		}
+	}
+	return "0"
+}
+
+func wantCode(t *testing.T, err error, want codes.Code) {
+	t.Helper()
+	if got := status.Code(err); got != want {
+		t.Errorf("code = %s (%v), want %s", got, err, want)
+	}
+}
+
+func TestGRPCGrantAndTransfer(t *testing.T) {
+	l := startGRPCLedger(t)
+	ctx := context.Background()
+
+	if got := l.balance(t, testAlice); got != "10" {
+		t.Errorf("alice's balance after the grant = %s, want 10", got)
+	}
+
+	transfer, err := l.Client.Transfer(ctx, &ledgerpb.TransferRequest{SenderId: testAlice, ReceiverId: testBob, Currency: "$coffee", Amount: "2.5", Note: "lunch"}, l.admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transfer.JournalEntryId == 0 || transfer.CreatedAt == nil {
+		t.Errorf("transfer response = %v, want its journal entry", transfer)
+	}
+	if got := l.balance(t, testAlice); got != "7.5" {
+		t.Errorf("alice's balance = %s, want 7.5", got)
+	}
+	if got := l.balance(t, testBob); got != "2.5" {
+		t.Errorf("bob's balance = %s, want 2.5", got)
+	}
+
+	// A transfer key may only move its own user's funds.
+	bobsKey := keyFor(t, l.Harness, testWorkspace, testBob, port.ScopeTransfer)
+	_, err = l.Client.Transfer(ctx, &ledgerpb.TransferRequest{SenderId: testBob, ReceiverId: testAlice, Currency: "$coffee", Amount: "1"}, bobsKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = l.Client.Transfer(ctx, &ledgerpb.TransferRequest{SenderId: testAlice, ReceiverId: testBob, Currency: "$coffee", Amount: "1"}, bobsKey)
+	wantCode(t, err, codes.PermissionDenied)
+	if got := l.balance(t, testBob); got != "1.5" {
+		t.Errorf("bob's balance = %s, want 1.5", got)
+	}
+}
+
+func TestGRPCListMovements(t *testing.T) {
+	l := startGRPCLedger(t)
+	ctx := context.Background()
+	for _, amount := range []string{"1", "2"} {
+		_, err := l.Client.Transfer(ctx, &ledgerpb.TransferRequest{SenderId: testAlice, ReceiverId: testBob, Currency: "$coffee", Amount: amount}, l.admin)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var amounts []string
+	req := &ledgerpb.ListMovementsRequest{UserId: testAlice, Currency: "$coffee", PageSize: 2}
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("too many pages")
+		}
+		res, err := l.Client.ListMovements(ctx, req, l.admin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range res.Movements {
+			amounts = append(amounts, m.Amount)
+		}
+		if res.NextPageToken == "" {
+			break
+		}
+		req.PageToken = res.NextPageToken
+	}
+
+	// Newest first: both transfers out, then the grant.
+	want := []string{"-2", "-1", "10"}
+	if fmt.Sprint(amounts) != fmt.Sprint(want) {
+		t.Errorf("alice's movements = %v, want %v", amounts, want)
+	}
+
+	_, err := l.Client.ListMovements(ctx, &ledgerpb.ListMovementsRequest{UserId: testAlice, PageToken: "!"}, l.admin)
+	wantCode(t, err, codes.InvalidArgument)
+}
+
+func TestGRPCWatchMovements(t *testing.T) {
+	l := startGRPCLedger(t)
+	ctx, cancel := context.WithTimeout(context.Background(), testWait)
+	defer cancel()
+	transfer := func(amount string) {
+		t.Helper()
+		_, err := l.Client.Transfer(context.Background(), &ledgerpb.TransferRequest{SenderId: testAlice, ReceiverId: testBob, Currency: "$coffee", Amount: amount}, l.admin)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Nobody can watch a user the workspace doesn't know.
+	unknown, err := l.Client.WatchMovements(ctx, &ledgerpb.WatchMovementsRequest{UserId: testBob}, l.admin)
+	if err == nil {
+		_, err = unknown.Recv()
+	}
+	wantCode(t, err, codes.NotFound)
+
+	transfer("0.5")
+	latest, err := l.Client.ListMovements(ctx, &ledgerpb.ListMovementsRequest{UserId: testBob, PageSize: 1}, l.admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := l.Client.WatchMovements(ctx, &ledgerpb.WatchMovementsRequest{UserId: testBob, AfterId: latest.Movements[0].Id}, l.admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transfer("1")
+	transfer("2")
+
+	last := latest.Movements[0].Id
+	for _, want := range []string{"1", "2"} {
+		m, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Amount != want || m.Currency != "$coffee" || m.Id <= last {
+			t.Errorf("movement = %v, want %s $coffee after #%d", m, want, last)
+		}
+		last = m.Id
+	}
+
+	cancel()
+	if _, err := stream.Recv(); status.Code(err) != codes.Canceled {
+		t.Errorf("Recv after cancelling = %v, want Canceled", err)
+	}
+}
+
+func TestGRPCAuthentication(t *testing.T) {
+	l := startGRPCLedger(t)
+	ctx := context.Background()
+	req := &ledgerpb.GetBalanceRequest{UserId: testAlice}
+
+	_, err := l.Client.GetBalance(ctx, req)
+	wantCode(t, err, codes.Unauthenticated)
+	_, err = l.Client.GetBalance(ctx, req, ledgertest.WithKey("yx_bogus"))
+	wantCode(t, err, codes.Unauthenticated)
+
+	// Streams are authorized too.
+	stream, err := l.Client.WatchMovements(ctx, &ledgerpb.WatchMovementsRequest{UserId: testAlice})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	wantCode(t, err, codes.Unauthenticated)
+}
+
+func TestGRPCScopes(t *testing.T) {
+	l := startGRPCLedger(t)
+	ctx := context.Background()
+	read := keyFor(t, l.Harness, testWorkspace, "", port.ScopeRead)
+	transfer := keyFor(t, l.Harness, testWorkspace, testAlice, port.ScopeTransfer)
+
+	if _, err := l.Client.GetBalance(ctx, &ledgerpb.GetBalanceRequest{UserId: testAlice}, read); err != nil {
+		t.Errorf("reading with a read key: %v", err)
+	}
+	_, err := l.Client.Transfer(ctx, &ledgerpb.TransferRequest{SenderId: testAlice, ReceiverId: testBob, Currency: "$coffee", Amount: "1"}, read)
+	wantCode(t, err, codes.PermissionDenied)
+	_, err = l.Client.Grant(ctx, &ledgerpb.GrantRequest{GranterId: testAlice, ReceiverId: testBob, Currency: "$coffee"}, transfer)
+	wantCode(t, err, codes.PermissionDenied)
+	if _, err := l.Client.Transfer(ctx, &ledgerpb.TransferRequest{SenderId: testAlice, ReceiverId: testBob, Currency: "$coffee", Amount: "1"}, transfer); err != nil {
+		t.Errorf("transferring with alice's transfer key: %v", err)
+	}
+}
+
+func TestGRPCLedgerErrors(t *testing.T) {
+	l := startGRPCLedger(t)
+	ctx := context.Background()
+	// Carol only ever moved currency in another workspace.
+	other := keyFor(t, l.Harness, "TOTHER00000", "", port.ScopeAdmin)
+	if _, err := l.Client.Grant(ctx, &ledgerpb.GrantRequest{GranterId: "UOTHER00000", ReceiverId: "UCAROL00000", Currency: "$tea"}, other); err != nil {
+		t.Fatal(err)
+	}
+	tomorrow := time.Now().UTC().AddDate(0, 0, 1).Format(domain.SnapshotDateLayout)
+
+	tests := []struct {
+		name string
+		call func() error
+		want codes.Code
+	}{
+		{"insufficient balance", func() error {
+			_, err := l.Client.Transfer(ctx, &ledgerpb.TransferRequest{SenderId: testAlice, ReceiverId: testBob, Currency: "$coffee", Amount: "11"}, l.admin)
+			return err
+		}, codes.FailedPrecondition},
+		{"already granted", func() error {
+			_, err := l.Client.Grant(ctx, &ledgerpb.GrantRequest{GranterId: testAdmin, ReceiverId: testBob, Currency: "$coffee"}, l.admin)
+			return err
+		}, codes.FailedPrecondition},
+		{"negative amount", func() error {
+			_, err := l.Client.Transfer(ctx, &ledgerpb.TransferRequest{SenderId: testAlice, ReceiverId: testBob, Currency: "$coffee", Amount: "-1"}, l.admin)
+			return err
+		}, codes.InvalidArgument},
+		{"not a user ID", func() error {
+			_, err := l.Client.GetBalance(ctx, &ledgerpb.GetBalanceRequest{UserId: "alice"}, l.admin)
+			return err
+		}, codes.InvalidArgument},
+		{"balance in the future", func() error {
+			_, err := l.Client.GetBalance(ctx, &ledgerpb.GetBalanceRequest{UserId: testAlice, AsOf: tomorrow}, l.admin)
+			return err
+		}, codes.InvalidArgument},
+		{"another workspace's user", func() error {
+			_, err := l.Client.GetBalance(ctx, &ledgerpb.GetBalanceRequest{UserId: "UCAROL00000"}, l.admin)
+			return err
+		}, codes.NotFound},
+		{"transfer to another workspace's user", func() error {
+			_, err := l.Client.Transfer(ctx, &ledgerpb.TransferRequest{SenderId: testAlice, ReceiverId: "UCAROL00000", Currency: "$coffee", Amount: "1"}, l.admin)
+			return err
+		}, codes.NotFound},
+		{"movements of an unknown user", func() error {
+			_, err := l.Client.ListMovements(ctx, &ledgerpb.ListMovementsRequest{UserId: "UNOBODY0000"}, l.admin)
+			return err
+		}, codes.NotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wantCode(t, tt.call(), tt.want)
+		})
+	}
+	if got := l.balance(t, testAlice); got != "10" {
+		t.Errorf("alice's balance = %s, want it untouched at 10", got)
+	}
+}
+
+func TestGRPCErrorCodes(t *testing.T) {
+	tests := []struct {
+		err  error
+		want codes.Code
+	}{
+		{domain.ErrInsufficientBalance, codes.FailedPrecondition},
+		{domain.ErrAlreadyGranted, codes.FailedPrecondition},
+		{domain.ErrAmountCannotBeNegative, codes.InvalidArgument},
+		{app.ErrDateInFuture, codes.InvalidArgument},
+		{app.ErrCannotFindOrCreateUser, codes.InvalidArgument},
+		{app.ErrUnknownUser, codes.NotFound},
+		{app.ErrUserNotInWorkspace, codes.NotFound},
+		{port.ErrUnknownAPIKey, codes.Unauthenticated},
+		{port.ErrAPIScopeDenied, codes.PermissionDenied},
+		{port.ErrShuttingDown, codes.Unavailable},
+		{context.Canceled, codes.Canceled},
+		{context.DeadlineExceeded, codes.DeadlineExceeded},
+		{errors.New("connection refused"), codes.Internal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			err := port.GRPCError(context.Background(), "/test", fmt.Errorf("repo: %w", tt.err))
+			s, _ := status.FromError(err)
+			if s.Code() != tt.want {
+				t.Errorf("code = %s, want %s", s.Code(), tt.want)
+			}
+			// Only the sentinel is shown, never what wrapped it.
+			if want := tt.err.Error(); tt.want != codes.Internal && s.Message() != want {
+				t.Errorf("message = %q, want %q", s.Message(), want)
+			}
+			if tt.want == codes.Internal && s.Message() != "internal error" {
+				t.Errorf("message = %q, want details hidden", s.Message())
+			}
+		})
+	}
+}
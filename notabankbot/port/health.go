@@ -0,0 +1,85 @@
+package port
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const readinessTimeout = 2 * time.Second
+
+// ReadinessCheck is a dependency that must be usable before we take traffic.
+type ReadinessCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// Health serves /healthz & /readyz. It stays unready until SetReady, so load
+// balancers hold off while the server is starting or draining.
+type Health struct {
+	checks []ReadinessCheck
+	ready  int32
+}
+
+func NewHealth(checks ...ReadinessCheck) *Health {
+	return &Health{checks: checks}
+}
+
+func (h *Health) SetReady(ready bool) {
+	var v int32
+	if ready {
+		v = 1
+	}
+	atomic.StoreInt32(&h.ready, v)
+}
+
+func (h *Health) Ready() bool {
+	return atomic.LoadInt32(&h.ready) == 1
+}
+
+// LivenessHandler reports that the process is up, whatever its dependencies.
+func (h *Health) LivenessHandler() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("ok\n"))
+	}
+}
+
+type readinessResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// ReadinessHandler runs every check, answering 503 unless all of them pass.
+// The endpoint is public, so why a check failed only goes to the logs.
+func (h *Health) ReadinessHandler() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+		defer cancel()
+
+		response := readinessResponse{Status: "ready", Checks: make(map[string]string, len(h.checks))}
+		status := http.StatusOK
+		if !h.Ready() {
+			response.Status = "starting"
+			status = http.StatusServiceUnavailable
+		}
+		for _, check := range h.checks {
+			if err := check.Check(ctx); err != nil {
+				log.Warn().Err(err).Str("check", check.Name).Msg("Readiness check failed")
+				response.Checks[check.Name] = "failing"
+				response.Status = "unavailable"
+				status = http.StatusServiceUnavailable
+				continue
+			}
+			response.Checks[check.Name] = "ok"
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(response)
+	}
+}
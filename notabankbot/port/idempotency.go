@@ -0,0 +1,148 @@
+package port
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/yammine/yamex-go/notabankbot/tracing"
+)
+
+// Keys are kept long enough to cover client retries, then may be reused.
+const idempotencyKeyTTL = 24 * time.Hour
+
+// A claim still in progress after this long belongs to a request that died
+// before recording its outcome, so the key may be claimed again. Comfortably
+// longer than the server's 15s write timeout.
+const idempotencyLease = time.Minute
+
+// IdempotentRequest is a write made under an Idempotency-Key. Status & Body are
+// only set once the request has completed.
+type IdempotentRequest struct {
+	Fingerprint string
+	Status      int
+	Body        []byte
+	CompletedAt *time.Time
+}
+
+type IdempotencyStore interface {
+	// BeginRequest claims the key for a request, unless it was claimed less
+	// than ttl ago, in which case it returns that request instead. A claim that
+	// hasn't completed within lease is taken over.
+	BeginRequest(ctx context.Context, apiKey, key, fingerprint string, ttl, lease time.Duration) (existing *IdempotentRequest, err error)
+	CompleteRequest(ctx context.Context, apiKey, key string, status int, body []byte) error
+	// ReleaseRequest forgets the key so the request can be retried.
+	ReleaseRequest(ctx context.Context, apiKey, key string) error
+	// PruneRequests deletes keys claimed more than ttl ago.
+	PruneRequests(ctx context.Context, ttl time.Duration) (int64, error)
+}
+
+// RunIdempotencyPruning deletes expired idempotency keys each interval until
+// ctx is cancelled.
+func (a *API) RunIdempotencyPruning(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if pruned, err := a.idempotency.PruneRequests(ctx, idempotencyKeyTTL); err != nil {
+			log.Error().Err(err).Msg("Failed to prune idempotency keys")
+		} else if pruned > 0 {
+			log.Info().Int64("pruned", pruned).Msg("Expired idempotency keys pruned")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// recordingWriter keeps a copy of the response so it can be replayed.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// idempotent runs next at most once per Idempotency-Key & API key.
+func idempotent(store IdempotencyStore, next apiHandler) apiHandler {
+	return func(w http.ResponseWriter, r *http.Request, key *APIKey) {
+		idempotencyKey := r.Header.Get("Idempotency-Key")
+		if idempotencyKey == "" || len(idempotencyKey) > 255 {
+			writeAPIError(w, http.StatusBadRequest, "idempotency_key_required", "write requests need an Idempotency-Key header of at most 255 characters")
+			return
+		}
+		once(store, idempotencyKey, next)(w, r, key)
+	}
+}
+
+// once runs next at most once per idempotencyKey & API key. Retries get the
+// first response back, or a conflict while it's still running. Server errors
+// release the key, as nothing was written.
+func once(store IdempotencyStore, idempotencyKey string, next apiHandler) apiHandler {
+	return func(w http.ResponseWriter, r *http.Request, key *APIKey) {
+		body, err := readBody(r)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid_request", "could not read request body")
+			return
+		}
+		sum := sha256.Sum256(append([]byte(r.Method+" "+r.URL.Path+"\n"), body...))
+		fingerprint := hex.EncodeToString(sum[:])
+
+		ctx := r.Context()
+		existing, err := store.BeginRequest(ctx, key.Prefix, idempotencyKey, fingerprint, idempotencyKeyTTL, idempotencyLease)
+		if err != nil {
+			tracing.Logger(ctx).Error().Err(err).Msg("Failed to claim idempotency key")
+			writeAPIError(w, http.StatusInternalServerError, "internal", "internal error")
+			return
+		}
+		switch {
+		case existing == nil:
+		case existing.Fingerprint != fingerprint:
+			writeAPIError(w, http.StatusUnprocessableEntity, "idempotency_key_reused", "Idempotency-Key was already used for a different request")
+			return
+		case existing.CompletedAt == nil:
+			writeAPIError(w, http.StatusConflict, "request_in_progress", "a request with this Idempotency-Key is still in progress")
+			return
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(existing.Status)
+			w.Write(existing.Body)
+			return
+		}
+
+		recorder := &recordingWriter{ResponseWriter: w}
+		next(recorder, r, key)
+
+		// The client may have gone, but the outcome must still be recorded.
+		ctx = detach(ctx)
+		if recorder.status >= http.StatusInternalServerError {
+			err = store.ReleaseRequest(ctx, key.Prefix, idempotencyKey)
+		} else {
+			err = store.CompleteRequest(ctx, key.Prefix, idempotencyKey, recorder.status, recorder.body.Bytes())
+		}
+		if err != nil {
+			tracing.Logger(ctx).Error().Err(err).Msg("Failed to record idempotent response")
+		}
+	}
+}
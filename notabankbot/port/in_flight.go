@@ -0,0 +1,99 @@
+package port
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/yammine/yamex-go"
+)
+
+const (
+	ErrShuttingDown  = yamex.Sentinel("shutting down")
+	ErrWorkAbandoned = yamex.Sentinel("work abandoned at shutdown")
+)
+
+// InFlight tracks work that carries on after Slack has been answered, e.g.
+// slash commands, so shutdown can wait for it. A nil InFlight runs work
+// untracked.
+type InFlight struct {
+	mu       sync.Mutex
+	draining bool
+	running  map[string]int
+	done     chan struct{}
+}
+
+func NewInFlight() *InFlight {
+	return &InFlight{running: map[string]int{}}
+}
+
+// Go runs fn in the background. Once draining it refuses new work, returning
+// false without running fn.
+func (f *InFlight) Go(kind string, fn func()) bool {
+	if f == nil {
+		go fn()
+		return true
+	}
+
+	f.mu.Lock()
+	if f.draining {
+		f.mu.Unlock()
+		return false
+	}
+	f.running[kind]++
+	f.mu.Unlock()
+
+	go func() {
+		defer f.finished(kind)
+		fn()
+	}()
+	return true
+}
+
+func (f *InFlight) finished(kind string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.running[kind]--; f.running[kind] == 0 {
+		delete(f.running, kind)
+	}
+	if f.draining && len(f.running) == 0 {
+		close(f.done)
+		f.done = nil
+	}
+}
+
+// Drain refuses new work and waits for running work to finish, or for ctx to
+// be done, in which case the work still running is reported as abandoned.
+func (f *InFlight) Drain(ctx context.Context) error {
+	f.mu.Lock()
+	f.draining = true
+	if len(f.running) == 0 {
+		f.mu.Unlock()
+		return nil
+	}
+	if f.done == nil {
+		f.done = make(chan struct{})
+	}
+	done := f.done
+	f.mu.Unlock()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.running) == 0 {
+		return nil
+	}
+	kinds := make([]string, 0, len(f.running))
+	for kind, n := range f.running {
+		kinds = append(kinds, fmt.Sprintf("%d %s", n, kind))
+	}
+	sort.Strings(kinds)
+	return fmt.Errorf("%w: %s", ErrWorkAbandoned, strings.Join(kinds, ", "))
+}
@@ -0,0 +1,386 @@
+package port
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/shopspring/decimal"
+
+	"github.com/yammine/yamex-go"
+	"github.com/yammine/yamex-go/notabankbot/app"
+	"github.com/yammine/yamex-go/notabankbot/tracing"
+)
+
+const (
+	ErrUnknownIntegration   = yamex.Sentinel("unknown or disabled integration")
+	ErrInvalidIntegration   = yamex.Sentinel("invalid integration")
+	ErrIntegrationMapping   = yamex.Sentinel("payload doesn't map to an award")
+	ErrInvalidHookSignature = yamex.Sentinel("missing or wrong webhook signature")
+)
+
+// IntegrationMode is how an integration awards currency.
+type IntegrationMode string
+
+const (
+	// IntegrationGrant grants the grant policy's amount, issued fresh, with the
+	// treasury as the granter.
+	IntegrationGrant IntegrationMode = "grant"
+	// IntegrationTransfer moves the mapped amount out of the treasury's
+	// balance.
+	IntegrationTransfer IntegrationMode = "transfer"
+)
+
+// Integration lets an external system, e.g. CI or the support desk, award
+// currency by posting signed webhooks. Its mappings pick the award out of the
+// payload: each is either a JSONPath like $.pull_request.user.login, or a
+// literal like $karma, optionally with {$.path} placeholders.
+type Integration struct {
+	Name        string
+	WorkspaceID string
+	// Secret signs payloads. It's needed to check their HMACs, so stores
+	// encrypt rather than hash it.
+	Secret string
+	Mode   IntegrationMode
+	// TreasuryID is the Slack user awards come from.
+	TreasuryID string
+	Recipient  string
+	Currency   string
+	// Amount is only mapped for transfers, grants are worth the grant policy.
+	Amount string
+	Note   string
+	// When, if set, skips payloads where it doesn't pick a truthy value.
+	When       string
+	CreatedAt  time.Time
+	DisabledAt *time.Time
+}
+
+type IntegrationStore interface {
+	SaveIntegration(ctx context.Context, integration *Integration) error
+	// GetIntegration returns ErrUnknownIntegration if there's no such
+	// integration, or it was disabled.
+	GetIntegration(ctx context.Context, name string) (*Integration, error)
+	ListIntegrations(ctx context.Context, workspaceID string) ([]*Integration, error)
+	DisableIntegration(ctx context.Context, name string) error
+}
+
+var integrationNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// NewIntegration checks the integration's settings and generates its secret.
+func NewIntegration(integration *Integration) error {
+	var problems []string
+	if !integrationNamePattern.MatchString(integration.Name) {
+		problems = append(problems, "name must be lowercase letters, digits, - or _")
+	}
+	if integration.WorkspaceID == "" {
+		problems = append(problems, "workspace is required")
+	}
+	if !slackUserIDPattern.MatchString(integration.TreasuryID) {
+		problems = append(problems, "treasury must be a Slack user ID")
+	}
+	switch integration.Mode {
+	case IntegrationGrant:
+		if integration.Amount != "" {
+			problems = append(problems, "grants are worth the grant policy's amount, so can't map one")
+		}
+	case IntegrationTransfer:
+		if integration.Amount == "" {
+			problems = append(problems, "transfers need an amount")
+		}
+	default:
+		problems = append(problems, "mode must be grant or transfer")
+	}
+	if integration.Recipient == "" || integration.Currency == "" {
+		problems = append(problems, "recipient and currency are required")
+	}
+	for _, expr := range []string{integration.Recipient, integration.Currency, integration.Amount, integration.Note, integration.When} {
+		if err := validateMapping(expr); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidIntegration, strings.Join(problems, ", "))
+	}
+
+	secret, err := randomHex(24)
+	if err != nil {
+		return err
+	}
+	integration.Secret = "whsec_" + secret
+	integration.CreatedAt = time.Now()
+	return nil
+}
+
+// Award is what a payload maps to.
+type Award struct {
+	Mode       IntegrationMode `json:"mode"`
+	TreasuryID string          `json:"treasury_id"`
+	Recipient  string          `json:"recipient"`
+	Currency   string          `json:"currency"`
+	Amount     decimal.Decimal `json:"amount"`
+	Note       string          `json:"note"`
+}
+
+// MapAward picks the award out of a JSON payload. It returns nil, with no
+// error, when the integration's When condition skips the payload. grantAmount
+// is what a grant is worth.
+func (i *Integration) MapAward(payload []byte, grantAmount decimal.Decimal) (*Award, error) {
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("%w: payload isn't JSON", ErrIntegrationMapping)
+	}
+
+	if i.When != "" {
+		value, err := resolveMapping(doc, i.When)
+		if errors.Is(err, ErrJSONPathMissing) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: when: %v", ErrIntegrationMapping, err)
+		}
+		if !truthy(value) {
+			return nil, nil
+		}
+	}
+
+	award := &Award{Mode: i.Mode, TreasuryID: i.TreasuryID, Amount: grantAmount}
+	fields := []struct {
+		name string
+		expr string
+		dest *string
+	}{
+		{"recipient", i.Recipient, &award.Recipient},
+		{"currency", i.Currency, &award.Currency},
+		{"note", i.Note, &award.Note},
+	}
+	for _, f := range fields {
+		value, err := resolveMapping(doc, f.expr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrIntegrationMapping, f.name, err)
+		}
+		*f.dest = strings.TrimSpace(jsonScalar(value))
+	}
+
+	switch {
+	case !slackUserIDPattern.MatchString(award.Recipient):
+		return nil, fmt.Errorf("%w: recipient %q isn't a Slack user ID", ErrIntegrationMapping, award.Recipient)
+	case !currencyPattern.MatchString(award.Currency):
+		return nil, fmt.Errorf("%w: currency %q must be letters, optionally starting with $", ErrIntegrationMapping, award.Currency)
+	case award.Recipient == award.TreasuryID:
+		return nil, fmt.Errorf("%w: the treasury can't award itself", ErrIntegrationMapping)
+	}
+
+	if i.Mode == IntegrationTransfer {
+		value, err := resolveMapping(doc, i.Amount)
+		if err != nil {
+			return nil, fmt.Errorf("%w: amount: %v", ErrIntegrationMapping, err)
+		}
+		amount, err := decimal.NewFromString(jsonScalar(value))
+		if err != nil || !amount.IsPositive() {
+			return nil, fmt.Errorf("%w: amount %q must be a number more than zero", ErrIntegrationMapping, jsonScalar(value))
+		}
+		award.Amount = amount
+	}
+	return award, nil
+}
+
+// truthy follows JavaScript, except that "false" and "0" are false too, as
+// systems often send flags as strings.
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != "" && v != "false" && v != "0"
+	case json.Number:
+		f, err := v.Float64()
+		return err == nil && f != 0
+	default:
+		return true
+	}
+}
+
+// HookSignatureTolerance is how far a signature's timestamp may be from now.
+// Older payloads are refused, so a captured one can't be replayed later.
+const HookSignatureTolerance = 5 * time.Minute
+
+// VerifyHookSignature checks an X-Yamex-Signature header, "t=<unix time>,
+// v1=<hex>", signed as SignWebhook signs outbound payloads. It returns the
+// signature, which is unique to the timestamp & body.
+func VerifyHookSignature(secret string, body []byte, header string, now time.Time) (string, error) {
+	var timestamp string
+	var given [][]byte
+	for _, part := range strings.Split(header, ",") {
+		name, value := part, ""
+		if i := strings.Index(part, "="); i >= 0 {
+			name, value = part[:i], part[i+1:]
+		}
+		switch strings.TrimSpace(name) {
+		case "t":
+			timestamp = value
+		case "v1":
+			if sig, err := hex.DecodeString(value); err == nil {
+				given = append(given, sig)
+			}
+		}
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("%w: no timestamp", ErrInvalidHookSignature)
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > HookSignatureTolerance || age < -HookSignatureTolerance {
+		return "", fmt.Errorf("%w: timestamp is outside the tolerance", ErrInvalidHookSignature)
+	}
+
+	expected := SignWebhook(secret, timestamp, body)
+	want, _ := hex.DecodeString(expected)
+	for _, sig := range given {
+		if hmac.Equal(sig, want) {
+			return expected, nil
+		}
+	}
+	return "", ErrInvalidHookSignature
+}
+
+// IntegrationHooks serves POST /hooks/{integration}, awarding currency for
+// signed payloads from external systems.
+type IntegrationHooks struct {
+	app          *app.Application
+	integrations IntegrationStore
+	idempotency  IdempotencyStore
+}
+
+func NewIntegrationHooks(app *app.Application, integrations IntegrationStore, idempotency IdempotencyStore) *IntegrationHooks {
+	return &IntegrationHooks{app: app, integrations: integrations, idempotency: idempotency}
+}
+
+// Register adds the hook route, relative to router.
+func (h *IntegrationHooks) Register(router *mux.Router) {
+	router.HandleFunc("/{integration}", h.receive).Methods(http.MethodPost)
+}
+
+// Headers carrying a sender's delivery ID, which stays the same when it
+// retries.
+var hookDeliveryHeaders = []string{"Idempotency-Key", "X-GitHub-Delivery"}
+
+type awardResponse struct {
+	DryRun         bool   `json:"dry_run"`
+	Skipped        bool   `json:"skipped,omitempty"`
+	Award          *Award `json:"award,omitempty"`
+	JournalEntryID uint   `json:"journal_entry_id,omitempty"`
+}
+
+func (h *IntegrationHooks) receive(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	integration, err := h.integrations.GetIntegration(ctx, mux.Vars(r)["integration"])
+	if errors.Is(err, ErrUnknownIntegration) {
+		writeAPIError(w, http.StatusNotFound, "unknown_integration", ErrUnknownIntegration.Error())
+		return
+	}
+	if err != nil {
+		writeAppError(w, r, err)
+		return
+	}
+	body, err := readBody(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "could not read request body")
+		return
+	}
+	signature, err := VerifyHookSignature(integration.Secret, body, r.Header.Get("X-Yamex-Signature"), time.Now())
+	if err != nil {
+		writeAPIError(w, http.StatusUnauthorized, "invalid_signature", err.Error())
+		return
+	}
+
+	award := func(w http.ResponseWriter, r *http.Request, _ *APIKey) {
+		h.award(w, r, integration, body)
+	}
+	if r.URL.Query().Get("dry_run") != "true" {
+		// Redeliveries, which are signed afresh, get the first response back
+		// rather than a second award.
+		for _, header := range hookDeliveryHeaders {
+			if id := r.Header.Get(header); id != "" && len(id) <= 255 {
+				award = once(h.idempotency, "delivery:"+id, award)
+				break
+			}
+		}
+		// As do replays within the signature's tolerance, whatever their
+		// headers.
+		award = once(h.idempotency, "signature:"+signature, award)
+	}
+	award(w, r, &APIKey{Prefix: "integration:" + integration.Name, WorkspaceID: integration.WorkspaceID})
+}
+
+func (h *IntegrationHooks) award(w http.ResponseWriter, r *http.Request, integration *Integration, body []byte) {
+	ctx := r.Context()
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+	award, err := integration.MapAward(body, h.app.GrantPolicy().Amount)
+	if err != nil {
+		writeAPIError(w, http.StatusUnprocessableEntity, "mapping_failed", err.Error())
+		return
+	}
+	if award == nil || dryRun {
+		writeJSON(w, http.StatusOK, awardResponse{DryRun: dryRun, Skipped: award == nil, Award: award})
+		return
+	}
+
+	var entryID uint
+	switch integration.Mode {
+	case IntegrationGrant:
+		grant, err := h.app.Grant(ctx, &app.GrantInput{
+			WorkspaceID: integration.WorkspaceID,
+			GranterID:   award.TreasuryID,
+			ReceiverID:  award.Recipient,
+			Platform:    "integration",
+			Currency:    award.Currency,
+			Note:        award.Note,
+			// The recipient comes from the payload, so may be anyone.
+			WorkspaceUsersOnly: true,
+		})
+		if err != nil {
+			writeAppError(w, r, err)
+			return
+		}
+		entryID = grant.Movement.JournalEntryID
+	case IntegrationTransfer:
+		entry, err := h.app.Transfer(ctx, &app.TransferInput{
+			WorkspaceID: integration.WorkspaceID,
+			SenderID:    award.TreasuryID,
+			ReceiverID:  award.Recipient,
+			Platform:    "integration",
+			Currency:    award.Currency,
+			Amount:      award.Amount,
+			Note:        award.Note,
+			// The recipient comes from the payload, so may be anyone.
+			WorkspaceUsersOnly: true,
+		})
+		if err != nil {
+			writeAppError(w, r, err)
+			return
+		}
+		entryID = entry.ID
+	}
+
+	tracing.Logger(ctx).Info().
+		Str("integration", integration.Name).
+		Str("recipient", award.Recipient).
+		Str("currency", award.Currency).
+		Str("amount", award.Amount.String()).
+		Uint("journal_entry_id", entryID).
+		Msg("Integration awarded currency")
+	writeJSON(w, http.StatusCreated, awardResponse{Award: award, JournalEntryID: entryID})
+}
@@ -0,0 +1,195 @@
+package port_test
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/yammine/yamex-go/notabankbot/port"
+)
+
+func TestVerifyHookSignature(t *testing.T) {
+	const secret = "whsec_test"
+	body := []byte(`{"number":42}`)
+	now := time.Unix(1700000000, 0)
+	header := func(at time.Time, secret string, body []byte) string {
+		timestamp := strconv.FormatInt(at.Unix(), 10)
+		return "t=" + timestamp + ",v1=" + port.SignWebhook(secret, timestamp, body)
+	}
+	signed := header(now, secret, body)
+
+	tests := []struct {
+		name   string
+		header string
+		body   []byte
+		want   error
+	}{
+		{name: "valid", header: signed},
+		{name: "just within the tolerance", header: header(now.Add(-port.HookSignatureTolerance), secret, body)},
+		{name: "clock ahead within the tolerance", header: header(now.Add(port.HookSignatureTolerance), secret, body)},
+		{name: "too old", header: header(now.Add(-port.HookSignatureTolerance-time.Second), secret, body), want: port.ErrInvalidHookSignature},
+		{name: "too far ahead", header: header(now.Add(port.HookSignatureTolerance+time.Second), secret, body), want: port.ErrInvalidHookSignature},
+		{name: "one of several signatures", header: signed + ",v1=00ff," + "v1=not-hex"},
+		{name: "spaces after commas", header: "t=" + strconv.FormatInt(now.Unix(), 10) + ", v1=" + port.SignWebhook(secret, strconv.FormatInt(now.Unix(), 10), body)},
+		{name: "other secret", header: header(now, "whsec_other", body), want: port.ErrInvalidHookSignature},
+		{name: "body changed", header: signed, body: []byte(`{"number":43}`), want: port.ErrInvalidHookSignature},
+		{name: "timestamp changed", header: "t=" + strconv.FormatInt(now.Unix()+1, 10) + signed[len("t=1700000000"):], want: port.ErrInvalidHookSignature},
+		{name: "no signature", header: "t=" + strconv.FormatInt(now.Unix(), 10), want: port.ErrInvalidHookSignature},
+		{name: "no timestamp", header: "v1=" + port.SignWebhook(secret, "", body), want: port.ErrInvalidHookSignature},
+		{name: "empty", header: "", want: port.ErrInvalidHookSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.body == nil {
+				tt.body = body
+			}
+			signature, err := port.VerifyHookSignature(secret, tt.body, tt.header, now)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("VerifyHookSignature() = %v, want %v", err, tt.want)
+			}
+			if err == nil && signature == "" {
+				t.Error("VerifyHookSignature() returned no signature")
+			}
+		})
+	}
+}
+
+// Replays are told apart by the signature VerifyHookSignature returns, so it
+// must be the same for a replay and differ once the sender signs afresh.
+func TestVerifyHookSignatureReplay(t *testing.T) {
+	const secret = "whsec_test"
+	body := []byte(`{"number":42}`)
+	now := time.Unix(1700000000, 0)
+	sign := func(at time.Time) string {
+		timestamp := strconv.FormatInt(at.Unix(), 10)
+		return "t=" + timestamp + ",v1=" + port.SignWebhook(secret, timestamp, body)
+	}
+
+	first, err := port.VerifyHookSignature(secret, body, sign(now), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed, err := port.VerifyHookSignature(secret, body, sign(now)+",v1=00ff", now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed != first {
+		t.Errorf("replay's signature = %s, want the first delivery's %s", replayed, first)
+	}
+	resigned, err := port.VerifyHookSignature(secret, body, sign(now.Add(time.Second)), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resigned == first {
+		t.Error("a payload signed afresh has the same signature as the first")
+	}
+	if _, err := port.VerifyHookSignature(secret, body, sign(now), now.Add(port.HookSignatureTolerance+time.Second)); !errors.Is(err, port.ErrInvalidHookSignature) {
+		t.Errorf("replay after the tolerance = %v, want %v", err, port.ErrInvalidHookSignature)
+	}
+}
+
+func TestMapAward(t *testing.T) {
+	grant := port.Integration{
+		Mode:       port.IntegrationGrant,
+		TreasuryID: "UTREASURY00",
+		Recipient:  "$.sender.slack_id",
+		Currency:   "$kudos",
+		Note:       "Merged #{$.number}",
+		When:       "$.merged",
+	}
+	transfer := grant
+	transfer.Mode = port.IntegrationTransfer
+	transfer.Amount = "$.points"
+	transfer.When = ""
+
+	tests := []struct {
+		name        string
+		integration port.Integration
+		payload     string
+		want        *port.Award
+		err         error
+	}{
+		{
+			name:        "grant",
+			integration: grant,
+			payload:     `{"merged": true, "number": 42, "sender": {"slack_id": " UALICE00000 "}}`,
+			want:        &port.Award{Mode: port.IntegrationGrant, TreasuryID: "UTREASURY00", Recipient: "UALICE00000", Currency: "$kudos", Amount: decimal.New(1, 0), Note: "Merged #42"},
+		},
+		{name: "when is false", integration: grant, payload: `{"merged": false, "sender": {"slack_id": "UALICE00000"}}`},
+		{name: "when is the string false", integration: grant, payload: `{"merged": "false", "sender": {"slack_id": "UALICE00000"}}`},
+		{name: "when is zero", integration: grant, payload: `{"merged": 0, "sender": {"slack_id": "UALICE00000"}}`},
+		{name: "when is null", integration: grant, payload: `{"merged": null, "sender": {"slack_id": "UALICE00000"}}`},
+		{name: "when is missing", integration: grant, payload: `{"sender": {"slack_id": "UALICE00000"}}`},
+		{
+			name:        "when is an object",
+			integration: grant,
+			payload:     `{"merged": {}, "number": 1, "sender": {"slack_id": "UALICE00000"}}`,
+			want:        &port.Award{Mode: port.IntegrationGrant, TreasuryID: "UTREASURY00", Recipient: "UALICE00000", Currency: "$kudos", Amount: decimal.New(1, 0), Note: "Merged #1"},
+		},
+		{name: "not JSON", integration: grant, payload: `merged`, err: port.ErrIntegrationMapping},
+		{name: "recipient missing", integration: grant, payload: `{"merged": true, "number": 42}`, err: port.ErrIntegrationMapping},
+		{name: "recipient not a Slack ID", integration: grant, payload: `{"merged": true, "number": 42, "sender": {"slack_id": "alice"}}`, err: port.ErrIntegrationMapping},
+		{name: "treasury awarding itself", integration: grant, payload: `{"merged": true, "number": 42, "sender": {"slack_id": "UTREASURY00"}}`, err: port.ErrIntegrationMapping},
+		{name: "note placeholder missing", integration: grant, payload: `{"merged": true, "sender": {"slack_id": "UALICE00000"}}`, err: port.ErrIntegrationMapping},
+		{
+			name:        "transfer",
+			integration: transfer,
+			payload:     `{"number": 42, "points": 2.5, "sender": {"slack_id": "UALICE00000"}}`,
+			want:        &port.Award{Mode: port.IntegrationTransfer, TreasuryID: "UTREASURY00", Recipient: "UALICE00000", Currency: "$kudos", Amount: decimal.New(25, -1), Note: "Merged #42"},
+		},
+		{
+			name:        "transfer amount as a string",
+			integration: transfer,
+			payload:     `{"number": 42, "points": "3", "sender": {"slack_id": "UALICE00000"}}`,
+			want:        &port.Award{Mode: port.IntegrationTransfer, TreasuryID: "UTREASURY00", Recipient: "UALICE00000", Currency: "$kudos", Amount: decimal.New(3, 0), Note: "Merged #42"},
+		},
+		{name: "transfer amount zero", integration: transfer, payload: `{"number": 42, "points": 0, "sender": {"slack_id": "UALICE00000"}}`, err: port.ErrIntegrationMapping},
+		{name: "transfer amount negative", integration: transfer, payload: `{"number": 42, "points": -1, "sender": {"slack_id": "UALICE00000"}}`, err: port.ErrIntegrationMapping},
+		{name: "transfer amount not a number", integration: transfer, payload: `{"number": 42, "points": "lots", "sender": {"slack_id": "UALICE00000"}}`, err: port.ErrIntegrationMapping},
+		{name: "transfer amount missing", integration: transfer, payload: `{"number": 42, "sender": {"slack_id": "UALICE00000"}}`, err: port.ErrIntegrationMapping},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			award, err := tt.integration.MapAward([]byte(tt.payload), decimal.New(1, 0))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("MapAward() error = %v, want %v", err, tt.err)
+			}
+			switch {
+			case tt.want == nil && award != nil:
+				t.Errorf("MapAward() = %+v, want the payload skipped", award)
+			case tt.want != nil && award == nil:
+				t.Errorf("MapAward() skipped the payload, want %+v", tt.want)
+			case tt.want != nil && (award.Mode != tt.want.Mode || award.TreasuryID != tt.want.TreasuryID ||
+				award.Recipient != tt.want.Recipient || award.Currency != tt.want.Currency ||
+				!award.Amount.Equal(tt.want.Amount) || award.Note != tt.want.Note):
+				t.Errorf("MapAward() = %+v, want %+v", award, tt.want)
+			}
+		})
+	}
+}
+
+func TestMapAwardCurrencyFromPayload(t *testing.T) {
+	integration := port.Integration{Mode: port.IntegrationGrant, TreasuryID: "UTREASURY00", Recipient: "$.to", Currency: "$.currency"}
+	tests := []struct {
+		currency string
+		err      error
+	}{
+		{currency: `"$coffee"`},
+		{currency: `"tea"`},
+		{currency: `"$coffee beans"`, err: port.ErrIntegrationMapping},
+		{currency: `"$1"`, err: port.ErrIntegrationMapping},
+		{currency: `""`, err: port.ErrIntegrationMapping},
+		{currency: `5`, err: port.ErrIntegrationMapping},
+	}
+	for _, tt := range tests {
+		t.Run(tt.currency, func(t *testing.T) {
+			_, err := integration.MapAward([]byte(`{"to": "UALICE00000", "currency": `+tt.currency+`}`), decimal.New(1, 0))
+			if !errors.Is(err, tt.err) {
+				t.Errorf("MapAward() error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
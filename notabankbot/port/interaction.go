@@ -9,16 +9,18 @@ import (
 	"net/url"
 
 	"github.com/yammine/yamex-go/notabankbot/app"
-
-	"github.com/rs/zerolog/log"
+	"github.com/yammine/yamex-go/notabankbot/domain"
+	"github.com/yammine/yamex-go/notabankbot/metrics"
+	"github.com/yammine/yamex-go/notabankbot/tracing"
 
 	"github.com/slack-go/slack"
-	"github.com/spf13/viper"
 )
 
 type SlackInteractor struct {
-	app         *app.Application
-	credentials SlackCredentialStore
+	app           *app.Application
+	dispatcher    *SlackDispatcher
+	signingSecret string
+	inFlight      *InFlight
 }
 
 type Channel struct {
@@ -55,10 +57,14 @@ type SlackInteraction struct {
 	Actions []*Action `json:"actions"`
 }
 
-func NewSlackInteractor(credentials SlackCredentialStore, app *app.Application) *SlackInteractor {
+// NewSlackInteractor wires the interactor. inFlight may be nil, in which case
+// shutdown doesn't wait for interactions.
+func NewSlackInteractor(app *app.Application, dispatcher *SlackDispatcher, signingSecret string, inFlight *InFlight) *SlackInteractor {
 	return &SlackInteractor{
-		app:         app,
-		credentials: credentials,
+		app:           app,
+		dispatcher:    dispatcher,
+		signingSecret: signingSecret,
+		inFlight:      inFlight,
 	}
 }
 
@@ -70,18 +76,8 @@ func (s SlackInteractor) Handler() func(w http.ResponseWriter, r *http.Request)
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		sv, err := slack.NewSecretsVerifier(r.Header, viper.GetString("SLACK_SIGNING_SECRET"))
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		if _, err := sv.Write(body); err != nil {
-			fmt.Println("error verifying r body")
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-		if err := sv.Ensure(); err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
+		if status := verifySignature(r.Context(), s.signingSecret, r.Header, body); status != 0 {
+			w.WriteHeader(status)
 			return
 		}
 
@@ -103,48 +99,49 @@ func (s SlackInteractor) Handler() func(w http.ResponseWriter, r *http.Request)
 		}
 
 		// Business logic
-		go func() {
-			s.ProcessInteraction(res)
-		}()
+		ctx := detach(r.Context())
+		if !s.inFlight.Go("interaction", func() { s.ProcessInteraction(ctx, res) }) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
 
 		w.WriteHeader(200)
 	}
 }
 
-func (s SlackInteractor) ProcessInteraction(i *SlackInteraction) error {
-	// setup
-	token, err := s.credentials.GetCredentials(context.Background(), i.Team.ID)
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to get slack credentials")
-		return err
-	}
-	client := slack.New(token, slack.OptionDebug(true))
+func (s SlackInteractor) ProcessInteraction(ctx context.Context, i *SlackInteraction) error {
+	ctx, span := tracer.Start(ctx, "slack.ProcessInteraction")
+	defer span.End()
+	metrics.Interactions.WithLabelValues(i.Type).Inc()
 	var response string
 
+	if _, err := s.app.JoinWorkspace(ctx, i.Team.ID, i.User.ID); err != nil {
+		tracing.Logger(ctx).Error().Err(err).Str("team", i.Team.ID).Msg("Failed to record workspace user")
+	}
+
 	// Process value
 	for j := range i.Actions {
 		action := i.Actions[j]
-		s.app.SaveFeedback(context.Background(), i.User.ID, action.Value)
-		log.Debug().Msgf("Action: %+v", action)
+		s.app.SaveFeedback(ctx, i.User.ID, action.Value)
+		tracing.Logger(ctx).Debug().Msgf("Action: %+v", action)
 	}
 
 	response = "Thanks for the feedback!"
 
 	// Reply
-	s.respondToAction(client, i, response)
+	s.respondToAction(ctx, i, response)
 
 	return nil
 }
 
-func (s SlackInteractor) respondToAction(client *slack.Client, i *SlackInteraction, response string) {
-	_, _, _, err := client.SendMessage(
-		i.Channel.ID,
-		slack.MsgOptionText(response, false),
-		slack.MsgOptionResponseURL(i.ResponseURL, "ephemeral"),
-		slack.MsgOptionReplaceOriginal(i.ResponseURL),
-	)
-
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to respond to action")
-	}
+func (s SlackInteractor) respondToAction(ctx context.Context, i *SlackInteraction, response string) {
+	s.dispatcher.Send(ctx, &domain.OutboxMessage{
+		TraceParent:     tracing.TraceParent(ctx),
+		WorkspaceID:     i.Team.ID,
+		ChannelID:       i.Channel.ID,
+		EphemeralUserID: i.User.ID,
+		ResponseURL:     i.ResponseURL,
+		ReplaceOriginal: true,
+		Text:            response,
+	})
 }
@@ -0,0 +1,131 @@
+package port
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/yammine/yamex-go"
+)
+
+const (
+	ErrInvalidJSONPath = yamex.Sentinel("invalid JSONPath expression")
+	ErrJSONPathMissing = yamex.Sentinel("JSONPath matched nothing in the payload")
+)
+
+// jsonPathSegment is a step into a JSON document: an object key, or an array
+// index when key is empty.
+type jsonPathSegment struct {
+	key   string
+	index int
+}
+
+var (
+	jsonPathName        = regexp.MustCompile(`^\.([A-Za-z0-9_-]+)`)
+	jsonPathQuotedKey   = regexp.MustCompile(`^\[(?:'([^']+)'|"([^"]+)")\]`)
+	jsonPathIndex       = regexp.MustCompile(`^\[(\d+)\]`)
+	jsonPathPlaceholder = regexp.MustCompile(`\{(\$[^{}]*)\}`)
+)
+
+// isJSONPath tells expressions from literals. A currency like $karma is a
+// literal, paths start with "$." or "$[".
+func isJSONPath(expr string) bool {
+	return expr == "$" || strings.HasPrefix(expr, "$.") || strings.HasPrefix(expr, "$[")
+}
+
+// parseJSONPath parses the subset of JSONPath that picks one value:
+// $.a.b, $['a b'] and $.a[0]. Quoted keys can't be empty, as that's how a
+// segment marks an index.
+func parseJSONPath(expr string) ([]jsonPathSegment, error) {
+	if !isJSONPath(expr) {
+		return nil, fmt.Errorf("%w: %q must start with $. or $[", ErrInvalidJSONPath, expr)
+	}
+	var segments []jsonPathSegment
+	for rest := expr[1:]; rest != ""; {
+		if m := jsonPathName.FindStringSubmatch(rest); m != nil {
+			segments = append(segments, jsonPathSegment{key: m[1]})
+			rest = rest[len(m[0]):]
+		} else if m := jsonPathQuotedKey.FindStringSubmatch(rest); m != nil {
+			segments = append(segments, jsonPathSegment{key: m[1] + m[2]})
+			rest = rest[len(m[0]):]
+		} else if m := jsonPathIndex.FindStringSubmatch(rest); m != nil {
+			index, _ := strconv.Atoi(m[1])
+			segments = append(segments, jsonPathSegment{index: index})
+			rest = rest[len(m[0]):]
+		} else {
+			return nil, fmt.Errorf("%w: can't parse %q in %q", ErrInvalidJSONPath, rest, expr)
+		}
+	}
+	return segments, nil
+}
+
+// evalJSONPath picks the value at expr from a document decoded into
+// interface{}.
+func evalJSONPath(doc interface{}, expr string) (interface{}, error) {
+	segments, err := parseJSONPath(expr)
+	if err != nil {
+		return nil, err
+	}
+	value := doc
+	for _, s := range segments {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			found, ok := v[s.key]
+			if s.key == "" || !ok {
+				return nil, fmt.Errorf("%w: %s", ErrJSONPathMissing, expr)
+			}
+			value = found
+		case []interface{}:
+			if s.key != "" || s.index >= len(v) {
+				return nil, fmt.Errorf("%w: %s", ErrJSONPathMissing, expr)
+			}
+			value = v[s.index]
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrJSONPathMissing, expr)
+		}
+	}
+	return value, nil
+}
+
+// validateMapping checks a mapping expression, either a path or a literal
+// with {$.path} placeholders.
+func validateMapping(expr string) error {
+	if isJSONPath(expr) {
+		_, err := parseJSONPath(expr)
+		return err
+	}
+	for _, m := range jsonPathPlaceholder.FindAllStringSubmatch(expr, -1) {
+		if _, err := parseJSONPath(m[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveMapping evaluates a mapping expression against the payload. A path
+// gives the value it picks, a literal is returned with its placeholders
+// replaced.
+func resolveMapping(doc interface{}, expr string) (interface{}, error) {
+	if isJSONPath(expr) {
+		return evalJSONPath(doc, expr)
+	}
+	var err error
+	resolved := jsonPathPlaceholder.ReplaceAllStringFunc(expr, func(placeholder string) string {
+		value, evalErr := evalJSONPath(doc, placeholder[1:len(placeholder)-1])
+		if evalErr != nil {
+			err = evalErr
+			return ""
+		}
+		return jsonScalar(value)
+	})
+	return resolved, err
+}
+
+// jsonScalar formats a value decoded with UseNumber for text.
+func jsonScalar(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
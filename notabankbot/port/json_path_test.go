@@ -0,0 +1,100 @@
+package port_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/yammine/yamex-go/notabankbot/port"
+)
+
+func TestParseJSONPath(t *testing.T) {
+	tests := []struct {
+		expr string
+		want []string
+		err  error
+	}{
+		{expr: "$"},
+		{expr: "$.a", want: []string{"a"}},
+		{expr: "$.pull_request.user.login", want: []string{"pull_request", "user", "login"}},
+		{expr: "$.a-b.c_d.0", want: []string{"a-b", "c_d", "0"}},
+		{expr: "$['a b']", want: []string{"a b"}},
+		{expr: `$["a.b"].c`, want: []string{"a.b", "c"}},
+		{expr: "$.commits[0].author", want: []string{"commits", "[0]", "author"}},
+		{expr: "$[12]", want: []string{"[12]"}},
+		{expr: "$['']", err: port.ErrInvalidJSONPath},
+		{expr: "$karma", err: port.ErrInvalidJSONPath},
+		{expr: "a.b", err: port.ErrInvalidJSONPath},
+		{expr: "$.", err: port.ErrInvalidJSONPath},
+		{expr: "$.a..b", err: port.ErrInvalidJSONPath},
+		{expr: "$.a[-1]", err: port.ErrInvalidJSONPath},
+		{expr: "$.a[*]", err: port.ErrInvalidJSONPath},
+		{expr: "$['a]", err: port.ErrInvalidJSONPath},
+		{expr: "$.a b", err: port.ErrInvalidJSONPath},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := port.ParseJSONPath(tt.expr)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("ParseJSONPath() error = %v, want %v", err, tt.err)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+				t.Errorf("ParseJSONPath() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveMapping(t *testing.T) {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(`{
+		"number": 42,
+		"merged": true,
+		"sender": {"slack_id": "UALICE00000", "name": null},
+		"labels": ["bug", "urgent"],
+		"a b": "spaced",
+		"points": 2.50
+	}`)))
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		want string
+		err  error
+	}{
+		{name: "path", expr: "$.sender.slack_id", want: "UALICE00000"},
+		{name: "number keeps its text", expr: "$.points", want: "2.50"},
+		{name: "bool", expr: "$.merged", want: "true"},
+		{name: "index", expr: "$.labels[1]", want: "urgent"},
+		{name: "quoted key", expr: "$['a b']", want: "spaced"},
+		{name: "null", expr: "$.sender.name", want: "<nil>"},
+		{name: "literal", expr: "$karma", want: "$karma"},
+		{name: "placeholders", expr: "Merged #{$.number}, {$.labels[0]}", want: "Merged #42, bug"},
+		{name: "null placeholder", expr: "by {$.sender.name}", want: "by "},
+		{name: "missing key", expr: "$.sender.email", err: port.ErrJSONPathMissing},
+		{name: "index past the end", expr: "$.labels[2]", err: port.ErrJSONPathMissing},
+		{name: "key into an array", expr: "$.labels.first", err: port.ErrJSONPathMissing},
+		{name: "index into an object", expr: "$.sender[0]", err: port.ErrJSONPathMissing},
+		{name: "into a scalar", expr: "$.number.value", err: port.ErrJSONPathMissing},
+		{name: "missing placeholder", expr: "Merged #{$.pr}", err: port.ErrJSONPathMissing},
+		{name: "invalid placeholder", expr: "Merged #{$.a..b}", err: port.ErrInvalidJSONPath},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := port.ResolveMapping(doc, tt.expr)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("ResolveMapping(%q) error = %v, want %v", tt.expr, err, tt.err)
+			}
+			if err == nil && fmt.Sprint(got) != tt.want {
+				t.Errorf("ResolveMapping(%q) = %v, want %s", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package port
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/yammine/yamex-go/notabankbot/app"
+)
+
+// RunLedgerCheckpoints signs the head of every workspace's chain each interval
+// until ctx is cancelled.
+func RunLedgerCheckpoints(ctx context.Context, application *app.Application, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checkpoints, err := application.CheckpointLedger(ctx)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to checkpoint ledger")
+				continue
+			}
+			log.Info().Int("workspaces", len(checkpoints)).Msg("Ledger checkpointed")
+		}
+	}
+}
+
+type publicKeyResponse struct {
+	KeyID     string `json:"key_id"`
+	PublicKey string `json:"public_key"`
+}
+
+// LedgerPublicKeyHandler publishes the key that receipts are signed with so
+// they can be verified offline.
+func LedgerPublicKeyHandler(application *app.Application) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		keyID, key, err := application.LedgerPublicKey()
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(publicKeyResponse{
+			KeyID:     keyID,
+			PublicKey: base64.StdEncoding.EncodeToString(key),
+		})
+	}
+}
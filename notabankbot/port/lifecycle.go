@@ -0,0 +1,76 @@
+package port
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type shutdownStage struct {
+	name string
+	stop func(ctx context.Context) error
+}
+
+// Lifecycle stops the server in stages when it's told to quit, so work that
+// was accepted is finished, or at least reported, rather than cut off.
+type Lifecycle struct {
+	timeout time.Duration
+	stages  []shutdownStage
+}
+
+// NewLifecycle gives every stage, together, timeout to finish.
+func NewLifecycle(timeout time.Duration) *Lifecycle {
+	return &Lifecycle{timeout: timeout}
+}
+
+// OnShutdown adds a stage, run after the stages added before it. Stages are
+// all run, even when an earlier one fails or the deadline has passed.
+func (l *Lifecycle) OnShutdown(name string, stop func(ctx context.Context) error) {
+	l.stages = append(l.stages, shutdownStage{name: name, stop: stop})
+}
+
+// Wait blocks until SIGINT or SIGTERM then shuts down. A second signal exits
+// straight away. It reports whether everything stopped cleanly.
+func (l *Lifecycle) Wait() bool {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	sig := <-signals
+	log.Info().Str("signal", sig.String()).Dur("timeout", l.timeout).Msg("Shutting down")
+
+	go func() {
+		sig := <-signals
+		log.Warn().Str("signal", sig.String()).Msg("Shutdown interrupted, exiting now")
+		os.Exit(1)
+	}()
+
+	return l.Shutdown()
+}
+
+// Shutdown runs the stages in order.
+func (l *Lifecycle) Shutdown() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), l.timeout)
+	defer cancel()
+
+	clean := true
+	for _, stage := range l.stages {
+		start := time.Now()
+		err := stage.stop(ctx)
+		logger := log.With().Str("stage", stage.name).Dur("took", time.Since(start)).Logger()
+		switch {
+		case err == nil:
+			logger.Debug().Msg("Shutdown stage done")
+		case errors.Is(err, ErrWorkAbandoned):
+			clean = false
+			logger.Warn().Err(err).Msg("Shutdown abandoned work")
+		default:
+			clean = false
+			logger.Error().Err(err).Msg("Shutdown stage failed")
+		}
+	}
+	return clean
+}
@@ -0,0 +1,48 @@
+package port
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/yammine/yamex-go/notabankbot/metrics"
+)
+
+// instrumentRoutes records request counts and latencies per route. Routes are
+// labelled by their template, so IDs in paths don't blow up cardinality.
+func instrumentRoutes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		metrics.HTTPRequestDuration.WithLabelValues(route).Observe(time.Since(start).Seconds())
+		metrics.HTTPRequests.WithLabelValues(route, strconv.Itoa(recorder.status)).Inc()
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// observeCommand records a processed bot command. Use it deferred, with the
+// time processing started.
+func observeCommand(command *string, start time.Time) {
+	metrics.Commands.WithLabelValues(*command).Inc()
+	metrics.CommandDuration.WithLabelValues(*command).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,272 @@
+package port
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/slack-go/slack"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/yammine/yamex-go/notabankbot/app"
+	"github.com/yammine/yamex-go/notabankbot/domain"
+	"github.com/yammine/yamex-go/notabankbot/metrics"
+	"github.com/yammine/yamex-go/notabankbot/tracing"
+)
+
+const (
+	outboxBatchSize       = 20
+	outboxLease           = time.Minute
+	outboxDeliveryTimeout = 15 * time.Second
+)
+
+// retryableSlackErrors are Web API errors worth another attempt.
+var retryableSlackErrors = map[string]bool{
+	"ratelimited":         true,
+	"internal_error":      true,
+	"fatal_error":         true,
+	"service_unavailable": true,
+	"request_timeout":     true,
+}
+
+// SlackDispatcher delivers the outbox to Slack. Messages are persisted before
+// they are sent, so a failed or interrupted delivery is retried, by any replica.
+type SlackDispatcher struct {
+	app         *app.Application
+	credentials SlackCredentialStore
+	newClient   SlackClientFactory
+	inFlight    *InFlight
+	wake        chan struct{}
+}
+
+// NewSlackDispatcher wires the dispatcher. inFlight, which may be nil, tracks
+// messages sent directly when the outbox is unavailable.
+func NewSlackDispatcher(app *app.Application, credentials SlackCredentialStore, newClient SlackClientFactory, inFlight *InFlight) *SlackDispatcher {
+	return &SlackDispatcher{
+		app:         app,
+		credentials: credentials,
+		newClient:   newClient,
+		inFlight:    inFlight,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Send queues a message and wakes the dispatcher. If the outbox can't be
+// written to, the message is sent directly, once.
+func (d *SlackDispatcher) Send(ctx context.Context, msg *domain.OutboxMessage) {
+	if err := d.app.EnqueueMessage(ctx, msg); err != nil {
+		metrics.OutboxMessages.WithLabelValues("enqueue_error").Inc()
+		log.Error().Err(err).Msg("Failed to enqueue message, sending it directly")
+		send := func() {
+			if err := d.send(detach(ctx), msg); err != nil {
+				log.Error().Err(err).Msg("Failed to send message")
+			}
+		}
+		// Once shutting down, the caller is being waited for, so send in its time.
+		if !d.inFlight.Go("direct_message", send) {
+			send()
+		}
+		return
+	}
+	d.Wake()
+}
+
+// Wake makes Run deliver pending messages now rather than at its next poll.
+func (d *SlackDispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Drain delivers whatever is due, e.g. replies to commands that finished
+// during shutdown, until ctx is done. Messages still pending are reported, they
+// are left in the outbox for the next dispatcher to deliver.
+func (d *SlackDispatcher) Drain(ctx context.Context) error {
+	d.deliverDue(ctx)
+	pending, err := d.app.PendingMessages(ctx)
+	if err != nil {
+		return fmt.Errorf("counting pending messages: %w", err)
+	}
+	if pending > 0 {
+		return fmt.Errorf("%w: %d messages left in the outbox", ErrWorkAbandoned, pending)
+	}
+	return nil
+}
+
+// Run delivers due messages whenever woken, and every interval to pick up
+// retries and messages queued by other replicas, until ctx is cancelled.
+func (d *SlackDispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		d.deliverDue(ctx)
+		d.recordPending(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+func (d *SlackDispatcher) deliverDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		msgs, err := d.app.ClaimMessages(ctx, outboxBatchSize, outboxLease)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to claim outbox messages")
+			return
+		}
+		for _, msg := range msgs {
+			d.deliver(ctx, msg)
+		}
+		if len(msgs) < outboxBatchSize {
+			return
+		}
+	}
+}
+
+func (d *SlackDispatcher) recordPending(ctx context.Context) {
+	pending, err := d.app.PendingMessages(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to count pending outbox messages")
+		return
+	}
+	metrics.OutboxPending.Set(float64(pending))
+}
+
+func (d *SlackDispatcher) deliver(ctx context.Context, msg *domain.OutboxMessage) {
+	// Delivery is its own trace, linked to the one that queued the message.
+	// A delivery that has started is finished even if Run is being stopped.
+	ctx, span := tracer.Start(detach(ctx), "outbox.Deliver", tracing.LinkTo(msg.TraceParent), trace.WithNewRoot())
+	defer span.End()
+	span.SetAttributes(attribute.Int64("yamex.outbox_message", int64(msg.ID)), attribute.Int("yamex.attempt", msg.Attempts+1))
+
+	sendCtx, cancel := context.WithTimeout(ctx, outboxDeliveryTimeout)
+	err := d.send(sendCtx, msg)
+	cancel()
+
+	if err == nil {
+		metrics.OutboxMessages.WithLabelValues("delivered").Inc()
+		if err := d.app.MessageDelivered(ctx, msg); err != nil {
+			tracing.Logger(ctx).Error().Err(err).Uint("outbox_message", msg.ID).Msg("Failed to mark message delivered")
+		}
+		return
+	}
+	span.RecordError(err)
+
+	failure := classifySlackError(err)
+	retry, saveErr := d.app.MessageFailed(ctx, msg, failure)
+	if saveErr != nil {
+		tracing.Logger(ctx).Error().Err(saveErr).Uint("outbox_message", msg.ID).Msg("Failed to record delivery failure")
+	}
+	logger := tracing.Logger(ctx).With().Err(err).Uint("outbox_message", msg.ID).Int("attempts", msg.Attempts).Logger()
+	if retry {
+		metrics.OutboxMessages.WithLabelValues("retried").Inc()
+		logger.Warn().Time("next_attempt", msg.NextAttemptAt).Msg("Failed to deliver message, retrying")
+		// Retries are often due before the next poll.
+		time.AfterFunc(time.Until(msg.NextAttemptAt), d.Wake)
+	} else {
+		metrics.OutboxMessages.WithLabelValues("failed").Inc()
+		logger.Error().Msg("Failed to deliver message, giving up")
+	}
+}
+
+func (d *SlackDispatcher) send(ctx context.Context, msg *domain.OutboxMessage) error {
+	creds, err := d.credentials.GetCredentials(ctx, msg.WorkspaceID)
+	if errors.Is(err, ErrUnknownWorkspace) {
+		return err
+	}
+	if err != nil {
+		return retryableError{fmt.Errorf("getting credentials: %w", err)}
+	}
+
+	opts := make([]slack.MsgOption, 0)
+	if msg.Text != "" {
+		opts = append(opts, slack.MsgOptionText(msg.Text, false))
+	}
+	if len(msg.Blocks) > 0 {
+		var blocks []*Block
+		if err := json.Unmarshal(msg.Blocks, &blocks); err != nil {
+			return fmt.Errorf("decoding blocks: %w", err)
+		}
+		set := make([]slack.Block, len(blocks))
+		for i, b := range blocks {
+			set[i] = b
+		}
+		opts = append(opts, slack.MsgOptionBlocks(set...))
+	}
+	switch {
+	case msg.ResponseURL != "":
+		responseType := slack.ResponseTypeInChannel
+		if msg.EphemeralUserID != "" {
+			responseType = slack.ResponseTypeEphemeral
+		}
+		opts = append(opts, slack.MsgOptionResponseURL(msg.ResponseURL, responseType))
+		if msg.ReplaceOriginal {
+			opts = append(opts, slack.MsgOptionReplaceOriginal(msg.ResponseURL))
+		}
+	case msg.EphemeralUserID != "":
+		opts = append(opts, slack.MsgOptionPostEphemeral(msg.EphemeralUserID))
+	case msg.ThreadTS != "":
+		opts = append(opts, slack.MsgOptionTS(msg.ThreadTS))
+	}
+
+	_, _, _, err = d.newClient(creds.Token).SendMessageContext(ctx, msg.ChannelID, opts...)
+	return err
+}
+
+// classifySlackError decides whether a failed delivery is worth retrying.
+func classifySlackError(err error) *app.DeliveryFailure {
+	if errors.Is(err, ErrUnknownWorkspace) {
+		return &app.DeliveryFailure{Err: err, Permanent: true}
+	}
+	var rateLimited *slack.RateLimitedError
+	if errors.As(err, &rateLimited) {
+		return &app.DeliveryFailure{Err: err, RetryAfter: rateLimited.RetryAfter}
+	}
+	var retryable interface{ Retryable() bool }
+	if errors.As(err, &retryable) {
+		return &app.DeliveryFailure{Err: err, Permanent: !retryable.Retryable()}
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) {
+		return &app.DeliveryFailure{Err: err}
+	}
+
+	return &app.DeliveryFailure{Err: err, Permanent: !retryableSlackErrors[err.Error()]}
+}
+
+// retryableError marks failures on our side, e.g. the credential store being
+// unavailable, as worth retrying.
+type retryableError struct {
+	error
+}
+
+func (retryableError) Retryable() bool {
+	return true
+}
+
+func (e retryableError) Unwrap() error {
+	return e.error
+}
+
+// outboxBlocks encodes blocks for the outbox.
+func outboxBlocks(blocks []slack.Block) []byte {
+	if len(blocks) == 0 {
+		return nil
+	}
+	encoded, err := json.Marshal(blocks)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to encode blocks")
+		return nil
+	}
+	return encoded
+}
@@ -3,20 +3,24 @@ package port
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/olekukonko/tablewriter"
 	"github.com/rs/zerolog"
 	_ "github.com/rs/zerolog"
-	"github.com/rs/zerolog/log"
 	"github.com/shopspring/decimal"
 	"github.com/slack-go/slack"
+	"go.opentelemetry.io/otel/attribute"
 
 	"github.com/yammine/yamex-go/notabankbot/app"
 	"github.com/yammine/yamex-go/notabankbot/domain"
+	"github.com/yammine/yamex-go/notabankbot/tracing"
 )
 
 const (
@@ -28,6 +32,10 @@ const (
 	AlreadyGrantedCurrencyResponse = "Oops! Looks like you've already granted currency recently. Try again later :simple_smile:"
 	NoNegativeAmountsResponse      = "You can't send a negative amount silly :clown_face:"
 	NotEnoughCurrencyResponse      = "You don't have enough %s to do that :cry:"
+	InvalidDateResponse            = "I couldn't understand that date, use the format YYYY-MM-DD :calendar:"
+	FutureDateResponse             = "I can't see into the future :crystal_ball:"
+	ReceiptNotFoundResponse        = "I couldn't find a receipt with that ID for you :mag:"
+	ReceiptsDisabledResponse       = "Receipts aren't available right now, the ledger has no signing key :lock:"
 
 	// Capture Keys
 	// TODO: Define a concrete type for captures, we shouldn't be passing around an arbitrary map.
@@ -36,16 +44,22 @@ const (
 	ckNote        = "note"
 	ckCommand     = "command"
 	ckAmount      = "amount"
+	ckEntryID     = "entry_id"
+	ckAsOf        = "as_of"
 )
 
 type BotMention struct {
-	Platform string
-	UserID   string
-	Text     string
+	Platform    string
+	WorkspaceID string
+	UserID      string
+	Text        string
+	// ReplyTo addresses replies to ledger changes. When set, they are queued
+	// in the change's transaction instead of being returned.
+	ReplyTo *domain.OutboxMessage
 }
 
 func (b BotMention) MarshalZerologObject(e *zerolog.Event) {
-	e.Str("UserID", b.UserID).Str("Platform", b.Platform).Str("Text", b.Text)
+	e.Str("UserID", b.UserID).Str("Platform", b.Platform).Str("WorkspaceID", b.WorkspaceID).Str("Text", b.Text)
 }
 
 var _ zerolog.LogObjectMarshaler = (*BotMention)(nil)
@@ -54,21 +68,40 @@ type BotResponse struct {
 	Text      string
 	Blocks    []slack.Block
 	Ephemeral bool
+	// Queued responses are already in the outbox.
+	Queued bool
 }
 
 func (s SlackConsumer) ProcessAppMention(ctx context.Context, m *BotMention) BotResponse {
 	//botId := viper.GetString("BOT_USER_ID")
 	r := BotResponse{}
+	command := "Unknown"
+	defer observeCommand(&command, time.Now())
+	ctx, span := tracer.Start(ctx, "bot.ProcessAppMention")
+	defer func() {
+		span.SetAttributes(attribute.String("yamex.command", command))
+		span.End()
+	}()
+
+	// Talking to the bot in a workspace makes the user a member of it.
+	if _, err := s.app.JoinWorkspace(ctx, m.WorkspaceID, cleanSlackUserID(m.UserID)); err != nil {
+		tracing.Logger(ctx).Error().Err(err).Object("context", m).Msg("Failed to record workspace user")
+	}
 
-	for name, expression := range s.expressions {
-		if expression.MatchString(m.Text) {
+	for _, name := range commandOrder {
+		if expression := s.expressions[name]; expression.MatchString(m.Text) {
 			captures := extractNamedCaptures(expression, m.Text)
+			command = name
 
 			switch name {
 			case CommandCmd:
-				r.Text = s.processCommand(ctx, m, captures)
+				command = s.subCommandName(captures[ckCommand])
+				r = s.processCommand(ctx, m, captures)
 			case GetBalanceCmd:
-				r.Text = s.processGetBalanceQuery(ctx, m.UserID)
+				r.Text = s.processGetBalanceQuery(ctx, m.UserID, captures[ckAsOf])
+			case ReceiptCmd:
+				r.Text = s.processReceiptQuery(ctx, m, captures)
+				r.Ephemeral = true
 			case FeedbackCmd:
 				contextBlock := &Block{
 					ID:   "feedback-context",
@@ -104,14 +137,18 @@ func (s SlackConsumer) ProcessAppMention(ctx context.Context, m *BotMention) Bot
 		}
 	}
 
-	log.Error().Str("text", m.Text).Msg("Could not process mention")
+	tracing.Logger(ctx).Error().Str("text", m.Text).Msg("Could not process mention")
 	return BotResponse{Text: GenericResponse}
 }
 
-func (s SlackConsumer) processGetBalanceQuery(ctx context.Context, slackUserID string) string {
+func (s SlackConsumer) processGetBalanceQuery(ctx context.Context, slackUserID, asOf string) string {
+	if asOf != "" {
+		return s.processGetBalanceAsOfQuery(ctx, slackUserID, asOf)
+	}
+
 	accounts, err := s.app.GetBalance(ctx, &app.GetBalanceInput{UserID: slackUserID})
 	if err != nil {
-		log.Error().Err(err).Msg("Error processing GetBalance query")
+		tracing.Logger(ctx).Error().Err(err).Msg("Error processing GetBalance query")
 		return GenericErrorResponse
 	}
 
@@ -120,12 +157,69 @@ func (s SlackConsumer) processGetBalanceQuery(ctx context.Context, slackUserID s
 	return fmt.Sprintf("Account balances for <@%s>:\n```%s```", slackUserID, accountsTable)
 }
 
-func (s SlackConsumer) processCommand(ctx context.Context, m *BotMention, captures map[string]string) string {
+func (s SlackConsumer) processGetBalanceAsOfQuery(ctx context.Context, slackUserID, asOf string) string {
+	day, err := time.Parse(domain.SnapshotDateLayout, asOf)
+	if err != nil {
+		return InvalidDateResponse
+	}
+
+	accounts, err := s.app.GetBalanceAsOf(ctx, &app.GetBalanceAsOfInput{UserID: slackUserID, Day: day})
+	if err != nil {
+		tracing.Logger(ctx).Error().Err(err).Str("as_of", asOf).Msg("Error processing GetBalanceAsOf query")
+		if errors.Is(err, app.ErrDateInFuture) {
+			return FutureDateResponse
+		}
+		return GenericErrorResponse
+	}
+
+	accountsTable := renderAccounts(accounts)
+
+	return fmt.Sprintf("Account balances for <@%s> as of the end of %s (UTC):\n```%s```", slackUserID, asOf, accountsTable)
+}
+
+func (s SlackConsumer) processReceiptQuery(ctx context.Context, m *BotMention, captures map[string]string) string {
+	entryID, err := strconv.ParseUint(captures[ckEntryID], 10, 64)
+	if err != nil {
+		return ReceiptNotFoundResponse
+	}
+
+	receipt, err := s.app.GetReceipt(ctx, &app.GetReceiptInput{UserID: m.UserID, EntryID: uint(entryID)})
+	if err != nil {
+		tracing.Logger(ctx).Error().Err(err).Object("context", m).Msg("Error fetching receipt")
+		if errors.Is(err, app.ErrReceiptNotFound) {
+			return ReceiptNotFoundResponse
+		}
+		if errors.Is(err, app.ErrSigningDisabled) {
+			return ReceiptsDisabledResponse
+		}
+		return GenericErrorResponse
+	}
+
+	encoded, _ := json.MarshalIndent(receipt, "", "  ")
+	return fmt.Sprintf(
+		"Receipt `#%d`, signed with ledger key `%s`. Verify it offline with `yamex verify-receipt`:\n```%s```",
+		receipt.EntryID,
+		receipt.KeyID,
+		encoded,
+	)
+}
+
+// subCommandName is the sub-command processCommand will run, for metrics.
+func (s SlackConsumer) subCommandName(command string) string {
+	for _, name := range subCommandOrder {
+		if s.subCommandExpressions[name].MatchString(command) {
+			return name
+		}
+	}
+	return CommandCmd
+}
+
+func (s SlackConsumer) processCommand(ctx context.Context, m *BotMention, captures map[string]string) BotResponse {
 	// TODO: Extract all handlers to their own files for better code organization.
 	command := captures[ckCommand]
 
-	for name, expression := range s.subCommandExpressions {
-		if expression.MatchString(command) {
+	for _, name := range subCommandOrder {
+		if expression := s.subCommandExpressions[name]; expression.MatchString(command) {
 			// Get captures within command, merge them into existing map
 			cmdCaptures := extractNamedCaptures(expression, command)
 			for k, v := range cmdCaptures {
@@ -135,67 +229,104 @@ func (s SlackConsumer) processCommand(ctx context.Context, m *BotMention, captur
 			// Find out which func to call
 			switch name {
 			case GetBalanceForCmd:
-				return s.processGetBalanceQuery(ctx, cleanSlackUserID(captures[ckRecipientID]))
+				// "balance for @user as of 2021-09-30" leaves the date in the note.
+				asOf := extractNamedCaptures(s.asOfExpression, captures[ckNote])[ckAsOf]
+				return BotResponse{Text: s.processGetBalanceQuery(ctx, cleanSlackUserID(captures[ckRecipientID]), asOf)}
 			case GrantCurrencyCmd:
-				_, err := s.app.Grant(ctx, &app.GrantInput{
-					GranterID:  cleanSlackUserID(m.UserID),
-					ReceiverID: cleanSlackUserID(captures[ckRecipientID]),
-					Platform:   "slack",
-					Currency:   captures[ckCurrency],
-					Note:       captures[ckNote],
+				granted := func(entryID uint) string {
+					return fmt.Sprintf(
+						"Success! Granted 1 `%s` to %s. Spend it wisely :sunglasses:\n\nReceipt: `#%d`",
+						captures[ckCurrency],
+						captures[ckRecipientID],
+						entryID,
+					)
+				}
+				grant, err := s.app.Grant(ctx, &app.GrantInput{
+					WorkspaceID: m.WorkspaceID,
+					GranterID:   cleanSlackUserID(m.UserID),
+					ReceiverID:  cleanSlackUserID(captures[ckRecipientID]),
+					Platform:    "slack",
+					Currency:    captures[ckCurrency],
+					Note:        captures[ckNote],
+					Reply:       queuedReply(m.ReplyTo, granted),
 				})
 
 				if err != nil {
-					log.Error().Object("context", m).Err(err).Msg("Error granting currency")
+					tracing.Logger(ctx).Error().Object("context", m).Err(err).Msg("Error granting currency")
 					if errors.Is(err, domain.ErrAlreadyGranted) {
-						return AlreadyGrantedCurrencyResponse
+						return BotResponse{Text: AlreadyGrantedCurrencyResponse}
 					}
-					return GenericErrorResponse
+					return BotResponse{Text: GenericErrorResponse}
 				}
 
-				return fmt.Sprintf("Success! Granted 1 `%s` to %s. Spend it wisely :sunglasses:", captures[ckCurrency], captures[ckRecipientID])
+				if m.ReplyTo != nil {
+					return BotResponse{Queued: true}
+				}
+				return BotResponse{Text: granted(grant.Movement.JournalEntryID)}
 			case SendCurrencyCmd:
 				amount, err := decimal.NewFromString(captures[ckAmount])
 				if err != nil {
-					log.Error().Err(err).Object("context", m).Msg("could not parse amount from message")
-					return GenericErrorResponse
+					tracing.Logger(ctx).Error().Err(err).Object("context", m).Msg("could not parse amount from message")
+					return BotResponse{Text: GenericErrorResponse}
 				}
 
-				err = s.app.Transfer(ctx, &app.TransferInput{
-					SenderID:   cleanSlackUserID(m.UserID),
-					ReceiverID: cleanSlackUserID(captures[ckRecipientID]),
-					Platform:   "slack",
-					Currency:   captures[ckCurrency],
-					Note:       captures[ckNote],
-					Amount:     amount,
+				sent := func(entryID uint) string {
+					return fmt.Sprintf(
+						"Success! Sent %s `%s` to %s for reason: `%s`.\n\nReceipt: `#%d`. Thanks for using yamex!",
+						amount.String(),
+						captures[ckCurrency],
+						captures[ckRecipientID],
+						strings.TrimSpace(captures[ckNote]),
+						entryID,
+					)
+				}
+				entry, err := s.app.Transfer(ctx, &app.TransferInput{
+					WorkspaceID: m.WorkspaceID,
+					SenderID:    cleanSlackUserID(m.UserID),
+					ReceiverID:  cleanSlackUserID(captures[ckRecipientID]),
+					Platform:    "slack",
+					Currency:    captures[ckCurrency],
+					Note:        captures[ckNote],
+					Amount:      amount,
+					Reply:       queuedReply(m.ReplyTo, sent),
 				})
 
 				if err != nil {
-					log.Error().Err(err).Object("context", m).Str("amount", amount.String()).Msg("Error during transfer")
+					tracing.Logger(ctx).Error().Err(err).Object("context", m).Str("amount", amount.String()).Msg("Error during transfer")
 					if errors.Is(err, domain.ErrAmountCannotBeNegative) {
-						return NoNegativeAmountsResponse
+						return BotResponse{Text: NoNegativeAmountsResponse}
 					}
 					if errors.Is(err, domain.ErrInsufficientBalance) {
-						return fmt.Sprintf(NotEnoughCurrencyResponse, captures[ckCurrency])
+						return BotResponse{Text: fmt.Sprintf(NotEnoughCurrencyResponse, captures[ckCurrency])}
 					}
-					return GenericErrorResponse
+					return BotResponse{Text: GenericErrorResponse}
+				}
+				if m.ReplyTo != nil {
+					return BotResponse{Queued: true}
 				}
-				return fmt.Sprintf(
-					"Success! Sent %s `%s` to %s for reason: `%s`.\n\nThanks for using yamex!",
-					amount.String(),
-					captures[ckCurrency],
-					captures[ckRecipientID],
-					strings.TrimSpace(captures[ckNote]),
-				)
+				return BotResponse{Text: sent(entry.ID)}
 			default:
-				log.Error().Object("context", m).Str("name", name).Str("command", command).Msg("Could not match command")
-				return GenericResponse
+				tracing.Logger(ctx).Error().Object("context", m).Str("name", name).Str("command", command).Msg("Could not match command")
+				return BotResponse{Text: GenericResponse}
 			}
 		}
 	}
 
-	log.Error().Str("command", command).Object("context", m).Msg("Could not match command")
-	return GenericResponse
+	tracing.Logger(ctx).Error().Str("command", command).Object("context", m).Msg("Could not match command")
+	return BotResponse{Text: GenericResponse}
+}
+
+// queuedReply renders a ledger change's reply into the outbox, or returns nil
+// when replies aren't queued.
+func queuedReply(to *domain.OutboxMessage, render func(entryID uint) string) app.ReplyFunc {
+	if to == nil {
+		return nil
+	}
+	return func(entry *domain.JournalEntry) (*domain.OutboxMessage, error) {
+		msg := to.Addressed()
+		msg.Text = render(entry.ID)
+		return msg, nil
+	}
 }
 
 func renderAccounts(accounts []*domain.Account) string {
@@ -0,0 +1,39 @@
+package port
+
+import (
+	"context"
+
+	"github.com/yammine/yamex-go/notabankbot/app"
+	"github.com/yammine/yamex-go/notabankbot/metrics"
+	"github.com/yammine/yamex-go/notabankbot/tracing"
+)
+
+const RateLimitedResponse = "Whoa, slow down! I'm getting too many requests, try again in a little while :turtle:"
+
+// allow checks the rate limits before a command runs. Requests are let through
+// when the limits can't be checked, an outage shouldn't take the bot down.
+func (s SlackConsumer) allow(ctx context.Context, in *app.RateLimitInput) bool {
+	if s.limiter == nil {
+		return true
+	}
+
+	scope, err := s.limiter.Allow(ctx, in)
+	if err != nil {
+		metrics.RateLimitDecisions.WithLabelValues("error", "").Inc()
+		tracing.Logger(ctx).Error().Err(err).Msg("Failed to check rate limits, allowing request")
+		return true
+	}
+	if scope != "" {
+		metrics.RateLimitDecisions.WithLabelValues("throttled", string(scope)).Inc()
+		tracing.Logger(ctx).Warn().
+			Str("scope", string(scope)).
+			Str("team", in.WorkspaceID).
+			Str("channel", in.ChannelID).
+			Str("user", in.UserID).
+			Msg("Throttled request")
+		return false
+	}
+
+	metrics.RateLimitDecisions.WithLabelValues("allowed", "").Inc()
+	return true
+}
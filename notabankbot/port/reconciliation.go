@@ -0,0 +1,153 @@
+package port
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/rs/zerolog/log"
+	"github.com/slack-go/slack"
+
+	"github.com/yammine/yamex-go/notabankbot/app"
+	"github.com/yammine/yamex-go/notabankbot/metrics"
+)
+
+// AdminChannel is where problems that need a human are posted.
+type AdminChannel struct {
+	TeamID    string
+	ChannelID string
+}
+
+type Reconciler struct {
+	app         *app.Application
+	credentials SlackCredentialStore
+	newClient   SlackClientFactory
+	admins      AdminChannel
+}
+
+// NewReconciler wires the reconciler. Reports are only posted if admins is
+// set.
+func NewReconciler(app *app.Application, credentials SlackCredentialStore, newClient SlackClientFactory, admins AdminChannel) *Reconciler {
+	return &Reconciler{
+		app:         app,
+		credentials: credentials,
+		newClient:   newClient,
+		admins:      admins,
+	}
+}
+
+// Run verifies the ledger every interval until ctx is cancelled. It never
+// corrects balances on its own, that is left to `yamex verify -fix`.
+func (r Reconciler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := r.Verify(ctx, &app.ReconcileInput{}); err != nil {
+				log.Error().Err(err).Msg("Scheduled reconciliation failed")
+			}
+		}
+	}
+}
+
+// Verify reconciles the ledger, records the outcome as metrics and posts any
+// problems to the admin channel.
+func (r Reconciler) Verify(ctx context.Context, in *app.ReconcileInput) (*app.ReconciliationReport, error) {
+	report, err := r.app.Reconcile(ctx, in)
+	if err != nil {
+		metrics.ReconciliationRuns.WithLabelValues("failed").Inc()
+		return nil, err
+	}
+	recordReconciliationMetrics(report)
+
+	if report.Healthy() {
+		log.Info().Int("accounts", report.AccountsChecked).Msg("Ledger reconciled without discrepancies")
+		return report, nil
+	}
+	log.Warn().
+		Int("accounts", report.AccountsChecked).
+		Int("discrepancies", len(report.Discrepancies)).
+		Msg("Ledger reconciliation found discrepancies")
+	r.notifyAdmins(ctx, report)
+
+	return report, nil
+}
+
+func (r Reconciler) notifyAdmins(ctx context.Context, report *app.ReconciliationReport) {
+	if r.admins.TeamID == "" || r.admins.ChannelID == "" {
+		return
+	}
+
+	creds, err := r.credentials.GetCredentials(ctx, r.admins.TeamID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get slack credentials for admin channel")
+		return
+	}
+	client := r.newClient(creds.Token)
+	text := fmt.Sprintf(":rotating_light: Ledger reconciliation found problems\n```%s```", RenderReconciliationReport(report))
+	if _, _, err := client.PostMessageContext(ctx, r.admins.ChannelID, slack.MsgOptionText(text, false)); err != nil {
+		log.Error().Err(err).Msg("Failed to post reconciliation report")
+	}
+}
+
+func recordReconciliationMetrics(report *app.ReconciliationReport) {
+	outcome := "healthy"
+	if !report.Healthy() {
+		outcome = "discrepancies"
+	}
+	metrics.ReconciliationRuns.WithLabelValues(outcome).Inc()
+	metrics.ReconciliationLastRun.Set(float64(report.StartedAt.Unix()))
+	metrics.ReconciliationDuration.Set(report.Duration.Seconds())
+	metrics.ReconciliationAccountsChecked.Set(float64(report.AccountsChecked))
+	metrics.ReconciliationDiscrepancies.Set(float64(len(report.Discrepancies)))
+
+	unbalanced := 0
+	for _, c := range report.Currencies {
+		if !c.Difference().IsZero() {
+			unbalanced++
+		}
+	}
+	metrics.ReconciliationUnbalancedCurrencies.Set(float64(unbalanced))
+}
+
+func RenderReconciliationReport(report *app.ReconciliationReport) string {
+	buf := bytes.NewBuffer([]byte{})
+	fmt.Fprintf(buf, "Checked %d accounts in %s\n\n", report.AccountsChecked, report.Duration.Round(time.Millisecond))
+
+	currencies := tablewriter.NewWriter(buf)
+	currencies.SetHeader([]string{"Currency", "Circulating", "Issued", "Difference"})
+	currencies.SetAlignment(tablewriter.ALIGN_LEFT)
+	for _, c := range report.Currencies {
+		currencies.Append([]string{c.Currency, c.Circulating.StringFixed(8), c.Issued.StringFixed(8), c.Difference().StringFixed(8)})
+	}
+	currencies.Render()
+
+	if len(report.Discrepancies) == 0 {
+		return buf.String()
+	}
+
+	buf.WriteString("\n")
+	accounts := tablewriter.NewWriter(buf)
+	accounts.SetHeader([]string{"Account ID", "User ID", "Currency", "Balance", "Movements", "Drift", "Corrected"})
+	accounts.SetAlignment(tablewriter.ALIGN_LEFT)
+	for _, d := range report.Discrepancies {
+		accounts.Append([]string{
+			fmt.Sprint(d.AccountID),
+			fmt.Sprint(d.UserID),
+			d.Currency,
+			d.Balance.StringFixed(8),
+			d.MovementSum.StringFixed(8),
+			d.Drift.StringFixed(8),
+			fmt.Sprint(d.Corrected),
+		})
+	}
+	accounts.Render()
+
+	return buf.String()
+}
@@ -0,0 +1,64 @@
+package port
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
+
+	"github.com/yammine/yamex-go/notabankbot/app"
+)
+
+// Handlers is everything the HTTP router dispatches to.
+type Handlers struct {
+	App        *app.Application
+	Consumer   *SlackConsumer
+	Interactor *SlackInteractor
+	Installer  *SlackInstaller
+	// Optional, /healthz & /readyz are only served with Health, /admin/debug
+	// only with Debug.
+	Health *Health
+	Debug  *AdminDebug
+	// Optional, /api/v1 is only served with API, /admin/api only with
+	// AdminAPI.
+	API      *API
+	AdminAPI *AdminAPI
+	// Optional, /hooks is only served with Integrations.
+	Integrations *IntegrationHooks
+}
+
+// NewRouter wires the public HTTP endpoints. The server, tests and tools that
+// replay traffic all share it so they exercise the same routes.
+func NewRouter(h Handlers) http.Handler {
+	router := mux.NewRouter()
+	router.Use(otelmux.Middleware("yamex"))
+	router.HandleFunc("/slack/events", h.Consumer.Handler())
+	router.HandleFunc("/slack/interaction", h.Interactor.Handler())
+	router.HandleFunc("/slack/commands", h.Consumer.SlashCommandHandler())
+	if h.Installer != nil {
+		router.HandleFunc("/slack/install", h.Installer.InstallHandler())
+		router.HandleFunc("/slack/oauth", h.Installer.CallbackHandler())
+	}
+	if h.Health != nil {
+		router.HandleFunc("/healthz", h.Health.LivenessHandler())
+		router.HandleFunc("/readyz", h.Health.ReadinessHandler())
+	}
+	if h.Debug != nil {
+		router.HandleFunc("/admin/debug", h.Debug.Handler())
+	}
+	if h.API != nil {
+		h.API.Register(router.PathPrefix("/api/v1").Subrouter())
+	}
+	if h.AdminAPI != nil {
+		h.AdminAPI.Register(router.PathPrefix("/admin/api").Subrouter())
+	}
+	if h.Integrations != nil {
+		h.Integrations.Register(router.PathPrefix("/hooks").Subrouter())
+	}
+	router.Handle("/metrics", promhttp.Handler())
+	router.HandleFunc("/ledger/public-key", LedgerPublicKeyHandler(h.App))
+	router.Use(instrumentRoutes)
+
+	return router
+}
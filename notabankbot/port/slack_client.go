@@ -0,0 +1,86 @@
+package port
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	stdlog "log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/slack-go/slack"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+
+	"github.com/yammine/yamex-go/notabankbot/metrics"
+)
+
+// SlackClientFactory builds the Web API client used to act on behalf of a
+// workspace. Swap it out to point the bot at a fake Slack.
+type SlackClientFactory func(token string) *slack.Client
+
+// NewSlackClientFactory builds clients whose debug output goes through
+// zerolog, so it gets the same token redaction as the rest of our logs.
+// An empty apiURL talks to the real Slack.
+func NewSlackClientFactory(apiURL string) SlackClientFactory {
+	logger := stdlog.New(log.Logger.With().Str("component", "slack-go").Logger(), "", 0)
+	httpClient := &http.Client{Transport: otelhttp.NewTransport(instrumentedTransport{next: http.DefaultTransport})}
+
+	return func(token string) *slack.Client {
+		opts := []slack.Option{slack.OptionDebug(true), slack.OptionLog(logger), slack.OptionHTTPClient(httpClient)}
+		if apiURL != "" {
+			opts = append(opts, slack.OptionAPIURL(apiURL))
+		}
+		return slack.New(token, opts...)
+	}
+}
+
+// instrumentedTransport records every Slack call's latency, status and, as
+// Slack reports most errors with a 200, the error in the response body.
+type instrumentedTransport struct {
+	next http.RoundTripper
+}
+
+func (t instrumentedTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	method := "response_url"
+	if i := strings.Index(r.URL.Path, "/api/"); i >= 0 {
+		method = r.URL.Path[i+len("/api/"):]
+	}
+
+	start := time.Now()
+	res, err := t.next.RoundTrip(r)
+	metrics.SlackAPIDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.SlackAPIRequests.WithLabelValues(method, "", "transport_error").Inc()
+		return nil, err
+	}
+
+	metrics.SlackAPIRequests.WithLabelValues(method, strconv.Itoa(res.StatusCode), slackError(res)).Inc()
+	return res, nil
+}
+
+// slackError peeks at a JSON response for Slack's error code, leaving the
+// body for the client to read.
+func slackError(res *http.Response) string {
+	if mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type")); mediaType != "application/json" {
+		return ""
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return "read_error"
+	}
+
+	var envelope struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &envelope) != nil || envelope.OK {
+		return ""
+	}
+	return envelope.Error
+}
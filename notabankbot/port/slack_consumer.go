@@ -1,7 +1,9 @@
 package port
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"regexp"
@@ -9,20 +11,22 @@ import (
 	"unicode"
 
 	"github.com/rs/zerolog"
-	"github.com/rs/zerolog/log"
-	"github.com/slack-go/slack"
 	"github.com/slack-go/slack/slackevents"
-	"github.com/spf13/viper"
 
 	"github.com/yammine/yamex-go/notabankbot/app"
+	"github.com/yammine/yamex-go/notabankbot/domain"
+	"github.com/yammine/yamex-go/notabankbot/tracing"
 )
 
 const (
 	// Top Level expressions
 
 	CommandExpression    = "(?P<bot_id><@[A-Z0-9]{11}>)(?P<command>.+)(?P<recipient_id><@[A-Z0-9]{11}>)(?P<note>.*)"
-	GetBalanceExpression = "(?P<bot_id><@[A-Z0-9]{11}>)[[:space:]](balance|my[[:space:]]balance)"
+	GetBalanceExpression = "(?P<bot_id><@[A-Z0-9]{11}>)[[:space:]](balance|my[[:space:]]balance)(" + AsOfExpression + ")?"
 	FeedbackExpression   = "(?P<bot_id><@[A-Z0-9]{11}>)[[:space:]]+feedback.*"
+	ReceiptExpression    = "(?P<bot_id><@[A-Z0-9]{11}>)[[:space:]]+receipt[[:space:]]+#?(?P<entry_id>[0-9]+)"
+
+	AsOfExpression = "[[:space:]]+as[[:space:]]+of[[:space:]]+(?P<as_of>[0-9]{4}-[0-9]{2}-[0-9]{2})"
 
 	// Sub-command expressions
 
@@ -35,6 +39,7 @@ const (
 	GetBalanceCmd = "GetBalance"
 	CommandCmd    = "Command"
 	FeedbackCmd   = "Feedback"
+	ReceiptCmd    = "Receipt"
 
 	// Sub-command Names
 
@@ -43,19 +48,36 @@ const (
 	SendCurrencyCmd  = "SendCurrency"
 )
 
+// Several expressions can match the same text, e.g. "balance for @user" is
+// both a balance query and a command, so they are tried in this order.
+var (
+	commandOrder    = []string{ReceiptCmd, FeedbackCmd, CommandCmd, GetBalanceCmd}
+	subCommandOrder = []string{SendCurrencyCmd, GrantCurrencyCmd, GetBalanceForCmd}
+)
+
 type SlackConsumer struct {
 	app         *app.Application
 	credentials SlackCredentialStore
+	dispatcher  *SlackDispatcher
+	limiter     *app.RateLimiter
+	// signingSecret verifies requests to the HTTP endpoints.
+	signingSecret string
+	inFlight      *InFlight
 
 	expressions           map[string]*regexp.Regexp
 	subCommandExpressions map[string]*regexp.Regexp
+	asOfExpression        *regexp.Regexp
 }
 
-func NewSlackConsumer(app *app.Application, credentialRepo SlackCredentialStore) *SlackConsumer {
+// NewSlackConsumer wires the consumer. limiter may be nil, in which case
+// requests are never throttled, as may inFlight, in which case shutdown
+// doesn't wait for slash commands.
+func NewSlackConsumer(app *app.Application, credentialRepo SlackCredentialStore, dispatcher *SlackDispatcher, limiter *app.RateLimiter, signingSecret string, inFlight *InFlight) *SlackConsumer {
 	top := map[string]*regexp.Regexp{
 		CommandCmd:    regexp.MustCompile(CommandExpression),
 		GetBalanceCmd: regexp.MustCompile(GetBalanceExpression),
 		FeedbackCmd:   regexp.MustCompile(FeedbackExpression),
+		ReceiptCmd:    regexp.MustCompile(ReceiptExpression),
 	}
 
 	sub := map[string]*regexp.Regexp{
@@ -67,8 +89,13 @@ func NewSlackConsumer(app *app.Application, credentialRepo SlackCredentialStore)
 	return &SlackConsumer{
 		app:                   app,
 		credentials:           credentialRepo,
+		dispatcher:            dispatcher,
+		limiter:               limiter,
+		signingSecret:         signingSecret,
+		inFlight:              inFlight,
 		expressions:           top,
 		subCommandExpressions: sub,
+		asOfExpression:        regexp.MustCompile(AsOfExpression),
 	}
 }
 
@@ -80,17 +107,8 @@ func (s SlackConsumer) Handler() func(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		sv, err := slack.NewSecretsVerifier(r.Header, viper.GetString("SLACK_SIGNING_SECRET"))
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-		if _, err := sv.Write(body); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-		if err := sv.Ensure(); err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
+		if status := verifySignature(ctx, s.signingSecret, r.Header, body); status != 0 {
+			w.WriteHeader(status)
 			return
 		}
 		eventsAPIEvent, err := slackevents.ParseEvent(body, slackevents.OptionNoVerifyToken())
@@ -111,64 +129,104 @@ func (s SlackConsumer) Handler() func(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if eventsAPIEvent.Type == slackevents.CallbackEvent {
-			innerEvent := eventsAPIEvent.InnerEvent
-
-			switch ev := innerEvent.Data.(type) {
-			case *slackevents.AppMentionEvent:
-				log.Debug().Dict(
-					"AppMentionEvent",
-					zerolog.Dict().
-						Str("type", ev.Type).
-						Str("user", ev.User).
-						Str("text", ev.Text),
-				).Msg("event received")
-
-				response := s.ProcessAppMention(ctx, &BotMention{
-					Platform: "slack",
-					UserID:   ev.User,
-					Text:     replaceWhitespace(ev.Text),
-				})
-				// TODO: Move this to somewhere else
-				token, err := s.credentials.GetCredentials(ctx, eventsAPIEvent.TeamID)
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to get slack credentials")
-					w.WriteHeader(500)
-					return
-				}
-				client := slack.New(token, slack.OptionDebug(true))
-				go s.reply(client, ev, response)
-
-			case *slackevents.MessageAction:
-				log.Debug().Msgf("Received message action: %+v", ev)
-			default:
-				log.Debug().Msgf("Unhandled message type: %+v", ev)
+			if err := s.HandleCallbackEvent(ctx, eventsAPIEvent); err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
 			}
 		}
 	}
 }
 
-func (s SlackConsumer) reply(client *slack.Client, ev *slackevents.AppMentionEvent, response BotResponse) {
-	opts := make([]slack.MsgOption, 0)
-	if response.Text != "" {
-		opts = append(opts, slack.MsgOptionText(response.Text, false))
+// HandleCallbackEvent processes an Events API callback, however it reached us.
+func (s SlackConsumer) HandleCallbackEvent(ctx context.Context, eventsAPIEvent slackevents.EventsAPIEvent) (err error) {
+	ctx, span := tracer.Start(ctx, "slack.HandleCallbackEvent")
+	defer tracing.End(span, &err)
+	innerEvent := eventsAPIEvent.InnerEvent
+
+	switch ev := innerEvent.Data.(type) {
+	case *slackevents.AppMentionEvent:
+		tracing.Logger(ctx).Debug().Dict(
+			"AppMentionEvent",
+			zerolog.Dict().
+				Str("type", ev.Type).
+				Str("user", ev.User).
+				Str("text", ev.Text),
+		).Msg("event received")
+
+		// TODO: Move this to somewhere else
+		_, err := s.credentials.GetCredentials(ctx, eventsAPIEvent.TeamID)
+		if errors.Is(err, ErrUnknownWorkspace) {
+			// Likely uninstalled, acknowledge so that Slack stops retrying.
+			tracing.Logger(ctx).Warn().Str("team", eventsAPIEvent.TeamID).Msg("Ignoring event from unknown workspace")
+			return nil
+		}
+		if err != nil {
+			tracing.Logger(ctx).Error().Err(err).Msg("Failed to get slack credentials")
+			return err
+		}
+
+		// Replies go to the thread the mention was made in.
+		replyTo := &domain.OutboxMessage{
+			TraceParent: tracing.TraceParent(ctx),
+			WorkspaceID: eventsAPIEvent.TeamID,
+			ChannelID:   ev.Channel,
+			ThreadTS:    messageTS(ev),
+		}
+		if !s.allow(ctx, &app.RateLimitInput{WorkspaceID: eventsAPIEvent.TeamID, ChannelID: ev.Channel, UserID: ev.User}) {
+			s.reply(ctx, replyTo, ev.User, BotResponse{Text: RateLimitedResponse, Ephemeral: true})
+			return nil
+		}
+
+		response := s.ProcessAppMention(ctx, &BotMention{
+			Platform:    "slack",
+			WorkspaceID: eventsAPIEvent.TeamID,
+			UserID:      ev.User,
+			Text:        replaceWhitespace(ev.Text),
+			ReplyTo:     replyTo,
+		})
+		s.reply(ctx, replyTo, ev.User, response)
+
+	case *slackevents.AppUninstalledEvent:
+		s.revokeCredentials(ctx, eventsAPIEvent.TeamID, "app_uninstalled")
+	case *slackevents.TokensRevokedEvent:
+		// We only ever hold a bot token, so any revoked bot token is ours.
+		if len(ev.Tokens.Bot) > 0 {
+			s.revokeCredentials(ctx, eventsAPIEvent.TeamID, "tokens_revoked")
+		}
+	case *slackevents.MessageAction:
+		tracing.Logger(ctx).Debug().Msgf("Received message action: %+v", ev)
+	default:
+		tracing.Logger(ctx).Debug().Msgf("Unhandled message type: %+v", ev)
+	}
+
+	return nil
+}
+
+func (s SlackConsumer) revokeCredentials(ctx context.Context, teamID, reason string) {
+	if err := s.credentials.RevokeCredentials(ctx, teamID); err != nil {
+		tracing.Logger(ctx).Error().Err(err).Str("team", teamID).Str("reason", reason).Msg("Failed to revoke slack credentials")
+		return
 	}
-	if len(response.Blocks) > 0 {
-		opts = append(opts, slack.MsgOptionBlocks(response.Blocks...))
+	tracing.Logger(ctx).Info().Str("team", teamID).Str("reason", reason).Msg("Revoked slack credentials")
+}
+
+// reply sends a response to the user who asked, ephemeral responses are only
+// visible to them.
+func (s SlackConsumer) reply(ctx context.Context, to *domain.OutboxMessage, userID string, response BotResponse) {
+	if response.Queued {
+		s.dispatcher.Wake()
+		return
 	}
+
+	msg := to.Addressed()
+	msg.Text = response.Text
+	msg.Blocks = outboxBlocks(response.Blocks)
 	if response.Ephemeral {
-		opts = append(opts, slack.MsgOptionPostEphemeral(ev.User))
-	} else {
 		// We only create threads if the message responses are not ephemeral
-		opts = append(opts, slack.MsgOptionTS(messageTS(ev)))
-	}
-
-	_, _, _, err := client.SendMessage(
-		ev.Channel,
-		opts...,
-	)
-	if err != nil {
-		log.Error().Err(err).Msg("failed to send response")
+		msg.ThreadTS = ""
+		msg.EphemeralUserID = userID
 	}
+	s.dispatcher.Send(ctx, msg)
 }
 
 func messageTS(ev *slackevents.AppMentionEvent) string {
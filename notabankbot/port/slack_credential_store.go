@@ -1,8 +1,33 @@
 package port
 
-import "context"
+import (
+	"context"
+	"time"
+
+	"github.com/yammine/yamex-go"
+)
+
+const ErrUnknownWorkspace = yamex.Sentinel("no credentials for workspace")
+
+// SlackInstallation is what we keep from a completed OAuth install.
+type SlackInstallation struct {
+	TeamID          string
+	EnterpriseID    string
+	BotUserID       string
+	Scopes          string
+	InstallerUserID string
+	Token           string
+
+	// Only set when token rotation is enabled, Token then expires at ExpiresAt
+	// and must be refreshed with RefreshToken.
+	RefreshToken string
+	ExpiresAt    *time.Time
+}
 
 type SlackCredentialStore interface {
-	SaveCredentials(ctx context.Context, workspaceID, token string) error
-	GetCredentials(ctx context.Context, workspaceID string) (string, error)
+	SaveCredentials(ctx context.Context, installation *SlackInstallation) error
+	GetCredentials(ctx context.Context, workspaceID string) (*SlackInstallation, error)
+	// RevokeCredentials forgets the workspace's token, e.g. once the app has
+	// been uninstalled. Revoking an unknown workspace is not an error.
+	RevokeCredentials(ctx context.Context, workspaceID string) error
 }
@@ -0,0 +1,212 @@
+package port_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/slack-go/slack"
+
+	"github.com/yammine/yamex-go/notabankbot/app"
+	"github.com/yammine/yamex-go/notabankbot/port"
+	"github.com/yammine/yamex-go/notabankbot/slackfake"
+)
+
+// mention sends an app mention from the user and returns the bot's reply.
+func (b *testBot) mention(userID, text string) slackfake.Call {
+	t := b.t
+	t.Helper()
+	seen := len(b.fake.Calls("chat.postMessage"))
+	b.serve(slackfake.EventRequest(testSecret, "/slack/events", slackfake.AppMention(testWorkspace, userID, testChannel, "<@"+testBotUser+"> "+text)))
+
+	calls, err := b.fake.WaitForCalls("chat.postMessage", seen+1, testWait)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply := calls[seen]
+	if got := reply.Params.Get("channel"); got != testChannel {
+		t.Errorf("replied in %q, want %s", got, testChannel)
+	}
+	return reply
+}
+
+// balance reads the user's balance from the ledger.
+func (b *testBot) balance(userID, currency string) decimal.Decimal {
+	t := b.t
+	t.Helper()
+	accounts, err := b.app.GetBalance(context.Background(), &app.GetBalanceInput{UserID: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, account := range accounts {
+		if account.Currency == currency {
+			return account.Balance
+		}
+	}
+	return decimal.Zero
+}
+
+// wantBalance checks the user's balance in the ledger.
+func (b *testBot) wantBalance(userID, currency string, want int64) {
+	t := b.t
+	t.Helper()
+	if got := b.balance(userID, currency); !got.Equal(decimal.NewFromInt(want)) {
+		t.Errorf("%s has %s %s, want %d", userID, got, currency, want)
+	}
+}
+
+func wantReply(t *testing.T, reply slackfake.Call, prefix string) {
+	t.Helper()
+	if got := reply.Params.Get("text"); !strings.HasPrefix(got, prefix) {
+		t.Errorf("reply = %q, want it to start with %q", got, prefix)
+	}
+}
+
+func TestSlackGrantAndTransfer(t *testing.T) {
+	bot := startBot(t, testBotUser, nil)
+
+	reply := bot.mention(testAlice, "grant $coffee <@"+testBob+"> for the help")
+	wantReply(t, reply, "Success! Granted 1 `$coffee` to <@"+testBob+">")
+	if reply.Params.Get("thread_ts") == "" {
+		t.Error("grant reply wasn't threaded under the mention")
+	}
+	bot.wantBalance(testBob, "$coffee", 1)
+
+	reply = bot.mention(testBob, "send 1 $coffee <@"+testAlice+"> thanks!")
+	wantReply(t, reply, "Success! Sent 1 `$coffee` to <@"+testAlice+">")
+	bot.wantBalance(testBob, "$coffee", 0)
+	bot.wantBalance(testAlice, "$coffee", 1)
+
+	reply = bot.mention(testBob, "send 5 $coffee <@"+testAlice+">")
+	wantReply(t, reply, "You don't have enough $coffee to do that")
+	bot.wantBalance(testBob, "$coffee", 0)
+	bot.wantBalance(testAlice, "$coffee", 1)
+
+	reply = bot.mention(testAlice, "balance")
+	wantReply(t, reply, "Account balances for <@"+testAlice+">")
+}
+
+func TestSlackGrantCooldown(t *testing.T) {
+	bot := startBot(t, testBotUser, nil)
+
+	reply := bot.mention(testBob, "grant $coffee <@"+testAlice+">")
+	wantReply(t, reply, "Success! Granted 1 `$coffee` to <@"+testAlice+">")
+	reply = bot.mention(testBob, "grant $coffee <@"+testAlice+">")
+	wantReply(t, reply, port.AlreadyGrantedCurrencyResponse)
+	bot.wantBalance(testAlice, "$coffee", 1)
+}
+
+func TestSlackSlashCommand(t *testing.T) {
+	bot := startBot(t, testBotUser, nil)
+
+	bot.serve(slackfake.SlashCommandRequest(testSecret, "/slack/commands", slack.SlashCommand{
+		TeamID:      testWorkspace,
+		ChannelID:   testChannel,
+		UserID:      testBob,
+		Command:     "/yamex",
+		Text:        "balance",
+		ResponseURL: bot.fake.ResponseURL("balance"),
+	}))
+
+	calls, err := bot.fake.WaitForCalls("response_url", 1, testWait)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls[0].Path != "balance" {
+		t.Errorf("replied to response URL %q, want balance", calls[0].Path)
+	}
+	var msg struct {
+		Text string `json:"text"`
+	}
+	if err := calls[0].JSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(msg.Text, "Account balances for <@"+testBob+">") {
+		t.Errorf("reply = %q, want bob's balances", msg.Text)
+	}
+}
+
+func TestSlackFeedback(t *testing.T) {
+	bot := startBot(t, testBotUser, nil)
+
+	bot.serve(slackfake.EventRequest(testSecret, "/slack/events", slackfake.AppMention(testWorkspace, testBob, testChannel, "<@"+testBotUser+"> feedback")))
+	calls, err := bot.fake.WaitForCalls("chat.postEphemeral", 1, testWait)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := calls[0].Params.Get("user"); got != testBob {
+		t.Errorf("feedback form shown to %q, want bob", got)
+	}
+	if got := calls[0].Params.Get("blocks"); !strings.Contains(got, "submit-feedback") {
+		t.Errorf("feedback form blocks = %s, want the feedback input", got)
+	}
+
+	bot.serve(slackfake.InteractionRequest(testSecret, "/slack/interaction", port.SlackInteraction{
+		Type:        "block_actions",
+		User:        &slack.User{ID: testBob},
+		Channel:     &port.Channel{ID: testChannel},
+		Team:        &slack.Team{ID: testWorkspace},
+		ResponseURL: bot.fake.ResponseURL("feedback"),
+		Actions:     []*port.Action{{Type: "plain_text_input", ActionID: "submit-feedback", Value: "more currencies please"}},
+	}))
+
+	calls, err = bot.fake.WaitForCalls("response_url", 1, testWait)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg struct {
+		Text string `json:"text"`
+	}
+	if err := calls[0].JSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Text != "Thanks for the feedback!" {
+		t.Errorf("reply = %q, want thanks", msg.Text)
+	}
+
+	feedback, err := bot.app.ListFeedback(context.Background(), 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(feedback) != 1 || feedback[0].SlackUserID != testBob || feedback[0].Text != "more currencies please" {
+		t.Errorf("feedback = %+v, want bob's", feedback)
+	}
+}
+
+func TestSlackURLVerification(t *testing.T) {
+	bot := startBot(t, testBotUser, nil)
+
+	res := bot.serve(slackfake.EventRequest(testSecret, "/slack/events", map[string]string{
+		"type":      "url_verification",
+		"challenge": "3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P",
+	}))
+	if got := res.Body.String(); got != "3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P" {
+		t.Errorf("challenge answer = %q", got)
+	}
+}
+
+func TestSlackRejectsUnsignedRequests(t *testing.T) {
+	bot := startBot(t, testBotUser, nil)
+
+	for _, path := range []string{"/slack/events", "/slack/interaction", "/slack/commands"} {
+		t.Run(path, func(t *testing.T) {
+			req, err := slackfake.EventRequest("wrong-secret", path, slackfake.AppMention(testWorkspace, testBob, testChannel, "<@"+testBotUser+"> balance"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			res := httptest.NewRecorder()
+			bot.router.ServeHTTP(res, req)
+			if res.Code != http.StatusUnauthorized {
+				t.Errorf("answered %d, want 401", res.Code)
+			}
+		})
+	}
+	time.Sleep(50 * time.Millisecond)
+	if calls := bot.fake.Calls(""); len(calls) != 0 {
+		t.Errorf("unsigned requests made %d Slack calls", len(calls))
+	}
+}
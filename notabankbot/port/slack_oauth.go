@@ -0,0 +1,217 @@
+package port
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/yammine/yamex-go"
+	"github.com/yammine/yamex-go/notabankbot/app"
+)
+
+const (
+	ErrInvalidOAuthState = yamex.Sentinel("invalid oauth state")
+	ErrExpiredOAuthState = yamex.Sentinel("expired oauth state")
+
+	slackAuthorizeURL = "https://slack.com/oauth/v2/authorize"
+	oauthStateCookie  = "yamex_oauth_state"
+	oauthStateTTL     = 10 * time.Minute
+	oauthNonceSize    = 16
+)
+
+type SlackOAuthConfig struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURI  string
+	Scopes       []string
+	// StateSecret signs the state parameter that protects the callback
+	// against CSRF.
+	StateSecret string
+}
+
+type SlackInstaller struct {
+	app         *app.Application
+	config      SlackOAuthConfig
+	credentials SlackCredentialStore
+	oauth       *SlackOAuthClient
+}
+
+func NewSlackInstaller(application *app.Application, config SlackOAuthConfig, credentials SlackCredentialStore, oauth *SlackOAuthClient) *SlackInstaller {
+	// The client secret is already only known to us, so it makes a fine default.
+	if config.StateSecret == "" {
+		config.StateSecret = config.ClientSecret
+	}
+
+	return &SlackInstaller{
+		app:         application,
+		config:      config,
+		credentials: credentials,
+		oauth:       oauth,
+	}
+}
+
+// InstallHandler starts an install by sending the user to Slack with a signed
+// state. The state's nonce is also set as a cookie, tying the callback to the
+// browser that started the install.
+func (s SlackInstaller) InstallHandler() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		nonce := make([]byte, oauthNonceSize)
+		if _, err := rand.Read(nonce); err != nil {
+			log.Error().Err(err).Msg("Failed to generate oauth state")
+			renderInstallPage(w, http.StatusInternalServerError, installFailedPage)
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     oauthStateCookie,
+			Value:    base64.RawURLEncoding.EncodeToString(nonce),
+			Path:     "/",
+			MaxAge:   int(oauthStateTTL.Seconds()),
+			HttpOnly: true,
+			Secure:   strings.HasPrefix(s.config.RedirectURI, "https://"),
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		query := url.Values{}
+		query.Set("client_id", s.config.ClientID)
+		query.Set("scope", strings.Join(s.config.Scopes, ","))
+		query.Set("redirect_uri", s.config.RedirectURI)
+		query.Set("state", s.signState(nonce, time.Now().Add(oauthStateTTL)))
+		http.Redirect(w, r, slackAuthorizeURL+"?"+query.Encode(), http.StatusFound)
+	}
+}
+
+// CallbackHandler completes an install: it validates the state, exchanges the
+// code for a token and stores the installation.
+func (s SlackInstaller) CallbackHandler() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		// The state cookie is single use.
+		http.SetCookie(w, &http.Cookie{Name: oauthStateCookie, Path: "/", MaxAge: -1})
+
+		if err := s.verifyState(r, query.Get("state")); err != nil {
+			log.Warn().Err(err).Msg("Rejected oauth callback")
+			renderInstallPage(w, http.StatusBadRequest, installExpiredPage)
+			return
+		}
+		// The user declined, or Slack refused the install.
+		if reason := query.Get("error"); reason != "" {
+			log.Info().Str("error", reason).Msg("Slack install was not completed")
+			renderInstallPage(w, http.StatusOK, installCancelledPage)
+			return
+		}
+
+		installation, teamName, err := s.oauth.ExchangeCode(r.Context(), query.Get("code"), s.config.RedirectURI)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to exchange oauth code")
+			renderInstallPage(w, http.StatusBadGateway, installFailedPage)
+			return
+		}
+
+		// Persist the token & team ID, so we can use them later when responding to mentions
+		if err := s.credentials.SaveCredentials(r.Context(), installation); err != nil {
+			log.Error().Err(err).Str("team", installation.TeamID).Msg("Failed to save slack credentials")
+			renderInstallPage(w, http.StatusInternalServerError, installFailedPage)
+			return
+		}
+
+		// The installer is the workspace's first member, a failure here only
+		// delays that until they talk to the bot.
+		if _, err := s.app.JoinWorkspace(r.Context(), installation.TeamID, installation.InstallerUserID); err != nil {
+			log.Error().Err(err).Str("team", installation.TeamID).Msg("Failed to record installer as workspace user")
+		}
+
+		log.Info().Str("team", installation.TeamID).Str("installer", installation.InstallerUserID).Msg("Slack app installed")
+		renderInstallPage(w, http.StatusOK, installSucceededPage(teamName))
+	}
+}
+
+func (s SlackInstaller) signState(nonce []byte, expiresAt time.Time) string {
+	payload := make([]byte, len(nonce)+8)
+	copy(payload, nonce)
+	binary.BigEndian.PutUint64(payload[len(nonce):], uint64(expiresAt.Unix()))
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(s.stateMAC(payload))
+}
+
+func (s SlackInstaller) verifyState(r *http.Request, state string) error {
+	parts := strings.SplitN(state, ".", 2)
+	if len(parts) != 2 {
+		return ErrInvalidOAuthState
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || len(payload) != oauthNonceSize+8 {
+		return ErrInvalidOAuthState
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(mac, s.stateMAC(payload)) {
+		return ErrInvalidOAuthState
+	}
+	if time.Now().Unix() > int64(binary.BigEndian.Uint64(payload[oauthNonceSize:])) {
+		return ErrExpiredOAuthState
+	}
+
+	cookie, err := r.Cookie(oauthStateCookie)
+	if err != nil {
+		return ErrInvalidOAuthState
+	}
+	nonce := base64.RawURLEncoding.EncodeToString(payload[:oauthNonceSize])
+	if subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(nonce)) != 1 {
+		return ErrInvalidOAuthState
+	}
+
+	return nil
+}
+
+func (s SlackInstaller) stateMAC(payload []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(s.config.StateSecret))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+type installPage struct {
+	Title   string
+	Message string
+}
+
+var (
+	installCancelledPage = installPage{Title: "Installation cancelled", Message: "yamex was not added to your workspace. You can close this window."}
+	installExpiredPage   = installPage{Title: "Installation expired", Message: "This install link is invalid or has expired. Please start the installation again."}
+	installFailedPage    = installPage{Title: "Installation failed", Message: "Something went wrong while installing yamex. Please try again in a few minutes."}
+)
+
+func installSucceededPage(teamName string) installPage {
+	return installPage{Title: "yamex is installed!", Message: "yamex was added to " + teamName + ". Mention @yamex in a channel to get started."}
+}
+
+var installPageTemplate = template.Must(template.New("install").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="utf-8">
+	<title>{{.Title}}</title>
+	<style>body { font-family: sans-serif; max-width: 36em; margin: 4em auto; text-align: center; }</style>
+</head>
+<body>
+	<h1>{{.Title}}</h1>
+	<p>{{.Message}}</p>
+</body>
+</html>
+`))
+
+func renderInstallPage(w http.ResponseWriter, status int, page installPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := installPageTemplate.Execute(w, page); err != nil {
+		log.Error().Err(err).Msg("Failed to render install page")
+	}
+}
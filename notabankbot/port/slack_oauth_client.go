@@ -0,0 +1,146 @@
+package port
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const DefaultSlackAPIURL = "https://slack.com/api/"
+
+// SlackOAuthClient calls oauth.v2.access. The slack package doesn't know
+// about token rotation, so we decode the response ourselves.
+type SlackOAuthClient struct {
+	clientID     string
+	clientSecret string
+	apiURL       string
+	httpClient   *http.Client
+}
+
+func NewSlackOAuthClient(clientID, clientSecret, apiURL string) *SlackOAuthClient {
+	if apiURL == "" {
+		apiURL = DefaultSlackAPIURL
+	}
+	if !strings.HasSuffix(apiURL, "/") {
+		apiURL += "/"
+	}
+
+	return &SlackOAuthClient{
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		apiURL:       apiURL,
+		httpClient:   &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+type oauthV2Response struct {
+	OK          bool   `json:"ok"`
+	Error       string `json:"error"`
+	AccessToken string `json:"access_token"`
+	// Only set when token rotation is enabled for the app.
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	Scope        string `json:"scope"`
+	BotUserID    string `json:"bot_user_id"`
+	Team         struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"team"`
+	Enterprise struct {
+		ID string `json:"id"`
+	} `json:"enterprise"`
+	AuthedUser struct {
+		ID string `json:"id"`
+	} `json:"authed_user"`
+}
+
+func (r oauthV2Response) installation() *SlackInstallation {
+	installation := &SlackInstallation{
+		TeamID:          r.Team.ID,
+		EnterpriseID:    r.Enterprise.ID,
+		BotUserID:       r.BotUserID,
+		Scopes:          r.Scope,
+		InstallerUserID: r.AuthedUser.ID,
+		Token:           r.AccessToken,
+		RefreshToken:    r.RefreshToken,
+	}
+	if r.ExpiresIn > 0 {
+		expiresAt := time.Now().Add(time.Duration(r.ExpiresIn) * time.Second)
+		installation.ExpiresAt = &expiresAt
+	}
+
+	return installation
+}
+
+// ExchangeCode completes an install, returning the new installation.
+func (c SlackOAuthClient) ExchangeCode(ctx context.Context, code, redirectURI string) (*SlackInstallation, string, error) {
+	resp, err := c.access(ctx, url.Values{
+		"code":         {code},
+		"redirect_uri": {redirectURI},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return resp.installation(), resp.Team.Name, nil
+}
+
+// Refresh swaps a refresh token for a new access token. Refresh responses
+// only describe the token, so the rest of the installation is carried over.
+func (c SlackOAuthClient) Refresh(ctx context.Context, installation *SlackInstallation) (*SlackInstallation, error) {
+	resp, err := c.access(ctx, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {installation.RefreshToken},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	refreshed := resp.installation()
+	refreshed.TeamID = installation.TeamID
+	refreshed.EnterpriseID = installation.EnterpriseID
+	refreshed.BotUserID = installation.BotUserID
+	refreshed.InstallerUserID = installation.InstallerUserID
+	if refreshed.Scopes == "" {
+		refreshed.Scopes = installation.Scopes
+	}
+	if refreshed.RefreshToken == "" {
+		refreshed.RefreshToken = installation.RefreshToken
+	}
+
+	return refreshed, nil
+}
+
+func (c SlackOAuthClient) access(ctx context.Context, values url.Values) (*oauthV2Response, error) {
+	values.Set("client_id", c.clientID)
+	values.Set("client_secret", c.clientSecret)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.apiURL+"oauth.v2.access", strings.NewReader(values.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oauth.v2.access: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oauth.v2.access: unexpected status %d", res.StatusCode)
+	}
+
+	var resp oauthV2Response
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("oauth.v2.access: decoding response: %w", err)
+	}
+	if !resp.OK {
+		return nil, fmt.Errorf("oauth.v2.access: %s", resp.Error)
+	}
+
+	return &resp, nil
+}
@@ -0,0 +1,183 @@
+package port
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/slack-go/slack"
+
+	"github.com/yammine/yamex-go"
+)
+
+// RedactedResponseURL replaces response URLs in recordings. They let anyone
+// post into the conversation for a while, so they are treated as secrets.
+const RedactedResponseURL = "https://hooks.slack.com/redacted"
+
+// recordedPaths are the endpoints Slack calls, and so the ones worth recording.
+var recordedPaths = map[string]bool{
+	"/slack/events":      true,
+	"/slack/interaction": true,
+	"/slack/commands":    true,
+}
+
+// RecordedRequest is one line of a recording.
+type RecordedRequest struct {
+	RecordedAt  time.Time `json:"recorded_at"`
+	Path        string    `json:"path"`
+	ContentType string    `json:"content_type"`
+	Body        string    `json:"body"`
+}
+
+// SlackRecorder writes verified inbound Slack requests to a JSONL recording,
+// with secrets redacted, so they can be replayed with `yamex replay`.
+type SlackRecorder struct {
+	mu            sync.Mutex
+	w             io.Writer
+	signingSecret string
+}
+
+func NewSlackRecorder(w io.Writer, signingSecret string) *SlackRecorder {
+	return &SlackRecorder{w: w, signingSecret: signingSecret}
+}
+
+// Middleware records requests to the Slack endpoints before passing them on.
+// Requests that fail signature verification are passed on but not recorded.
+func (s *SlackRecorder) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !recordedPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		if verifySlackRequest(s.signingSecret, r.Header, body) {
+			s.record(r, body)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *SlackRecorder) record(r *http.Request, body []byte) {
+	contentType := r.Header.Get("Content-Type")
+	line, err := json.Marshal(&RecordedRequest{
+		RecordedAt:  time.Now().UTC(),
+		Path:        r.URL.Path,
+		ContentType: contentType,
+		Body:        RedactSlackPayload(contentType, body),
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to encode recorded request")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.w.Write(append(line, '\n')); err != nil {
+		log.Error().Err(err).Msg("Failed to record request")
+	}
+}
+
+// ReadRecording parses a JSONL recording.
+func ReadRecording(r io.Reader) ([]*RecordedRequest, error) {
+	var requests []*RecordedRequest
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var req RecordedRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		requests = append(requests, &req)
+	}
+
+	return requests, scanner.Err()
+}
+
+// RedactSlackPayload strips verification tokens, response URLs and anything
+// that looks like a Slack token from a request body, keeping its encoding.
+func RedactSlackPayload(contentType string, body []byte) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType != "application/x-www-form-urlencoded" {
+		return redactJSON(string(body))
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return yamex.Redact(string(body))
+	}
+	for key := range form {
+		switch key {
+		case "token":
+			form.Set(key, "[REDACTED]")
+		case "response_url":
+			form.Set(key, RedactedResponseURL)
+		case "payload":
+			form.Set(key, redactJSON(form.Get(key)))
+		}
+	}
+	return yamex.Redact(form.Encode())
+}
+
+func redactJSON(s string) string {
+	var payload interface{}
+	if err := json.Unmarshal([]byte(s), &payload); err != nil {
+		return yamex.Redact(s)
+	}
+	redacted, err := json.Marshal(redactJSONValue(payload))
+	if err != nil {
+		return yamex.Redact(s)
+	}
+	return yamex.Redact(string(redacted))
+}
+
+func redactJSONValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for key, nested := range value {
+			switch key {
+			case "token":
+				value[key] = "[REDACTED]"
+			case "response_url":
+				value[key] = RedactedResponseURL
+			default:
+				value[key] = redactJSONValue(nested)
+			}
+		}
+	case []interface{}:
+		for i := range value {
+			value[i] = redactJSONValue(value[i])
+		}
+	}
+	return v
+}
+
+func verifySlackRequest(secret string, header http.Header, body []byte) bool {
+	sv, err := slack.NewSecretsVerifier(header, secret)
+	if err != nil {
+		return false
+	}
+	if _, err := sv.Write(body); err != nil {
+		return false
+	}
+	return sv.Ensure() == nil
+}
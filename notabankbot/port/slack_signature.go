@@ -0,0 +1,39 @@
+package port
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/slack-go/slack"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/yammine/yamex-go/notabankbot/port")
+
+// verifySignature checks a request was signed by Slack with the app's signing
+// secret. It returns the status to reject the request with, or 0 if it is
+// genuine.
+func verifySignature(ctx context.Context, secret string, header http.Header, body []byte) (status int) {
+	_, span := tracer.Start(ctx, "slack.VerifySignature")
+	defer span.End()
+
+	sv, err := slack.NewSecretsVerifier(header, secret)
+	if err != nil {
+		return http.StatusBadRequest
+	}
+	if _, err := sv.Write(body); err != nil {
+		return http.StatusInternalServerError
+	}
+	if err := sv.Ensure(); err != nil {
+		span.RecordError(err)
+		return http.StatusUnauthorized
+	}
+	return 0
+}
+
+// detach keeps ctx's trace but not its deadline or cancellation, for work
+// that continues after the response is sent.
+func detach(ctx context.Context) context.Context {
+	return trace.ContextWithSpanContext(context.Background(), trace.SpanContextFromContext(ctx))
+}
@@ -0,0 +1,137 @@
+package port
+
+import (
+	"context"
+	"encoding/json"
+	stdlog "log"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"github.com/slack-go/slack/socketmode"
+)
+
+const (
+	socketModeMinBackoff = time.Second
+	socketModeMaxBackoff = time.Minute
+)
+
+// SlackSocketMode receives events, interactions & slash commands over a Socket
+// Mode websocket instead of public HTTP endpoints, handy when developing
+// without a tunnel. Everything is handed to the same processing as the HTTP
+// handlers.
+type SlackSocketMode struct {
+	consumer   *SlackConsumer
+	interactor *SlackInteractor
+	client     *socketmode.Client
+
+	connected int32
+}
+
+// NewSlackSocketMode builds a runner authenticated by an app-level token
+// (xapp-...). apiURL may point at a fake Slack, empty uses the real one.
+func NewSlackSocketMode(consumer *SlackConsumer, interactor *SlackInteractor, appToken, apiURL string) *SlackSocketMode {
+	logger := stdlog.New(log.Logger.With().Str("component", "socketmode").Logger(), "", 0)
+
+	opts := []slack.Option{slack.OptionAppLevelToken(appToken), slack.OptionLog(logger)}
+	if apiURL != "" {
+		opts = append(opts, slack.OptionAPIURL(apiURL))
+	}
+	api := slack.New("", opts...)
+
+	return &SlackSocketMode{
+		consumer:   consumer,
+		interactor: interactor,
+		client:     socketmode.New(api, socketmode.OptionLog(logger)),
+	}
+}
+
+// Run keeps a Socket Mode connection open until ctx is cancelled, reconnecting
+// with backoff whenever the connection can't be (re)established.
+func (s *SlackSocketMode) Run(ctx context.Context) {
+	go s.handleEvents(ctx)
+
+	backoff := socketModeMinBackoff
+	for {
+		err := s.client.RunContext(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if atomic.SwapInt32(&s.connected, 0) == 1 {
+			backoff = socketModeMinBackoff
+		}
+		log.Error().Err(err).Dur("retry_in", backoff).Msg("Socket Mode connection lost")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > socketModeMaxBackoff {
+			backoff = socketModeMaxBackoff
+		}
+	}
+}
+
+func (s *SlackSocketMode) handleEvents(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case evt := <-s.client.Events:
+			s.handleEvent(ctx, evt)
+		}
+	}
+}
+
+func (s *SlackSocketMode) handleEvent(ctx context.Context, evt socketmode.Event) {
+	switch evt.Type {
+	case socketmode.EventTypeConnected:
+		atomic.StoreInt32(&s.connected, 1)
+		log.Info().Msg("Connected to Slack with Socket Mode")
+	case socketmode.EventTypeConnectionError, socketmode.EventTypeIncomingError:
+		log.Warn().Msgf("Socket Mode connection error: %+v", evt.Data)
+	case socketmode.EventTypeInvalidAuth:
+		log.Error().Msg("Socket Mode authentication failed, check SLACK_APP_TOKEN")
+	case socketmode.EventTypeEventsAPI:
+		// Slack redelivers anything not acknowledged within 3 seconds, so
+		// acknowledge once processing has started in the background.
+		eventsAPIEvent, ok := evt.Data.(slackevents.EventsAPIEvent)
+		if !ok || eventsAPIEvent.Type != slackevents.CallbackEvent {
+			s.client.Ack(*evt.Request)
+			return
+		}
+		s.process(evt, "event", func() { s.consumer.HandleCallbackEvent(ctx, eventsAPIEvent) })
+	case socketmode.EventTypeInteractive:
+		interaction := &SlackInteraction{}
+		if err := json.Unmarshal(evt.Request.Payload, interaction); err != nil {
+			s.client.Ack(*evt.Request)
+			log.Error().Err(err).Msg("Failed to parse interaction payload")
+			return
+		}
+		s.process(evt, "interaction", func() { s.interactor.ProcessInteraction(ctx, interaction) })
+	case socketmode.EventTypeSlashCommand:
+		cmd, ok := evt.Data.(slack.SlashCommand)
+		if !ok {
+			s.client.Ack(*evt.Request)
+			return
+		}
+		s.process(evt, "slash_command", func() { s.consumer.HandleSlashCommand(ctx, cmd) })
+	case socketmode.EventTypeHello, socketmode.EventTypeConnecting, socketmode.EventTypeDisconnect:
+		log.Debug().Str("type", string(evt.Type)).Msg("Socket Mode lifecycle event")
+	default:
+		log.Debug().Str("type", string(evt.Type)).Msg("Unhandled Socket Mode event")
+	}
+}
+
+// process runs fn in the background and acknowledges evt. While shutting down
+// evt is left unacknowledged, for Slack to redeliver elsewhere.
+func (s *SlackSocketMode) process(evt socketmode.Event, kind string, fn func()) {
+	if !s.consumer.inFlight.Go(kind, fn) {
+		log.Warn().Str("type", string(evt.Type)).Msg("Shutting down, leaving Socket Mode event for Slack to redeliver")
+		return
+	}
+	s.client.Ack(*evt.Request)
+}
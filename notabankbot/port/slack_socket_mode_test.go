@@ -0,0 +1,118 @@
+package port_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+
+	"github.com/yammine/yamex-go/notabankbot/port"
+	"github.com/yammine/yamex-go/notabankbot/slackfake"
+)
+
+// startSocketMode connects the bot to the fake over Socket Mode.
+func startSocketMode(t *testing.T, botUserID string) *slackfake.Server {
+	t.Helper()
+	b := startBot(t, botUserID, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go port.NewSlackSocketMode(b.consumer, b.interactor, "xapp-test", b.fake.APIURL()).Run(ctx)
+
+	waitForSocket(t, b.fake)
+	return b.fake
+}
+
+func waitForSocket(t *testing.T, fake *slackfake.Server) {
+	t.Helper()
+	waitForSocketState(t, fake, true)
+}
+
+func waitForSocketState(t *testing.T, fake *slackfake.Server, connected bool) {
+	t.Helper()
+	deadline := time.Now().Add(testWait)
+	for fake.SocketConnected() != connected {
+		if time.Now().After(deadline) {
+			t.Fatalf("socket mode client connected = %t, want %t", !connected, connected)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSocketModeSlashCommand(t *testing.T) {
+	for _, botUserID := range []string{testBotUser, ""} {
+		t.Run(fmt.Sprintf("bot user %q", botUserID), func(t *testing.T) {
+			fake := startSocketMode(t, botUserID)
+
+			envelopeID, err := fake.SendSocketRequest("slash_commands", slack.SlashCommand{
+				TeamID:      testWorkspace,
+				ChannelID:   testChannel,
+				UserID:      testAlice,
+				Command:     "/yamex",
+				Text:        "balance",
+				ResponseURL: fake.ResponseURL("balance"),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := fake.WaitForAck(envelopeID, testWait); err != nil {
+				t.Fatal(err)
+			}
+
+			calls, err := fake.WaitForCalls("response_url", 1, testWait)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var msg struct {
+				Text string `json:"text"`
+			}
+			if err := calls[0].JSON(&msg); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(msg.Text, "Account balances for <@"+testAlice+">") {
+				t.Errorf("reply = %q, want alice's balances", msg.Text)
+			}
+		})
+	}
+}
+
+func TestSocketModeAppMention(t *testing.T) {
+	fake := startSocketMode(t, testBotUser)
+
+	envelopeID, err := fake.SendSocketRequest("events_api", slackfake.AppMention(testWorkspace, testBob, testChannel, "<@"+testBotUser+"> balance"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fake.WaitForAck(envelopeID, testWait); err != nil {
+		t.Fatal(err)
+	}
+
+	calls, err := fake.WaitForCalls("chat.postMessage", 1, testWait)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := calls[0].Params.Get("channel"); got != testChannel {
+		t.Errorf("posted to %q, want %s", got, testChannel)
+	}
+	if got := calls[0].Params.Get("text"); !strings.HasPrefix(got, "Account balances for <@"+testBob+">") {
+		t.Errorf("reply = %q, want bob's balances", got)
+	}
+}
+
+func TestSocketModeReconnects(t *testing.T) {
+	fake := startSocketMode(t, testBotUser)
+
+	fake.DropSockets()
+	waitForSocketState(t, fake, false)
+	waitForSocket(t, fake)
+
+	envelopeID, err := fake.SendSocketRequest("events_api", slackfake.AppMention(testWorkspace, testBob, testChannel, "<@"+testBotUser+"> balance"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fake.WaitForAck(envelopeID, testWait); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,71 @@
+package port
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/singleflight"
+)
+
+// refreshBefore is how long before expiry a rotating token gets refreshed, so
+// that it can't expire while a request is in flight.
+const refreshBefore = 5 * time.Minute
+
+// RefreshingCredentialStore wraps a SlackCredentialStore so that rotating
+// tokens are refreshed transparently whenever credentials are fetched.
+type RefreshingCredentialStore struct {
+	SlackCredentialStore
+
+	oauth *SlackOAuthClient
+	// Concurrent events for the same workspace share one refresh.
+	refreshes singleflight.Group
+}
+
+func NewRefreshingCredentialStore(store SlackCredentialStore, oauth *SlackOAuthClient) *RefreshingCredentialStore {
+	return &RefreshingCredentialStore{
+		SlackCredentialStore: store,
+		oauth:                oauth,
+	}
+}
+
+func (r *RefreshingCredentialStore) GetCredentials(ctx context.Context, workspaceID string) (*SlackInstallation, error) {
+	installation, err := r.SlackCredentialStore.GetCredentials(ctx, workspaceID)
+	if err != nil || !needsRefresh(installation) {
+		return installation, err
+	}
+
+	refreshed, err, _ := r.refreshes.Do(workspaceID, func() (interface{}, error) {
+		// Another caller may have refreshed while we waited.
+		current, err := r.SlackCredentialStore.GetCredentials(ctx, workspaceID)
+		if err != nil {
+			return nil, err
+		}
+		if !needsRefresh(current) {
+			return current, nil
+		}
+
+		refreshed, err := r.oauth.Refresh(ctx, current)
+		if err != nil {
+			return nil, fmt.Errorf("refreshing token: %w", err)
+		}
+		if err := r.SlackCredentialStore.SaveCredentials(ctx, refreshed); err != nil {
+			return nil, fmt.Errorf("saving refreshed token: %w", err)
+		}
+		log.Info().Str("team", workspaceID).Interface("expires_at", refreshed.ExpiresAt).Msg("Refreshed slack token")
+
+		return refreshed, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return refreshed.(*SlackInstallation), nil
+}
+
+func needsRefresh(installation *SlackInstallation) bool {
+	return installation.RefreshToken != "" &&
+		installation.ExpiresAt != nil &&
+		time.Until(*installation.ExpiresAt) < refreshBefore
+}
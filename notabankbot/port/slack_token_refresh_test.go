@@ -0,0 +1,237 @@
+package port_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yammine/yamex-go/notabankbot/port"
+)
+
+// savedCredentials is a credential store that remembers what it's given.
+type savedCredentials struct {
+	mu            sync.Mutex
+	installations map[string]port.SlackInstallation
+}
+
+func newSavedCredentials(installations ...port.SlackInstallation) *savedCredentials {
+	s := &savedCredentials{installations: map[string]port.SlackInstallation{}}
+	for _, installation := range installations {
+		s.installations[installation.TeamID] = installation
+	}
+	return s
+}
+
+func (s *savedCredentials) SaveCredentials(ctx context.Context, installation *port.SlackInstallation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.installations[installation.TeamID] = *installation
+	return nil
+}
+
+func (s *savedCredentials) GetCredentials(ctx context.Context, workspaceID string) (*port.SlackInstallation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	installation, ok := s.installations[workspaceID]
+	if !ok {
+		return nil, port.ErrUnknownWorkspace
+	}
+	return &installation, nil
+}
+
+func (s *savedCredentials) RevokeCredentials(ctx context.Context, workspaceID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.installations, workspaceID)
+	return nil
+}
+
+// fakeOAuth answers oauth.v2.access refreshes with respond.
+type fakeOAuth struct {
+	*httptest.Server
+	respond func(w http.ResponseWriter)
+
+	mu    sync.Mutex
+	calls int
+	// arrived is signalled on every call.
+	arrived chan struct{}
+}
+
+func startFakeOAuth(t *testing.T, respond func(w http.ResponseWriter)) *fakeOAuth {
+	t.Helper()
+	f := &fakeOAuth{respond: respond, arrived: make(chan struct{}, 100)}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/oauth.v2.access" {
+			http.NotFound(w, r)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.PostForm.Get("grant_type") != "refresh_token" || r.PostForm.Get("refresh_token") != "xoxe-1-old" ||
+			r.PostForm.Get("client_id") != "client-id" || r.PostForm.Get("client_secret") != "client-secret" {
+			t.Errorf("unexpected refresh request: %v", r.PostForm)
+		}
+		f.mu.Lock()
+		f.calls++
+		f.mu.Unlock()
+		f.arrived <- struct{}{}
+		f.respond(w)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeOAuth) callCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+func refreshed(w http.ResponseWriter) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ok":            true,
+		"access_token":  "xoxe.xoxb-new",
+		"refresh_token": "xoxe-1-new",
+		"expires_in":    43200,
+	})
+}
+
+func expiringInstallation(expiresIn time.Duration) port.SlackInstallation {
+	expiresAt := time.Now().Add(expiresIn)
+	return port.SlackInstallation{
+		TeamID:       testWorkspace,
+		BotUserID:    testBotUser,
+		Scopes:       "app_mentions:read,chat:write",
+		Token:        "xoxe.xoxb-old",
+		RefreshToken: "xoxe-1-old",
+		ExpiresAt:    &expiresAt,
+	}
+}
+
+func TestRefreshingCredentialStoreRefreshesOnce(t *testing.T) {
+	release := make(chan struct{})
+	oauth := startFakeOAuth(t, func(w http.ResponseWriter) {
+		<-release
+		refreshed(w)
+	})
+	saved := newSavedCredentials(expiringInstallation(time.Minute))
+	store := port.NewRefreshingCredentialStore(saved, port.NewSlackOAuthClient("client-id", "client-secret", oauth.URL))
+
+	const callers = 10
+	var wg sync.WaitGroup
+	tokens := make(chan string, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			installation, err := store.GetCredentials(context.Background(), testWorkspace)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			tokens <- installation.Token
+		}()
+	}
+	// Hold the refresh until the others have had time to pile up behind it.
+	<-oauth.arrived
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(tokens)
+
+	for token := range tokens {
+		if token != "xoxe.xoxb-new" {
+			t.Errorf("caller got token %q, want the refreshed one", token)
+		}
+	}
+	if calls := oauth.callCount(); calls != 1 {
+		t.Errorf("refreshed %d times, want once", calls)
+	}
+
+	installation, err := saved.GetCredentials(context.Background(), testWorkspace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if installation.Token != "xoxe.xoxb-new" || installation.RefreshToken != "xoxe-1-new" {
+		t.Errorf("saved tokens %q, %q, want the refreshed ones", installation.Token, installation.RefreshToken)
+	}
+	if installation.BotUserID != testBotUser || installation.Scopes != "app_mentions:read,chat:write" {
+		t.Errorf("refresh lost the installation's details: %+v", installation)
+	}
+	if installation.ExpiresAt == nil || time.Until(*installation.ExpiresAt) < 11*time.Hour {
+		t.Errorf("refreshed token expires at %v, want in 12 hours", installation.ExpiresAt)
+	}
+
+	// Fresh tokens are served without refreshing.
+	if _, err := store.GetCredentials(context.Background(), testWorkspace); err != nil {
+		t.Fatal(err)
+	}
+	if calls := oauth.callCount(); calls != 1 {
+		t.Errorf("refreshed a fresh token, %d calls", calls)
+	}
+}
+
+func TestRefreshingCredentialStoreSkipsLongLivedTokens(t *testing.T) {
+	oauth := startFakeOAuth(t, refreshed)
+	longLived := expiringInstallation(time.Hour)
+	saved := newSavedCredentials(longLived)
+	store := port.NewRefreshingCredentialStore(saved, port.NewSlackOAuthClient("client-id", "client-secret", oauth.URL))
+
+	installation, err := store.GetCredentials(context.Background(), testWorkspace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if installation.Token != "xoxe.xoxb-old" || oauth.callCount() != 0 {
+		t.Errorf("token %q after %d refreshes, want the unexpired one untouched", installation.Token, oauth.callCount())
+	}
+}
+
+func TestRefreshingCredentialStoreReportsFailures(t *testing.T) {
+	cases := []struct {
+		name    string
+		respond func(w http.ResponseWriter)
+		want    string
+	}{
+		{"slack error", func(w http.ResponseWriter) {
+			json.NewEncoder(w).Encode(map[string]interface{}{"ok": false, "error": "invalid_refresh_token"})
+		}, "invalid_refresh_token"},
+		{"server error", func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}, "unexpected status 500"},
+		{"garbled response", func(w http.ResponseWriter) {
+			w.Write([]byte("<html>"))
+		}, "decoding response"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			oauth := startFakeOAuth(t, tc.respond)
+			saved := newSavedCredentials(expiringInstallation(time.Minute))
+			store := port.NewRefreshingCredentialStore(saved, port.NewSlackOAuthClient("client-id", "client-secret", oauth.URL))
+
+			for attempt := 1; attempt <= 2; attempt++ {
+				_, err := store.GetCredentials(context.Background(), testWorkspace)
+				if err == nil || !strings.Contains(err.Error(), "refreshing token") || !strings.Contains(err.Error(), tc.want) {
+					t.Errorf("attempt %d: err = %v, want a refresh failure mentioning %q", attempt, err, tc.want)
+				}
+			}
+			// Failures aren't remembered, the next fetch tries again.
+			if calls := oauth.callCount(); calls != 2 {
+				t.Errorf("refreshed %d times, want 2", calls)
+			}
+			installation, err := saved.GetCredentials(context.Background(), testWorkspace)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if installation.Token != "xoxe.xoxb-old" {
+				t.Errorf("saved token %q after a failed refresh, want the old one", installation.Token)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package port
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+
+	"github.com/slack-go/slack"
+
+	"github.com/yammine/yamex-go/notabankbot/app"
+	"github.com/yammine/yamex-go/notabankbot/domain"
+	"github.com/yammine/yamex-go/notabankbot/tracing"
+)
+
+// The command expressions start with a mention of the bot, which slash
+// commands don't have, so one is prepended. Credentials that don't record the
+// bot's user ID get a stand-in, which is only ever matched, never shown.
+const slashCommandBotUserID = "U0000000000"
+
+var botUserIDPattern = regexp.MustCompile(`^[A-Z0-9]{11}$`)
+
+// SlashCommandHandler receives slash commands over HTTP, e.g. `/yamex balance`.
+func (s SlackConsumer) SlashCommandHandler() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if status := verifySignature(r.Context(), s.signingSecret, r.Header, body); status != 0 {
+			w.WriteHeader(status)
+			return
+		}
+
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		cmd, err := slack.SlashCommandParse(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// Slack wants an answer within 3 seconds, the response goes to response_url instead.
+		ctx := detach(r.Context())
+		if !s.inFlight.Go("slash_command", func() { s.HandleSlashCommand(ctx, cmd) }) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// HandleSlashCommand runs a slash command as if the bot had been mentioned
+// with the same text, and replies through the command's response_url.
+func (s SlackConsumer) HandleSlashCommand(ctx context.Context, cmd slack.SlashCommand) (err error) {
+	ctx, span := tracer.Start(ctx, "slack.HandleSlashCommand")
+	defer tracing.End(span, &err)
+	creds, err := s.credentials.GetCredentials(ctx, cmd.TeamID)
+	if errors.Is(err, ErrUnknownWorkspace) {
+		tracing.Logger(ctx).Warn().Str("team", cmd.TeamID).Msg("Ignoring slash command from unknown workspace")
+		return nil
+	}
+	if err != nil {
+		tracing.Logger(ctx).Error().Err(err).Msg("Failed to get slack credentials")
+		return err
+	}
+
+	// Slash commands are answered through their response_url, in the channel
+	// unless the response is ephemeral.
+	replyTo := &domain.OutboxMessage{
+		TraceParent: tracing.TraceParent(ctx),
+		WorkspaceID: cmd.TeamID,
+		ChannelID:   cmd.ChannelID,
+		ResponseURL: cmd.ResponseURL,
+	}
+	var response BotResponse
+	if s.allow(ctx, &app.RateLimitInput{WorkspaceID: cmd.TeamID, ChannelID: cmd.ChannelID, UserID: cmd.UserID}) {
+		response = s.ProcessAppMention(ctx, &BotMention{
+			Platform:    "slack",
+			WorkspaceID: cmd.TeamID,
+			UserID:      cmd.UserID,
+			Text:        slashCommandText(creds.BotUserID, cmd.Text),
+			ReplyTo:     replyTo,
+		})
+	} else {
+		response = BotResponse{Text: RateLimitedResponse, Ephemeral: true}
+	}
+	s.reply(ctx, replyTo, cmd.UserID, response)
+
+	return nil
+}
+
+// slashCommandText is the command's text as if the bot had been mentioned.
+func slashCommandText(botUserID, text string) string {
+	if !botUserIDPattern.MatchString(botUserID) {
+		botUserID = slashCommandBotUserID
+	}
+	return replaceWhitespace("<@" + botUserID + "> " + text)
+}
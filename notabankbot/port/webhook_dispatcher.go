@@ -0,0 +1,249 @@
+package port
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/yammine/yamex-go"
+	"github.com/yammine/yamex-go/notabankbot/app"
+	"github.com/yammine/yamex-go/notabankbot/domain"
+	"github.com/yammine/yamex-go/notabankbot/metrics"
+)
+
+const (
+	ErrWebhookEndpointDisabled = yamex.Sentinel("webhook endpoint is disabled")
+
+	webhookBatchSize       = 20
+	webhookLease           = time.Minute
+	webhookDeliveryTimeout = 10 * time.Second
+)
+
+// WebhookDispatcher delivers queued ledger events to webhook endpoints,
+// retrying failures with backoff. Deliveries are queued with the ledger change
+// they announce, so none are lost if the server stops.
+type WebhookDispatcher struct {
+	app *app.Application
+	// Client sends the requests, one with a timeout unless replaced.
+	Client *http.Client
+	wake   chan struct{}
+}
+
+func NewWebhookDispatcher(app *app.Application) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		app:    app,
+		Client: &http.Client{Timeout: webhookDeliveryTimeout},
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Wake makes Run deliver pending events now rather than at its next poll.
+func (d *WebhookDispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers due events every interval, and whenever woken, until ctx is
+// cancelled.
+func (d *WebhookDispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		d.deliverDue(ctx)
+		d.recordPending(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// Test sends the endpoint a webhook.test event right away, once, returning
+// the logged delivery.
+func (d *WebhookDispatcher) Test(ctx context.Context, endpointID uint) (*domain.WebhookDelivery, error) {
+	endpoint, err := d.app.GetWebhookEndpoint(ctx, endpointID)
+	if err != nil {
+		return nil, err
+	}
+	delivery, err := domain.NewWebhookDelivery(endpoint.ID, &domain.LedgerEvent{
+		Type:        domain.EventWebhookTest,
+		WorkspaceID: endpoint.WorkspaceID,
+		CreatedAt:   time.Now(),
+		Data:        map[string]uint{"endpoint_id": endpoint.ID},
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Queued claimed, so a dispatcher doesn't send it too.
+	delivery.NextAttemptAt = time.Now().Add(webhookLease)
+	if err := d.app.EnqueueWebhookDelivery(ctx, delivery); err != nil {
+		return nil, err
+	}
+
+	status, err := d.send(ctx, endpoint, delivery)
+	if err == nil {
+		return delivery, d.app.WebhookDelivered(ctx, delivery, status)
+	}
+	_, saveErr := d.app.WebhookFailed(ctx, delivery, status, &app.DeliveryFailure{Err: err, Permanent: true})
+	return delivery, saveErr
+}
+
+func (d *WebhookDispatcher) deliverDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		deliveries, err := d.app.ClaimWebhookDeliveries(ctx, webhookBatchSize, webhookLease)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to claim webhook deliveries")
+			return
+		}
+		// Deliveries in a batch are often for the same few endpoints.
+		endpoints := map[uint]*domain.WebhookEndpoint{}
+		for _, delivery := range deliveries {
+			endpoint, ok := endpoints[delivery.EndpointID]
+			if !ok {
+				endpoint, err = d.app.GetWebhookEndpoint(ctx, delivery.EndpointID)
+				if err != nil {
+					log.Error().Err(err).Uint("webhook_delivery", delivery.ID).Msg("Failed to fetch webhook endpoint")
+					continue
+				}
+				endpoints[delivery.EndpointID] = endpoint
+			}
+			d.deliver(ctx, endpoint, delivery)
+		}
+		if len(deliveries) < webhookBatchSize {
+			return
+		}
+	}
+}
+
+func (d *WebhookDispatcher) recordPending(ctx context.Context) {
+	pending, err := d.app.PendingWebhookDeliveries(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to count pending webhook deliveries")
+		return
+	}
+	metrics.WebhookPending.Set(float64(pending))
+}
+
+func (d *WebhookDispatcher) deliver(ctx context.Context, endpoint *domain.WebhookEndpoint, delivery *domain.WebhookDelivery) {
+	// A delivery that has started is finished even if Run is being stopped.
+	ctx, span := tracer.Start(detach(ctx), "webhook.Deliver", trace.WithNewRoot())
+	defer span.End()
+	span.SetAttributes(
+		attribute.Int64("yamex.webhook_delivery", int64(delivery.ID)),
+		attribute.String("yamex.webhook_event", delivery.EventType),
+		attribute.Int("yamex.attempt", delivery.Attempts+1),
+	)
+
+	var status int
+	var failure *app.DeliveryFailure
+	if endpoint.DisabledAt != nil {
+		failure = &app.DeliveryFailure{Err: ErrWebhookEndpointDisabled, Permanent: true}
+	} else {
+		var err error
+		status, err = d.send(ctx, endpoint, delivery)
+		if err == nil {
+			metrics.WebhookDeliveries.WithLabelValues("delivered").Inc()
+			if err := d.app.WebhookDelivered(ctx, delivery, status); err != nil {
+				log.Error().Err(err).Uint("webhook_delivery", delivery.ID).Msg("Failed to mark webhook delivered")
+			}
+			return
+		}
+		span.RecordError(err)
+		failure = classifyWebhookError(err)
+	}
+
+	retry, saveErr := d.app.WebhookFailed(ctx, delivery, status, failure)
+	if saveErr != nil {
+		log.Error().Err(saveErr).Uint("webhook_delivery", delivery.ID).Msg("Failed to record webhook failure")
+	}
+	logger := log.With().Err(failure.Err).
+		Uint("webhook_delivery", delivery.ID).
+		Uint("webhook_endpoint", endpoint.ID).
+		Int("attempts", delivery.Attempts).
+		Logger()
+	if retry {
+		metrics.WebhookDeliveries.WithLabelValues("retried").Inc()
+		logger.Warn().Time("next_attempt", delivery.NextAttemptAt).Msg("Failed to deliver webhook, retrying")
+		time.AfterFunc(time.Until(delivery.NextAttemptAt), d.Wake)
+	} else {
+		metrics.WebhookDeliveries.WithLabelValues("failed").Inc()
+		logger.Error().Msg("Failed to deliver webhook, giving up")
+	}
+}
+
+// webhookStatusError is an endpoint answering with something other than 2xx.
+type webhookStatusError struct {
+	status     int
+	retryAfter time.Duration
+}
+
+func (e webhookStatusError) Error() string {
+	return fmt.Sprintf("endpoint answered %d %s", e.status, http.StatusText(e.status))
+}
+
+// send posts the delivery's payload, signed with the endpoint's secret. It
+// returns the status the endpoint answered with, if it did.
+func (d *WebhookDispatcher) send(ctx context.Context, endpoint *domain.WebhookEndpoint, delivery *domain.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "yamex-webhooks")
+	req.Header.Set("Yamex-Event", delivery.EventType)
+	req.Header.Set("Yamex-Delivery", strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set("Yamex-Signature", "t="+timestamp+",v1="+SignWebhook(endpoint.Secret, timestamp, delivery.Payload))
+
+	res, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		retryAfter, _ := strconv.Atoi(res.Header.Get("Retry-After"))
+		return res.StatusCode, webhookStatusError{status: res.StatusCode, retryAfter: time.Duration(retryAfter) * time.Second}
+	}
+	return res.StatusCode, nil
+}
+
+// SignWebhook is the hex HMAC-SHA256 of "<timestamp>.<payload>". Receivers
+// recompute it to check the Yamex-Signature header, and should reject stale
+// timestamps.
+func SignWebhook(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s.%s", timestamp, payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// classifyWebhookError retries every failure, as endpoints often fail while
+// they're being deployed or fixed, except an endpoint saying it's gone.
+func classifyWebhookError(err error) *app.DeliveryFailure {
+	if statusErr, ok := err.(webhookStatusError); ok {
+		return &app.DeliveryFailure{
+			Err:        err,
+			Permanent:  statusErr.status == http.StatusGone,
+			RetryAfter: statusErr.retryAfter,
+		}
+	}
+	return &app.DeliveryFailure{Err: err}
+}
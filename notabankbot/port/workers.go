@@ -0,0 +1,113 @@
+package port
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WorkerStatus is a background loop as reported by /admin/debug.
+type WorkerStatus struct {
+	Name      string     `json:"name"`
+	Running   bool       `json:"running"`
+	StartedAt time.Time  `json:"started_at"`
+	StoppedAt *time.Time `json:"stopped_at,omitempty"`
+}
+
+type worker struct {
+	status WorkerStatus
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Workers runs and keeps track of the server's background loops.
+type Workers struct {
+	mu      sync.Mutex
+	workers []*worker
+}
+
+func NewWorkers() *Workers {
+	return &Workers{}
+}
+
+// Go runs fn in its own goroutine, recording when it starts & returns. fn's
+// ctx is cancelled by Stop.
+func (w *Workers) Go(name string, fn func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	wk := &worker{
+		status: WorkerStatus{Name: name, Running: true, StartedAt: time.Now()},
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	w.mu.Lock()
+	w.workers = append(w.workers, wk)
+	w.mu.Unlock()
+
+	go func() {
+		defer func() {
+			now := time.Now()
+			w.mu.Lock()
+			wk.status.Running = false
+			wk.status.StoppedAt = &now
+			w.mu.Unlock()
+			close(wk.done)
+		}()
+		fn(ctx)
+	}()
+}
+
+// Stop cancels the named workers, or all of them if none are named, and waits
+// for them to return until ctx is done.
+func (w *Workers) Stop(ctx context.Context, names ...string) error {
+	w.mu.Lock()
+	var stopping []*worker
+	for _, wk := range w.workers {
+		if len(names) == 0 || contains(names, wk.status.Name) {
+			stopping = append(stopping, wk)
+		}
+	}
+	w.mu.Unlock()
+
+	for _, wk := range stopping {
+		wk.cancel()
+	}
+	var abandoned []string
+	for _, wk := range stopping {
+		select {
+		case <-wk.done:
+			continue
+		default:
+		}
+		select {
+		case <-wk.done:
+		case <-ctx.Done():
+			abandoned = append(abandoned, wk.status.Name)
+		}
+	}
+	if len(abandoned) > 0 {
+		return fmt.Errorf("%w: workers %s", ErrWorkAbandoned, strings.Join(abandoned, ", "))
+	}
+	return nil
+}
+
+func (w *Workers) Status() []WorkerStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	statuses := make([]WorkerStatus, len(w.workers))
+	for i, wk := range w.workers {
+		statuses[i] = wk.status
+	}
+	return statuses
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
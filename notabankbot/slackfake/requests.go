@@ -0,0 +1,97 @@
+package slackfake
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Sign adds the headers Slack uses to sign requests, so synthetic payloads
+// pass the bot's signing secret verification.
+func Sign(r *http.Request, signingSecret string, body []byte) {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(signingSecret))
+	fmt.Fprintf(mac, "v0:%s:%s", ts, body)
+
+	r.Header.Set("X-Slack-Request-Timestamp", ts)
+	r.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+}
+
+// AppMention builds an Events API callback for an app_mention.
+func AppMention(teamID, userID, channelID, text string) map[string]interface{} {
+	ts := fmt.Sprintf("%d.000100", time.Now().Unix())
+	return map[string]interface{}{
+		"type":       "event_callback",
+		"team_id":    teamID,
+		"api_app_id": "AFAKE000000",
+		"event_id":   "Ev" + ts,
+		"event": map[string]interface{}{
+			"type":     "app_mention",
+			"user":     userID,
+			"text":     text,
+			"ts":       ts,
+			"channel":  channelID,
+			"event_ts": ts,
+		},
+	}
+}
+
+// EventRequest builds a signed POST of an Events API payload to path.
+func EventRequest(signingSecret, path string, event interface{}) (*http.Request, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	r, err := http.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	Sign(r, signingSecret, body)
+
+	return r, nil
+}
+
+// InteractionRequest builds a signed POST of an interaction payload to path.
+func InteractionRequest(signingSecret, path string, interaction interface{}) (*http.Request, error) {
+	payload, err := json.Marshal(interaction)
+	if err != nil {
+		return nil, err
+	}
+	return formRequest(signingSecret, path, url.Values{"payload": {string(payload)}})
+}
+
+// SlashCommandRequest builds a signed POST of a slash command to path.
+func SlashCommandRequest(signingSecret, path string, cmd slack.SlashCommand) (*http.Request, error) {
+	return formRequest(signingSecret, path, url.Values{
+		"team_id":      {cmd.TeamID},
+		"channel_id":   {cmd.ChannelID},
+		"user_id":      {cmd.UserID},
+		"command":      {cmd.Command},
+		"text":         {cmd.Text},
+		"response_url": {cmd.ResponseURL},
+		"trigger_id":   {cmd.TriggerID},
+	})
+}
+
+func formRequest(signingSecret, path string, form url.Values) (*http.Request, error) {
+	body := form.Encode()
+	r, err := http.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	Sign(r, signingSecret, []byte(body))
+
+	return r, nil
+}
@@ -0,0 +1,273 @@
+// Package slackfake is an in-process stand-in for the Slack Web API, response
+// URLs and Socket Mode. It records every call so end-to-end tests can assert
+// on what the bot posted without talking to Slack.
+//
+// Point the bot at it through port.NewSlackClientFactory(server.APIURL()) and
+// SLACK_API_URL.
+package slackfake
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// Call is a single request received by the fake.
+type Call struct {
+	// Method is the Web API method, e.g. chat.postMessage, or "response_url".
+	Method string
+	Token  string
+	// Params holds form & multipart values, Body the raw body for JSON calls.
+	Params url.Values
+	Body   []byte
+	// Path is only set for response_url calls.
+	Path string
+}
+
+// JSON decodes the body of a JSON call, e.g. a response_url message.
+func (c Call) JSON(v interface{}) error {
+	return json.Unmarshal(c.Body, v)
+}
+
+// Server is the fake Slack. Create one with NewServer and Close it when done.
+type Server struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	calls   []Call
+	users   map[string]slack.User
+	changed chan struct{}
+	seq     int
+	limits  map[string]*rateLimit
+
+	sockets *socketHub
+}
+
+func NewServer() *Server {
+	s := &Server{
+		users:   map[string]slack.User{},
+		changed: make(chan struct{}),
+		limits:  map[string]*rateLimit{},
+		sockets: newSocketHub(),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/", s.handleAPI)
+	mux.HandleFunc("/response/", s.handleResponseURL)
+	mux.HandleFunc("/socket", s.sockets.handle)
+	s.Server = httptest.NewServer(mux)
+
+	return s
+}
+
+// APIURL is the Web API base URL, in the form slack.OptionAPIURL expects.
+func (s *Server) APIURL() string {
+	return s.URL + "/api/"
+}
+
+// ResponseURL returns a response_url for synthetic interactions & commands.
+// Messages posted to it are recorded as "response_url" calls.
+func (s *Server) ResponseURL(id string) string {
+	return s.URL + "/response/" + id
+}
+
+// AddUser makes users.info answer for the user.
+func (s *Server) AddUser(user slack.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[user.ID] = user
+}
+
+type rateLimit struct {
+	remaining  int
+	retryAfter time.Duration
+}
+
+// RateLimit answers the next n calls to method, which may be "response_url",
+// with a 429 and a Retry-After header. The calls are still recorded.
+func (s *Server) RateLimit(method string, n int, retryAfter time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limits[method] = &rateLimit{remaining: n, retryAfter: retryAfter}
+}
+
+// throttle answers the call with a 429 if method is rate limited.
+func (s *Server) throttle(w http.ResponseWriter, method string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	limit, ok := s.limits[method]
+	if !ok || limit.remaining == 0 {
+		return false
+	}
+	limit.remaining--
+	w.Header().Set("Retry-After", strconv.Itoa(int(limit.retryAfter.Seconds())))
+	w.WriteHeader(http.StatusTooManyRequests)
+	return true
+}
+
+// Calls returns the recorded calls to method, or every call when method is empty.
+func (s *Server) Calls(method string) []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	calls := make([]Call, 0, len(s.calls))
+	for _, c := range s.calls {
+		if method == "" || c.Method == method {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// WaitForCalls blocks until at least n calls to method were recorded. The bot
+// replies asynchronously, so tests should wait rather than assert right away.
+func (s *Server) WaitForCalls(method string, n int, timeout time.Duration) ([]Call, error) {
+	deadline := time.After(timeout)
+	for {
+		s.mu.Lock()
+		changed := s.changed
+		s.mu.Unlock()
+
+		if calls := s.Calls(method); len(calls) >= n {
+			return calls, nil
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			return s.Calls(method), fmt.Errorf("timed out waiting for %d %s calls, got %d", n, method, len(s.Calls(method)))
+		}
+	}
+}
+
+// Reset forgets recorded calls.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = nil
+}
+
+func (s *Server) record(c Call) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, c)
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *Server) nextID(prefix string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	return fmt.Sprintf("%s%010d", prefix, s.seq)
+}
+
+func (s *Server) handleAPI(w http.ResponseWriter, r *http.Request) {
+	call, err := readCall(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	call.Method = strings.TrimPrefix(r.URL.Path, "/api/")
+	s.record(call)
+	if s.throttle(w, call.Method) {
+		return
+	}
+
+	switch call.Method {
+	case "auth.test":
+		respond(w, map[string]interface{}{"ok": true, "user_id": "UFAKEBOT000", "team_id": "TFAKE000000"})
+	case "chat.postMessage":
+		respond(w, map[string]interface{}{"ok": true, "channel": call.Params.Get("channel"), "ts": s.timestamp()})
+	case "chat.postEphemeral":
+		respond(w, map[string]interface{}{"ok": true, "message_ts": s.timestamp()})
+	case "views.open", "views.publish":
+		respond(w, map[string]interface{}{"ok": true, "view": map[string]interface{}{"id": s.nextID("V")}})
+	case "users.info":
+		s.mu.Lock()
+		user, ok := s.users[call.Params.Get("user")]
+		s.mu.Unlock()
+		if !ok {
+			respond(w, map[string]interface{}{"ok": false, "error": "user_not_found"})
+			return
+		}
+		respond(w, map[string]interface{}{"ok": true, "user": user})
+	case "files.upload":
+		respond(w, map[string]interface{}{"ok": true, "file": map[string]interface{}{"id": s.nextID("F"), "title": call.Params.Get("title")}})
+	case "apps.connections.open":
+		respond(w, map[string]interface{}{"ok": true, "url": "ws" + strings.TrimPrefix(s.URL, "http") + "/socket"})
+	default:
+		respond(w, map[string]interface{}{"ok": false, "error": "unknown_method"})
+	}
+}
+
+func (s *Server) handleResponseURL(w http.ResponseWriter, r *http.Request) {
+	call, err := readCall(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	call.Method = "response_url"
+	call.Path = strings.TrimPrefix(r.URL.Path, "/response/")
+	s.record(call)
+	if s.throttle(w, call.Method) {
+		return
+	}
+
+	respond(w, map[string]interface{}{"ok": true})
+}
+
+func (s *Server) timestamp() string {
+	id := s.nextID("")
+	return fmt.Sprintf("%d.%s", time.Now().Unix(), id[len(id)-6:])
+}
+
+func readCall(r *http.Request) (Call, error) {
+	call := Call{Params: url.Values{}}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		call.Token = strings.TrimPrefix(auth, "Bearer ")
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "multipart/form-data":
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			return call, err
+		}
+		call.Params = r.MultipartForm.Value
+	case "application/x-www-form-urlencoded":
+		if err := r.ParseForm(); err != nil {
+			return call, err
+		}
+		call.Params = r.PostForm
+	default:
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return call, err
+		}
+		call.Body = body
+	}
+	for k, v := range r.URL.Query() {
+		call.Params[k] = v
+	}
+	if call.Token == "" {
+		call.Token = call.Params.Get("token")
+	}
+
+	return call, nil
+}
+
+func respond(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
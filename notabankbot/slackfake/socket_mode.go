@@ -0,0 +1,191 @@
+package slackfake
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// socketPingInterval keeps the client's dead connection detection happy.
+const socketPingInterval = 5 * time.Second
+
+// ErrNoSocket is returned when sending a Socket Mode request while no client
+// is connected.
+var ErrNoSocket = errors.New("no socket mode client connected")
+
+// Ack is a Socket Mode acknowledgement sent back by the client.
+type Ack struct {
+	EnvelopeID string          `json:"envelope_id"`
+	Payload    json.RawMessage `json:"payload,omitempty"`
+}
+
+type socketHub struct {
+	upgrader websocket.Upgrader
+
+	mu        sync.Mutex
+	conns     map[*websocket.Conn]*sync.Mutex
+	acks      map[string]Ack
+	changed   chan struct{}
+	envelopes int
+}
+
+func newSocketHub() *socketHub {
+	return &socketHub{
+		// Socket Mode clients claim to come from api.slack.com.
+		upgrader: websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }},
+		conns:    map[*websocket.Conn]*sync.Mutex{},
+		acks:     map[string]Ack{},
+		changed:  make(chan struct{}),
+	}
+}
+
+func (h *socketHub) handle(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	writeMu := &sync.Mutex{}
+	h.mu.Lock()
+	h.conns[conn] = writeMu
+	h.mu.Unlock()
+	defer h.drop(conn)
+
+	if err := h.write(conn, writeMu, map[string]interface{}{"type": "hello", "num_connections": 1}); err != nil {
+		return
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(socketPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				writeMu.Lock()
+				err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second))
+				writeMu.Unlock()
+				if err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		var ack Ack
+		if err := conn.ReadJSON(&ack); err != nil {
+			return
+		}
+		h.mu.Lock()
+		h.acks[ack.EnvelopeID] = ack
+		close(h.changed)
+		h.changed = make(chan struct{})
+		h.mu.Unlock()
+	}
+}
+
+func (h *socketHub) write(conn *websocket.Conn, writeMu *sync.Mutex, v interface{}) error {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+	return conn.WriteJSON(v)
+}
+
+func (h *socketHub) drop(conn *websocket.Conn) {
+	h.mu.Lock()
+	delete(h.conns, conn)
+	h.mu.Unlock()
+	conn.Close()
+}
+
+// SocketConnected reports whether a Socket Mode client is connected.
+func (s *Server) SocketConnected() bool {
+	s.sockets.mu.Lock()
+	defer s.sockets.mu.Unlock()
+	return len(s.sockets.conns) > 0
+}
+
+// SendSocketRequest delivers a Socket Mode request, e.g. "events_api",
+// "interactive" or "slash_commands", to every connected client and returns
+// its envelope ID.
+func (s *Server) SendSocketRequest(requestType string, payload interface{}) (string, error) {
+	h := s.sockets
+	h.mu.Lock()
+	h.envelopes++
+	envelopeID := fmt.Sprintf("envelope-%d", h.envelopes)
+	conns := make(map[*websocket.Conn]*sync.Mutex, len(h.conns))
+	for conn, writeMu := range h.conns {
+		conns[conn] = writeMu
+	}
+	h.mu.Unlock()
+
+	if len(conns) == 0 {
+		return "", ErrNoSocket
+	}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	for conn, writeMu := range conns {
+		err := h.write(conn, writeMu, map[string]interface{}{
+			"type":                     requestType,
+			"envelope_id":              envelopeID,
+			"payload":                  json.RawMessage(raw),
+			"accepts_response_payload": requestType != "events_api",
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return envelopeID, nil
+}
+
+// WaitForAck blocks until the client acknowledges the envelope.
+func (s *Server) WaitForAck(envelopeID string, timeout time.Duration) (Ack, error) {
+	h := s.sockets
+	deadline := time.After(timeout)
+	for {
+		h.mu.Lock()
+		ack, ok := h.acks[envelopeID]
+		changed := h.changed
+		h.mu.Unlock()
+		if ok {
+			return ack, nil
+		}
+
+		select {
+		case <-changed:
+		case <-deadline:
+			return Ack{}, fmt.Errorf("timed out waiting for ack of %s", envelopeID)
+		}
+	}
+}
+
+// DropSockets disconnects every Socket Mode client, to exercise reconnects.
+func (s *Server) DropSockets() {
+	h := s.sockets
+	h.mu.Lock()
+	conns := make([]*websocket.Conn, 0, len(h.conns))
+	for conn := range h.conns {
+		conns = append(conns, conn)
+	}
+	h.mu.Unlock()
+
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+// Close disconnects Socket Mode clients and shuts the server down.
+func (s *Server) Close() {
+	s.DropSockets()
+	s.Server.Close()
+}
@@ -0,0 +1,123 @@
+// Package tracing sets up OpenTelemetry and ties traces to our logs. Until
+// Setup is called every tracer is a no-op, so tools that don't trace pay
+// nothing for the instrumentation.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/yammine/yamex-go"
+)
+
+const ErrUnknownExporter = yamex.Sentinel("tracing exporters are otlp, stdout or empty to disable tracing")
+
+type Config struct {
+	ServiceName string
+	// Exporter is "otlp", "stdout" or empty to disable tracing. The OTLP
+	// exporter is configured with the standard OTEL_EXPORTER_OTLP_* variables.
+	Exporter string
+	// SampleRatio is the fraction of new traces recorded, 0 records none.
+	SampleRatio float64
+}
+
+// Setup installs the global tracer provider. The returned func flushes and
+// stops it, and must be called before exiting.
+func Setup(ctx context.Context, config Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch config.Exporter {
+	case "":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		exporter, err = otlptracehttp.New(ctx)
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stderr), stdouttrace.WithPrettyPrint())
+	default:
+		return nil, fmt.Errorf("%w, got %q", ErrUnknownExporter, config.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("creating %s exporter: %w", config.Exporter, err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(config.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Logger is the global logger, tagged with the trace & span in ctx if any.
+func Logger(ctx context.Context) *zerolog.Logger {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return &log.Logger
+	}
+	logger := log.With().Str("trace_id", sc.TraceID().String()).Str("span_id", sc.SpanID().String()).Logger()
+	return &logger
+}
+
+// End ends span, marking it failed if *err is set. Defer it with a pointer
+// to the function's named error.
+func End(span trace.Span, err *error) {
+	if err != nil && *err != nil {
+		span.RecordError(*err)
+		span.SetStatus(codes.Error, (*err).Error())
+	}
+	span.End()
+}
+
+// TraceParent serialises the span in ctx as a W3C traceparent, so work done
+// later, e.g. delivering a queued message, can link back to it.
+func TraceParent(ctx context.Context) string {
+	carrier := mapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// LinkTo links a new span to the one serialised by TraceParent.
+func LinkTo(traceParent string) trace.SpanStartOption {
+	ctx := propagation.TraceContext{}.Extract(context.Background(), mapCarrier{"traceparent": traceParent})
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return trace.WithLinks()
+	}
+	return trace.WithLinks(trace.Link{SpanContext: sc})
+}
+
+// mapCarrier carries propagated context in a map.
+type mapCarrier map[string]string
+
+func (c mapCarrier) Get(key string) string {
+	return c[key]
+}
+
+func (c mapCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+func (c mapCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
@@ -0,0 +1,77 @@
+package yamex
+
+import (
+	"io"
+	"regexp"
+)
+
+// slackTokenPattern matches Slack bot, user, app, refresh & config tokens.
+var slackTokenPattern = regexp.MustCompile(`xox[a-z]-[A-Za-z0-9-]+|xapp-[A-Za-z0-9-]+|xoxe(\.xox[a-z])?-[A-Za-z0-9-]+`)
+
+const redacted = "[REDACTED]"
+
+// Redact replaces anything that looks like a Slack token.
+func Redact(s string) string {
+	return slackTokenPattern.ReplaceAllString(s, redacted)
+}
+
+// RedactingWriter scrubs Slack tokens from everything written through it. Wrap
+// log outputs with it so that tokens never reach the logs, whichever library
+// does the logging.
+type RedactingWriter struct {
+	w io.Writer
+}
+
+func NewRedactingWriter(w io.Writer) *RedactingWriter {
+	return &RedactingWriter{w: w}
+}
+
+func (r RedactingWriter) Write(p []byte) (int, error) {
+	if _, err := r.w.Write(slackTokenPattern.ReplaceAll(p, []byte(redacted))); err != nil {
+		return 0, err
+	}
+	// Report the original length, callers don't care that we rewrote it.
+	return len(p), nil
+}
+
+// secretSettingPattern matches the names of settings that hold secrets.
+var secretSettingPattern = regexp.MustCompile(`(?i)secret|token|key|password|dsn`)
+
+// RedactSettings copies settings, e.g. viper.AllSettings(), hiding the values
+// of anything that looks like a secret.
+func RedactSettings(settings map[string]interface{}) map[string]interface{} {
+	redactedSettings := make(map[string]interface{}, len(settings))
+	for name, value := range settings {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if secretSettingPattern.MatchString(name) {
+				redactedSettings[name] = redactValues(v)
+			} else {
+				redactedSettings[name] = RedactSettings(v)
+			}
+		case string:
+			if secretSettingPattern.MatchString(name) && v != "" {
+				redactedSettings[name] = redacted
+			} else {
+				redactedSettings[name] = Redact(v)
+			}
+		default:
+			if secretSettingPattern.MatchString(name) {
+				redactedSettings[name] = redacted
+			} else {
+				redactedSettings[name] = value
+			}
+		}
+	}
+	return redactedSettings
+}
+
+// redactValues keeps the keys of a map of secrets, e.g. key IDs, but not the
+// secrets themselves.
+func redactValues(secrets map[string]interface{}) map[string]interface{} {
+	redactedSecrets := make(map[string]interface{}, len(secrets))
+	for name := range secrets {
+		redactedSecrets[name] = redacted
+	}
+	return redactedSecrets
+}